@@ -12,6 +12,7 @@ import (
 
 	"github.com/flant/addon-operator/pkg/addon-operator"
 	"github.com/flant/addon-operator/pkg/app"
+	"github.com/flant/addon-operator/pkg/utils"
 )
 
 func main() {
@@ -31,6 +32,8 @@ func main() {
 		Default().
 		Action(func(c *kingpin.ParseContext) error {
 			sh_app.SetupLogging()
+			// Values from Secrets should not be logged.
+			log.AddHook(utils.NewRedactLogHook())
 			log.Infof("%s %s, shell-operator %s", app.AppName, app.Version, sh_app.Version)
 
 			operator := addon_operator.DefaultOperator()
@@ -5,9 +5,11 @@ go 1.12
 require (
 	github.com/davecgh/go-spew v1.1.1
 	github.com/evanphx/json-patch v4.5.0+incompatible
+	github.com/flant/libjq-go v1.6.2-0.20200616114952-907039e8a02a
 	github.com/flant/shell-operator v1.0.0-beta.12.0.20200903102652-4e8b8ad0bb3e // branch: master
 	github.com/go-chi/chi v4.0.3+incompatible
 	github.com/go-openapi/spec v0.19.3
+	github.com/hashicorp/go-multierror v1.0.0
 	github.com/kennygrant/sanitize v1.2.4
 	github.com/onsi/ginkgo v1.11.0
 	github.com/onsi/gomega v1.9.0
@@ -21,9 +23,11 @@ require (
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 	gopkg.in/satori/go.uuid.v1 v1.2.0
 	gopkg.in/yaml.v3 v3.0.0-20191120175047-4206685974f2
-	k8s.io/api v0.17.0
-	k8s.io/apimachinery v0.17.0
-	k8s.io/client-go v0.17.0
+	helm.sh/helm/v3 v3.1.2
+	k8s.io/api v0.17.2
+	k8s.io/apimachinery v0.17.2
+	k8s.io/cli-runtime v0.17.2
+	k8s.io/client-go v0.17.2
 	sigs.k8s.io/yaml v1.1.1-0.20191128155103-745ef44e09d6 // branch master, commit 745ef44e09d6, with fixes in yaml.v2.2.7
 )
 
@@ -0,0 +1,52 @@
+package addon_operator
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	sh_app "github.com/flant/shell-operator/pkg/app"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/flant/addon-operator/pkg/app"
+	"github.com/flant/addon-operator/pkg/kube_config_manager"
+)
+
+// StartAdmissionWebhookServer starts an HTTPS server with the validating admission webhook
+// for the ConfigMap. Schemas and migrations should be loaded before the start.
+func (op *AddonOperator) StartAdmissionWebhookServer() error {
+	if app.AdmissionWebhookListenPort == "" {
+		return nil
+	}
+	if app.ConfigBackend != app.ConfigBackendConfigMap {
+		log.Warnf("Admission webhook is not started: it validates only ConfigMap, config backend is '%s'", app.ConfigBackend)
+		return nil
+	}
+	if app.AdmissionWebhookCertFile == "" || app.AdmissionWebhookKeyFile == "" {
+		return fmt.Errorf("admission webhook: certificate and key files are required")
+	}
+
+	router := http.NewServeMux()
+	router.Handle(kube_config_manager.AdmissionWebhookPath, kube_config_manager.NewConfigMapAdmissionHandler(app.Namespace, app.ConfigMapName, op.KubeConfigManager))
+
+	address := net.JoinHostPort(sh_app.ListenAddress, app.AdmissionWebhookListenPort)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("admission webhook: listen on '%s': %s", address, err)
+	}
+	log.Infof("Admission webhook for ConfigMap/%s listens on https://%s%s", app.ConfigMapName, address, kube_config_manager.AdmissionWebhookPath)
+
+	server := &http.Server{Handler: router}
+	go func() {
+		err := server.ServeTLS(listener, app.AdmissionWebhookCertFile, app.AdmissionWebhookKeyFile)
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("Admission webhook server: %s", err)
+		}
+	}()
+	go func() {
+		<-op.ctx.Done()
+		_ = server.Close()
+	}()
+
+	return nil
+}
@@ -51,6 +51,12 @@ func RegisterHookMetrics(metricStorage *metric_storage.MetricStorage) {
 	)
 	metricStorage.RegisterCounter("{PREFIX}module_run_errors_total", map[string]string{"module": ""})
 
+	// helm upgrades and rollbacks made by the module HelmUpgradePolicy
+	metricStorage.RegisterCounter("{PREFIX}module_helm_upgrade_errors_total", map[string]string{"module": ""})
+	metricStorage.RegisterGauge("{PREFIX}module_helm_failed_revision", map[string]string{"module": ""})
+	metricStorage.RegisterCounter("{PREFIX}module_helm_rollbacks_total", map[string]string{"module": ""})
+	metricStorage.RegisterGauge("{PREFIX}module_helm_rollback_revision", map[string]string{"module": ""})
+
 	moduleHookLabels := map[string]string{
 		"module":     "",
 		"hook":       "",
@@ -102,6 +108,10 @@ func RegisterHookMetrics(metricStorage *metric_storage.MetricStorage) {
 		},
 		buckets_1msTo10s)
 
+	// kube config manager
+	metricStorage.RegisterCounter("{PREFIX}kube_config_conflicts_total", map[string]string{})
+	metricStorage.RegisterCounter("{PREFIX}config_history_errors_total", map[string]string{})
+
 	// task age
 	// hook_run task waiting time
 	metricStorage.RegisterCounter(
@@ -9,7 +9,10 @@ import (
 	"os"
 	"path"
 	"runtime/trace"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -37,6 +40,8 @@ import (
 	"github.com/flant/addon-operator/pkg/module_manager"
 	"github.com/flant/addon-operator/pkg/task"
 	"github.com/flant/addon-operator/pkg/utils"
+	"github.com/flant/addon-operator/pkg/values/migration"
+	"github.com/flant/addon-operator/pkg/values/validation"
 )
 
 // AddonOperator extends ShellOperator with modules and global hooks
@@ -57,6 +62,12 @@ type AddonOperator struct {
 
 	HelmResourcesManager helm_resources_manager.HelmResourcesManager
 
+	// Lock to create and remove queues from concurrent ModuleRun tasks.
+	queuesLock sync.Mutex
+	// Lock to enable and start bindings of module hooks from concurrent ModuleRun tasks:
+	// kube events manager and schedule manager are not safe for concurrent use.
+	bindingsLock sync.Mutex
+
 	// converge state
 	StartupConvergeStarted bool
 	StartupConvergeDone    bool
@@ -154,13 +165,35 @@ func (op *AddonOperator) InitModuleManager() error {
 		return err
 	}
 
-	// Initializing ConfigMap storage for values
-	op.KubeConfigManager = kube_config_manager.NewKubeConfigManager()
+	// Schemas are loaded by ModuleManager and used by KubeConfigManager to validate ConfigMap changes.
+	valuesValidator := validation.NewValuesValidator()
+	// Migrations are loaded by ModuleManager and applied by KubeConfigManager.
+	configMigrations := migration.NewMigrations()
+
+	// Initializing storage for values: ConfigMap, ModuleConfig objects or local file
+	switch app.ConfigBackend {
+	case app.ConfigBackendModuleConfig:
+		op.KubeConfigManager = kube_config_manager.NewModuleConfigManager()
+	case app.ConfigBackendFile:
+		if app.ConfigPath == "" {
+			return fmt.Errorf("config path is required for '%s' config backend", app.ConfigBackendFile)
+		}
+		op.KubeConfigManager = kube_config_manager.NewFileConfigManager(app.ConfigPath)
+	default:
+		op.KubeConfigManager = kube_config_manager.NewKubeConfigManager()
+	}
+	logEntry.Infof("Config values backend: %s", app.ConfigBackend)
 	op.KubeConfigManager.WithKubeClient(op.KubeClient)
 	op.KubeConfigManager.WithContext(op.ctx)
 	op.KubeConfigManager.WithNamespace(app.Namespace)
 	op.KubeConfigManager.WithConfigMapName(app.ConfigMapName)
 	op.KubeConfigManager.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+	op.KubeConfigManager.WithValuesValidator(valuesValidator)
+	op.KubeConfigManager.WithConfigMigrations(configMigrations)
+	op.KubeConfigManager.WithConfigMigrationsWriteBack(app.ConfigMigrationsWriteBack)
+	op.KubeConfigManager.WithConfigHistory(app.ConfigHistoryMax, app.ConfigHistoryConfigMap)
+	op.KubeConfigManager.WithModuleConfigMaps(app.ModuleConfigMaps && app.ConfigBackend == app.ConfigBackendConfigMap)
+	op.KubeConfigManager.WithMetricStorage(op.MetricStorage)
 
 	err = op.KubeConfigManager.Init()
 	if err != nil {
@@ -175,6 +208,8 @@ func (op *AddonOperator) InitModuleManager() error {
 	op.ModuleManager.WithKubeEventManager(op.KubeEventsManager)
 	op.ModuleManager.WithMetricStorage(op.MetricStorage)
 	op.ModuleManager.WithHookMetricStorage(op.HookMetricStorage)
+	op.ModuleManager.WithValuesValidator(valuesValidator)
+	op.ModuleManager.WithConfigMigrations(configMigrations)
 	err = op.ModuleManager.Init()
 	if err != nil {
 		return fmt.Errorf("init module manager: %s", err)
@@ -542,8 +577,18 @@ func (op *AddonOperator) CreateReloadAllTasks(onStartup bool, logLabels map[stri
 	return tasks
 }
 
+// getQueue returns a queue by name. It is used by ModuleRun tasks that can run concurrently.
+func (op *AddonOperator) getQueue(name string) *queue.TaskQueue {
+	op.queuesLock.Lock()
+	defer op.queuesLock.Unlock()
+	return op.TaskQueues.GetByName(name)
+}
+
 // CreateQueues create all queues defined in hooks
 func (op *AddonOperator) InitAndStartHookQueues() {
+	op.queuesLock.Lock()
+	defer op.queuesLock.Unlock()
+
 	schHooks := op.ModuleManager.GetGlobalHooksInOrder(Schedule)
 	for _, hookName := range schHooks {
 		h := op.ModuleManager.GetGlobalHook(hookName)
@@ -687,6 +732,11 @@ func (op *AddonOperator) StartModuleManagerEventHandler() {
 
 				//eventLogEntry.Debugf("Got %d absent resources from module", len(absentResourcesEvent.Absent))
 
+				eventDescription := "DetectAbsentHelmResources"
+				if len(absentResourcesEvent.Absent) == 0 {
+					eventDescription = "DetectDriftedHelmResources"
+				}
+
 				// Do not add ModuleRun task if it is already queued.
 				hasTask := QueueHasModuleRunTask(op.TaskQueues.GetMain(), absentResourcesEvent.ModuleName)
 				if !hasTask {
@@ -694,14 +744,14 @@ func (op *AddonOperator) StartModuleManagerEventHandler() {
 						WithLogLabels(logLabels).
 						WithQueueName("main").
 						WithMetadata(task.HookMetadata{
-							EventDescription: "DetectAbsentHelmResources",
+							EventDescription: eventDescription,
 							ModuleName:       absentResourcesEvent.ModuleName,
 						})
 					op.TaskQueues.GetMain().AddLast(newTask.WithQueuedAt(time.Now()))
 					eventLogEntry.WithFields(utils.LabelsToLogFields(newTask.LogLabels)).
-						Infof("queue task %s - got %d absent module resources", newTask.GetDescription(), len(absentResourcesEvent.Absent))
+						Infof("queue task %s - got %d absent and %d drifted module resources", newTask.GetDescription(), len(absentResourcesEvent.Absent), len(absentResourcesEvent.Drifted))
 				} else {
-					eventLogEntry.Infof("Got %d absent module resources, ModuleRun task already queued", len(absentResourcesEvent.Absent))
+					eventLogEntry.Infof("Got %d absent and %d drifted module resources, ModuleRun task already queued", len(absentResourcesEvent.Absent), len(absentResourcesEvent.Drifted))
 				}
 			}
 		}
@@ -885,6 +935,9 @@ func (op *AddonOperator) TaskHandler(t sh_task.Task) queue.TaskResult {
 	case task.ModuleRun:
 		res = op.HandleModuleRun(t, taskLogLabels)
 
+	case task.ParallelModuleRun:
+		res = op.HandleParallelModuleRun(t, taskLogLabels)
+
 	case task.ModuleDelete:
 		// TODO wait while module's tasks in other queues are done.
 		hm := task.HookMetadataAccessor(t)
@@ -965,6 +1018,7 @@ func (op *AddonOperator) UpdateWaitInQueueMetric(t sh_task.Task) {
 
 	case task.ReloadAllModules,
 		task.DiscoverModulesState,
+		task.ParallelModuleRun,
 		task.ModuleManagerRetry:
 		// no action required
 	}
@@ -1024,7 +1078,9 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 		// run onStartup hooks
 		moduleRunErr = module.RunOnStartup(t.GetLogLabels())
 		if moduleRunErr == nil {
-			module.State.OnStartupDone = true
+			module.UpdateState(func(state *module_manager.ModuleState) {
+				state.OnStartupDone = true
+			})
 		}
 		treg.End()
 	}
@@ -1033,8 +1089,10 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 
 	// Prevent tasks queueing and waiting if there is no kubernetes hooks with executeHookOnSynchronization
 	if module.State.OnStartupDone && !module.SynchronizationNeeded() {
-		module.State.SynchronizationTasksQueued = true
-		module.State.SynchronizationDone = true
+		module.UpdateState(func(state *module_manager.ModuleState) {
+			state.SynchronizationTasksQueued = true
+			state.SynchronizationDone = true
+		})
 	}
 
 	// Queue Synchronization tasks if needed
@@ -1050,6 +1108,7 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 			eventDescription += ".EnableKubernetesBindings"
 		}
 
+		op.bindingsLock.Lock()
 		err := op.ModuleManager.HandleModuleEnableKubernetesBindings(hm.ModuleName, func(hook *module_manager.ModuleHook, info controller.BindingExecutionInfo) {
 			queueName := info.QueueName
 			if queueName == t.GetQueueName() {
@@ -1092,6 +1151,7 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 				}
 			}
 		})
+		op.bindingsLock.Unlock()
 		if err != nil {
 			// Fail to enable bindings: cannot start Kubernetes monitors.
 			moduleRunErr = err
@@ -1100,7 +1160,7 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 
 			// Wait tasks are queued first
 			for id, tsk := range waitSyncTasks {
-				q := op.TaskQueues.GetByName(tsk.GetQueueName())
+				q := op.getQueue(tsk.GetQueueName())
 				if q == nil {
 					log.Errorf("Queue %s is not created while run GlobalHookEnableKubernetesBindings task!", tsk.GetQueueName())
 				} else {
@@ -1118,7 +1178,7 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 			}
 
 			for _, tsk := range parallelSyncTasks {
-				q := op.TaskQueues.GetByName(tsk.GetQueueName())
+				q := op.getQueue(tsk.GetQueueName())
 				if q == nil {
 					log.Errorf("Queue %s is not created while run GlobalHookEnableKubernetesBindings task!", tsk.GetQueueName())
 				} else {
@@ -1128,8 +1188,10 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 
 			if len(mainSyncTasks) > 0 {
 				// EnableKubernetesBindings and StartInformers for all kubernetes bindings.
+				op.queuesLock.Lock()
 				op.TaskQueues.NewNamedQueue(syncQueueName, op.TaskHandler)
 				syncSubQueue := op.TaskQueues.GetByName(syncQueueName)
+				op.queuesLock.Unlock()
 
 				for _, tsk := range mainSyncTasks {
 					logEntry.WithFields(utils.LabelsToLogFields(tsk.GetLogLabels())).
@@ -1160,13 +1222,15 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 
 			// Enter wait loop if there are tasks that should be waited.
 			// Synchronization is there are no tasks to wait.
-			if len(mainSyncTasks)+len(waitSyncTasks) == 0 {
-				module.State.SynchronizationDone = true
-			} else {
-				module.State.ShouldWaitForSynchronization = true
-			}
-			// prevent task queueing on next ModuleRun
-			module.State.SynchronizationTasksQueued = true
+			module.UpdateState(func(state *module_manager.ModuleState) {
+				if len(mainSyncTasks)+len(waitSyncTasks) == 0 {
+					state.SynchronizationDone = true
+				} else {
+					state.ShouldWaitForSynchronization = true
+				}
+				// prevent task queueing on next ModuleRun
+				state.SynchronizationTasksQueued = true
+			})
 		}
 	}
 
@@ -1175,7 +1239,9 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 		if !module.State.WaitStarted {
 			logEntry.WithField("module.state", "wait-for-synchronization").
 				Infof("ModuleRun wait for Synchronization")
-			module.State.WaitStarted = true
+			module.UpdateState(func(state *module_manager.ModuleState) {
+				state.WaitStarted = true
+			})
 		}
 
 		// Throttle debug messages: print hooks state every 5s
@@ -1188,9 +1254,13 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 		}
 
 		if module.SynchronizationDone() {
-			module.State.SynchronizationDone = true
+			module.UpdateState(func(state *module_manager.ModuleState) {
+				state.SynchronizationDone = true
+			})
 			// remove temporary subqueue
+			op.queuesLock.Lock()
 			op.TaskQueues.Remove(syncQueueName)
+			op.queuesLock.Unlock()
 		} else {
 			logEntry.Debugf("Module run repeat")
 			t.WithQueuedAt(time.Now())
@@ -1202,8 +1272,12 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 	// Start kubernetes monitors if Synchronization is done.
 	if module.State.SynchronizationDone && !module.State.MonitorsStarted {
 		// kubernetes Event
+		op.bindingsLock.Lock()
 		op.ModuleManager.StartModuleHooks(hm.ModuleName)
-		module.State.MonitorsStarted = true
+		op.bindingsLock.Unlock()
+		module.UpdateState(func(state *module_manager.ModuleState) {
+			state.MonitorsStarted = true
+		})
 	}
 
 	// Phase with helm hooks and helm chart.
@@ -1213,6 +1287,14 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 		valuesChanged, moduleRunErr = module.Run(t.GetLogLabels())
 	}
 
+	module.UpdateState(func(state *module_manager.ModuleState) {
+		state.LastRunTime = time.Now()
+		state.LastRunError = ""
+		if moduleRunErr != nil {
+			state.LastRunError = moduleRunErr.Error()
+		}
+	})
+
 	if moduleRunErr != nil {
 		op.MetricStorage.CounterAdd("{PREFIX}module_run_errors_total", 1.0, map[string]string{"module": hm.ModuleName})
 		logEntry.WithField("module.state", "failed").
@@ -1248,6 +1330,84 @@ func (op *AddonOperator) HandleModuleRun(t sh_task.Task, labels map[string]strin
 	return
 }
 
+// HandleParallelModuleRun runs ModuleRun tasks for independent modules concurrently.
+// The number of concurrent runs is limited by app.ModuleRunWorkers.
+// The task is repeated until all modules are ready: failed modules are retried
+// after delay, modules with changed values and modules waiting for
+// Synchronization are run again.
+func (op *AddonOperator) HandleParallelModuleRun(t sh_task.Task, labels map[string]string) (res queue.TaskResult) {
+	defer trace.StartRegion(context.Background(), "ParallelModuleRun").End()
+	logEntry := log.WithFields(utils.LabelsToLogFields(labels))
+
+	hm := task.HookMetadataAccessor(t)
+	logEntry.Infof("ParallelModuleRun for modules: %s", strings.Join(hm.ParallelModuleNames(), ", "))
+
+	results := make([]queue.TaskResult, len(hm.ParallelModuleRunTasks))
+	workers := make(chan struct{}, app.ModuleRunWorkers)
+	var wg sync.WaitGroup
+	for i, moduleRunTask := range hm.ParallelModuleRunTasks {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, moduleRunTask sh_task.Task) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			moduleLogLabels := utils.MergeLabels(labels, moduleRunTask.GetLogLabels())
+			results[i] = op.HandleModuleRun(moduleRunTask, moduleLogLabels)
+		}(i, moduleRunTask)
+	}
+	wg.Wait()
+
+	// Keep tasks for modules that are not ready.
+	remainingTasks := make([]sh_task.Task, 0)
+	failedModules := make([]string, 0)
+	for i, moduleRunTask := range hm.ParallelModuleRunTasks {
+		switch results[i].Status {
+		case "Success":
+			// ModuleRun is repeated if values are changed by afterHelm hooks, so module should be run again.
+			// Other tasks are queued as for the ModuleRun task when all modules are ready.
+			for _, afterTask := range results[i].AfterTasks {
+				if afterTask.GetType() == task.ModuleRun {
+					remainingTasks = append(remainingTasks, afterTask)
+				} else {
+					hm.ParallelAfterTasks = append(hm.ParallelAfterTasks, afterTask)
+				}
+			}
+			hm.ParallelHeadTasks = append(hm.ParallelHeadTasks, results[i].HeadTasks...)
+			hm.ParallelTailTasks = append(hm.ParallelTailTasks, results[i].TailTasks...)
+		case "Fail":
+			moduleRunTask.IncrementFailureCount()
+			failedModules = append(failedModules, task.HookMetadataAccessor(moduleRunTask).ModuleName)
+			remainingTasks = append(remainingTasks, moduleRunTask)
+		default:
+			remainingTasks = append(remainingTasks, moduleRunTask)
+		}
+	}
+
+	if len(remainingTasks) == 0 {
+		logEntry.Infof("ParallelModuleRun success, modules are ready")
+		res.Status = "Success"
+		res.HeadTasks = hm.ParallelHeadTasks
+		res.AfterTasks = hm.ParallelAfterTasks
+		res.TailTasks = hm.ParallelTailTasks
+		return
+	}
+
+	hm.ParallelModuleRunTasks = remainingTasks
+	t.UpdateMetadata(hm)
+	t.WithQueuedAt(time.Now())
+
+	if len(failedModules) > 0 {
+		logEntry.Errorf("ParallelModuleRun failed for modules %s. Requeue task to retry after delay. Failed count is %d.", strings.Join(failedModules, ", "), t.GetFailureCount()+1)
+		t.UpdateFailureMessage(fmt.Sprintf("ModuleRun failed for modules: %s", strings.Join(failedModules, ", ")))
+		res.Status = "Fail"
+		return
+	}
+
+	logEntry.Debugf("ParallelModuleRun repeat for modules: %s", strings.Join(hm.ParallelModuleNames(), ", "))
+	res.Status = "Repeat"
+	return
+}
+
 func (op *AddonOperator) HandleModuleHookRun(t sh_task.Task, labels map[string]string) (res queue.TaskResult) {
 	defer trace.StartRegion(context.Background(), "ModuleHookRun").End()
 
@@ -1573,7 +1733,7 @@ func (op *AddonOperator) RunDiscoverModulesState(discoverTask sh_task.Task, logL
 	}
 
 	// queue ModuleRun tasks for enabled modules
-	for _, moduleName := range modulesState.EnabledModules {
+	newModuleRunTask := func(moduleName string) sh_task.Task {
 		newLogLabels := utils.MergeLabels(logLabels)
 		newLogLabels["module"] = moduleName
 		delete(newLogLabels, "task.id")
@@ -1589,7 +1749,7 @@ func (op *AddonOperator) RunDiscoverModulesState(discoverTask sh_task.Task, logL
 			}
 		}
 
-		newTask := sh_task.NewTask(task.ModuleRun).
+		return sh_task.NewTask(task.ModuleRun).
 			WithLogLabels(newLogLabels).
 			WithQueueName("main").
 			WithMetadata(task.HookMetadata{
@@ -1597,10 +1757,55 @@ func (op *AddonOperator) RunDiscoverModulesState(discoverTask sh_task.Task, logL
 				ModuleName:       moduleName,
 				OnStartupHooks:   runOnStartupHooks,
 			})
-		newTasks = append(newTasks, newTask)
+	}
 
-		logEntry.WithFields(utils.LabelsToLogFields(newTask.LogLabels)).
-			Infof("queue task %s", newTask.GetDescription())
+	if app.ModuleRunWorkers > 1 {
+		// Independent modules are run concurrently with one ParallelModuleRun task.
+		// Modules with different weights are not run concurrently to keep the order of weights.
+		requires := make(map[string][]string)
+		weights := make(map[string]int)
+		for _, moduleName := range modulesState.EnabledModules {
+			module := op.ModuleManager.GetModule(moduleName)
+			requires[moduleName] = module.Requires()
+			if module.Definition != nil {
+				weights[moduleName] = module.Definition.Weight
+			}
+		}
+		for _, group := range module_manager.GroupModulesByRequirements(modulesState.EnabledModules, requires, weights) {
+			if len(group) == 1 {
+				newTask := newModuleRunTask(group[0])
+				newTasks = append(newTasks, newTask)
+				logEntry.WithFields(utils.LabelsToLogFields(newTask.GetLogLabels())).
+					Infof("queue task %s", newTask.GetDescription())
+				continue
+			}
+
+			moduleRunTasks := make([]sh_task.Task, 0, len(group))
+			for _, moduleName := range group {
+				moduleRunTasks = append(moduleRunTasks, newModuleRunTask(moduleName))
+			}
+			newLogLabels := utils.MergeLabels(logLabels)
+			delete(newLogLabels, "task.id")
+			newTask := sh_task.NewTask(task.ParallelModuleRun).
+				WithLogLabels(newLogLabels).
+				WithQueueName("main").
+				WithMetadata(task.HookMetadata{
+					EventDescription:       eventDescription,
+					ParallelModuleRunTasks: moduleRunTasks,
+				})
+			newTasks = append(newTasks, newTask)
+
+			logEntry.WithFields(utils.LabelsToLogFields(newTask.LogLabels)).
+				Infof("queue task %s", newTask.GetDescription())
+		}
+	} else {
+		for _, moduleName := range modulesState.EnabledModules {
+			newTask := newModuleRunTask(moduleName)
+			newTasks = append(newTasks, newTask)
+
+			logEntry.WithFields(utils.LabelsToLogFields(newTask.GetLogLabels())).
+				Infof("queue task %s", newTask.GetDescription())
+		}
 	}
 
 	// queue ModuleDelete tasks for disabled modules
@@ -1726,6 +1931,16 @@ func (op *AddonOperator) RunAddonOperatorMetrics() {
 	}()
 }
 
+// revealSensitive returns true if the debug request explicitly asks
+// to show sensitive values with the "reveal=true" query parameter.
+func revealSensitive(request *http.Request) bool {
+	reveal := request.URL.Query().Get("reveal") == "true"
+	if reveal {
+		log.Warnf("Debug server: sensitive values are revealed for '%s'", request.URL.Path)
+	}
+	return reveal
+}
+
 func (op *AddonOperator) SetupDebugServerHandles() {
 	op.DebugServer.Router.Get("/global/{type:(config|values)}.{format:(json|yaml)}", func(writer http.ResponseWriter, request *http.Request) {
 		valType := chi.URLParam(request, "type")
@@ -1746,6 +1961,10 @@ func (op *AddonOperator) SetupDebugServerHandles() {
 			return
 		}
 
+		if !revealSensitive(request) {
+			values = values.Redacted()
+		}
+
 		outBytes, err := values.AsBytes(format)
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
@@ -1757,6 +1976,9 @@ func (op *AddonOperator) SetupDebugServerHandles() {
 
 	op.DebugServer.Router.Get("/global/patches.json", func(writer http.ResponseWriter, request *http.Request) {
 		jp := op.ModuleManager.GlobalValuesPatches()
+		if !revealSensitive(request) {
+			jp = utils.RedactValuesPatches(jp)
+		}
 		data, err := json.Marshal(jp)
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
@@ -1769,12 +1991,45 @@ func (op *AddonOperator) SetupDebugServerHandles() {
 	op.DebugServer.Router.Get("/module/list.{format:(json|yaml|text)}", func(writer http.ResponseWriter, request *http.Request) {
 		format := chi.URLParam(request, "format")
 
-		_, _ = fmt.Fprintf(writer, "Dump enabled modules in %s format.\n", format)
+		if format == "text" {
+			_, _ = fmt.Fprintf(writer, "Dump enabled modules in %s format.\n", format)
 
-		for _, mName := range op.ModuleManager.GetModuleNamesInOrder() {
-			_, _ = fmt.Fprintf(writer, "%s \n", mName)
+			for _, mName := range op.ModuleManager.GetModuleNamesInOrder() {
+				_, _ = fmt.Fprintf(writer, "%s \n", mName)
+			}
+
+			disabledReasons := op.ModuleManager.GetModulesDisabledReasons()
+			disabledNames := make([]string, 0, len(disabledReasons))
+			for mName := range disabledReasons {
+				disabledNames = append(disabledNames, mName)
+			}
+			sort.Strings(disabledNames)
+
+			if len(disabledNames) > 0 {
+				_, _ = fmt.Fprintf(writer, "\nDisabled modules:\n")
+				for _, mName := range disabledNames {
+					_, _ = fmt.Fprintf(writer, "%s: %s\n", mName, disabledReasons[mName])
+				}
+			}
+			return
 		}
 
+		dump := op.ModuleManager.GetModulesStatus()
+
+		var outBytes []byte
+		var err error
+		switch format {
+		case "yaml":
+			outBytes, err = yaml.Marshal(dump)
+		case "json":
+			outBytes, err = json.Marshal(dump)
+		}
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprintf(writer, "Error: %s", err)
+			return
+		}
+		_, _ = writer.Write(outBytes)
 	})
 
 	op.DebugServer.Router.Get("/module/{name}/{type:(config|values)}.{format:(json|yaml)}", func(writer http.ResponseWriter, request *http.Request) {
@@ -1804,6 +2059,10 @@ func (op *AddonOperator) SetupDebugServerHandles() {
 			return
 		}
 
+		if !revealSensitive(request) {
+			values = values.Redacted()
+		}
+
 		outBytes, err := values.AsBytes(format)
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
@@ -1813,6 +2072,44 @@ func (op *AddonOperator) SetupDebugServerHandles() {
 		_, _ = writer.Write(outBytes)
 	})
 
+	op.DebugServer.Router.Get("/module/{name}/values-provenance.{format:(json|yaml)}", func(writer http.ResponseWriter, request *http.Request) {
+		modName := chi.URLParam(request, "name")
+		format := chi.URLParam(request, "format")
+
+		m := op.ModuleManager.GetModule(modName)
+		if m == nil {
+			writer.WriteHeader(http.StatusNotFound)
+			_, _ = writer.Write([]byte("Module not found"))
+			return
+		}
+
+		provenance, err := m.ValuesProvenance()
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			_, _ = writer.Write([]byte(err.Error()))
+			return
+		}
+		if !revealSensitive(request) {
+			for i := range provenance {
+				provenance[i].Value = utils.RedactValueAtPath(provenance[i].Path, provenance[i].Value)
+			}
+		}
+
+		var outBytes []byte
+		switch format {
+		case "yaml":
+			outBytes, err = yaml.Marshal(provenance)
+		case "json":
+			outBytes, err = json.Marshal(provenance)
+		}
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprintf(writer, "Error: %s", err)
+			return
+		}
+		_, _ = writer.Write(outBytes)
+	})
+
 	op.DebugServer.Router.Get("/module/{name}/render", func(writer http.ResponseWriter, request *http.Request) {
 		modName := chi.URLParam(request, "name")
 
@@ -1838,6 +2135,9 @@ func (op *AddonOperator) SetupDebugServerHandles() {
 			return
 		}
 
+		if !revealSensitive(request) {
+			output = utils.RedactString(output)
+		}
 		_, _ = writer.Write([]byte(output))
 	})
 
@@ -1852,6 +2152,9 @@ func (op *AddonOperator) SetupDebugServerHandles() {
 		}
 
 		jp := m.ValuesPatches()
+		if !revealSensitive(request) {
+			jp = utils.RedactValuesPatches(jp)
+		}
 		data, err := json.Marshal(jp)
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
@@ -1861,6 +2164,53 @@ func (op *AddonOperator) SetupDebugServerHandles() {
 		_, _ = writer.Write(data)
 	})
 
+	op.DebugServer.Router.Get("/module/{name}/explain-enabled.{format:(json|yaml|text)}", func(writer http.ResponseWriter, request *http.Request) {
+		modName := chi.URLParam(request, "name")
+		format := chi.URLParam(request, "format")
+
+		trace := op.ModuleManager.GetModuleEnabledTrace(modName)
+		if trace == nil {
+			writer.WriteHeader(http.StatusNotFound)
+			_, _ = writer.Write([]byte("Module not found"))
+			return
+		}
+
+		if format == "text" {
+			state := "disabled"
+			if trace.Enabled {
+				state = "enabled"
+			}
+			_, _ = fmt.Fprintf(writer, "Module '%s' is %s.\n", trace.Name, state)
+			for i, step := range trace.Steps {
+				flag := "not set"
+				if step.Enabled != nil {
+					flag = fmt.Sprintf("enabled=%v", *step.Enabled)
+				}
+				_, _ = fmt.Fprintf(writer, "%d. %s: %s, result enabled=%v", i+1, step.Source, flag, step.Result)
+				if step.Message != "" {
+					_, _ = fmt.Fprintf(writer, " (%s)", step.Message)
+				}
+				_, _ = fmt.Fprintln(writer)
+			}
+			return
+		}
+
+		var outBytes []byte
+		var err error
+		switch format {
+		case "yaml":
+			outBytes, err = yaml.Marshal(trace)
+		case "json":
+			outBytes, err = json.Marshal(trace)
+		}
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprintf(writer, "Error: %s", err)
+			return
+		}
+		_, _ = writer.Write(outBytes)
+	})
+
 	op.DebugServer.Router.Get("/module/resource-monitor.{format:(json|yaml)}", func(writer http.ResponseWriter, request *http.Request) {
 		format := chi.URLParam(request, "format")
 
@@ -1891,6 +2241,67 @@ func (op *AddonOperator) SetupDebugServerHandles() {
 		_, _ = writer.Write(outBytes)
 	})
 
+	op.DebugServer.Router.Get("/config/history.{format:(json|yaml)}", func(writer http.ResponseWriter, request *http.Request) {
+		format := chi.URLParam(request, "format")
+		reveal := revealSensitive(request)
+
+		type revisionDump struct {
+			Revision int          `json:"revision"`
+			Time     string       `json:"time"`
+			Source   string       `json:"source"`
+			Values   utils.Values `json:"values"`
+		}
+
+		dump := make([]revisionDump, 0)
+		for _, revision := range op.KubeConfigManager.ConfigHistory() {
+			values, err := revision.Values()
+			if err != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = fmt.Fprintf(writer, "Error: revision %d: %s", revision.Revision, err)
+				return
+			}
+			if !reveal {
+				values = values.Redacted()
+			}
+			dump = append(dump, revisionDump{
+				Revision: revision.Revision,
+				Time:     revision.Time.Format(time.RFC3339),
+				Source:   revision.Source,
+				Values:   values,
+			})
+		}
+
+		var outBytes []byte
+		var err error
+		switch format {
+		case "yaml":
+			outBytes, err = yaml.Marshal(dump)
+		case "json":
+			outBytes, err = json.Marshal(dump)
+		}
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprintf(writer, "Error: %s", err)
+			return
+		}
+		_, _ = writer.Write(outBytes)
+	})
+
+	op.DebugServer.Router.Post("/config/rollback/{revision:[0-9]+}", func(writer http.ResponseWriter, request *http.Request) {
+		revision, _ := strconv.Atoi(chi.URLParam(request, "revision"))
+		section := request.URL.Query().Get("section")
+
+		err := op.KubeConfigManager.RollbackConfig(revision, section)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprintf(writer, "Error: %s", err)
+			return
+		}
+		if section == "" {
+			section = "all sections"
+		}
+		_, _ = fmt.Fprintf(writer, "Config is rolled back to revision %d: %s\n", revision, section)
+	})
 }
 
 func (op *AddonOperator) SetupHttpServerHandles() {
@@ -1959,7 +2370,7 @@ func (op *AddonOperator) MainQueueHasConvergeTasks() int {
 	op.TaskQueues.GetMain().Iterate(func(t sh_task.Task) {
 		ttype := t.GetType()
 		switch ttype {
-		case task.ModuleRun, task.DiscoverModulesState, task.ModuleDelete, task.ModulePurge, task.ModuleManagerRetry, task.ReloadAllModules, task.GlobalHookEnableKubernetesBindings, task.GlobalHookEnableScheduleBindings:
+		case task.ModuleRun, task.ParallelModuleRun, task.DiscoverModulesState, task.ModuleDelete, task.ModulePurge, task.ModuleManagerRetry, task.ReloadAllModules, task.GlobalHookEnableKubernetesBindings, task.GlobalHookEnableScheduleBindings:
 			convergeTasks++
 			return
 		}
@@ -2052,6 +2463,12 @@ func InitAndStart(operator *AddonOperator) error {
 		return err
 	}
 
+	err = operator.StartAdmissionWebhookServer()
+	if err != nil {
+		log.Errorf("Admission webhook start failed: %s", err)
+		return err
+	}
+
 	operator.Start()
 	return nil
 }
@@ -2065,6 +2482,14 @@ func QueueHasModuleRunTask(q *queue.TaskQueue, moduleName string) bool {
 				hasTask = true
 			}
 		}
+		if t.GetType() == task.ParallelModuleRun {
+			hm := task.HookMetadataAccessor(t)
+			for _, name := range hm.ParallelModuleNames() {
+				if name == moduleName {
+					hasTask = true
+				}
+			}
+		}
 		return true
 	})
 	return hasTask
@@ -2,6 +2,9 @@ package addon_operator
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/gomega"
@@ -9,8 +12,17 @@ import (
 	sh_app "github.com/flant/shell-operator/pkg/app"
 	. "github.com/flant/shell-operator/pkg/hook/types"
 	"github.com/flant/shell-operator/pkg/kube"
+	"github.com/flant/shell-operator/pkg/kube/fake"
+	"github.com/flant/shell-operator/pkg/metric_storage"
 	sh_task "github.com/flant/shell-operator/pkg/task"
+	"github.com/flant/shell-operator/pkg/task/queue"
 
+	"github.com/flant/addon-operator/pkg/app"
+	"github.com/flant/addon-operator/pkg/helm"
+	"github.com/flant/addon-operator/pkg/helm/client"
+	"github.com/flant/addon-operator/pkg/helm_resources_manager"
+	"github.com/flant/addon-operator/pkg/kube_config_manager"
+	"github.com/flant/addon-operator/pkg/module_manager"
 	"github.com/flant/addon-operator/pkg/task"
 )
 
@@ -136,3 +148,103 @@ func Test_Operator_Startup(t *testing.T) {
 	//assert.True(t, hookRun.hookGlobal2)
 	//assert.Equalf(t, 0, TasksQueue.Length(), "%d tasks remain in queue after TasksRunner", TasksQueue.Length())
 }
+
+// ParallelModuleRun runs ModuleRun tasks for independent modules concurrently.
+// Run tests with -race to detect unguarded access to shared state.
+func Test_Operator_ParallelModuleRun(t *testing.T) {
+	g := NewWithT(t)
+
+	workers := app.ModuleRunWorkers
+	app.ModuleRunWorkers = 2
+	defer func() { app.ModuleRunWorkers = workers }()
+
+	helm.NewClient = func(logLabels ...map[string]string) client.HelmClient {
+		return &helm.MockHelmClient{Manifests: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: module-config
+`}
+	}
+
+	tempDir, err := ioutil.TempDir("", "addon-operator-")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	defer os.RemoveAll(tempDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fc := fake.NewFakeCluster()
+	fc.CreateNs(app.Namespace)
+
+	op := NewAddonOperator()
+	op.WithContext(ctx)
+	op.MetricStorage = metric_storage.NewMetricStorage()
+	op.MetricStorage.WithNewRegistry()
+	op.TaskQueues = queue.NewTaskQueueSet()
+
+	op.HelmResourcesManager = helm_resources_manager.NewHelmResourcesManager()
+	op.HelmResourcesManager.WithContext(ctx)
+	op.HelmResourcesManager.WithKubeClient(fc.KubeClient)
+
+	kcm := kube_config_manager.NewFileConfigManager(filepath.Join(tempDir, "config.yaml"))
+	kcm.WithContext(ctx)
+	g.Expect(kcm.Init()).Should(Succeed())
+
+	mm := module_manager.NewMainModuleManager()
+	mm.WithContext(ctx)
+	mm.WithDirectories("testdata/parallel_module_run/modules", "testdata/parallel_module_run/global-hooks", tempDir)
+	mm.WithKubeConfigManager(kcm)
+	mm.WithMetricStorage(op.MetricStorage)
+	mm.WithHookMetricStorage(op.MetricStorage)
+	mm.WithHelmResourcesManager(op.HelmResourcesManager)
+	g.Expect(mm.Init()).Should(Succeed())
+	op.ModuleManager = mm
+
+	state, err := mm.DiscoverModulesState(map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(state.EnabledModules).Should(Equal([]string{"module-a", "module-b"}))
+
+	moduleRunTasks := make([]sh_task.Task, 0)
+	for _, moduleName := range state.EnabledModules {
+		g.Expect(mm.RegisterModuleHooks(mm.GetModule(moduleName), map[string]string{})).Should(Succeed())
+		moduleRunTasks = append(moduleRunTasks, sh_task.NewTask(task.ModuleRun).
+			WithQueueName("main").
+			WithMetadata(task.HookMetadata{ModuleName: moduleName, OnStartupHooks: true}))
+	}
+	parallelTask := sh_task.NewTask(task.ParallelModuleRun).
+		WithQueueName("main").
+		WithMetadata(task.HookMetadata{ParallelModuleRunTasks: moduleRunTasks})
+
+	// Debug endpoints read modules state while modules are running.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = mm.GetModulesStatus()
+			}
+		}
+	}()
+
+	// afterHelm hook of module-b changes values, so module-b is run again.
+	res := op.HandleParallelModuleRun(parallelTask, map[string]string{})
+	g.Expect(res.Status).Should(Equal("Repeat"))
+	g.Expect(task.HookMetadataAccessor(parallelTask).ParallelModuleNames()).Should(Equal([]string{"module-b"}))
+
+	res = op.HandleParallelModuleRun(parallelTask, map[string]string{})
+	g.Expect(res.Status).Should(Equal("Success"))
+
+	for _, moduleName := range state.EnabledModules {
+		module := mm.GetModule(moduleName)
+		g.Expect(module.StateSnapshot().LastRunError).Should(BeEmpty(), moduleName)
+		g.Expect(op.HelmResourcesManager.HasMonitor(moduleName)).Should(BeTrue(), moduleName)
+	}
+
+	values, err := mm.GetModule("module-a").Values()
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(values["moduleA"]).Should(HaveKeyWithValue("replicas", 2.0))
+}
@@ -26,10 +26,42 @@ var TillerMaxHistory int32 = 0
 var Helm3HistoryMax int32 = 10
 var Helm3Timeout time.Duration = 5 * time.Minute
 
+// Helm3Lib enables the Helm 3 library client instead of the helm binary.
+var Helm3Lib = false
+var Helm3Driver = "secret"
+
 var Namespace = ""
 var ConfigMapName = "addon-operator"
+
+// Backends to store config values.
+const (
+	ConfigBackendConfigMap    = "ConfigMap"
+	ConfigBackendModuleConfig = "ModuleConfig"
+	ConfigBackendFile         = "File"
+)
+
+var ConfigBackend = ConfigBackendConfigMap
+var ConfigPath = ""
 var ValuesChecksumsAnnotation = "addon-operator/values-checksums"
 
+// ConfigMigrationsWriteBack enables saving of migrated config values with new versions.
+var ConfigMigrationsWriteBack = false
+
+// ModuleConfigMaps enables ConfigMaps with module sections in addition to the main ConfigMap.
+var ModuleConfigMaps = false
+
+// Bounded history of accepted configs. History is also stored in the ConfigMap if the name is not empty.
+var ConfigHistoryMax = 10
+var ConfigHistoryConfigMap = ""
+
+// Validating admission webhook for the ConfigMap. Disabled if the port is empty.
+var AdmissionWebhookListenPort = ""
+var AdmissionWebhookCertFile = ""
+var AdmissionWebhookKeyFile = ""
+
+// ModuleRunWorkers is a number of ModuleRun tasks for independent modules that can run concurrently.
+var ModuleRunWorkers = 1
+
 var GlobalHooksDir = "global-hooks"
 var ModulesDir = "modules"
 var DefaultTempDir = "/tmp/addon-operator"
@@ -89,11 +121,67 @@ func DefineStartCommandFlags(kpApp *kingpin.Application, cmd *kingpin.CmdClause)
 		Default(Helm3Timeout.String()).
 		DurationVar(&Helm3Timeout)
 
+	cmd.Flag("helm3-lib", "Helm: use Helm 3 library in-process instead of the helm binary. The helm binary is used if the library client cannot be initialized.").
+		Envar("HELM3_LIB").
+		Default(strconv.FormatBool(Helm3Lib)).
+		BoolVar(&Helm3Lib)
+
+	cmd.Flag("helm3-driver", "Helm: a storage for releases of the Helm 3 library client: 'secret', 'configmap' or 'memory'.").
+		Envar("HELM_DRIVER").
+		Default(Helm3Driver).
+		EnumVar(&Helm3Driver, "secret", "configmap", "memory")
+
 	cmd.Flag("config-map", "Name of a ConfigMap to store values.").
 		Envar("ADDON_OPERATOR_CONFIG_MAP").
 		Default(ConfigMapName).
 		StringVar(&ConfigMapName)
 
+	cmd.Flag("config-backend", "A storage for config values: 'ConfigMap', cluster-wide 'ModuleConfig' objects or local 'File'.").
+		Envar("ADDON_OPERATOR_CONFIG_BACKEND").
+		Default(ConfigBackend).
+		EnumVar(&ConfigBackend, ConfigBackendConfigMap, ConfigBackendModuleConfig, ConfigBackendFile)
+	cmd.Flag("config-path", "A path to a YAML file or a directory with config values for 'File' backend.").
+		Envar("ADDON_OPERATOR_CONFIG_PATH").
+		Default(ConfigPath).
+		StringVar(&ConfigPath)
+
+	cmd.Flag("config-migrations-write-back", "Save migrated config values and their new versions into the config backend. Migrations are applied on every read if disabled.").
+		Envar("ADDON_OPERATOR_CONFIG_MIGRATIONS_WRITE_BACK").
+		Default(strconv.FormatBool(ConfigMigrationsWriteBack)).
+		BoolVar(&ConfigMigrationsWriteBack)
+
+	cmd.Flag("module-config-maps", "Read module sections from ConfigMaps labeled with 'addon-operator.io/module=<module name>' and save module values into them. Only for 'ConfigMap' backend.").
+		Envar("ADDON_OPERATOR_MODULE_CONFIG_MAPS").
+		Default(strconv.FormatBool(ModuleConfigMaps)).
+		BoolVar(&ModuleConfigMaps)
+
+	cmd.Flag("config-history-max", "A number of accepted config revisions to keep for rollback. History is disabled if 0.").
+		Envar("ADDON_OPERATOR_CONFIG_HISTORY_MAX").
+		Default(strconv.Itoa(ConfigHistoryMax)).
+		IntVar(&ConfigHistoryMax)
+	cmd.Flag("config-history-config-map", "Name of a ConfigMap to store config revisions. Revisions are kept only in memory if empty.").
+		Envar("ADDON_OPERATOR_CONFIG_HISTORY_CONFIG_MAP").
+		Default(ConfigHistoryConfigMap).
+		StringVar(&ConfigHistoryConfigMap)
+
+	cmd.Flag("admission-webhook-listen-port", "Port for HTTPS server with validating admission webhook for the ConfigMap. Webhook is disabled if empty.").
+		Envar("ADDON_OPERATOR_ADMISSION_WEBHOOK_LISTEN_PORT").
+		Default(AdmissionWebhookListenPort).
+		StringVar(&AdmissionWebhookListenPort)
+	cmd.Flag("admission-webhook-cert-file", "A path to the TLS certificate for the admission webhook server.").
+		Envar("ADDON_OPERATOR_ADMISSION_WEBHOOK_CERT_FILE").
+		Default(AdmissionWebhookCertFile).
+		StringVar(&AdmissionWebhookCertFile)
+	cmd.Flag("admission-webhook-key-file", "A path to the TLS key for the admission webhook server.").
+		Envar("ADDON_OPERATOR_ADMISSION_WEBHOOK_KEY_FILE").
+		Default(AdmissionWebhookKeyFile).
+		StringVar(&AdmissionWebhookKeyFile)
+
+	cmd.Flag("module-run-workers", "A number of concurrent ModuleRun tasks for modules without requirements between each other. Modules are run one by one if 1.").
+		Envar("ADDON_OPERATOR_MODULE_RUN_WORKERS").
+		Default(strconv.Itoa(ModuleRunWorkers)).
+		IntVar(&ModuleRunWorkers)
+
 	sh_app.DefineKubeClientFlags(cmd)
 	sh_app.DefineJqFlags(cmd)
 	sh_app.DefineLoggingFlags(cmd)
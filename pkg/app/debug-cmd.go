@@ -1,7 +1,17 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 
@@ -23,6 +33,7 @@ func DefineDebugCommands(kpApp *kingpin.Application) {
 		})
 	// -o json|yaml and --debug-unix-socket <file>
 	AddOutputJsonYamlFlag(globalValuesCmd)
+	AddRevealSensitiveFlag(globalValuesCmd)
 	sh_app.DefineDebugUnixSocketFlag(globalValuesCmd)
 
 	globalConfigCmd := globalCmd.Command("config", "Dump global config values.").
@@ -36,6 +47,7 @@ func DefineDebugCommands(kpApp *kingpin.Application) {
 		})
 	// -o json|yaml and --debug-unix-socket <file>
 	AddOutputJsonYamlFlag(globalConfigCmd)
+	AddRevealSensitiveFlag(globalConfigCmd)
 	sh_app.DefineDebugUnixSocketFlag(globalConfigCmd)
 
 	globalPatchesCmd := globalCmd.Command("patches", "Dump global value patches.").
@@ -47,6 +59,7 @@ func DefineDebugCommands(kpApp *kingpin.Application) {
 			fmt.Println(string(dump))
 			return nil
 		})
+	AddRevealSensitiveFlag(globalPatchesCmd)
 	// --debug-unix-socket <file>
 	sh_app.DefineDebugUnixSocketFlag(globalPatchesCmd)
 
@@ -54,6 +67,13 @@ func DefineDebugCommands(kpApp *kingpin.Application) {
 
 	moduleListCmd := moduleCmd.Command("list", "List available modules and their enabled status.").
 		Action(func(c *kingpin.ParseContext) error {
+			if sh_debug.OutputFormat == "text" {
+				modules, err := Module(sh_debug.DefaultClient()).List("json")
+				if err != nil {
+					return err
+				}
+				return PrintModuleListTable(os.Stdout, modules)
+			}
 			modules, err := Module(sh_debug.DefaultClient()).List(sh_debug.OutputFormat)
 			if err != nil {
 				return err
@@ -66,8 +86,17 @@ func DefineDebugCommands(kpApp *kingpin.Application) {
 	sh_app.DefineDebugUnixSocketFlag(moduleListCmd)
 
 	var moduleName string
+	var explainValues bool
 	moduleValuesCmd := moduleCmd.Command("values", "Dump module values by name.").
 		Action(func(c *kingpin.ParseContext) error {
+			if explainValues {
+				dump, err := Module(sh_debug.DefaultClient()).Name(moduleName).ValuesProvenance(sh_debug.OutputFormat)
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(dump))
+				return nil
+			}
 			dump, err := Module(sh_debug.DefaultClient()).Name(moduleName).Values(sh_debug.OutputFormat)
 			if err != nil {
 				return err
@@ -76,10 +105,26 @@ func DefineDebugCommands(kpApp *kingpin.Application) {
 			return nil
 		})
 	moduleValuesCmd.Arg("module_name", "").Required().StringVar(&moduleName)
+	moduleValuesCmd.Flag("explain", "Show an origin layer for every values path.").BoolVar(&explainValues)
 	// -o json|yaml and --debug-unix-socket <file>
 	AddOutputJsonYamlFlag(moduleValuesCmd)
+	AddRevealSensitiveFlag(moduleValuesCmd)
 	sh_app.DefineDebugUnixSocketFlag(moduleValuesCmd)
 
+	moduleExplainEnabledCmd := moduleCmd.Command("explain-enabled", "Explain why the module is enabled or disabled.").
+		Action(func(c *kingpin.ParseContext) error {
+			dump, err := Module(sh_debug.DefaultClient()).Name(moduleName).ExplainEnabled(sh_debug.OutputFormat)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(dump))
+			return nil
+		})
+	moduleExplainEnabledCmd.Arg("module_name", "").Required().StringVar(&moduleName)
+	// -o json|yaml|text and --debug-unix-socket <file>
+	sh_debug.AddOutputJsonYamlTextFlag(moduleExplainEnabledCmd)
+	sh_app.DefineDebugUnixSocketFlag(moduleExplainEnabledCmd)
+
 	moduleRenderCmd := moduleCmd.Command("render", "Render module manifests.").
 		Action(func(c *kingpin.ParseContext) error {
 			dump, err := Module(sh_debug.DefaultClient()).Name(moduleName).Render()
@@ -91,6 +136,7 @@ func DefineDebugCommands(kpApp *kingpin.Application) {
 		})
 	moduleRenderCmd.Arg("module_name", "").Required().StringVar(&moduleName)
 	AddOutputJsonYamlFlag(moduleRenderCmd)
+	AddRevealSensitiveFlag(moduleRenderCmd)
 	sh_app.DefineDebugUnixSocketFlag(moduleRenderCmd)
 
 	moduleConfigCmd := moduleCmd.Command("config", "Dump module config values by name.").
@@ -105,6 +151,7 @@ func DefineDebugCommands(kpApp *kingpin.Application) {
 	moduleConfigCmd.Arg("module_name", "").Required().StringVar(&moduleName)
 	// -o json|yaml and --debug-unix-socket <file>
 	AddOutputJsonYamlFlag(moduleConfigCmd)
+	AddRevealSensitiveFlag(moduleConfigCmd)
 	sh_app.DefineDebugUnixSocketFlag(moduleConfigCmd)
 
 	modulePatchesCmd := moduleCmd.Command("patches", "Dump module value patches by name.").
@@ -117,6 +164,7 @@ func DefineDebugCommands(kpApp *kingpin.Application) {
 			return nil
 		})
 	modulePatchesCmd.Arg("module_name", "").Required().StringVar(&moduleName)
+	AddRevealSensitiveFlag(modulePatchesCmd)
 	// --debug-unix-socket <file>
 	sh_app.DefineDebugUnixSocketFlag(modulePatchesCmd)
 
@@ -133,6 +181,38 @@ func DefineDebugCommands(kpApp *kingpin.Application) {
 	AddOutputJsonYamlFlag(moduleResourceMonitorCmd)
 	sh_app.DefineDebugUnixSocketFlag(moduleResourceMonitorCmd)
 
+	configCmd := sh_app.CommandWithDefaultUsageTemplate(kpApp, "config", "manage config values history")
+
+	configHistoryCmd := configCmd.Command("history", "Dump accepted config revisions.").
+		Action(func(c *kingpin.ParseContext) error {
+			dump, err := Config(sh_debug.DefaultClient()).History(sh_debug.OutputFormat)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(dump))
+			return nil
+		})
+	// -o json|yaml and --debug-unix-socket <file>
+	AddOutputJsonYamlFlag(configHistoryCmd)
+	AddRevealSensitiveFlag(configHistoryCmd)
+	sh_app.DefineDebugUnixSocketFlag(configHistoryCmd)
+
+	var configRevision int
+	var configSection string
+	configRollbackCmd := configCmd.Command("rollback", "Write config sections from the revision back into the config.").
+		Action(func(c *kingpin.ParseContext) error {
+			out, err := Config(sh_debug.DefaultClient()).Rollback(configRevision, configSection)
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(out))
+			return nil
+		})
+	configRollbackCmd.Arg("revision", "").Required().IntVar(&configRevision)
+	configRollbackCmd.Flag("section", "Roll back only this section: 'global' or a module name. All sections of the revision by default.").
+		StringVar(&configSection)
+	// --debug-unix-socket <file>
+	sh_app.DefineDebugUnixSocketFlag(configRollbackCmd)
 }
 
 func AddOutputJsonYamlFlag(cmd *kingpin.CmdClause) {
@@ -141,6 +221,74 @@ func AddOutputJsonYamlFlag(cmd *kingpin.CmdClause) {
 		EnumVar(&sh_debug.OutputFormat, "json", "yaml")
 }
 
+// RevealSensitive is set to show sensitive values in debug output.
+var RevealSensitive bool
+
+func AddRevealSensitiveFlag(cmd *kingpin.CmdClause) {
+	cmd.Flag("reveal-sensitive", "Show sensitive values instead of '<redacted>'.").
+		BoolVar(&RevealSensitive)
+}
+
+// revealQuery returns a query string for the debug server to show sensitive values.
+func revealQuery() string {
+	if RevealSensitive {
+		return "?reveal=true"
+	}
+	return ""
+}
+
+// moduleListItem is an item of the module list in JSON format.
+type moduleListItem struct {
+	Name                string `json:"name"`
+	Weight              int    `json:"weight"`
+	Stage               string `json:"stage"`
+	Enabled             bool   `json:"enabled"`
+	EnabledSource       string `json:"enabledSource"`
+	DisabledReason      string `json:"disabledReason"`
+	HelmRelease         string `json:"helmRelease"`
+	HelmReleaseRevision string `json:"helmReleaseRevision"`
+	LastRunTime         string `json:"lastRunTime"`
+	LastRunError        string `json:"lastRunError"`
+	MonitorRunning      bool   `json:"monitorRunning"`
+}
+
+// PrintModuleListTable renders the module list in JSON format as a table.
+func PrintModuleListTable(out io.Writer, data []byte) error {
+	var modules []moduleListItem
+	err := json.Unmarshal(data, &modules)
+	if err != nil {
+		return fmt.Errorf("parse module list: %s", err)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tWEIGHT\tSTAGE\tENABLED\tSOURCE\tRELEASE\tREVISION\tMONITOR\tLAST RUN\tLAST ERROR")
+	for _, m := range modules {
+		source := dashIfEmpty(m.EnabledSource)
+		if m.DisabledReason != "" {
+			source = fmt.Sprintf("%s: %s", source, m.DisabledReason)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			m.Name,
+			m.Weight,
+			dashIfEmpty(m.Stage),
+			strconv.FormatBool(m.Enabled),
+			source,
+			dashIfEmpty(m.HelmRelease),
+			dashIfEmpty(m.HelmReleaseRevision),
+			strconv.FormatBool(m.MonitorRunning),
+			dashIfEmpty(m.LastRunTime),
+			dashIfEmpty(m.LastRunError))
+	}
+	return w.Flush()
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
 type GlobalRequest struct {
 	client *sh_debug.Client
 }
@@ -150,17 +298,17 @@ func Global(client *sh_debug.Client) *GlobalRequest {
 }
 
 func (gr *GlobalRequest) Values(format string) ([]byte, error) {
-	url := fmt.Sprintf("http://unix/global/values.%s", format)
+	url := fmt.Sprintf("http://unix/global/values.%s%s", format, revealQuery())
 	return gr.client.Get(url)
 }
 
 func (gr *GlobalRequest) Config(format string) ([]byte, error) {
-	url := fmt.Sprintf("http://unix/global/config.%s", format)
+	url := fmt.Sprintf("http://unix/global/config.%s%s", format, revealQuery())
 	return gr.client.Get(url)
 }
 
 func (gr *GlobalRequest) Patches() ([]byte, error) {
-	url := fmt.Sprintf("http://unix/global/patches.json")
+	url := fmt.Sprintf("http://unix/global/patches.json%s", revealQuery())
 	return gr.client.Get(url)
 }
 
@@ -189,21 +337,75 @@ func (mr *ModuleRequest) Name(name string) *ModuleRequest {
 }
 
 func (mr *ModuleRequest) Values(format string) ([]byte, error) {
-	url := fmt.Sprintf("http://unix/module/%s/values.%s", mr.name, format)
+	url := fmt.Sprintf("http://unix/module/%s/values.%s%s", mr.name, format, revealQuery())
+	return mr.client.Get(url)
+}
+
+func (mr *ModuleRequest) ValuesProvenance(format string) ([]byte, error) {
+	url := fmt.Sprintf("http://unix/module/%s/values-provenance.%s%s", mr.name, format, revealQuery())
 	return mr.client.Get(url)
 }
 
 func (mr *ModuleRequest) Render() ([]byte, error) {
-	url := fmt.Sprintf("http://unix/module/%s/render", mr.name)
+	url := fmt.Sprintf("http://unix/module/%s/render%s", mr.name, revealQuery())
 	return mr.client.Get(url)
 }
 
 func (mr *ModuleRequest) Patches() ([]byte, error) {
-	url := fmt.Sprintf("http://unix/module/%s/patches.json", mr.name)
+	url := fmt.Sprintf("http://unix/module/%s/patches.json%s", mr.name, revealQuery())
 	return mr.client.Get(url)
 }
 
 func (mr *ModuleRequest) Config(format string) ([]byte, error) {
-	url := fmt.Sprintf("http://unix/module/%s/config.%s", mr.name, format)
+	url := fmt.Sprintf("http://unix/module/%s/config.%s%s", mr.name, format, revealQuery())
 	return mr.client.Get(url)
 }
+
+func (mr *ModuleRequest) ExplainEnabled(format string) ([]byte, error) {
+	url := fmt.Sprintf("http://unix/module/%s/explain-enabled.%s", mr.name, format)
+	return mr.client.Get(url)
+}
+
+type ConfigRequest struct {
+	client *sh_debug.Client
+}
+
+func Config(client *sh_debug.Client) *ConfigRequest {
+	return &ConfigRequest{client: client}
+}
+
+func (cr *ConfigRequest) History(format string) ([]byte, error) {
+	url := fmt.Sprintf("http://unix/config/history.%s%s", format, revealQuery())
+	return cr.client.Get(url)
+}
+
+// Rollback sends a POST request: debug client can only send GET requests.
+func (cr *ConfigRequest) Rollback(revision int, section string) ([]byte, error) {
+	httpc := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", cr.client.SocketPath)
+			},
+		},
+	}
+
+	query := url.Values{}
+	if section != "" {
+		query.Set("section", section)
+	}
+	u := fmt.Sprintf("http://unix/config/rollback/%d?%s", revision, query.Encode())
+	resp, err := httpc.Post(u, "text/plain", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", body)
+	}
+	return body, nil
+}
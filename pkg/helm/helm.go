@@ -4,10 +4,13 @@ import (
 	"fmt"
 	"net/http"
 
+	log "github.com/sirupsen/logrus"
+
 	"github.com/flant/addon-operator/pkg/app"
 	"github.com/flant/addon-operator/pkg/helm/client"
 	"github.com/flant/addon-operator/pkg/helm/helm2"
 	"github.com/flant/addon-operator/pkg/helm/helm3"
+	"github.com/flant/addon-operator/pkg/helm/helm3lib"
 	"github.com/flant/shell-operator/pkg/kube"
 )
 
@@ -17,7 +20,27 @@ var NewClient = func(logLabels ...map[string]string) client.HelmClient {
 
 var HealthzHandler func(writer http.ResponseWriter, request *http.Request)
 
+// RestoresDrift is false for Helm 2: it uses a two-way merge with the previous release,
+// so `helm upgrade` does not restore fields changed in the cluster.
+var RestoresDrift = true
+
 func Init(client kube.KubernetesClient) error {
+	// Use helm3 library if enabled
+	if app.Helm3Lib {
+		err := helm3lib.Init(&helm3lib.Helm3LibOptions{
+			Namespace:  app.Namespace,
+			HistoryMax: app.Helm3HistoryMax,
+			Timeout:    app.Helm3Timeout,
+			KubeClient: client,
+			Driver:     app.Helm3Driver,
+		})
+		if err == nil {
+			NewClient = helm3lib.NewClient
+			return nil
+		}
+		log.Errorf("Helm 3 library client is not initialized, fallback to the helm binary: %s", err)
+	}
+
 	// Try helm3 first
 	err := helm3.Init(&helm3.Helm3Options{
 		Namespace:  app.Namespace,
@@ -54,5 +77,6 @@ func Init(client kube.KubernetesClient) error {
 	}
 	NewClient = helm2.NewClient
 	HealthzHandler = helm2.TillerHealthHandler()
+	RestoresDrift = false
 	return nil
 }
@@ -2,6 +2,7 @@ package helm2
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -212,6 +213,35 @@ func (h *Helm2Client) LastReleaseStatus(releaseName string) (revision string, st
 	return
 }
 
+// LastDeployedRevision returns the last revision that was successfully deployed:
+// a revision with DEPLOYED or SUPERSEDED status. Empty string is returned if there is no such revision.
+func (h *Helm2Client) LastDeployedRevision(releaseName string) (string, error) {
+	stdout, stderr, err := h.Cmd("history", releaseName, "--output", "json")
+	if err != nil {
+		return "", fmt.Errorf("cannot get history for release '%s': %s\n%v %v", releaseName, err, stdout, stderr)
+	}
+
+	var history []struct {
+		Revision int    `json:"revision"`
+		Status   string `json:"status"`
+	}
+	err = json.Unmarshal([]byte(stdout), &history)
+	if err != nil {
+		return "", fmt.Errorf("helm history returns invalid json: %v", err)
+	}
+
+	lastDeployed := 0
+	for _, item := range history {
+		if (item.Status == "DEPLOYED" || item.Status == "SUPERSEDED") && item.Revision > lastDeployed {
+			lastDeployed = item.Revision
+		}
+	}
+	if lastDeployed == 0 {
+		return "", nil
+	}
+	return strconv.Itoa(lastDeployed), nil
+}
+
 func (h *Helm2Client) UpgradeRelease(releaseName string, chart string, valuesPaths []string, setValues []string, namespace string) error {
 	args := make([]string, 0)
 	args = append(args, "upgrade")
@@ -258,6 +288,18 @@ func (h *Helm2Client) GetReleaseValues(releaseName string) (utils.Values, error)
 	return values, nil
 }
 
+// RollbackRelease rolls back the release to the revision.
+func (h *Helm2Client) RollbackRelease(releaseName string, revision string) error {
+	h.LogEntry.Infof("Running helm rollback for release '%s' to revision %s ...", releaseName, revision)
+	stdout, stderr, err := h.Cmd("rollback", releaseName, revision)
+	if err != nil {
+		return fmt.Errorf("helm rollback failed: %s:\n%s %s", err, stdout, stderr)
+	}
+	h.LogEntry.Infof("Helm rollback for release '%s' to revision %s successful:\n%s\n%s", releaseName, revision, stdout, stderr)
+
+	return nil
+}
+
 func (h *Helm2Client) DeleteRelease(releaseName string) (err error) {
 	h.LogEntry.Debugf("helm release '%s': execute helm delete --purge", releaseName)
 
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -157,6 +158,36 @@ func (h *Helm3Client) LastReleaseStatus(releaseName string) (revision string, st
 	return
 }
 
+// LastDeployedRevision returns the last revision that was successfully deployed:
+// a revision with "deployed" or "superseded" status. Empty string is returned if there is no such revision.
+func (h *Helm3Client) LastDeployedRevision(releaseName string) (string, error) {
+	stdout, stderr, err := h.Cmd("history", releaseName, "--namespace", h.Namespace, "--output", "yaml")
+	if err != nil {
+		return "", fmt.Errorf("cannot get history for release '%s': %s\n%v %v", releaseName, err, stdout, stderr)
+	}
+
+	var history []struct {
+		Revision int    `json:"revision"`
+		Status   string `json:"status"`
+	}
+	err = k8syaml.Unmarshal([]byte(stdout), &history)
+	if err != nil {
+		return "", fmt.Errorf("helm history returns invalid yaml: %v", err)
+	}
+
+	lastDeployed := 0
+	for _, item := range history {
+		status := strings.ToLower(item.Status)
+		if (status == "deployed" || status == "superseded") && item.Revision > lastDeployed {
+			lastDeployed = item.Revision
+		}
+	}
+	if lastDeployed == 0 {
+		return "", nil
+	}
+	return strconv.Itoa(lastDeployed), nil
+}
+
 func (h *Helm3Client) UpgradeRelease(releaseName string, chart string, valuesPaths []string, setValues []string, namespace string) error {
 	args := make([]string, 0)
 	args = append(args, "upgrade")
@@ -223,6 +254,32 @@ func (h *Helm3Client) GetReleaseValues(releaseName string) (utils.Values, error)
 	return values, nil
 }
 
+// RollbackRelease rolls back the release to the revision.
+func (h *Helm3Client) RollbackRelease(releaseName string, revision string) error {
+	args := make([]string, 0)
+	args = append(args, "rollback")
+	args = append(args, releaseName)
+	args = append(args, revision)
+
+	args = append(args, "--namespace")
+	args = append(args, h.Namespace)
+
+	args = append(args, "--history-max")
+	args = append(args, fmt.Sprintf("%d", Options.HistoryMax))
+
+	args = append(args, "--timeout")
+	args = append(args, Options.Timeout.String())
+
+	h.LogEntry.Infof("Running helm rollback for release '%s' to revision %s ...", releaseName, revision)
+	stdout, stderr, err := h.Cmd(args...)
+	if err != nil {
+		return fmt.Errorf("helm rollback failed: %s:\n%s %s", err, stdout, stderr)
+	}
+	h.LogEntry.Infof("Helm rollback for release '%s' to revision %s successful:\n%s\n%s", releaseName, revision, stdout, stderr)
+
+	return nil
+}
+
 func (h *Helm3Client) DeleteRelease(releaseName string) (err error) {
 	h.LogEntry.Debugf("helm release '%s': execute helm uninstall", releaseName)
 
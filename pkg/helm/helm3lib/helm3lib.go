@@ -0,0 +1,391 @@
+package helm3lib
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	sh_app "github.com/flant/shell-operator/pkg/app"
+	"github.com/flant/shell-operator/pkg/kube"
+
+	"github.com/flant/addon-operator/pkg/helm/client"
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+type Helm3LibOptions struct {
+	Namespace  string
+	HistoryMax int32
+	Timeout    time.Duration
+	KubeClient kube.KubernetesClient
+	// Driver is a storage driver for releases: 'secret', 'configmap' or 'memory'.
+	Driver string
+}
+
+var Options *Helm3LibOptions
+
+// actionConfig is a Helm configuration with the Kubernetes client and the release storage.
+// It is shared by all clients.
+var actionConfig *action.Configuration
+
+// Init creates a Helm configuration with the Kubernetes client configured as the addon-operator client.
+func Init(options *Helm3LibOptions) error {
+	getter := genericclioptions.NewConfigFlags(false)
+	getter.Namespace = &options.Namespace
+	if sh_app.KubeConfig != "" {
+		getter.KubeConfig = &sh_app.KubeConfig
+	}
+	if sh_app.KubeContext != "" {
+		getter.Context = &sh_app.KubeContext
+	}
+	if sh_app.KubeServer != "" {
+		getter.APIServer = &sh_app.KubeServer
+	}
+
+	cfg := new(action.Configuration)
+	err := cfg.Init(getter, options.Namespace, options.Driver, debugLog)
+	if err != nil {
+		return fmt.Errorf("init helm 3 library: %s", err)
+	}
+	return InitWithActionConfig(options, cfg)
+}
+
+// InitWithActionConfig uses the prepared Helm configuration, e.g. with the memory storage driver
+// and the fake Kubernetes client in tests.
+func InitWithActionConfig(options *Helm3LibOptions, cfg *action.Configuration) error {
+	if options.HistoryMax > 0 {
+		cfg.Releases.MaxHistory = int(options.HistoryMax)
+	}
+	Options = options
+	actionConfig = cfg
+	log.Infof("Helm 3 library client, storage driver '%s'", options.Driver)
+	return nil
+}
+
+func debugLog(format string, v ...interface{}) {
+	log.WithField("operator.component", "helm").Debugf(format, v...)
+}
+
+// LibClient is a HelmClient that uses Helm 3 library instead of the helm binary.
+type LibClient struct {
+	KubeClient kube.KubernetesClient
+	LogEntry   *log.Entry
+	Namespace  string
+}
+
+var _ client.HelmClient = &LibClient{}
+
+func NewClient(logLabels ...map[string]string) client.HelmClient {
+	logEntry := log.WithField("operator.component", "helm")
+	if len(logLabels) > 0 {
+		logEntry = logEntry.WithFields(utils.LabelsToLogFields(logLabels[0]))
+	}
+
+	return &LibClient{
+		LogEntry:   logEntry,
+		KubeClient: Options.KubeClient,
+		Namespace:  Options.Namespace,
+	}
+}
+
+func (h *LibClient) CommandEnv() []string {
+	return []string{}
+}
+
+// Cmd is not supported: there is no helm binary.
+func (h *LibClient) Cmd(args ...string) (string, string, error) {
+	return "", "", fmt.Errorf("helm 3 library client cannot run helm commands")
+}
+
+func (h *LibClient) InitAndVersion() error {
+	return nil
+}
+
+// DeleteSingleFailedRevision uninstalls a release that has never been deployed,
+// e.g. after a failed first install. Helm 3.1 cannot upgrade such a release
+// ("has no deployed releases"), so it should be installed again from scratch.
+func (h *LibClient) DeleteSingleFailedRevision(releaseName string) error {
+	releases, err := action.NewHistory(actionConfig).Run(releaseName)
+	if err == driver.ErrReleaseNotFound || (err == nil && len(releases) == 0) {
+		h.LogEntry.Debugf("helm release '%s': Release not found, no cleanup required.", releaseName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot get history for release '%s': %s", releaseName, err)
+	}
+
+	if lastDeployedVersion(releases) > 0 {
+		return nil
+	}
+
+	last := releases[0]
+	for _, rel := range releases {
+		if rel.Version > last.Version {
+			last = rel
+		}
+	}
+	h.LogEntry.Infof("helm release '%s': revision %d has status %s and there are no deployed revisions, uninstall release", releaseName, last.Version, last.Info.Status)
+	_, err = action.NewUninstall(actionConfig).Run(releaseName)
+	if err != nil {
+		h.LogEntry.Errorf("helm release '%s': cleanup of failed revision got error: %v", releaseName, err)
+		return err
+	}
+	h.LogEntry.Infof("helm release '%s': cleanup of failed revision succeeded", releaseName)
+	return nil
+}
+
+func (h *LibClient) DeleteOldFailedRevisions(releaseName string) error {
+	// No need to delete single failed revision anymore
+	// https://github.com/helm/helm/issues/8037#issuecomment-622217632
+	return nil
+}
+
+// lastRelease returns the last revision of the release or driver.ErrReleaseNotFound.
+func (h *LibClient) lastRelease(releaseName string) (*release.Release, error) {
+	releases, err := action.NewHistory(actionConfig).Run(releaseName)
+	if err == nil && len(releases) == 0 {
+		err = driver.ErrReleaseNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	releaseutil.Reverse(releases, releaseutil.SortByRevision)
+	return releases[0], nil
+}
+
+// LastReleaseStatus returns last known revision for release and its status.
+func (h *LibClient) LastReleaseStatus(releaseName string) (revision string, status string, err error) {
+	rel, err := h.lastRelease(releaseName)
+	if err == driver.ErrReleaseNotFound {
+		// Bad module name or no releases installed
+		return "0", "", fmt.Errorf("release '%s' not found", releaseName)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("cannot get history for release '%s': %s", releaseName, err)
+	}
+	return strconv.Itoa(rel.Version), rel.Info.Status.String(), nil
+}
+
+// LastDeployedRevision returns the last revision that was successfully deployed:
+// a revision with "deployed" or "superseded" status. Empty string is returned if there is no such revision.
+func (h *LibClient) LastDeployedRevision(releaseName string) (string, error) {
+	releases, err := action.NewHistory(actionConfig).Run(releaseName)
+	if err == driver.ErrReleaseNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot get history for release '%s': %s", releaseName, err)
+	}
+
+	lastDeployed := lastDeployedVersion(releases)
+	if lastDeployed == 0 {
+		return "", nil
+	}
+	return strconv.Itoa(lastDeployed), nil
+}
+
+// lastDeployedVersion returns the version of the last revision with "deployed" or "superseded" status or 0.
+func lastDeployedVersion(releases []*release.Release) int {
+	lastDeployed := 0
+	for _, rel := range releases {
+		status := rel.Info.Status
+		if (status == release.StatusDeployed || status == release.StatusSuperseded) && rel.Version > lastDeployed {
+			lastDeployed = rel.Version
+		}
+	}
+	return lastDeployed
+}
+
+// mergeValues merges values files and --set values as helm does.
+func mergeValues(valuesPaths []string, setValues []string) (map[string]interface{}, error) {
+	opts := &values.Options{
+		ValueFiles: valuesPaths,
+		Values:     setValues,
+	}
+	return opts.MergeValues(getter.Providers{})
+}
+
+func (h *LibClient) UpgradeRelease(releaseName string, chart string, valuesPaths []string, setValues []string, namespace string) error {
+	chrt, err := loader.Load(chart)
+	if err != nil {
+		return fmt.Errorf("helm upgrade failed: load chart '%s': %s", chart, err)
+	}
+	vals, err := mergeValues(valuesPaths, setValues)
+	if err != nil {
+		return fmt.Errorf("helm upgrade failed: %s", err)
+	}
+	if namespace == "" {
+		namespace = h.Namespace
+	}
+
+	h.LogEntry.Infof("Running helm upgrade for release '%s' with chart '%s' in namespace '%s' ...", releaseName, chart, namespace)
+
+	// Install the release if there is no history as 'helm upgrade --install' does.
+	// Helm 3.1 cannot upgrade a release without deployed revisions, e.g. after a failed
+	// first install, so such a release is installed again over the failed revision.
+	releases, err := action.NewHistory(actionConfig).Run(releaseName)
+	if err != nil && err != driver.ErrReleaseNotFound {
+		return fmt.Errorf("helm upgrade failed: get history for release '%s': %s", releaseName, err)
+	}
+	if lastDeployedVersion(releases) == 0 {
+		install := action.NewInstall(actionConfig)
+		install.ReleaseName = releaseName
+		install.Namespace = namespace
+		install.Timeout = Options.Timeout
+		install.Replace = len(releases) > 0
+		rel, err := install.Run(chrt, vals)
+		if err != nil {
+			return fmt.Errorf("helm upgrade failed: install release '%s': %s", releaseName, err)
+		}
+		h.LogEntry.Infof("Helm upgrade for release '%s' with chart '%s' in namespace '%s' successful: installed revision %d", releaseName, chart, namespace, rel.Version)
+		return nil
+	}
+
+	upgrade := action.NewUpgrade(actionConfig)
+	upgrade.Namespace = namespace
+	upgrade.Timeout = Options.Timeout
+	upgrade.MaxHistory = int(Options.HistoryMax)
+	rel, err := upgrade.Run(releaseName, chrt, vals)
+	if err != nil {
+		return fmt.Errorf("helm upgrade failed: %s", err)
+	}
+	h.LogEntry.Infof("Helm upgrade for release '%s' with chart '%s' in namespace '%s' successful: revision %d", releaseName, chart, namespace, rel.Version)
+
+	return nil
+}
+
+func (h *LibClient) GetReleaseValues(releaseName string) (utils.Values, error) {
+	vals, err := action.NewGetValues(actionConfig).Run(releaseName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get values of helm release %s: %s", releaseName, err)
+	}
+	return utils.Values(vals), nil
+}
+
+// RollbackRelease rolls back the release to the revision.
+func (h *LibClient) RollbackRelease(releaseName string, revision string) error {
+	version, err := strconv.Atoi(revision)
+	if err != nil {
+		return fmt.Errorf("helm rollback failed: bad revision '%s': %s", revision, err)
+	}
+
+	h.LogEntry.Infof("Running helm rollback for release '%s' to revision %s ...", releaseName, revision)
+	rollback := action.NewRollback(actionConfig)
+	rollback.Version = version
+	rollback.Timeout = Options.Timeout
+	err = rollback.Run(releaseName)
+	if err != nil {
+		return fmt.Errorf("helm rollback failed: %s", err)
+	}
+	h.LogEntry.Infof("Helm rollback for release '%s' to revision %s successful", releaseName, revision)
+
+	return nil
+}
+
+func (h *LibClient) DeleteRelease(releaseName string) error {
+	h.LogEntry.Debugf("helm release '%s': execute helm uninstall", releaseName)
+
+	_, err := action.NewUninstall(actionConfig).Run(releaseName)
+	if err != nil {
+		return fmt.Errorf("helm uninstall %s invocation error: %v", releaseName, err)
+	}
+	return nil
+}
+
+func (h *LibClient) IsReleaseExists(releaseName string) (bool, error) {
+	revision, _, err := h.LastReleaseStatus(releaseName)
+	if err != nil && revision == "0" {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ListReleases returns all known releases as strings — "<release_name>.v<release_number>"
+// It is required only for helm2.
+func (h *LibClient) ListReleases(labelSelector map[string]string) (releases []string, err error) {
+	return
+}
+
+// ListReleasesNames returns list of release names from the release storage.
+func (h *LibClient) ListReleasesNames(labelSelector map[string]string) ([]string, error) {
+	query := map[string]string{"owner": "helm"}
+	for k, v := range labelSelector {
+		query[k] = v
+	}
+
+	releases, err := actionConfig.Releases.Driver.Query(query)
+	if err == driver.ErrReleaseNotFound {
+		return []string{}, nil
+	}
+	if err != nil {
+		h.LogEntry.Debugf("helm: list of releases failed: %s", err)
+		return nil, err
+	}
+
+	uniqNamesMap := make(map[string]struct{})
+	for _, rel := range releases {
+		if rel.Name != "" {
+			uniqNamesMap[rel.Name] = struct{}{}
+		}
+	}
+
+	uniqNames := make([]string, 0)
+	for name := range uniqNamesMap {
+		uniqNames = append(uniqNames, name)
+	}
+
+	sort.Strings(uniqNames)
+	return uniqNames, nil
+}
+
+// Render returns manifests and hooks as 'helm template' does.
+func (h *LibClient) Render(releaseName string, chart string, valuesPaths []string, setValues []string, namespace string) (string, error) {
+	chrt, err := loader.Load(chart)
+	if err != nil {
+		return "", fmt.Errorf("helm render failed: load chart '%s': %s", chart, err)
+	}
+	vals, err := mergeValues(valuesPaths, setValues)
+	if err != nil {
+		return "", fmt.Errorf("helm render failed: %s", err)
+	}
+	if namespace == "" {
+		namespace = h.Namespace
+	}
+
+	h.LogEntry.Debugf("Render helm templates for chart '%s' in namespace '%s' ...", chart, namespace)
+
+	install := action.NewInstall(actionConfig)
+	install.ReleaseName = releaseName
+	install.Namespace = namespace
+	install.DryRun = true
+	install.ClientOnly = true
+	install.Replace = true
+	rel, err := install.Run(chrt, vals)
+	if err != nil {
+		return "", fmt.Errorf("helm render failed: %s", err)
+	}
+
+	var manifests bytes.Buffer
+	_, _ = fmt.Fprintln(&manifests, strings.TrimSpace(rel.Manifest))
+	for _, hook := range rel.Hooks {
+		_, _ = fmt.Fprintf(&manifests, "---\n# Source: %s\n%s\n", hook.Path, hook.Manifest)
+	}
+	h.LogEntry.Infof("Render helm templates for chart '%s' was successful", chart)
+
+	return manifests.String(), nil
+}
@@ -0,0 +1,226 @@
+package helm3lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+func initMemoryStorage(t *testing.T) {
+	g := NewWithT(t)
+
+	memory := driver.NewMemory()
+	memory.SetNamespace("default")
+	cfg := &action.Configuration{
+		Releases:     storage.Init(memory),
+		KubeClient:   &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+		Capabilities: chartutil.DefaultCapabilities,
+		Log:          t.Logf,
+	}
+	err := InitWithActionConfig(&Helm3LibOptions{
+		Namespace:  "default",
+		HistoryMax: 2,
+		Timeout:    time.Minute,
+		Driver:     "memory",
+	}, cfg)
+	g.Expect(err).ShouldNot(HaveOccurred())
+}
+
+func writeValues(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	NewWithT(t).Expect(err).ShouldNot(HaveOccurred())
+	return path
+}
+
+func TestLibClient_Release(t *testing.T) {
+	g := NewWithT(t)
+	initMemoryStorage(t)
+
+	dir, err := ioutil.TempDir("", "helm3lib")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	valuesPath := writeValues(t, dir, "values.yaml", "param: one\n")
+
+	cl := NewClient()
+
+	exists, err := cl.IsReleaseExists("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(exists).Should(BeFalse())
+	revision, _, err := cl.LastReleaseStatus("hello")
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(revision).Should(Equal("0"))
+
+	// Release is installed on the first upgrade.
+	err = cl.UpgradeRelease("hello", "testdata/chart", []string{valuesPath}, nil, "default")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	revision, status, err := cl.LastReleaseStatus("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(revision).Should(Equal("1"))
+	g.Expect(status).Should(Equal("deployed"))
+
+	// --set values override values files.
+	err = cl.UpgradeRelease("hello", "testdata/chart", []string{valuesPath}, []string{"param=two"}, "default")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	revision, _, err = cl.LastReleaseStatus("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(revision).Should(Equal("2"))
+
+	values, err := cl.GetReleaseValues("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(values).Should(Equal(utils.Values{"param": "two"}))
+
+	err = cl.UpgradeRelease("hello", "testdata/chart", []string{valuesPath}, nil, "default")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	history, err := action.NewHistory(actionConfig).Run("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(history).Should(HaveLen(2))
+
+	names, err := cl.ListReleasesNames(nil)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(names).Should(Equal([]string{"hello"}))
+
+	err = cl.DeleteRelease("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	exists, err = cl.IsReleaseExists("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(exists).Should(BeFalse())
+	names, err = cl.ListReleasesNames(nil)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(names).Should(BeEmpty())
+}
+
+func TestLibClient_Rollback(t *testing.T) {
+	g := NewWithT(t)
+	initMemoryStorage(t)
+
+	cl := NewClient()
+
+	revision, err := cl.LastDeployedRevision("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(revision).Should(BeEmpty())
+
+	err = cl.UpgradeRelease("hello", "testdata/chart", nil, []string{"param=one"}, "default")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	err = cl.UpgradeRelease("hello", "testdata/chart", nil, []string{"param=two"}, "default")
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	revision, err = cl.LastDeployedRevision("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(revision).Should(Equal("2"))
+
+	// Rollback creates a new revision with values of the target revision.
+	err = cl.RollbackRelease("hello", "1")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	revision, status, err := cl.LastReleaseStatus("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(revision).Should(Equal("3"))
+	g.Expect(status).Should(Equal("deployed"))
+	values, err := cl.GetReleaseValues("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(values).Should(Equal(utils.Values{"param": "one"}))
+
+	// Failed revision is not a deployed revision.
+	actionConfig.KubeClient = &kubefake.FailingKubeClient{
+		PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard},
+		UpdateError:        fmt.Errorf("update error"),
+	}
+	err = cl.UpgradeRelease("hello", "testdata/chart", nil, []string{"param=three"}, "default")
+	g.Expect(err).Should(HaveOccurred())
+	revision, status, err = cl.LastReleaseStatus("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(revision).Should(Equal("4"))
+	g.Expect(status).Should(Equal("failed"))
+	revision, err = cl.LastDeployedRevision("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(revision).Should(Equal("3"))
+}
+
+func TestLibClient_FailedFirstInstall(t *testing.T) {
+	g := NewWithT(t)
+	initMemoryStorage(t)
+
+	cl := NewClient()
+
+	failingClient := &kubefake.FailingKubeClient{
+		PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard},
+		CreateError:        fmt.Errorf("create error"),
+	}
+	printingClient := actionConfig.KubeClient
+
+	// First install fails and leaves a release without deployed revisions.
+	actionConfig.KubeClient = failingClient
+	err := cl.UpgradeRelease("hello", "testdata/chart", nil, []string{"param=one"}, "default")
+	g.Expect(err).Should(HaveOccurred())
+	revision, status, err := cl.LastReleaseStatus("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(revision).Should(Equal("1"))
+	g.Expect(status).Should(Equal("failed"))
+
+	// Next upgrade installs the release over the failed revision.
+	actionConfig.KubeClient = printingClient
+	err = cl.UpgradeRelease("hello", "testdata/chart", nil, []string{"param=two"}, "default")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	revision, status, err = cl.LastReleaseStatus("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(revision).Should(Equal("2"))
+	g.Expect(status).Should(Equal("deployed"))
+
+	// Deployed release is upgraded as usual.
+	err = cl.UpgradeRelease("hello", "testdata/chart", nil, []string{"param=three"}, "default")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	revision, err = cl.LastDeployedRevision("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(revision).Should(Equal("3"))
+
+	// Cleanup does not touch a release with deployed revisions.
+	err = cl.DeleteSingleFailedRevision("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	exists, err := cl.IsReleaseExists("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(exists).Should(BeTrue())
+
+	// Cleanup uninstalls a release that has never been deployed.
+	actionConfig.KubeClient = failingClient
+	err = cl.UpgradeRelease("world", "testdata/chart", nil, nil, "default")
+	g.Expect(err).Should(HaveOccurred())
+	actionConfig.KubeClient = printingClient
+	err = cl.DeleteSingleFailedRevision("world")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	exists, err = cl.IsReleaseExists("world")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(exists).Should(BeFalse())
+
+	err = cl.UpgradeRelease("world", "testdata/chart", nil, nil, "default")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	revision, status, err = cl.LastReleaseStatus("world")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(revision).Should(Equal("1"))
+	g.Expect(status).Should(Equal("deployed"))
+}
+
+func TestLibClient_Render(t *testing.T) {
+	g := NewWithT(t)
+	initMemoryStorage(t)
+
+	cl := NewClient()
+	out, err := cl.Render("hello", "testdata/chart", nil, []string{"param=rendered"}, "default")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(out).Should(ContainSubstring("kind: ConfigMap"))
+	g.Expect(out).Should(ContainSubstring(`param: "rendered"`))
+
+	// Render does not create releases.
+	exists, err := cl.IsReleaseExists("hello")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(exists).Should(BeFalse())
+}
@@ -13,6 +13,20 @@ type MockHelmClient struct {
 	UpgradeReleaseExecuted             bool
 	DeleteReleaseExecuted              bool
 	ReleaseNames                       []string
+
+	// Revision and status returned by LastReleaseStatus.
+	LastRevision string
+	LastStatus   string
+	// Revision returned by LastDeployedRevision.
+	DeployedRevision string
+	// Error returned by UpgradeRelease.
+	UpgradeReleaseError error
+	// Revision passed to RollbackRelease.
+	RolledBackRevision string
+	// Manifests returned by Render.
+	Manifests string
+	// Values returned by GetReleaseValues.
+	ReleaseValues utils.Values
 }
 
 func (h *MockHelmClient) DeleteOldFailedRevisions(releaseName string) error {
@@ -43,7 +57,11 @@ func (h *MockHelmClient) DeleteSingleFailedRevision(_ string) error {
 }
 
 func (h *MockHelmClient) LastReleaseStatus(_ string) (string, string, error) {
-	return "", "", nil
+	return h.LastRevision, h.LastStatus, nil
+}
+
+func (h *MockHelmClient) LastDeployedRevision(_ string) (string, error) {
+	return h.DeployedRevision, nil
 }
 
 func (h *MockHelmClient) IsReleaseExists(_ string) (bool, error) {
@@ -51,11 +69,23 @@ func (h *MockHelmClient) IsReleaseExists(_ string) (bool, error) {
 }
 
 func (h *MockHelmClient) GetReleaseValues(_ string) (utils.Values, error) {
+	if h.ReleaseValues != nil {
+		return h.ReleaseValues, nil
+	}
 	return make(utils.Values), nil
 }
 
+func (h *MockHelmClient) Render(_ string, _ string, _ []string, _ []string, _ string) (string, error) {
+	return h.Manifests, nil
+}
+
 func (h *MockHelmClient) UpgradeRelease(_, _ string, _ []string, _ []string, _ string) error {
 	h.UpgradeReleaseExecuted = true
+	return h.UpgradeReleaseError
+}
+
+func (h *MockHelmClient) RollbackRelease(_ string, revision string) error {
+	h.RolledBackRevision = revision
 	return nil
 }
 
@@ -0,0 +1,251 @@
+package helm_resources_manager
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/flant/shell-operator/pkg/utils/manifest"
+)
+
+// DriftPathWildcard matches any key or array index in the ignored path.
+const DriftPathWildcard = "*"
+
+// DriftIgnoreRule is a path of fields that are expected to change in live objects.
+// Rule format is "[Kind[/name]:]dotted.path", e.g. "spec.replicas",
+// "Deployment:spec.template.metadata.annotations" or "Secret/tls:*".
+type DriftIgnoreRule struct {
+	Kind string
+	Name string
+	Path []string
+}
+
+// DriftedField is a field of the resource that has a different value in the cluster.
+type DriftedField struct {
+	// Resource is an id of the resource: "namespace/Kind/name".
+	Resource string
+	Path     []string
+	// Live is a value of the field in the cluster, nil if the field is absent.
+	Live interface{}
+}
+
+func (f DriftedField) String() string {
+	return f.Resource + ":" + strings.Join(f.Path, ".")
+}
+
+// same returns true if the field is drifted to the same value.
+func (f DriftedField) same(other DriftedField) bool {
+	return f.Resource == other.Resource &&
+		reflect.DeepEqual(f.Path, other.Path) &&
+		reflect.DeepEqual(f.Live, other.Live)
+}
+
+// DriftOptions configures drift detection for resources of the release.
+type DriftOptions struct {
+	// Ignore is a list of rules for fields that are expected to change.
+	Ignore []DriftIgnoreRule
+	// Known is a list of fields left drifted after the last helm upgrade. Helm cannot
+	// restore them, e.g. fields dropped by the API server or changed by mutating webhooks,
+	// so they are not reported while their live values are unchanged.
+	Known []DriftedField
+	// ReportOnly is true if drifted resources are only logged. Absent resources are still reported.
+	ReportOnly bool
+}
+
+// isKnown returns true if the field is drifted the same way as one of the known fields.
+func (o DriftOptions) isKnown(field DriftedField) bool {
+	for _, known := range o.Known {
+		if known.same(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDriftIgnoreRules parses rules from the module definition.
+func ParseDriftIgnoreRules(rules []string) ([]DriftIgnoreRule, error) {
+	res := make([]DriftIgnoreRule, 0, len(rules))
+	for _, rule := range rules {
+		var r DriftIgnoreRule
+		path := rule
+		if idx := strings.Index(rule, ":"); idx >= 0 {
+			resourceId := rule[:idx]
+			path = rule[idx+1:]
+			parts := strings.SplitN(resourceId, "/", 2)
+			r.Kind = parts[0]
+			if len(parts) == 2 {
+				r.Name = parts[1]
+			}
+			if r.Kind == "" || (len(parts) == 2 && r.Name == "") {
+				return nil, fmt.Errorf("bad resource in drift ignore rule '%s'", rule)
+			}
+		}
+		if path == "" {
+			return nil, fmt.Errorf("drift ignore rule '%s' has no path", rule)
+		}
+		r.Path = strings.Split(path, ".")
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// matchResource returns true if the rule is for the resource.
+func (r DriftIgnoreRule) matchResource(kind, name string) bool {
+	if r.Kind != "" && r.Kind != kind {
+		return false
+	}
+	return r.Name == "" || r.Name == name
+}
+
+// matchPath returns true if the path is inside of the ignored path.
+func (r DriftIgnoreRule) matchPath(path []string) bool {
+	if len(path) < len(r.Path) {
+		return false
+	}
+	for i, token := range r.Path {
+		if token != DriftPathWildcard && token != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// DriftedFields returns dotted paths of fields from the rendered manifest that have
+// different values in the live object. Only fields defined in the chart are compared:
+// fields defaulted or added by Kubernetes are ignored as well as status and metadata
+// except labels and annotations.
+func DriftedFields(m manifest.Manifest, live map[string]interface{}, ignore []DriftIgnoreRule) []string {
+	res := make([]string, 0)
+	for _, field := range driftedFields(m, live, ignore) {
+		res = append(res, strings.Join(field.Path, "."))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// driftedFields returns fields from the rendered manifest with different values in the live object.
+// Resource is not set.
+func driftedFields(m manifest.Manifest, live map[string]interface{}, ignore []DriftIgnoreRule) []DriftedField {
+	rules := make([]DriftIgnoreRule, 0)
+	for _, rule := range ignore {
+		if rule.matchResource(m.Kind(), m.Name()) {
+			rules = append(rules, rule)
+		}
+	}
+
+	res := make([]DriftedField, 0)
+	for key, desired := range m {
+		switch key {
+		case "apiVersion", "kind", "status":
+			continue
+		case "metadata":
+			desiredMeta, _ := desired.(map[string]interface{})
+			liveMeta, _ := live["metadata"].(map[string]interface{})
+			for _, metaKey := range []string{"labels", "annotations"} {
+				compareFields([]string{"metadata", metaKey}, desiredMeta[metaKey], liveMeta[metaKey], rules, &res)
+			}
+			continue
+		case "stringData":
+			// Secret's stringData is write-only, it is stored in data.
+			if m.Kind() == "Secret" {
+				continue
+			}
+		}
+		compareFields([]string{key}, desired, live[key], rules, &res)
+	}
+
+	return res
+}
+
+func compareFields(path []string, desired interface{}, live interface{}, rules []DriftIgnoreRule, res *[]DriftedField) {
+	if desired == nil {
+		return
+	}
+	for _, rule := range rules {
+		if rule.matchPath(path) {
+			return
+		}
+	}
+
+	switch desiredValue := desired.(type) {
+	case map[string]interface{}:
+		if len(desiredValue) == 0 {
+			return
+		}
+		liveValue, ok := live.(map[string]interface{})
+		if !ok {
+			*res = append(*res, DriftedField{Path: path, Live: live})
+			return
+		}
+		for key, value := range desiredValue {
+			compareFields(appendPath(path, key), value, liveValue[key], rules, res)
+		}
+
+	case []interface{}:
+		liveValue, _ := live.([]interface{})
+		if len(desiredValue) != len(liveValue) {
+			*res = append(*res, DriftedField{Path: path, Live: live})
+			return
+		}
+		for i := range desiredValue {
+			compareFields(appendPath(path, strconv.Itoa(i)), desiredValue[i], liveValue[i], rules, res)
+		}
+
+	default:
+		if !scalarsEqual(desired, live) {
+			*res = append(*res, DriftedField{Path: path, Live: live})
+		}
+	}
+}
+
+func appendPath(path []string, key string) []string {
+	res := make([]string, len(path), len(path)+1)
+	copy(res, path)
+	return append(res, key)
+}
+
+// scalarsEqual compares scalars from YAML and from the API server: numbers
+// are compared regardless of type and quantities are compared by value, so "500m" equals 0.5.
+// An empty string, false or zero equals an absent field as the API server omits empty fields.
+func scalarsEqual(a interface{}, b interface{}) bool {
+	if b == nil {
+		return isEmptyScalar(a)
+	}
+	sa, sb := scalarString(a), scalarString(b)
+	if sa == sb {
+		return true
+	}
+	qa, errA := resource.ParseQuantity(sa)
+	qb, errB := resource.ParseQuantity(sb)
+	return errA == nil && errB == nil && qa.Cmp(qb) == 0
+}
+
+func scalarString(v interface{}) string {
+	switch value := v.(type) {
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case string:
+		return value
+	}
+	return fmt.Sprint(v)
+}
+
+func isEmptyScalar(v interface{}) bool {
+	switch value := v.(type) {
+	case string:
+		return value == ""
+	case bool:
+		return !value
+	case float64:
+		return value == 0
+	case int64:
+		return value == 0
+	case int:
+		return value == 0
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package helm_resources_manager
+
+import (
+	"testing"
+
+	"github.com/flant/shell-operator/pkg/kube/fake"
+	"github.com/flant/shell-operator/pkg/utils/manifest"
+	. "github.com/onsi/gomega"
+)
+
+func Test_ParseDriftIgnoreRules(t *testing.T) {
+	g := NewWithT(t)
+
+	rules, err := ParseDriftIgnoreRules([]string{"spec.replicas", "Deployment:spec.template.metadata.annotations", "Secret/tls:*"})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(rules).Should(Equal([]DriftIgnoreRule{
+		{Path: []string{"spec", "replicas"}},
+		{Kind: "Deployment", Path: []string{"spec", "template", "metadata", "annotations"}},
+		{Kind: "Secret", Name: "tls", Path: []string{"*"}},
+	}))
+
+	for _, bad := range []string{"", "Deployment:", ":spec", "Deployment/:spec"} {
+		_, err = ParseDriftIgnoreRules([]string{bad})
+		g.Expect(err).Should(HaveOccurred(), bad)
+	}
+}
+
+func Test_DriftedFields(t *testing.T) {
+	g := NewWithT(t)
+
+	desired := manifestFromYaml(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  labels: {app: backend}
+spec:
+  replicas: 2
+  template:
+    metadata:
+      annotations: {checksum: abc}
+    spec:
+      containers:
+      - name: backend
+        image: backend:v1
+        resources:
+          requests: {cpu: 0.5, memory: 128Mi}
+        ports:
+        - containerPort: 8080
+`)
+
+	// Live object with defaults, status and server metadata.
+	live := objectFromYaml(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  uid: 123
+  resourceVersion: "42"
+  labels: {app: backend}
+  annotations: {deployment.kubernetes.io/revision: "1"}
+spec:
+  replicas: 2
+  strategy: {type: RollingUpdate}
+  template:
+    metadata:
+      annotations: {checksum: abc}
+    spec:
+      containers:
+      - name: backend
+        image: backend:v1
+        imagePullPolicy: IfNotPresent
+        resources:
+          requests: {cpu: 500m, memory: 128Mi}
+        ports:
+        - containerPort: 8080
+          protocol: TCP
+status:
+  replicas: 2
+`).Object
+	g.Expect(DriftedFields(desired, live, nil)).Should(BeEmpty())
+
+	// kubectl edit and kubectl scale.
+	live = objectFromYaml(t, `
+metadata:
+  labels: {app: backend-edited}
+spec:
+  replicas: 5
+  template:
+    metadata:
+      annotations: {checksum: abc}
+    spec:
+      containers:
+      - name: backend
+        image: backend:v2
+        resources:
+          requests: {cpu: 500m, memory: 128Mi}
+        ports:
+        - containerPort: 8080
+      - name: sidecar
+`).Object
+	g.Expect(DriftedFields(desired, live, nil)).Should(Equal([]string{
+		"metadata.labels.app",
+		"spec.replicas",
+		"spec.template.spec.containers",
+	}))
+
+	rules, err := ParseDriftIgnoreRules([]string{"Deployment/backend:spec.replicas", "spec.template.spec.containers", "Service:metadata"})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(DriftedFields(desired, live, rules)).Should(Equal([]string{"metadata.labels.app"}))
+
+	// Secret's stringData is not compared.
+	secret := manifestFromYaml(t, `
+apiVersion: v1
+kind: Secret
+metadata: {name: creds}
+stringData: {password: secret}
+`)
+	g.Expect(DriftedFields(secret, objectFromYaml(t, `data: {password: c2VjcmV0}`).Object, nil)).Should(BeEmpty())
+
+	// Empty values are omitted by the API server.
+	service := manifestFromYaml(t, `
+apiVersion: v1
+kind: Service
+metadata: {name: backend}
+spec:
+  clusterIP: ""
+  publishNotReadyAddresses: false
+  ports:
+  - port: 80
+    nodePort: 0
+`)
+	g.Expect(DriftedFields(service, objectFromYaml(t, `spec: {ports: [{port: 80}]}`).Object, nil)).Should(BeEmpty())
+	g.Expect(DriftedFields(service, objectFromYaml(t, `spec: {ports: [{port: 80}], publishNotReadyAddresses: true}`).Object, nil)).
+		Should(Equal([]string{"spec.publishNotReadyAddresses"}))
+}
+
+func Test_CheckResources_Drifted(t *testing.T) {
+	g := NewWithT(t)
+
+	fc := fake.NewFakeCluster()
+	fc.CreateNs("default")
+
+	rendered := manifestFromYaml(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: production
+`)
+	edited := manifestFromYaml(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: debug
+`)
+	absent := manifestFromYaml(t, `
+apiVersion: v1
+kind: Service
+metadata:
+  name: backend
+`)
+	g.Expect(fc.Create("default", rendered)).Should(Succeed())
+
+	mgr := NewHelmResourcesManager()
+	mgr.WithKubeClient(fc.KubeClient)
+
+	absentList, driftedList, err := mgr.GetAbsentAndDriftedResources([]manifest.Manifest{rendered, absent}, "default", DriftOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(absentList).Should(Equal([]manifest.Manifest{absent}))
+	g.Expect(driftedList).Should(BeEmpty())
+
+	g.Expect(fc.Update("default", edited)).Should(Succeed())
+	absentList, driftedList, err = mgr.GetAbsentAndDriftedResources([]manifest.Manifest{rendered}, "default", DriftOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(absentList).Should(BeEmpty())
+	g.Expect(driftedList).Should(Equal([]manifest.Manifest{rendered}))
+
+	rules, _ := ParseDriftIgnoreRules([]string{"ConfigMap:data.mode"})
+	_, driftedList, err = mgr.GetAbsentAndDriftedResources([]manifest.Manifest{rendered}, "default", DriftOptions{Ignore: rules})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(driftedList).Should(BeEmpty())
+
+	// Drift is only logged in report only mode.
+	_, driftedList, err = mgr.GetAbsentAndDriftedResources([]manifest.Manifest{rendered}, "default", DriftOptions{ReportOnly: true})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(driftedList).Should(BeEmpty())
+
+	// Drift left after upgrade is not reported while the live value is unchanged.
+	known, err := mgr.GetDriftedFields([]manifest.Manifest{rendered}, "default", nil)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(known).Should(Equal([]DriftedField{
+		{Resource: "default/ConfigMap/settings", Path: []string{"data", "mode"}, Live: "debug"},
+	}))
+	_, driftedList, err = mgr.GetAbsentAndDriftedResources([]manifest.Manifest{rendered}, "default", DriftOptions{Known: known})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(driftedList).Should(BeEmpty())
+
+	g.Expect(fc.Update("default", manifestFromYaml(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: trace
+`))).Should(Succeed())
+	_, driftedList, err = mgr.GetAbsentAndDriftedResources([]manifest.Manifest{rendered}, "default", DriftOptions{Known: known})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(driftedList).Should(Equal([]manifest.Manifest{rendered}))
+}
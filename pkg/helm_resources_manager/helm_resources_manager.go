@@ -2,6 +2,8 @@ package helm_resources_manager
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -19,7 +21,7 @@ type HelmResourcesManager interface {
 	StopMonitors()
 	PauseMonitors()
 	ResumeMonitors()
-	StartMonitor(moduleName string, manifests []manifest.Manifest, defaultNamespace string)
+	StartMonitor(moduleName string, manifests []manifest.Manifest, defaultNamespace string, drift DriftOptions)
 	HasMonitor(moduleName string) bool
 	StopMonitor(moduleName string)
 	PauseMonitor(moduleName string)
@@ -27,6 +29,10 @@ type HelmResourcesManager interface {
 	AbsentResources(moduleName string) ([]manifest.Manifest, error)
 	GetMonitor(moduleName string) *ResourcesMonitor
 	GetAbsentResources(templates []manifest.Manifest, defaultNamespace string) ([]manifest.Manifest, error)
+	GetAbsentAndDriftedResources(templates []manifest.Manifest, defaultNamespace string, drift DriftOptions) ([]manifest.Manifest, []manifest.Manifest, error)
+	GetDriftedFields(templates []manifest.Manifest, defaultNamespace string, driftIgnore []DriftIgnoreRule) ([]DriftedField, error)
+	NotReadyResources(manifests []manifest.Manifest, defaultNamespace string) ([]string, error)
+	WaitForReady(manifests []manifest.Manifest, defaultNamespace string, timeout time.Duration) error
 	Ch() chan AbsentResourcesEvent
 }
 
@@ -38,7 +44,11 @@ type helmResourcesManager struct {
 
 	kubeClient kube.KubernetesClient
 
-	monitors map[string]*ResourcesMonitor
+	monitors   map[string]*ResourcesMonitor
+	monitorsMu sync.RWMutex
+
+	// Informers are shared by monitors of all modules.
+	informers *resourceInformers
 
 	eventCh chan AbsentResourcesEvent
 }
@@ -74,9 +84,16 @@ func (hm *helmResourcesManager) Ch() chan AbsentResourcesEvent {
 	return hm.eventCh
 }
 
-func (hm *helmResourcesManager) StartMonitor(moduleName string, manifests []manifest.Manifest, defaultNamespace string) {
+func (hm *helmResourcesManager) StartMonitor(moduleName string, manifests []manifest.Manifest, defaultNamespace string, drift DriftOptions) {
 	log.Debugf("Start helm resources monitor for '%s'", moduleName)
-	hm.StopMonitor(moduleName)
+	hm.monitorsMu.Lock()
+	defer hm.monitorsMu.Unlock()
+
+	hm.stopMonitor(moduleName)
+
+	if hm.informers == nil {
+		hm.informers = newResourceInformers(hm.ctx, hm.kubeClient)
+	}
 
 	rm := NewResourcesMonitor()
 	rm.WithKubeClient(hm.kubeClient)
@@ -84,42 +101,60 @@ func (hm *helmResourcesManager) StartMonitor(moduleName string, manifests []mani
 	rm.WithModuleName(moduleName)
 	rm.WithManifests(manifests)
 	rm.WithDefaultNamespace(defaultNamespace)
+	rm.WithDrift(drift)
+	rm.WithInformers(hm.informers)
 	rm.WithAbsentCb(hm.absentResourcesCallback)
 
 	hm.monitors[moduleName] = rm
 	rm.Start()
 }
 
-func (hm *helmResourcesManager) absentResourcesCallback(moduleName string, absent []manifest.Manifest, defaultNs string) {
-	log.Debugf("Detect absent resources for %s", moduleName)
+func (hm *helmResourcesManager) absentResourcesCallback(moduleName string, absent []manifest.Manifest, drifted []manifest.Manifest, defaultNs string) {
+	log.Debugf("Detect absent or drifted resources for %s", moduleName)
 	for _, m := range absent {
-		log.Debugf("%s/%s/%s", m.Namespace(defaultNs), m.Kind(), m.Name())
+		log.Debugf("absent %s/%s/%s", m.Namespace(defaultNs), m.Kind(), m.Name())
+	}
+	for _, m := range drifted {
+		log.Debugf("drifted %s/%s/%s", m.Namespace(defaultNs), m.Kind(), m.Name())
 	}
 	hm.eventCh <- AbsentResourcesEvent{
 		ModuleName: moduleName,
 		Absent:     absent,
+		Drifted:    drifted,
 	}
 }
 
 func (hm *helmResourcesManager) StopMonitors() {
+	hm.monitorsMu.Lock()
+	defer hm.monitorsMu.Unlock()
 	for moduleName := range hm.monitors {
-		hm.StopMonitor(moduleName)
+		hm.stopMonitor(moduleName)
 	}
 }
 
 func (hm *helmResourcesManager) PauseMonitors() {
+	hm.monitorsMu.RLock()
+	defer hm.monitorsMu.RUnlock()
 	for _, monitor := range hm.monitors {
 		monitor.Pause()
 	}
 }
 
 func (hm *helmResourcesManager) ResumeMonitors() {
+	hm.monitorsMu.RLock()
+	defer hm.monitorsMu.RUnlock()
 	for _, monitor := range hm.monitors {
 		monitor.Resume()
 	}
 }
 
 func (hm *helmResourcesManager) StopMonitor(moduleName string) {
+	hm.monitorsMu.Lock()
+	defer hm.monitorsMu.Unlock()
+	hm.stopMonitor(moduleName)
+}
+
+func (hm *helmResourcesManager) stopMonitor(moduleName string) {
 	if monitor, ok := hm.monitors[moduleName]; ok {
 		monitor.Stop()
 		delete(hm.monitors, moduleName)
@@ -127,30 +162,31 @@ func (hm *helmResourcesManager) StopMonitor(moduleName string) {
 }
 
 func (hm *helmResourcesManager) PauseMonitor(moduleName string) {
-	if monitor, ok := hm.monitors[moduleName]; ok {
+	if monitor := hm.GetMonitor(moduleName); monitor != nil {
 		monitor.Pause()
 	}
 }
 
 func (hm *helmResourcesManager) ResumeMonitor(moduleName string) {
-	if monitor, ok := hm.monitors[moduleName]; ok {
+	if monitor := hm.GetMonitor(moduleName); monitor != nil {
 		monitor.Resume()
 	}
 }
 
 func (hm *helmResourcesManager) HasMonitor(moduleName string) bool {
-	_, ok := hm.monitors[moduleName]
-	return ok
+	return hm.GetMonitor(moduleName) != nil
 }
 
 func (hm *helmResourcesManager) AbsentResources(moduleName string) ([]manifest.Manifest, error) {
-	if monitor, ok := hm.monitors[moduleName]; ok {
+	if monitor := hm.GetMonitor(moduleName); monitor != nil {
 		return monitor.AbsentResources()
 	}
 	return nil, nil
 }
 
 func (hm *helmResourcesManager) GetMonitor(moduleName string) *ResourcesMonitor {
+	hm.monitorsMu.RLock()
+	defer hm.monitorsMu.RUnlock()
 	return hm.monitors[moduleName]
 }
 
@@ -161,3 +197,22 @@ func (hm *helmResourcesManager) GetAbsentResources(manifests []manifest.Manifest
 	rm.WithDefaultNamespace(defaultNamespace)
 	return rm.AbsentResources()
 }
+
+func (hm *helmResourcesManager) GetAbsentAndDriftedResources(manifests []manifest.Manifest, defaultNamespace string, drift DriftOptions) ([]manifest.Manifest, []manifest.Manifest, error) {
+	rm := NewResourcesMonitor()
+	rm.WithKubeClient(hm.kubeClient)
+	rm.WithManifests(manifests)
+	rm.WithDefaultNamespace(defaultNamespace)
+	rm.WithDrift(drift)
+	return rm.CheckResources()
+}
+
+// GetDriftedFields returns fields of resources that have different values in the cluster.
+func (hm *helmResourcesManager) GetDriftedFields(manifests []manifest.Manifest, defaultNamespace string, driftIgnore []DriftIgnoreRule) ([]DriftedField, error) {
+	rm := NewResourcesMonitor()
+	rm.WithKubeClient(hm.kubeClient)
+	rm.WithManifests(manifests)
+	rm.WithDefaultNamespace(defaultNamespace)
+	rm.WithDrift(DriftOptions{Ignore: driftIgnore})
+	return rm.DriftedFields()
+}
@@ -1,12 +1,15 @@
 package helm_resources_manager
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
 	"github.com/flant/shell-operator/pkg/kube/fake"
 	"github.com/flant/shell-operator/pkg/utils/manifest"
 	. "github.com/onsi/gomega"
+
+	. "github.com/flant/addon-operator/pkg/helm_resources_manager/types"
 )
 
 // Problem: fake client do not support metadata.name filtering
@@ -70,6 +73,77 @@ metadata:
 
 }
 
+func Test_ResourcesMonitor_Events(t *testing.T) {
+	g := NewWithT(t)
+
+	fc := fake.NewFakeCluster()
+	fc.CreateNs("default")
+
+	rendered := manifestFromYaml(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: production
+`)
+	edited := manifestFromYaml(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: debug
+`)
+	g.Expect(fc.Create("default", rendered)).Should(Succeed())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr := NewHelmResourcesManager()
+	mgr.WithContext(ctx)
+	mgr.WithKubeClient(fc.KubeClient)
+	mgr.StartMonitor("module", []manifest.Manifest{rendered}, "default", DriftOptions{})
+
+	monitor := mgr.GetMonitor("module")
+	g.Eventually(func() error {
+		_, err := monitor.cachedObject(rendered)
+		return err
+	}, "5s", "10ms").Should(Succeed())
+
+	// Changes are detected without waiting for the timer.
+	g.Expect(fc.Update("default", edited)).Should(Succeed())
+	var event AbsentResourcesEvent
+	g.Eventually(mgr.Ch(), "5s").Should(Receive(&event))
+	g.Expect(event.ModuleName).Should(Equal("module"))
+	g.Expect(event.Absent).Should(BeEmpty())
+	g.Expect(event.Drifted).Should(Equal([]manifest.Manifest{rendered}))
+
+	// Paused monitor ignores changes.
+	mgr.PauseMonitor("module")
+	g.Expect(fc.Delete("default", edited)).Should(Succeed())
+	g.Consistently(mgr.Ch(), "200ms").ShouldNot(Receive())
+
+	mgr.ResumeMonitor("module")
+	g.Expect(fc.Create("default", rendered)).Should(Succeed())
+	g.Consistently(mgr.Ch(), "200ms").ShouldNot(Receive())
+	g.Expect(fc.Delete("default", rendered)).Should(Succeed())
+	g.Eventually(mgr.Ch(), "5s").Should(Receive(&event))
+	g.Expect(event.Absent).Should(Equal([]manifest.Manifest{rendered}))
+
+	// Informer is stopped with the last monitor.
+	informers := mgr.(*helmResourcesManager).informers
+	informersCount := func() int {
+		informers.m.Lock()
+		defer informers.m.Unlock()
+		return len(informers.informers)
+	}
+	g.Expect(informersCount()).Should(Equal(1))
+	mgr.StopMonitor("module")
+	g.Expect(mgr.HasMonitor("module")).Should(BeFalse())
+	g.Eventually(informersCount, "5s", "10ms").Should(Equal(0))
+}
+
 func createResource(fc *fake.FakeCluster, ns, manifestYaml string) manifest.Manifest {
 	manifests, err := manifest.GetManifestListFromYamlDocuments(manifestYaml)
 	if err != nil {
@@ -0,0 +1,202 @@
+package helm_resources_manager
+
+import (
+	"context"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/shell-operator/pkg/kube"
+	"github.com/flant/shell-operator/pkg/utils/manifest"
+)
+
+// informerKey identifies a shared informer for objects of one resource in one namespace.
+// Namespace is empty for cluster scoped resources. Selectors limit cached objects
+// to objects of releases.
+type informerKey struct {
+	gvr           schema.GroupVersionResource
+	namespace     string
+	fieldSelector string
+	labelSelector string
+}
+
+// releaseLabels are labels with the release name recommended for helm charts.
+var releaseLabels = []string{"app.kubernetes.io/instance", "release"}
+
+// filteredInformerKey returns a key of the informer for the object of the manifest.
+// group is manifests of the release with the same resource and namespace as in the key.
+// Informers cache only objects of releases and are shared by objects of the resource:
+// objects are selected by the release label common for the group or by the release label
+// of the object. Objects without release labels are watched by names.
+func filteredInformerKey(key informerKey, m manifest.Manifest, group []manifest.Manifest) informerKey {
+	for _, label := range releaseLabels {
+		if value := commonLabel(group, label); value != "" {
+			key.labelSelector = labels.SelectorFromSet(labels.Set{label: value}).String()
+			return key
+		}
+	}
+	for _, label := range releaseLabels {
+		if value := manifestLabel(m, label); value != "" {
+			key.labelSelector = labels.SelectorFromSet(labels.Set{label: value}).String()
+			return key
+		}
+	}
+	key.fieldSelector = fields.OneTermEqualSelector("metadata.name", m.Name()).String()
+	return key
+}
+
+// commonLabel returns a value of the label if it is the same for all manifests.
+func commonLabel(group []manifest.Manifest, label string) string {
+	value := manifestLabel(group[0], label)
+	for _, other := range group[1:] {
+		if manifestLabel(other, label) != value {
+			return ""
+		}
+	}
+	return value
+}
+
+func manifestLabel(m manifest.Manifest, label string) string {
+	manifestLabels, _ := m.Metadata()["labels"].(map[string]interface{})
+	value, _ := manifestLabels[label].(string)
+	return value
+}
+
+// objectRef is an informer and a cache key of the object.
+type objectRef struct {
+	informer informerKey
+	key      string
+}
+
+type sharedInformer struct {
+	informer cache.SharedIndexInformer
+	cancel   context.CancelFunc
+	// Monitors that are notified about changes of their objects by object keys.
+	monitors map[string]map[*ResourcesMonitor]struct{}
+}
+
+// resourceInformers is a set of dynamic informers shared by all monitors.
+// An informer is started by the first subscribed monitor and is stopped
+// when the last monitor is unsubscribed, so the API server load depends
+// on the number of resource types and namespaces, not on the number of resources.
+type resourceInformers struct {
+	m          sync.Mutex
+	ctx        context.Context
+	kubeClient kube.KubernetesClient
+	informers  map[informerKey]*sharedInformer
+}
+
+func newResourceInformers(ctx context.Context, kubeClient kube.KubernetesClient) *resourceInformers {
+	return &resourceInformers{
+		ctx:        ctx,
+		kubeClient: kubeClient,
+		informers:  make(map[informerKey]*sharedInformer),
+	}
+}
+
+// Subscribe starts an informer for the object if needed and adds monitor to receivers of the object changes.
+func (ri *resourceInformers) Subscribe(ref objectRef, monitor *ResourcesMonitor) {
+	ri.m.Lock()
+	defer ri.m.Unlock()
+
+	si, ok := ri.informers[ref.informer]
+	if !ok {
+		si = ri.start(ref.informer)
+		ri.informers[ref.informer] = si
+	}
+	if si.monitors[ref.key] == nil {
+		si.monitors[ref.key] = make(map[*ResourcesMonitor]struct{})
+	}
+	si.monitors[ref.key][monitor] = struct{}{}
+}
+
+// Unsubscribe removes monitor from all informers and stops informers without monitors.
+func (ri *resourceInformers) Unsubscribe(monitor *ResourcesMonitor) {
+	ri.m.Lock()
+	defer ri.m.Unlock()
+
+	for key, si := range ri.informers {
+		for objKey, monitors := range si.monitors {
+			delete(monitors, monitor)
+			if len(monitors) == 0 {
+				delete(si.monitors, objKey)
+			}
+		}
+		if len(si.monitors) == 0 {
+			si.cancel()
+			delete(ri.informers, key)
+		}
+	}
+}
+
+// Get returns an object from the informer cache. Object is nil if it is absent.
+// synced is false if informer is not started or its cache is not filled yet.
+func (ri *resourceInformers) Get(ref objectRef) (obj *unstructured.Unstructured, synced bool, err error) {
+	ri.m.Lock()
+	si, ok := ri.informers[ref.informer]
+	ri.m.Unlock()
+	if !ok || !si.informer.HasSynced() {
+		return nil, false, nil
+	}
+
+	item, exists, err := si.informer.GetStore().GetByKey(ref.key)
+	if err != nil || !exists {
+		return nil, true, err
+	}
+	obj, _ = item.(*unstructured.Unstructured)
+	return obj, true, nil
+}
+
+func (ri *resourceInformers) start(key informerKey) *sharedInformer {
+	ctx, cancel := context.WithCancel(ri.ctx)
+
+	tweakListOptions := func(options *metav1.ListOptions) {
+		options.FieldSelector = key.fieldSelector
+		options.LabelSelector = key.labelSelector
+	}
+	// No resync: monitors recheck the cache periodically.
+	informer := dynamicinformer.NewFilteredDynamicInformer(ri.kubeClient.Dynamic(), key.gvr, key.namespace, 0, cache.Indexers{}, tweakListOptions).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			ri.notify(key, obj)
+		},
+		UpdateFunc: func(_ interface{}, obj interface{}) {
+			ri.notify(key, obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			ri.notify(key, obj)
+		},
+	})
+	go informer.Run(ctx.Done())
+
+	return &sharedInformer{
+		informer: informer,
+		cancel:   cancel,
+		monitors: make(map[string]map[*ResourcesMonitor]struct{}),
+	}
+}
+
+// notify triggers a check in monitors of the changed object.
+func (ri *resourceInformers) notify(key informerKey, obj interface{}) {
+	objKey, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+
+	ri.m.Lock()
+	defer ri.m.Unlock()
+
+	si, ok := ri.informers[key]
+	if !ok {
+		return
+	}
+	for monitor := range si.monitors[objKey] {
+		monitor.trigger()
+	}
+}
@@ -0,0 +1,100 @@
+package helm_resources_manager
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/flant/shell-operator/pkg/kube/fake"
+	"github.com/flant/shell-operator/pkg/utils/manifest"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_FilteredInformerKey(t *testing.T) {
+	g := NewWithT(t)
+
+	configMap := func(name string, labels string) manifest.Manifest {
+		return manifestFromYaml(t, fmt.Sprintf(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: %s
+  labels: {%s}
+`, name, labels))
+	}
+	group := func(count int, labels func(i int) string) []manifest.Manifest {
+		res := make([]manifest.Manifest, 0, count)
+		for i := 0; i < count; i++ {
+			res = append(res, configMap(fmt.Sprintf("cm-%d", i), labels(i)))
+		}
+		return res
+	}
+	key := informerKey{namespace: "default"}
+
+	// Objects without release labels are watched by names.
+	few := group(2, func(int) string { return "" })
+	g.Expect(filteredInformerKey(key, few[1], few)).Should(Equal(informerKey{namespace: "default", fieldSelector: "metadata.name=cm-1"}))
+
+	// Objects are selected by the common release label.
+	single := group(1, func(int) string { return "app.kubernetes.io/instance: module" })
+	g.Expect(filteredInformerKey(key, single[0], single)).Should(Equal(informerKey{namespace: "default", labelSelector: "app.kubernetes.io/instance=module"}))
+
+	labeled := group(5, func(int) string { return "app.kubernetes.io/instance: module, app: x" })
+	g.Expect(filteredInformerKey(key, labeled[0], labeled)).Should(Equal(informerKey{namespace: "default", labelSelector: "app.kubernetes.io/instance=module"}))
+
+	mixed := group(5, func(i int) string { return fmt.Sprintf("app.kubernetes.io/instance: module-%d, release: module", i) })
+	g.Expect(filteredInformerKey(key, mixed[0], mixed)).Should(Equal(informerKey{namespace: "default", labelSelector: "release=module"}))
+
+	// Without the common label, objects are selected by their own release labels.
+	different := group(5, func(i int) string { return fmt.Sprintf("release: module-%d", i) })
+	g.Expect(filteredInformerKey(key, different[2], different)).Should(Equal(informerKey{namespace: "default", labelSelector: "release=module-2"}))
+}
+
+func Test_ResourcesMonitor_InformersNumber(t *testing.T) {
+	g := NewWithT(t)
+
+	fc := fake.NewFakeCluster()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manifests := make([]manifest.Manifest, 0)
+	for _, kind := range []string{"ConfigMap", "Service", "Pod"} {
+		for i := 0; i < 5; i++ {
+			manifests = append(manifests, manifestFromYaml(t, fmt.Sprintf(`
+apiVersion: v1
+kind: %s
+metadata:
+  name: obj-%d
+  labels:
+    app.kubernetes.io/instance: module
+`, kind, i)))
+		}
+	}
+	manifests = append(manifests, manifestFromYaml(t, `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: unlabeled
+`))
+
+	informers := newResourceInformers(ctx, fc.KubeClient)
+	rm := NewResourcesMonitor()
+	rm.WithContext(ctx)
+	rm.WithKubeClient(fc.KubeClient)
+	rm.WithModuleName("module")
+	rm.WithDefaultNamespace("default")
+	rm.WithManifests(manifests)
+	rm.WithInformers(informers)
+	rm.subscribe(log.WithField("test", t.Name()))
+
+	// One informer for each kind: the number of informers does not depend on the number of objects.
+	g.Expect(informers.informers).Should(HaveLen(4))
+	for key := range informers.informers {
+		if key.gvr.Resource == "secrets" {
+			g.Expect(key.fieldSelector).Should(Equal("metadata.name=unlabeled"))
+			continue
+		}
+		g.Expect(key.labelSelector).Should(Equal("app.kubernetes.io/instance=module"))
+	}
+}
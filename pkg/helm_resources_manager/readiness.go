@@ -0,0 +1,217 @@
+package helm_resources_manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/flant/shell-operator/pkg/utils/manifest"
+)
+
+// readinessCheckInterval is a delay between readiness checks in WaitForReady.
+var readinessCheckInterval = 5 * time.Second
+
+// HelmHookAnnotation marks manifests of helm hooks. Hooks are not part of the release:
+// test hooks are not created and hook resources can be deleted by the hook-delete-policy.
+const HelmHookAnnotation = "helm.sh/hook"
+
+// WaitForReady checks resources from manifests until all of them are ready or timeout is reached.
+// See ObjectReadiness for readiness rules. Error is returned on timeout with
+// a list of not ready resources or immediately if the resource cannot become ready (e.g. Job is failed).
+func (hm *helmResourcesManager) WaitForReady(manifests []manifest.Manifest, defaultNamespace string, timeout time.Duration) error {
+	parent := hm.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	var notReady []string
+	var checkErr error
+	err := wait.PollImmediateUntil(readinessCheckInterval, func() (bool, error) {
+		notReady, checkErr = hm.NotReadyResources(manifests, defaultNamespace)
+		if checkErr != nil {
+			// Stop waiting if resource will never be ready.
+			return false, checkErr
+		}
+		if len(notReady) > 0 {
+			log.Debugf("Wait for %d not ready resources: %s", len(notReady), strings.Join(notReady, ", "))
+		}
+		return len(notReady) == 0, nil
+	}, ctx.Done())
+
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("resources are not ready after %s: %s", timeout, strings.Join(notReady, ", "))
+	}
+	return err
+}
+
+// NotReadyResources returns descriptions of resources that are not ready.
+// Manifests of helm hooks are ignored.
+func (hm *helmResourcesManager) NotReadyResources(manifests []manifest.Manifest, defaultNamespace string) ([]string, error) {
+	res := make([]string, 0)
+
+	for _, m := range manifests {
+		if IsHelmHook(m) {
+			continue
+		}
+		id := fmt.Sprintf("%s/%s/%s", m.Namespace(defaultNamespace), m.Kind(), m.Name())
+
+		apiRes, err := hm.kubeClient.APIResource(m.ApiVersion(), m.Kind())
+		if err != nil {
+			return nil, err
+		}
+		gvr := schema.GroupVersionResource{
+			Group:    apiRes.Group,
+			Version:  apiRes.Version,
+			Resource: apiRes.Name,
+		}
+
+		var obj *unstructured.Unstructured
+		if apiRes.Namespaced {
+			obj, err = hm.kubeClient.Dynamic().Resource(gvr).Namespace(m.Namespace(defaultNamespace)).Get(m.Name(), v1.GetOptions{})
+		} else {
+			obj, err = hm.kubeClient.Dynamic().Resource(gvr).Get(m.Name(), v1.GetOptions{})
+		}
+		if errors.IsNotFound(err) {
+			res = append(res, fmt.Sprintf("%s: not found", id))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get helm resource %s: %s", id, err)
+		}
+
+		ready, reason, err := ObjectReadiness(obj)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", id, err)
+		}
+		if !ready {
+			res = append(res, fmt.Sprintf("%s: %s", id, reason))
+		}
+	}
+
+	return res, nil
+}
+
+// IsHelmHook returns true if the manifest has the helm hook annotation.
+func IsHelmHook(m manifest.Manifest) bool {
+	annotations, _ := m.Metadata()["annotations"].(map[string]interface{})
+	_, has := annotations[HelmHookAnnotation]
+	return has
+}
+
+// ObjectReadiness returns true if the object is ready or a reason why it is not ready:
+//  - Deployment, StatefulSet, DaemonSet are rolled out: status is observed, all replicas are updated and available.
+//  - Job is complete. Error is returned for the failed Job.
+//  - CustomResourceDefinition is established.
+// Objects of other kinds are ready if exist.
+func ObjectReadiness(obj *unstructured.Unstructured) (bool, string, error) {
+	if obj.GetKind() != "CustomResourceDefinition" && obj.GetKind() != "Job" {
+		generation := nestedInt64(obj, 0, "metadata", "generation")
+		observedGeneration := nestedInt64(obj, -1, "status", "observedGeneration")
+		if observedGeneration >= 0 && observedGeneration < generation {
+			return false, fmt.Sprintf("generation %d is not observed yet", generation), nil
+		}
+	}
+
+	switch obj.GetKind() {
+	case "Deployment":
+		replicas := nestedInt64(obj, 1, "spec", "replicas")
+		updated := nestedInt64(obj, 0, "status", "updatedReplicas")
+		total := nestedInt64(obj, 0, "status", "replicas")
+		available := nestedInt64(obj, 0, "status", "availableReplicas")
+		if updated < replicas {
+			return false, fmt.Sprintf("%d of %d replicas are updated", updated, replicas), nil
+		}
+		if total > updated {
+			return false, fmt.Sprintf("%d old replicas are pending termination", total-updated), nil
+		}
+		if available < replicas {
+			return false, fmt.Sprintf("%d of %d updated replicas are available", available, replicas), nil
+		}
+
+	case "StatefulSet":
+		replicas := nestedInt64(obj, 1, "spec", "replicas")
+		strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type")
+		if strategy == "" || strategy == "RollingUpdate" {
+			partition := nestedInt64(obj, 0, "spec", "updateStrategy", "rollingUpdate", "partition")
+			updated := nestedInt64(obj, 0, "status", "updatedReplicas")
+			if updated < replicas-partition {
+				return false, fmt.Sprintf("%d of %d replicas are updated", updated, replicas-partition), nil
+			}
+		}
+		ready := nestedInt64(obj, 0, "status", "readyReplicas")
+		if ready < replicas {
+			return false, fmt.Sprintf("%d of %d replicas are ready", ready, replicas), nil
+		}
+
+	case "DaemonSet":
+		desired := nestedInt64(obj, 0, "status", "desiredNumberScheduled")
+		strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type")
+		if strategy == "" || strategy == "RollingUpdate" {
+			updated := nestedInt64(obj, 0, "status", "updatedNumberScheduled")
+			if updated < desired {
+				return false, fmt.Sprintf("%d of %d pods are updated", updated, desired), nil
+			}
+		}
+		available := nestedInt64(obj, 0, "status", "numberAvailable")
+		if available < desired {
+			return false, fmt.Sprintf("%d of %d pods are available", available, desired), nil
+		}
+
+	case "Job":
+		if status, message := conditionStatus(obj, "Failed"); status == "True" {
+			return false, "", fmt.Errorf("job is failed: %s", message)
+		}
+		if status, _ := conditionStatus(obj, "Complete"); status != "True" {
+			return false, "job is not complete", nil
+		}
+
+	case "CustomResourceDefinition":
+		if status, _ := conditionStatus(obj, "Established"); status != "True" {
+			return false, "not established", nil
+		}
+	}
+
+	return true, "", nil
+}
+
+// nestedInt64 returns an integer field or the default value if field is not set.
+func nestedInt64(obj *unstructured.Unstructured, defaultValue int64, fields ...string) int64 {
+	value, found, err := unstructured.NestedFieldNoCopy(obj.Object, fields...)
+	if !found || err != nil {
+		return defaultValue
+	}
+	switch v := value.(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+	return defaultValue
+}
+
+// conditionStatus returns status and message of the condition from status.conditions.
+func conditionStatus(obj *unstructured.Unstructured, conditionType string) (string, string) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, item := range conditions {
+		condition, ok := item.(map[string]interface{})
+		if !ok || condition["type"] != conditionType {
+			continue
+		}
+		status, _ := condition["status"].(string)
+		message, _ := condition["message"].(string)
+		return status, message
+	}
+	return "", ""
+}
@@ -0,0 +1,192 @@
+package helm_resources_manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flant/shell-operator/pkg/kube/fake"
+	"github.com/flant/shell-operator/pkg/utils/manifest"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func objectFromYaml(t *testing.T, objYaml string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	err := yaml.Unmarshal([]byte(objYaml), &obj.Object)
+	NewWithT(t).Expect(err).ShouldNot(HaveOccurred())
+	return obj
+}
+
+func manifestFromYaml(t *testing.T, objYaml string) manifest.Manifest {
+	manifests, err := manifest.GetManifestListFromYamlDocuments(objYaml)
+	g := NewWithT(t)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(manifests).Should(HaveLen(1))
+	return manifests[0]
+}
+
+func Test_ObjectReadiness(t *testing.T) {
+	g := NewWithT(t)
+
+	tests := []struct {
+		name    string
+		obj     string
+		ready   bool
+		wantErr bool
+	}{
+		{"deployment ready", `
+kind: Deployment
+metadata: {generation: 2}
+spec: {replicas: 2}
+status: {observedGeneration: 2, replicas: 2, updatedReplicas: 2, availableReplicas: 2}
+`, true, false},
+		{"deployment not observed", `
+kind: Deployment
+metadata: {generation: 3}
+spec: {replicas: 2}
+status: {observedGeneration: 2, replicas: 2, updatedReplicas: 2, availableReplicas: 2}
+`, false, false},
+		{"deployment rolling out", `
+kind: Deployment
+metadata: {generation: 2}
+spec: {replicas: 2}
+status: {observedGeneration: 2, replicas: 3, updatedReplicas: 2, availableReplicas: 2}
+`, false, false},
+		{"statefulset with partition", `
+kind: StatefulSet
+spec: {replicas: 3, updateStrategy: {type: RollingUpdate, rollingUpdate: {partition: 2}}}
+status: {updatedReplicas: 1, readyReplicas: 3}
+`, true, false},
+		{"statefulset not ready", `
+kind: StatefulSet
+spec: {replicas: 3}
+status: {updatedReplicas: 3, readyReplicas: 2}
+`, false, false},
+		{"daemonset on delete", `
+kind: DaemonSet
+spec: {updateStrategy: {type: OnDelete}}
+status: {desiredNumberScheduled: 3, updatedNumberScheduled: 0, numberAvailable: 3}
+`, true, false},
+		{"daemonset not available", `
+kind: DaemonSet
+status: {desiredNumberScheduled: 3, updatedNumberScheduled: 3, numberAvailable: 1}
+`, false, false},
+		{"job complete", `
+kind: Job
+status: {conditions: [{type: Complete, status: "True"}]}
+`, true, false},
+		{"job running", `
+kind: Job
+status: {active: 1}
+`, false, false},
+		{"job failed", `
+kind: Job
+status: {conditions: [{type: Failed, status: "True", message: "BackoffLimitExceeded"}]}
+`, false, true},
+		{"crd established", `
+kind: CustomResourceDefinition
+status: {conditions: [{type: NamesAccepted, status: "True"}, {type: Established, status: "True"}]}
+`, true, false},
+		{"crd not established", `
+kind: CustomResourceDefinition
+status: {conditions: [{type: Established, status: "False"}]}
+`, false, false},
+		{"configmap", `
+kind: ConfigMap
+`, true, false},
+	}
+
+	for _, tt := range tests {
+		ready, reason, err := ObjectReadiness(objectFromYaml(t, tt.obj))
+		if tt.wantErr {
+			g.Expect(err).Should(HaveOccurred(), tt.name)
+			continue
+		}
+		g.Expect(err).ShouldNot(HaveOccurred(), tt.name)
+		g.Expect(ready).Should(Equal(tt.ready), tt.name)
+		if !ready {
+			g.Expect(reason).ShouldNot(BeEmpty(), tt.name)
+		}
+	}
+}
+
+func Test_WaitForReady(t *testing.T) {
+	g := NewWithT(t)
+	readinessCheckInterval = 10 * time.Millisecond
+
+	fc := fake.NewFakeCluster()
+	fc.CreateNs("default")
+
+	notReady := manifestFromYaml(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  generation: 1
+spec:
+  replicas: 1
+status:
+  observedGeneration: 1
+  replicas: 1
+  updatedReplicas: 1
+  availableReplicas: 0
+`)
+	ready := manifestFromYaml(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  generation: 1
+spec:
+  replicas: 1
+status:
+  observedGeneration: 1
+  replicas: 1
+  updatedReplicas: 1
+  availableReplicas: 1
+`)
+	g.Expect(fc.Create("default", notReady)).Should(Succeed())
+
+	mgr := NewHelmResourcesManager()
+	mgr.WithKubeClient(fc.KubeClient)
+
+	err := mgr.WaitForReady([]manifest.Manifest{notReady}, "default", 50*time.Millisecond)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("default/Deployment/backend: 0 of 1 updated replicas are available"))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = fc.Update("default", ready)
+	}()
+	err = mgr.WaitForReady([]manifest.Manifest{notReady}, "default", 5*time.Second)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	// Absent resource is not ready.
+	g.Expect(fc.Delete("default", ready)).Should(Succeed())
+	notReadyList, err := mgr.NotReadyResources([]manifest.Manifest{ready}, "default")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(notReadyList).Should(Equal([]string{"default/Deployment/backend: not found"}))
+
+	// Hooks are not waited for: the test Pod is never created, the hook Job is deleted after success.
+	testHook := manifestFromYaml(t, `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: backend-test
+  annotations:
+    helm.sh/hook: test
+`)
+	jobHook := manifestFromYaml(t, `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: backend-migrate
+  annotations:
+    helm.sh/hook: pre-upgrade
+    helm.sh/hook-delete-policy: hook-succeeded
+`)
+	g.Expect(fc.Create("default", ready)).Should(Succeed())
+	err = mgr.WaitForReady([]manifest.Manifest{ready, testHook, jobHook}, "default", 50*time.Millisecond)
+	g.Expect(err).ShouldNot(HaveOccurred())
+}
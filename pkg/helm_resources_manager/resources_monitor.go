@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"math/rand"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -23,9 +25,10 @@ import (
 const monitorDelayBase = time.Minute*4 + time.Second*30
 
 type ResourcesMonitor struct {
-	ctx    context.Context
-	cancel context.CancelFunc
-	paused bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	paused   bool
+	pausedMu sync.RWMutex
 
 	moduleName       string
 	manifests        []manifest.Manifest
@@ -34,7 +37,20 @@ type ResourcesMonitor struct {
 	kubeClient kube.KubernetesClient
 	logLabels  map[string]string
 
-	absentCb func(moduleName string, absent []manifest.Manifest, defaultNs string)
+	// Drift detection options: ignored and known drifted fields.
+	drift DriftOptions
+
+	// Shared informers to get live objects from the cache.
+	informers *resourceInformers
+	// Objects in informers by manifest id.
+	refs   map[string]objectRef
+	refsMu sync.Mutex
+	// changedCh receives a signal when one of watched objects is changed.
+	changedCh chan struct{}
+	// lastReported is ids of absent and drifted resources from the last callback.
+	lastReported string
+
+	absentCb func(moduleName string, absent []manifest.Manifest, drifted []manifest.Manifest, defaultNs string)
 }
 
 func NewResourcesMonitor() *ResourcesMonitor {
@@ -42,6 +58,8 @@ func NewResourcesMonitor() *ResourcesMonitor {
 		paused:    false,
 		logLabels: make(map[string]string),
 		manifests: make([]manifest.Manifest, 0),
+		refs:      make(map[string]objectRef),
+		changedCh: make(chan struct{}, 1),
 	}
 }
 
@@ -76,14 +94,25 @@ func (r *ResourcesMonitor) WithManifests(manifests []manifest.Manifest) {
 	r.manifests = manifests
 }
 
-func (r *ResourcesMonitor) WithAbsentCb(cb func(string, []manifest.Manifest, string)) {
+func (r *ResourcesMonitor) WithDrift(options DriftOptions) {
+	r.drift = options
+}
+
+func (r *ResourcesMonitor) WithInformers(informers *resourceInformers) {
+	r.informers = informers
+}
+
+func (r *ResourcesMonitor) WithAbsentCb(cb func(string, []manifest.Manifest, []manifest.Manifest, string)) {
 	r.absentCb = cb
 }
 
-// Start creates a timer and check if all manifests are present in cluster.
+// Start subscribes to shared informers and checks if all manifests are present in cluster
+// and are not drifted when watched objects are changed. Live objects are
+// also rechecked in the informers cache by timer.
 func (r *ResourcesMonitor) Start() {
 	logEntry := log.WithFields(utils.LabelsToLogFields(r.logLabels)).
 		WithField("operator.component", "HelmResourceMonitor")
+	r.subscribe(logEntry)
 	go func() {
 		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
 		randSecondsDelay := time.Second * time.Duration(rnd.Int31n(60))
@@ -91,95 +120,283 @@ func (r *ResourcesMonitor) Start() {
 
 		for {
 			select {
-			case <-timer.C:
-				if r.paused {
-					continue
-				}
-				// Check resources
-				absent, err := r.AbsentResources()
-				if err != nil {
-					logEntry.Errorf("Cannot list helm resources: %s", err)
-				}
+			case <-r.changedCh:
+				r.check(logEntry, false)
 
-				if len(absent) > 0 {
-					logEntry.Debug("Absent resources detected")
-					if r.absentCb != nil {
-						r.absentCb(r.moduleName, absent, r.defaultNamespace)
-					}
-				} else {
-					logEntry.Debug("No absent resources detected")
-				}
+			case <-timer.C:
+				// Retry subscription for resources which kinds were not discovered, e.g. CRD was not created yet.
+				r.subscribe(logEntry)
+				r.check(logEntry, true)
 
 			case <-r.ctx.Done():
 				timer.Stop()
+				r.informers.Unsubscribe(r)
 				return
 			}
 		}
 	}()
 }
 
+// check runs absent callback if there are absent or drifted resources in the informers cache.
+// Callback runs once for the same resources on changes and every time on timer.
+func (r *ResourcesMonitor) check(logEntry *log.Entry, onTimer bool) {
+	if r.isPaused() {
+		return
+	}
+
+	absent, drifted, err := r.checkResourcesWith(true, r.cachedObject)
+	if err != nil {
+		if err == errCacheNotSynced {
+			logEntry.Debugf("Skip check: %s", err)
+		} else {
+			logEntry.Errorf("Cannot check helm resources: %s", err)
+		}
+		return
+	}
+
+	if len(absent) == 0 && len(drifted) == 0 {
+		logEntry.Debug("No absent or drifted resources detected")
+		r.lastReported = ""
+		return
+	}
+
+	reported := strings.Join(r.manifestIds(absent), ",") + ";" + strings.Join(r.manifestIds(drifted), ",")
+	if !onTimer && reported == r.lastReported {
+		return
+	}
+	r.lastReported = reported
+
+	logEntry.Debugf("Absent or drifted resources detected: %d absent, %d drifted", len(absent), len(drifted))
+	if r.absentCb != nil {
+		r.absentCb(r.moduleName, absent, drifted, r.defaultNamespace)
+	}
+}
+
+// subscribe adds monitor to the informers for resources of all manifests.
+func (r *ResourcesMonitor) subscribe(logEntry *log.Entry) {
+	// Manifests by resource and namespace to select objects in informers.
+	groups := make(map[informerKey][]manifest.Manifest)
+	for _, m := range r.manifests {
+		if _, ok := r.objectRef(m); ok {
+			continue
+		}
+		gvr, namespaced, err := r.resourceGVR(m)
+		if err != nil {
+			logEntry.Errorf("Cannot watch helm resource %s: %s", m.Id(), err)
+			continue
+		}
+		key := informerKey{gvr: gvr}
+		if namespaced {
+			key.namespace = m.Namespace(r.defaultNamespace)
+		}
+		groups[key] = append(groups[key], m)
+	}
+
+	for key, group := range groups {
+		for _, m := range group {
+			ref := objectRef{
+				informer: filteredInformerKey(key, m, group),
+				key:      m.Name(),
+			}
+			if key.namespace != "" {
+				ref.key = key.namespace + "/" + m.Name()
+			}
+
+			r.refsMu.Lock()
+			r.refs[m.Id()] = ref
+			r.refsMu.Unlock()
+			r.informers.Subscribe(ref, r)
+		}
+	}
+}
+
+func (r *ResourcesMonitor) objectRef(m manifest.Manifest) (objectRef, bool) {
+	r.refsMu.Lock()
+	defer r.refsMu.Unlock()
+	ref, ok := r.refs[m.Id()]
+	return ref, ok
+}
+
+// trigger signals the monitor to check resources. Signals are merged while the check is running.
+func (r *ResourcesMonitor) trigger() {
+	select {
+	case r.changedCh <- struct{}{}:
+	default:
+	}
+}
+
 // Pause prevent execution of absent callback
 func (r *ResourcesMonitor) Pause() {
+	r.pausedMu.Lock()
 	r.paused = true
+	r.pausedMu.Unlock()
 }
 
 // Resume allows execution of absent callback
 func (r *ResourcesMonitor) Resume() {
+	r.pausedMu.Lock()
 	r.paused = false
+	r.pausedMu.Unlock()
+}
+
+func (r *ResourcesMonitor) isPaused() bool {
+	r.pausedMu.RLock()
+	defer r.pausedMu.RUnlock()
+	return r.paused
 }
 
 func (r *ResourcesMonitor) AbsentResources() ([]manifest.Manifest, error) {
-	res := make([]manifest.Manifest, 0)
+	absent, _, err := r.checkResources(false)
+	return absent, err
+}
+
+// CheckResources returns resources that are absent in cluster and resources
+// with fields changed since the release, see DriftedFields.
+func (r *ResourcesMonitor) CheckResources() ([]manifest.Manifest, []manifest.Manifest, error) {
+	return r.checkResources(true)
+}
+
+func (r *ResourcesMonitor) checkResources(detectDrift bool) ([]manifest.Manifest, []manifest.Manifest, error) {
+	return r.checkResourcesWith(detectDrift, r.fetchObject)
+}
+
+// checkResourcesWith compares manifests with live objects returned by getObject. Nil object means absent resource.
+func (r *ResourcesMonitor) checkResourcesWith(detectDrift bool, getObject func(m manifest.Manifest) (*unstructured.Unstructured, error)) ([]manifest.Manifest, []manifest.Manifest, error) {
+	absent := make([]manifest.Manifest, 0)
+	drifted := make([]manifest.Manifest, 0)
 
 	for _, m := range r.manifests {
-		// Get GVR
-		//log.Debugf("%s: discover GVR for apiVersion '%s' kind '%s'...", ei.Monitor.Metadata.DebugName, ei.Monitor.ApiVersion, ei.Monitor.Kind)
-		apiRes, err := r.kubeClient.APIResource(m.ApiVersion(), m.Kind())
+		obj, err := getObject(m)
 		if err != nil {
-			//log.Errorf("%s: Cannot get GroupVersionResource info for apiVersion '%s' kind '%s' from api-server. Possibly CRD is not created before informers are started. Error was: %v", ei.Monitor.Metadata.DebugName, ei.Monitor.ApiVersion, ei.Monitor.Kind, err)
-			return nil, err
+			return nil, nil, err
 		}
-		//log.Debugf("%s: GVR for kind '%s' is '%s'", ei.Monitor.Metadata.DebugName, ei.Monitor.Kind, ei.GroupVersionResource.String())
 
-		gvr := schema.GroupVersionResource{
-			Group:    apiRes.Group,
-			Version:  apiRes.Version,
-			Resource: apiRes.Name,
+		if obj == nil {
+			absent = append(absent, m)
+			continue
 		}
-		// Resources are filtered by metadata.name field. Object is considered absent if list is empty.
-		listOptions := v1.ListOptions{
-			FieldSelector: fields.OneTermEqualSelector("metadata.name", m.Name()).String(),
+
+		if detectDrift {
+			fields := make([]string, 0)
+			for _, field := range r.driftedFields(m, obj) {
+				if !r.drift.isKnown(field) {
+					fields = append(fields, strings.Join(field.Path, "."))
+				}
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			logEntry := log.WithFields(utils.LabelsToLogFields(r.logLabels))
+			if r.drift.ReportOnly {
+				logEntry.Debugf("Helm resource %s is drifted, upgrade is disabled: %s", m.Id(), strings.Join(fields, ", "))
+				continue
+			}
+			logEntry.Infof("Helm resource %s is drifted: %s", m.Id(), strings.Join(fields, ", "))
+			drifted = append(drifted, m)
 		}
+	}
 
-		var objList *unstructured.UnstructuredList
+	return absent, drifted, nil
+}
 
-		if apiRes.Namespaced {
-			ns := m.Namespace(r.defaultNamespace)
-			objList, err = r.kubeClient.Dynamic().Resource(gvr).Namespace(ns).List(listOptions)
-		} else {
-			objList, err = r.kubeClient.Dynamic().Resource(gvr).List(listOptions)
-		}
+// DriftedFields returns all drifted fields of resources including known ones.
+func (r *ResourcesMonitor) DriftedFields() ([]DriftedField, error) {
+	res := make([]DriftedField, 0)
+	for _, m := range r.manifests {
+		obj, err := r.fetchObject(m)
 		if err != nil {
-			return nil, fmt.Errorf("Fetch list for helm resource %s: %s", m.Id(), err)
+			return nil, err
 		}
-
-		if len(objList.Items) == 0 {
-			res = append(res, m)
+		if obj != nil {
+			res = append(res, r.driftedFields(m, obj)...)
 		}
 	}
-
 	return res, nil
 }
 
-func (r *ResourcesMonitor) ResourceIds() []string {
-	res := make([]string, 0)
+func (r *ResourcesMonitor) driftedFields(m manifest.Manifest, obj *unstructured.Unstructured) []DriftedField {
+	fields := driftedFields(m, obj.Object, r.drift.Ignore)
+	resourceId := r.manifestIds([]manifest.Manifest{m})[0]
+	for i := range fields {
+		fields[i].Resource = resourceId
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].String() < fields[j].String()
+	})
+	return fields
+}
 
-	for _, m := range r.manifests {
-		id := fmt.Sprintf("%s/%s/%s", m.Namespace(r.defaultNamespace), m.Kind(), m.Name())
-		res = append(res, id)
+func (r *ResourcesMonitor) resourceGVR(m manifest.Manifest) (schema.GroupVersionResource, bool, error) {
+	apiRes, err := r.kubeClient.APIResource(m.ApiVersion(), m.Kind())
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+	gvr := schema.GroupVersionResource{
+		Group:    apiRes.Group,
+		Version:  apiRes.Version,
+		Resource: apiRes.Name,
+	}
+	return gvr, apiRes.Namespaced, nil
+}
+
+// fetchObject gets a live object from the API server.
+func (r *ResourcesMonitor) fetchObject(m manifest.Manifest) (*unstructured.Unstructured, error) {
+	gvr, namespaced, err := r.resourceGVR(m)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resources are filtered by metadata.name field. Object is considered absent if list is empty.
+	listOptions := v1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", m.Name()).String(),
 	}
 
+	var objList *unstructured.UnstructuredList
+
+	if namespaced {
+		ns := m.Namespace(r.defaultNamespace)
+		objList, err = r.kubeClient.Dynamic().Resource(gvr).Namespace(ns).List(listOptions)
+	} else {
+		objList, err = r.kubeClient.Dynamic().Resource(gvr).List(listOptions)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Fetch list for helm resource %s: %s", m.Id(), err)
+	}
+
+	if len(objList.Items) == 0 {
+		return nil, nil
+	}
+	return &objList.Items[0], nil
+}
+
+var errCacheNotSynced = fmt.Errorf("informers cache is not synced yet")
+
+// cachedObject gets a live object from the shared informers cache.
+func (r *ResourcesMonitor) cachedObject(m manifest.Manifest) (*unstructured.Unstructured, error) {
+	ref, ok := r.objectRef(m)
+	if !ok {
+		return nil, fmt.Errorf("helm resource %s is not watched", m.Id())
+	}
+	obj, synced, err := r.informers.Get(ref)
+	if err != nil {
+		return nil, fmt.Errorf("Get helm resource %s from cache: %s", m.Id(), err)
+	}
+	if !synced {
+		return nil, errCacheNotSynced
+	}
+	return obj, nil
+}
+
+func (r *ResourcesMonitor) ResourceIds() []string {
+	res := r.manifestIds(r.manifests)
 	sort.Strings(res)
+	return res
+}
 
+func (r *ResourcesMonitor) manifestIds(manifests []manifest.Manifest) []string {
+	res := make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		res = append(res, fmt.Sprintf("%s/%s/%s", m.Namespace(r.defaultNamespace), m.Kind(), m.Name()))
+	}
 	return res
 }
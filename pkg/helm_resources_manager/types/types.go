@@ -2,7 +2,10 @@ package types
 
 import "github.com/flant/shell-operator/pkg/utils/manifest"
 
+// AbsentResourcesEvent is sent when resources of the module release are absent
+// or drifted: changed in cluster since the release.
 type AbsentResourcesEvent struct {
 	ModuleName string
 	Absent     []manifest.Manifest
+	Drifted    []manifest.Manifest
 }
@@ -0,0 +1,115 @@
+package kube_config_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AdmissionWebhookPath is a path of the validating webhook for the ConfigMap.
+const AdmissionWebhookPath = "/validate-config-map"
+
+// ConfigDataValidator checks ConfigMap-like data.
+type ConfigDataValidator interface {
+	ValidateConfigData(configData map[string]string, versions map[string]int) error
+}
+
+// ValidateConfigData checks ConfigMap-like data as handleNewConfigData does: sections are
+// migrated, references to Secrets are resolved, sections are parsed and validated with schemas.
+func (kcm *kubeConfigManager) ValidateConfigData(rawConfigData map[string]string, versions map[string]int) error {
+	migratedData, _, _, err := kcm.migrateConfigData(rawConfigData, versions)
+	if err != nil {
+		return fmt.Errorf("cannot migrate config values: %s", err)
+	}
+
+	configData, _, secretErrs := kcm.resolveConfigData(migratedData)
+	if len(secretErrs) > 0 {
+		return joinSectionErrors(secretErrs)
+	}
+
+	return kcm.validateConfigData(configData)
+}
+
+// NewConfigMapAdmissionHandler returns a handler for AdmissionReview requests from
+// the ValidatingWebhookConfiguration. Changes of the ConfigMap with invalid config data
+// are rejected with the validation error. Other objects and deletions are allowed.
+func NewConfigMapAdmissionHandler(namespace string, configMapName string, validator ConfigDataValidator) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("read request: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		review := new(admissionv1.AdmissionReview)
+		err = json.Unmarshal(body, review)
+		if err != nil || review.Request == nil {
+			http.Error(writer, fmt.Sprintf("bad AdmissionReview: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		review.Response = reviewConfigMap(review.Request, namespace, configMapName, validator)
+		review.Response.UID = review.Request.UID
+		review.Request = nil
+		// Response should have the same apiVersion as the request.
+		if review.APIVersion == "" {
+			review.APIVersion = admissionv1.SchemeGroupVersion.String()
+		}
+		review.Kind = "AdmissionReview"
+
+		data, err := json.Marshal(review)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("dump AdmissionReview: %s", err), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write(data)
+	})
+}
+
+// reviewConfigMap validates the ConfigMap from the admission request.
+func reviewConfigMap(request *admissionv1.AdmissionRequest, namespace string, configMapName string, validator ConfigDataValidator) *admissionv1.AdmissionResponse {
+	allowed := &admissionv1.AdmissionResponse{Allowed: true}
+
+	if request.Kind.Kind != "ConfigMap" || request.Namespace != namespace || request.Name != configMapName {
+		return allowed
+	}
+	if request.Operation == admissionv1.Delete {
+		return allowed
+	}
+
+	obj := new(v1.ConfigMap)
+	err := json.Unmarshal(request.Object.Raw, obj)
+	if err != nil {
+		return deniedResponse(fmt.Sprintf("ConfigMap/%s: bad object: %s", configMapName, err))
+	}
+
+	versions, err := GetConfigVersions(obj.Annotations)
+	if err == nil {
+		err = validator.ValidateConfigData(obj.Data, versions)
+	}
+	if err != nil {
+		log.Warnf("Admission webhook: reject ConfigMap/%s %s by '%s': %s", configMapName, request.Operation, request.UserInfo.Username, err)
+		return deniedResponse(fmt.Sprintf("ConfigMap/%s is not valid: %s", configMapName, err))
+	}
+
+	return allowed
+}
+
+func deniedResponse(message string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+			Message: message,
+		},
+	}
+}
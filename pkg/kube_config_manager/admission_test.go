@@ -0,0 +1,112 @@
+package kube_config_manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/flant/shell-operator/pkg/kube"
+
+	"github.com/flant/addon-operator/pkg/app"
+	"github.com/flant/addon-operator/pkg/values/validation"
+)
+
+func TestConfigMapAdmissionHandler(t *testing.T) {
+	g := NewWithT(t)
+
+	validator := validation.NewValuesValidator()
+	err := validator.SchemaStorage.AddModuleValuesSchemas("module-one", []byte(`
+type: object
+properties:
+  param1:
+    type: string
+`), nil)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	kcm := NewKubeConfigManager()
+	kcm.WithKubeClient(kube.NewFakeKubernetesClient())
+	kcm.WithNamespace("default")
+	kcm.WithValuesValidator(validator)
+
+	server := httptest.NewServer(NewConfigMapAdmissionHandler("default", app.ConfigMapName, kcm))
+	defer server.Close()
+
+	review := func(name string, operation admissionv1.Operation, data map[string]string) *admissionv1.AdmissionResponse {
+		cm := &v1.ConfigMap{}
+		cm.SetNamespace("default")
+		cm.SetName(name)
+		cm.Data = data
+		raw, err := json.Marshal(cm)
+		g.Expect(err).ShouldNot(HaveOccurred())
+
+		request := admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request: &admissionv1.AdmissionRequest{
+				UID:       "uid-1",
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+				Namespace: "default",
+				Name:      name,
+				Operation: operation,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		}
+		body, err := json.Marshal(request)
+		g.Expect(err).ShouldNot(HaveOccurred())
+
+		resp, err := http.Post(server.URL+AdmissionWebhookPath, "application/json", bytes.NewReader(body))
+		g.Expect(err).ShouldNot(HaveOccurred())
+		defer resp.Body.Close()
+		g.Expect(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		response := new(admissionv1.AdmissionReview)
+		err = json.NewDecoder(resp.Body).Decode(response)
+		g.Expect(err).ShouldNot(HaveOccurred())
+		g.Expect(response.APIVersion).Should(Equal("admission.k8s.io/v1"))
+		g.Expect(response.Response).ShouldNot(BeNil())
+		g.Expect(response.Response.UID).Should(BeEquivalentTo("uid-1"))
+		return response.Response
+	}
+
+	// Valid config.
+	resp := review(app.ConfigMapName, admissionv1.Update, map[string]string{
+		"global":           "param: value\n",
+		"moduleOne":        "param1: val1\n",
+		"moduleOneEnabled": "true",
+	})
+	g.Expect(resp.Allowed).Should(BeTrue())
+
+	// Bad yaml.
+	resp = review(app.ConfigMapName, admissionv1.Create, map[string]string{
+		"global": "param: [value\n",
+	})
+	g.Expect(resp.Allowed).Should(BeFalse())
+	g.Expect(resp.Result.Message).Should(ContainSubstring("bad yaml at key 'global'"))
+
+	// Bad enabled flag.
+	resp = review(app.ConfigMapName, admissionv1.Update, map[string]string{
+		"moduleOneEnabled": "yes",
+	})
+	g.Expect(resp.Allowed).Should(BeFalse())
+	g.Expect(resp.Result.Message).Should(ContainSubstring("should have a boolean value"))
+
+	// Values not valid against the schema.
+	resp = review(app.ConfigMapName, admissionv1.Update, map[string]string{
+		"moduleOne": "param1: val1\nparma2: val2\n",
+	})
+	g.Expect(resp.Allowed).Should(BeFalse())
+	g.Expect(resp.Result.Message).Should(ContainSubstring("moduleOne.parma2 is a forbidden property"))
+
+	// Other ConfigMaps and deletions are not validated.
+	resp = review("other", admissionv1.Update, map[string]string{"global": "param: [value\n"})
+	g.Expect(resp.Allowed).Should(BeTrue())
+	resp = review(app.ConfigMapName, admissionv1.Delete, nil)
+	g.Expect(resp.Allowed).Should(BeTrue())
+}
@@ -0,0 +1,398 @@
+package kube_config_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// configRevisionKeyPrefix is a prefix of keys in the history ConfigMap: revision-1, revision-2, ...
+const configRevisionKeyPrefix = "revision-"
+
+// maxConfigHistorySize is a maximum size of revisions in the history ConfigMap.
+// It is less than 1MiB limit for ConfigMap to leave a room for metadata.
+const maxConfigHistorySize = 900 * 1024
+
+// ConfigRevision is a snapshot of the accepted config. ConfigData is ConfigMap-like data
+// after migrations with unresolved references to Secrets, so values from Secrets are not stored.
+type ConfigRevision struct {
+	Revision   int               `json:"revision"`
+	Time       time.Time         `json:"time"`
+	Source     string            `json:"source"`
+	ConfigData map[string]string `json:"configData"`
+}
+
+// Sections returns names of the global section and module sections in the revision.
+func (r ConfigRevision) Sections() []string {
+	res := make([]string, 0)
+	if _, has := r.ConfigData[utils.GlobalValuesKey]; has {
+		res = append(res, utils.GlobalValuesKey)
+	}
+	moduleNames := make([]string, 0)
+	for moduleName := range GetModulesNamesFromConfigData(r.ConfigData) {
+		moduleNames = append(moduleNames, moduleName)
+	}
+	sort.Strings(moduleNames)
+	return append(res, moduleNames...)
+}
+
+// Values returns config values of the revision with enabled flags for modules.
+func (r ConfigRevision) Values() (utils.Values, error) {
+	res := make(utils.Values)
+
+	globalKubeConfig, err := GetGlobalKubeConfigFromConfigData(r.ConfigData)
+	if err != nil {
+		return nil, err
+	}
+	if globalKubeConfig != nil {
+		res = utils.MergeValues(res, globalKubeConfig.Values)
+	}
+
+	for moduleName := range GetModulesNamesFromConfigData(r.ConfigData) {
+		moduleKubeConfig, err := ExtractModuleKubeConfig(moduleName, r.ConfigData)
+		if err != nil {
+			return nil, err
+		}
+		res = utils.MergeValues(res, moduleKubeConfig.Values)
+		if moduleKubeConfig.IsEnabled != nil {
+			res[moduleKubeConfig.ModuleEnabledKey] = *moduleKubeConfig.IsEnabled
+		}
+	}
+
+	return res, nil
+}
+
+// configHistory is a bounded list of accepted config revisions.
+type configHistory struct {
+	lock sync.Mutex
+
+	// Max is a number of revisions to keep. History is disabled if 0.
+	Max int
+	// ConfigMapName is a name of the ConfigMap to store revisions. Revisions are kept only in memory if empty.
+	ConfigMapName string
+
+	revisions    []ConfigRevision
+	lastRevision int
+}
+
+// sectionRestorer writes sections of config revisions into the storage.
+type sectionRestorer interface {
+	// restoreSection writes keys of the section from configData as is: values and
+	// the enabled flag. Keys of the section absent in configData are deleted.
+	// The saved checksum of the section is removed, so the change is handled as
+	// a change made by a user.
+	restoreSection(section string, configData map[string]string) error
+}
+
+// WithConfigHistory enables the history of accepted configs with max revisions.
+// Revisions are also stored in the ConfigMap if configMapName is not empty.
+func (kcm *kubeConfigManager) WithConfigHistory(max int, configMapName string) {
+	kcm.history.Max = max
+	kcm.history.ConfigMapName = configMapName
+}
+
+// ConfigHistory returns accepted config revisions, the oldest first.
+func (kcm *kubeConfigManager) ConfigHistory() []ConfigRevision {
+	kcm.history.lock.Lock()
+	defer kcm.history.lock.Unlock()
+
+	res := make([]ConfigRevision, len(kcm.history.revisions))
+	copy(res, kcm.history.revisions)
+	return res
+}
+
+// loadConfigHistory reads revisions from the history ConfigMap to continue revision numbers after restart.
+func (kcm *kubeConfigManager) loadConfigHistory() error {
+	if kcm.history.Max == 0 || kcm.history.ConfigMapName == "" || kcm.KubeClient == nil {
+		return nil
+	}
+
+	obj, err := kcm.KubeClient.CoreV1().ConfigMaps(kcm.Namespace).Get(kcm.history.ConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get history ConfigMap/%s: %s", kcm.history.ConfigMapName, err)
+	}
+
+	revisions := make([]ConfigRevision, 0, len(obj.Data))
+	for key, data := range obj.Data {
+		if !strings.HasPrefix(key, configRevisionKeyPrefix) {
+			continue
+		}
+		var revision ConfigRevision
+		err := json.Unmarshal([]byte(data), &revision)
+		if err != nil {
+			log.Warnf("Kube config manager: history ConfigMap/%s has bad key '%s': %s", kcm.history.ConfigMapName, key, err)
+			continue
+		}
+		revisions = append(revisions, revision)
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+
+	kcm.history.lock.Lock()
+	defer kcm.history.lock.Unlock()
+	kcm.history.revisions = revisions
+	kcm.history.trim()
+	if len(revisions) > 0 {
+		kcm.history.lastRevision = revisions[len(revisions)-1].Revision
+	}
+	return nil
+}
+
+// trim removes the oldest revisions over Max. If revisions are stored in the ConfigMap,
+// the oldest revisions are also removed until the rest fit into maxConfigHistorySize.
+// The last revision is always kept.
+func (h *configHistory) trim() {
+	if len(h.revisions) > h.Max {
+		h.revisions = h.revisions[len(h.revisions)-h.Max:]
+	}
+	if h.ConfigMapName == "" {
+		return
+	}
+
+	size := 0
+	for i := len(h.revisions) - 1; i >= 0; i-- {
+		data, _ := json.Marshal(h.revisions[i])
+		size += len(data)
+		if size > maxConfigHistorySize && i < len(h.revisions)-1 {
+			log.Warnf("Kube config manager: history ConfigMap/%s is too big, %d oldest revisions are removed", h.ConfigMapName, i+1)
+			h.revisions = h.revisions[i+1:]
+			return
+		}
+	}
+}
+
+// recordConfigRevision adds a new revision if config data is changed since the last revision.
+func (kcm *kubeConfigManager) recordConfigRevision(source string, configData map[string]string) {
+	if kcm.history.Max == 0 {
+		return
+	}
+
+	kcm.history.lock.Lock()
+	if n := len(kcm.history.revisions); n > 0 && reflect.DeepEqual(kcm.history.revisions[n-1].ConfigData, configData) {
+		kcm.history.lock.Unlock()
+		return
+	}
+	kcm.history.lastRevision++
+	revision := ConfigRevision{
+		Revision:   kcm.history.lastRevision,
+		Time:       time.Now(),
+		Source:     source,
+		ConfigData: simpleMergeConfigMapData(make(map[string]string, len(configData)), configData),
+	}
+	kcm.history.revisions = append(kcm.history.revisions, revision)
+	kcm.history.trim()
+	revisions := make([]ConfigRevision, len(kcm.history.revisions))
+	copy(revisions, kcm.history.revisions)
+	kcm.history.lock.Unlock()
+
+	log.Debugf("Kube config manager: config revision %d from %s", revision.Revision, source)
+
+	err := kcm.saveConfigHistory(revisions)
+	if err != nil {
+		log.Errorf("Kube config manager: cannot save config revision %d into history ConfigMap/%s, it will be saved with the next revision: %s", revision.Revision, kcm.history.ConfigMapName, err)
+		if kcm.metricStorage != nil {
+			kcm.metricStorage.CounterAdd("{PREFIX}config_history_errors_total", 1.0, map[string]string{})
+		}
+	}
+}
+
+// saveConfigHistory replaces revisions in the history ConfigMap with revisions,
+// so revisions not saved because of previous errors are saved too.
+func (kcm *kubeConfigManager) saveConfigHistory(revisions []ConfigRevision) error {
+	if kcm.history.ConfigMapName == "" || kcm.KubeClient == nil {
+		return nil
+	}
+
+	revisionsData := make(map[string]string, len(revisions))
+	for _, revision := range revisions {
+		data, err := json.Marshal(revision)
+		if err != nil {
+			return err
+		}
+		revisionsData[configRevisionKeyPrefix+strconv.Itoa(revision.Revision)] = string(data)
+	}
+
+	return kcm.retryOnConflict(fmt.Sprintf("ConfigMap/%s", kcm.history.ConfigMapName), func() error {
+		obj, err := kcm.KubeClient.CoreV1().ConfigMaps(kcm.Namespace).Get(kcm.history.ConfigMapName, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		isNew := errors.IsNotFound(err)
+		if isNew {
+			obj = &v1.ConfigMap{}
+			obj.Name = kcm.history.ConfigMapName
+		}
+		if obj.Data == nil {
+			obj.Data = make(map[string]string)
+		}
+
+		for key := range obj.Data {
+			if strings.HasPrefix(key, configRevisionKeyPrefix) {
+				delete(obj.Data, key)
+			}
+		}
+		obj.Data = simpleMergeConfigMapData(obj.Data, revisionsData)
+
+		if isNew {
+			_, err = kcm.KubeClient.CoreV1().ConfigMaps(kcm.Namespace).Create(obj)
+		} else {
+			_, err = kcm.KubeClient.CoreV1().ConfigMaps(kcm.Namespace).Update(obj)
+		}
+		return err
+	})
+}
+
+// RollbackConfig writes sections from the revision back into the config.
+// All sections of the revision are written and sections absent in the revision
+// are deleted if section is empty.
+func (kcm *kubeConfigManager) RollbackConfig(revision int, section string) error {
+	return kcm.rollbackConfig(kcm, revision, section)
+}
+
+// rollbackConfig writes sections using restoreSection of the backend.
+func (kcm *kubeConfigManager) rollbackConfig(backend sectionRestorer, revision int, section string) error {
+	var rev *ConfigRevision
+	for _, r := range kcm.ConfigHistory() {
+		if r.Revision == revision {
+			rev = &r
+			break
+		}
+	}
+	if rev == nil {
+		return fmt.Errorf("config revision %d is not found", revision)
+	}
+
+	sections := rev.Sections()
+	if section != "" {
+		found := false
+		for _, s := range sections {
+			found = found || s == section
+		}
+		if !found {
+			return fmt.Errorf("config revision %d has no '%s' section", revision, section)
+		}
+		sections = []string{section}
+	} else {
+		sections = append(sections, kcm.sectionsAbsentIn(rev.ConfigData)...)
+	}
+
+	for _, s := range sections {
+		sectionData := make(map[string]string)
+		for _, key := range sectionKeys(s) {
+			if data, has := rev.ConfigData[key]; has {
+				sectionData[key] = data
+			}
+		}
+
+		err := backend.restoreSection(s, sectionData)
+		if err != nil {
+			return fmt.Errorf("write '%s' section from config revision %d: %s", s, revision, err)
+		}
+		if len(sectionData) == 0 {
+			log.Infof("Kube config manager: '%s' section is deleted as absent in config revision %d", s, revision)
+		} else {
+			log.Infof("Kube config manager: '%s' section is rolled back to config revision %d", s, revision)
+		}
+	}
+
+	return nil
+}
+
+// sectionsAbsentIn returns sections of the last handled config that are absent in configData.
+func (kcm *kubeConfigManager) sectionsAbsentIn(configData map[string]string) []string {
+	kcm.configDataLock.Lock()
+	handled := kcm.handledConfigData
+	kcm.configDataLock.Unlock()
+
+	res := make([]string, 0)
+	_, hasGlobal := handled[utils.GlobalValuesKey]
+	if _, has := configData[utils.GlobalValuesKey]; hasGlobal && !has {
+		res = append(res, utils.GlobalValuesKey)
+	}
+	modules := GetModulesNamesFromConfigData(configData)
+	absent := make([]string, 0)
+	for moduleName := range GetModulesNamesFromConfigData(handled) {
+		if !modules[moduleName] {
+			absent = append(absent, moduleName)
+		}
+	}
+	sort.Strings(absent)
+	return append(res, absent...)
+}
+
+// sectionValues returns values and the enabled flag of the section from ConfigMap-like data.
+// Values are nil if the section has no values.
+func sectionValues(section string, configData map[string]string) (interface{}, *bool, error) {
+	if section == utils.GlobalValuesKey {
+		globalKubeConfig, err := GetGlobalKubeConfigFromConfigData(configData)
+		if err != nil || globalKubeConfig == nil {
+			return nil, nil, err
+		}
+		return globalKubeConfig.Values[utils.GlobalValuesKey], nil, nil
+	}
+
+	if !GetModulesNamesFromConfigData(configData)[section] {
+		return nil, nil, nil
+	}
+	moduleKubeConfig, err := ExtractModuleKubeConfig(section, configData)
+	if err != nil {
+		return nil, nil, err
+	}
+	return moduleKubeConfig.Values[utils.ModuleNameToValuesKey(section)], moduleKubeConfig.IsEnabled, nil
+}
+
+// restoreSection writes keys of the section into the ConfigMap or into the module ConfigMap.
+func (kcm *kubeConfigManager) restoreSection(section string, configData map[string]string) error {
+	configChangeFunc := func(obj *v1.ConfigMap) error {
+		checksums, err := kcm.getValuesChecksums(obj)
+		if err != nil {
+			return err
+		}
+		delete(checksums, section)
+		err = kcm.setValuesChecksums(obj, checksums)
+		if err != nil {
+			return fmt.Errorf("update '%s' values checksum in annotation: %s", section, err)
+		}
+
+		if len(configData) > 0 {
+			obj.Annotations, err = kcm.setConfigVersion(obj.Annotations, section)
+			if err != nil {
+				return fmt.Errorf("update '%s' values version in annotation: %s", section, err)
+			}
+		}
+
+		for _, key := range sectionKeys(section) {
+			delete(obj.Data, key)
+		}
+		obj.Data = simpleMergeConfigMapData(obj.Data, configData)
+		return nil
+	}
+
+	if kcm.moduleConfigMaps.Enabled && section != utils.GlobalValuesKey {
+		return kcm.changeOrCreateModuleConfigMap(section, configChangeFunc)
+	}
+	return kcm.changeOrCreateKubeConfig(configChangeFunc)
+}
+
+func sectionValuesKey(section string) string {
+	if section == utils.GlobalValuesKey {
+		return utils.GlobalValuesKey
+	}
+	return utils.ModuleNameToValuesKey(section)
+}
@@ -0,0 +1,231 @@
+package kube_config_manager
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/flant/shell-operator/pkg/kube"
+
+	"github.com/flant/addon-operator/pkg/app"
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+func TestKubeConfigManager_ConfigHistory(t *testing.T) {
+	g := NewWithT(t)
+
+	kubeClient := kube.NewFakeKubernetesClient()
+
+	cm := &v1.ConfigMap{}
+	cm.SetName(app.ConfigMapName)
+	cm.Data = map[string]string{
+		"global":    "param: v1\n",
+		"moduleOne": "param1: v1\n",
+	}
+	_, err := kubeClient.CoreV1().ConfigMaps("default").Create(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	newKcm := func() KubeConfigManager {
+		kcm := NewKubeConfigManager()
+		kcm.WithKubeClient(kubeClient)
+		kcm.WithNamespace("default")
+		kcm.WithConfigMapName(app.ConfigMapName)
+		kcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+		kcm.WithConfigHistory(2, "addon-operator-history")
+		return kcm
+	}
+
+	kcm := newKcm()
+	err = kcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	// Initial config is the first revision.
+	history := kcm.ConfigHistory()
+	g.Expect(history).Should(HaveLen(1))
+	g.Expect(history[0].Revision).Should(Equal(1))
+	g.Expect(history[0].Sections()).Should(Equal([]string{"global", "module-one"}))
+
+	// The same data is not recorded again.
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(kcm.ConfigHistory()).Should(HaveLen(1))
+
+	cm.Data["moduleOne"] = "param1: v2\n"
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	<-ModuleConfigsUpdated
+
+	cm.Data["moduleOne"] = "param1: v3\n"
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	<-ModuleConfigsUpdated
+
+	// History is bounded.
+	history = kcm.ConfigHistory()
+	g.Expect(history).Should(HaveLen(2))
+	g.Expect(history[0].Revision).Should(Equal(2))
+	g.Expect(history[1].Revision).Should(Equal(3))
+	values, err := history[0].Values()
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(values["moduleOne"]).Should(Equal(map[string]interface{}{"param1": "v2"}))
+
+	historyCm, err := kubeClient.CoreV1().ConfigMaps("default").Get("addon-operator-history", metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(historyCm.Data).Should(HaveLen(2))
+	g.Expect(historyCm.Data).Should(HaveKey("revision-2"))
+	g.Expect(historyCm.Data).Should(HaveKey("revision-3"))
+
+	// Unknown revision and section.
+	g.Expect(kcm.RollbackConfig(1, "")).Should(HaveOccurred())
+	g.Expect(kcm.RollbackConfig(2, "module-two")).Should(HaveOccurred())
+
+	// Rolled back section is written and reported as changed.
+	err = kcm.RollbackConfig(2, "module-one")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	cm, err = kubeClient.CoreV1().ConfigMaps("default").Get(app.ConfigMapName, metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(cm.Data["moduleOne"]).Should(Equal("param1: v2\n"))
+	g.Expect(cm.Data["global"]).Should(Equal("param: v1\n"))
+
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	moduleConfigs := <-ModuleConfigsUpdated
+	g.Expect(moduleConfigs["module-one"].IsUpdated).Should(BeTrue())
+	g.Expect(moduleConfigs["module-one"].Values).Should(Equal(utils.Values{"moduleOne": map[string]interface{}{"param1": "v2"}}))
+
+	// Revisions are loaded from the history ConfigMap after restart.
+	kcm = newKcm()
+	err = kcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+	// The initial config is the same as the last revision.
+	history = kcm.ConfigHistory()
+	g.Expect(history).Should(HaveLen(2))
+	g.Expect(history[0].Revision).Should(Equal(3))
+	g.Expect(history[1].Revision).Should(Equal(4))
+
+	cm.Data["global"] = "param: v2\n"
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	<-ConfigUpdated
+	history = kcm.ConfigHistory()
+	g.Expect(history[1].Revision).Should(Equal(5))
+}
+
+// Rollback of all sections should restore enabled flags and delete sections absent in the revision.
+func TestKubeConfigManager_RollbackAllSections(t *testing.T) {
+	g := NewWithT(t)
+
+	kubeClient := kube.NewFakeKubernetesClient()
+
+	cm := &v1.ConfigMap{}
+	cm.SetName(app.ConfigMapName)
+	cm.Data = map[string]string{
+		"moduleOne":        "param1: v1\n",
+		"moduleOneEnabled": "false",
+	}
+	_, err := kubeClient.CoreV1().ConfigMaps("default").Create(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	kcm := NewKubeConfigManager()
+	kcm.WithKubeClient(kubeClient)
+	kcm.WithNamespace("default")
+	kcm.WithConfigMapName(app.ConfigMapName)
+	kcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+	kcm.WithConfigHistory(5, "")
+	err = kcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	cm.Data = map[string]string{
+		"global":           "param: v1\n",
+		"moduleOne":        "param1: v2\n",
+		"moduleOneEnabled": "true",
+		"moduleTwoEnabled": "true",
+	}
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	<-ConfigUpdated
+	_, err = kubeClient.CoreV1().ConfigMaps("default").Update(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	err = kcm.RollbackConfig(1, "")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	cm, err = kubeClient.CoreV1().ConfigMaps("default").Get(app.ConfigMapName, metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(cm.Data).Should(Equal(map[string]string{
+		"moduleOne":        "param1: v1\n",
+		"moduleOneEnabled": "false",
+	}))
+}
+
+// Revisions not saved because of errors should be saved with the next revision.
+func TestKubeConfigManager_ConfigHistory_SaveError(t *testing.T) {
+	g := NewWithT(t)
+
+	cm := &v1.ConfigMap{}
+	cm.SetNamespace("default")
+	cm.SetName(app.ConfigMapName)
+	cm.Data = map[string]string{
+		"global": "param: v1\n",
+	}
+
+	clientset := fake.NewSimpleClientset(cm)
+	failures := 1
+	clientset.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failures == 0 {
+			return false, nil, nil
+		}
+		failures--
+		return true, nil, fmt.Errorf("create is forbidden")
+	})
+
+	kcm := NewKubeConfigManager()
+	kcm.WithKubeClient(&clientWithFakeCoreV1{KubernetesClient: kube.NewFakeKubernetesClient(), clientset: clientset})
+	kcm.WithNamespace("default")
+	kcm.WithConfigMapName(app.ConfigMapName)
+	kcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+	kcm.WithConfigHistory(5, "addon-operator-history")
+	err := kcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	_, err = clientset.CoreV1().ConfigMaps("default").Get("addon-operator-history", metav1.GetOptions{})
+	g.Expect(err).Should(HaveOccurred())
+
+	cm.Data["global"] = "param: v2\n"
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	<-ConfigUpdated
+
+	historyCm, err := clientset.CoreV1().ConfigMaps("default").Get("addon-operator-history", metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(historyCm.Data).Should(HaveKey("revision-1"))
+	g.Expect(historyCm.Data).Should(HaveKey("revision-2"))
+}
+
+// The oldest revisions should be removed if revisions do not fit into the history ConfigMap.
+func TestConfigHistory_TrimBySize(t *testing.T) {
+	g := NewWithT(t)
+
+	h := &configHistory{Max: 10, ConfigMapName: "addon-operator-history"}
+	bigValue := strings.Repeat("x", maxConfigHistorySize/3)
+	for i := 1; i <= 5; i++ {
+		h.revisions = append(h.revisions, ConfigRevision{
+			Revision:   i,
+			ConfigData: map[string]string{"global": "param: " + bigValue},
+		})
+	}
+	h.trim()
+	g.Expect(h.revisions).Should(HaveLen(2))
+	g.Expect(h.revisions[0].Revision).Should(Equal(4))
+
+	// The last revision is kept even if it is too big.
+	h.revisions = []ConfigRevision{{Revision: 6, ConfigData: map[string]string{"global": bigValue + bigValue + bigValue + bigValue}}}
+	h.trim()
+	g.Expect(h.revisions).Should(HaveLen(1))
+}
@@ -0,0 +1,490 @@
+package kube_config_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// DefaultFileConfigPollInterval is a period to check the file or the directory for changes.
+var DefaultFileConfigPollInterval = 2 * time.Second
+
+// ConfigVersionsFile is a file in the config directory with versions of config sections.
+// Versions for the YAML file are stored in the file with the same name and this suffix.
+const ConfigVersionsFile = ".config-versions"
+
+// fileConfigManager is a KubeConfigManager that reads config values from
+// a local path instead of the ConfigMap. The path can be:
+//
+// - a directory with files named as ConfigMap keys, e.g. a mounted ConfigMap:
+//   'global', 'simpleModule', 'simpleModuleEnabled';
+//
+// - a YAML file with values as in values.yaml:
+//   global: {...}, simpleModule: {...}, simpleModuleEnabled: true
+//
+// The path is polled for changes, changes are sent over channels as in kubeConfigManager.
+//
+// Versions of sections are stored in the ConfigVersionsFile as a YAML map:
+// 'global: 1', 'simple-module: 2'.
+type fileConfigManager struct {
+	*kubeConfigManager
+
+	Path         string
+	PollInterval time.Duration
+
+	// Checksums of sections saved by SetKubeGlobalValues and SetKubeModuleValues.
+	savedChecksums map[string]string
+	lastConfigData map[string]string
+	lastVersions   map[string]int
+
+	lock sync.Mutex
+}
+
+// fileConfigManager should implement KubeConfigManager
+var _ KubeConfigManager = &fileConfigManager{}
+
+func NewFileConfigManager(path string) KubeConfigManager {
+	return &fileConfigManager{
+		kubeConfigManager: NewKubeConfigManager().(*kubeConfigManager),
+		Path:              path,
+		PollInterval:      DefaultFileConfigPollInterval,
+		savedChecksums:    make(map[string]string),
+	}
+}
+
+func (fcm *fileConfigManager) isDir() bool {
+	info, err := os.Stat(fcm.Path)
+	return err == nil && info.IsDir()
+}
+
+// readConfigData returns ConfigMap-like data from the directory or the YAML file.
+// Absent path is treated as empty config.
+func (fcm *fileConfigManager) readConfigData() (map[string]string, error) {
+	if _, err := os.Stat(fcm.Path); os.IsNotExist(err) {
+		return make(map[string]string), nil
+	}
+
+	if fcm.isDir() {
+		return readConfigDataFromDir(fcm.Path)
+	}
+
+	content, err := ioutil.ReadFile(fcm.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file '%s': %s", fcm.Path, err)
+	}
+	return ValuesYamlToConfigData(content)
+}
+
+// versionsPath returns a path of the file with versions of sections.
+func (fcm *fileConfigManager) versionsPath() string {
+	if fcm.isDir() {
+		return filepath.Join(fcm.Path, ConfigVersionsFile)
+	}
+	return fcm.Path + ConfigVersionsFile
+}
+
+// readVersions returns versions of sections. Absent file means that all sections have version 0.
+func (fcm *fileConfigManager) readVersions() (map[string]int, error) {
+	versions := make(map[string]int)
+
+	content, err := ioutil.ReadFile(fcm.versionsPath())
+	if os.IsNotExist(err) {
+		return versions, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config versions file '%s': %s", fcm.versionsPath(), err)
+	}
+	err = yaml.Unmarshal(content, &versions)
+	if err != nil {
+		return nil, fmt.Errorf("read config versions file '%s': bad yaml: %s", fcm.versionsPath(), err)
+	}
+	return versions, nil
+}
+
+// saveVersion writes the latest version of the section into the versions file.
+func (fcm *fileConfigManager) saveVersion(name string) error {
+	version := fcm.LatestConfigVersion(name)
+	if version == 0 {
+		if _, err := os.Stat(fcm.versionsPath()); os.IsNotExist(err) {
+			return nil
+		}
+	}
+
+	versions, err := fcm.readVersions()
+	if err != nil {
+		return err
+	}
+	versions[name] = version
+
+	content, err := yaml.Marshal(versions)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(fcm.versionsPath(), content)
+	if err != nil {
+		return fmt.Errorf("write config versions file '%s': %s", fcm.versionsPath(), err)
+	}
+	return nil
+}
+
+// configFilesInDir returns paths of files in the directory by ConfigMap keys: a key
+// is a file name without the '.yaml' or '.yml' extension.
+// Hidden files are ignored, so a mounted ConfigMap can be used.
+func configFilesInDir(dir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("list config directory '%s': %s", dir, err)
+	}
+
+	paths := make(map[string]string)
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		// Follow symlinks for mounted ConfigMaps.
+		filePath := filepath.Join(dir, file.Name())
+		info, err := os.Stat(filePath)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		key := strings.TrimSuffix(strings.TrimSuffix(file.Name(), ".yaml"), ".yml")
+		if other, has := paths[key]; has {
+			return nil, fmt.Errorf("config directory '%s': files '%s' and '%s' have the same key '%s'", dir, filepath.Base(other), file.Name(), key)
+		}
+		paths[key] = filePath
+	}
+	return paths, nil
+}
+
+// readConfigDataFromDir reads files in the directory as ConfigMap keys.
+func readConfigDataFromDir(dir string) (map[string]string, error) {
+	paths, err := configFilesInDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	configData := make(map[string]string)
+	for key, filePath := range paths {
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("read config file '%s': %s", filePath, err)
+		}
+		configData[key] = strings.TrimSpace(string(content))
+	}
+	return configData, nil
+}
+
+// writeFileAtomic writes content into a temporary hidden file and renames it to the path,
+// so the poller never reads a partially written file. Symlinks are followed.
+func writeFileAtomic(path string, content []byte) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	// Temporary file is absent after the successful rename.
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmpFile.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// ValuesYamlToConfigData converts values in values.yaml format to ConfigMap-like data.
+func ValuesYamlToConfigData(content []byte) (map[string]string, error) {
+	var values map[string]interface{}
+	err := yaml.Unmarshal(content, &values)
+	if err != nil {
+		return nil, fmt.Errorf("bad yaml: %s", err)
+	}
+
+	configData := make(map[string]string)
+	for key, value := range values {
+		if enabled, ok := value.(bool); ok && strings.HasSuffix(key, "Enabled") {
+			configData[key] = strconv.FormatBool(enabled)
+			continue
+		}
+		data, err := yaml.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("dump key '%s': %s", key, err)
+		}
+		configData[key] = string(data)
+	}
+	return configData, nil
+}
+
+func (fcm *fileConfigManager) Init() error {
+	log.Debugf("INIT: KUBE_CONFIG from '%s'", fcm.Path)
+
+	ConfigUpdated = make(chan Config, 1)
+	ModuleConfigsUpdated = make(chan ModuleConfigs, 1)
+
+	err := fcm.loadConfigHistory()
+	if err != nil {
+		return err
+	}
+
+	configData, err := fcm.readConfigData()
+	if err != nil {
+		return err
+	}
+	versions, err := fcm.readVersions()
+	if err != nil {
+		return err
+	}
+	fcm.lastConfigData = configData
+	fcm.lastVersions = versions
+
+	return fcm.initConfigFromData(configData, versions)
+}
+
+func (fcm *fileConfigManager) MigrateInitialConfig() error {
+	err := fcm.migrateInitialConfig()
+	if err != nil {
+		return err
+	}
+	fcm.writeBackMigratedSections(fcm)
+	return nil
+}
+
+func (fcm *fileConfigManager) RollbackConfig(revision int, section string) error {
+	return fcm.rollbackConfig(fcm, revision, section)
+}
+
+// checkChanges reads the path and sends changes over channels.
+func (fcm *fileConfigManager) checkChanges() error {
+	fcm.lock.Lock()
+	defer fcm.lock.Unlock()
+
+	configData, err := fcm.readConfigData()
+	if err != nil {
+		return err
+	}
+	versions, err := fcm.readVersions()
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(configData, fcm.lastConfigData) && reflect.DeepEqual(versions, fcm.lastVersions) {
+		return nil
+	}
+
+	// Data is remembered even if it is invalid, so the error is reported once for each change.
+	// Changed Secrets are handled by the Secrets informer.
+	fcm.lastConfigData = configData
+	fcm.lastVersions = versions
+	return fcm.handleNewConfigData(fcm.Path, configData, fcm.savedChecksums, versions)
+}
+
+func (fcm *fileConfigManager) Start() {
+	log.Debugf("Run kube config manager for '%s'", fcm.Path)
+
+	ticker := time.NewTicker(fcm.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := fcm.checkChanges()
+			if err != nil {
+				log.Errorf("Kube config manager: cannot handle changes in '%s': %s", fcm.Path, err)
+			}
+			// Migrated sections are saved without the lock held by checkChanges.
+			fcm.writeBackMigratedSections(fcm)
+		case <-fcm.ctx.Done():
+			return
+		}
+	}
+}
+
+func (fcm *fileConfigManager) SetKubeGlobalValues(values utils.Values) error {
+	globalKubeConfig, err := GetGlobalKubeConfigFromValues(values)
+	if err != nil || globalKubeConfig == nil {
+		return err
+	}
+
+	log.Debugf("Kube config manager: set kube global values:\n%s", values.DebugString())
+
+	configData, err := fcm.configDataWithSecretRefs(utils.GlobalValuesKey, values, globalKubeConfig.ConfigData)
+	if err != nil {
+		return err
+	}
+	sectionValues := fcm.withSecretRefs(utils.GlobalValuesKey, values[utils.GlobalValuesKey])
+	return fcm.saveSection(utils.GlobalValuesKey, utils.GlobalValuesKey, sectionValues, configData)
+}
+
+func (fcm *fileConfigManager) SetKubeModuleValues(moduleName string, values utils.Values) error {
+	moduleKubeConfig, err := GetModuleKubeConfigFromValues(moduleName, values)
+	if err != nil || moduleKubeConfig == nil {
+		return err
+	}
+
+	log.Debugf("Kube config manager: set kube module values:\n%s", moduleKubeConfig.ModuleConfig.String())
+
+	valuesKey := utils.ModuleNameToValuesKey(moduleName)
+	configData, err := fcm.configDataWithSecretRefs(valuesKey, values, moduleKubeConfig.ConfigData)
+	if err != nil {
+		return err
+	}
+	return fcm.saveSection(moduleName, valuesKey, fcm.withSecretRefs(valuesKey, values[valuesKey]), configData)
+}
+
+// saveSection writes a section into the file or the directory. Other keys stay unchanged.
+// In the directory, keys are written into files they were read from, e.g. 'global.yaml'.
+func (fcm *fileConfigManager) saveSection(name string, valuesKey string, sectionValues interface{}, sectionData map[string]string) error {
+	fcm.lock.Lock()
+	defer fcm.lock.Unlock()
+
+	if fcm.isDir() {
+		paths, err := configFilesInDir(fcm.Path)
+		if err != nil {
+			return err
+		}
+		for key, value := range sectionData {
+			path, has := paths[key]
+			if !has {
+				path = filepath.Join(fcm.Path, key)
+			}
+			err := writeFileAtomic(path, []byte(value))
+			if err != nil {
+				return fmt.Errorf("write key '%s' into '%s': %s", key, path, err)
+			}
+		}
+	} else {
+		err := fcm.changeValuesFile(func(values map[string]interface{}) {
+			values[valuesKey] = sectionValues
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := fcm.saveVersion(name)
+	if err != nil {
+		return err
+	}
+
+	return fcm.saveChecksum(name)
+}
+
+// changeValuesFile applies changeFunc to values from the YAML file and writes them back.
+func (fcm *fileConfigManager) changeValuesFile(changeFunc func(values map[string]interface{})) error {
+	values := make(map[string]interface{})
+	content, err := ioutil.ReadFile(fcm.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read config file '%s': %s", fcm.Path, err)
+	}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("read config file '%s': bad yaml: %s", fcm.Path, err)
+	}
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	changeFunc(values)
+
+	content, err = yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(fcm.Path, content)
+	if err != nil {
+		return fmt.Errorf("write config file '%s': %s", fcm.Path, err)
+	}
+	return nil
+}
+
+// restoreSection writes keys of the section into the directory or the YAML file.
+// Keys absent in configData are deleted.
+func (fcm *fileConfigManager) restoreSection(section string, configData map[string]string) error {
+	fcm.lock.Lock()
+	defer fcm.lock.Unlock()
+
+	if fcm.isDir() {
+		paths, err := configFilesInDir(fcm.Path)
+		if err != nil {
+			return err
+		}
+		for _, key := range sectionKeys(section) {
+			path, has := paths[key]
+			value, restore := configData[key]
+			switch {
+			case restore:
+				if !has {
+					path = filepath.Join(fcm.Path, key)
+				}
+				err = writeFileAtomic(path, []byte(value))
+			case has:
+				err = os.Remove(path)
+			}
+			if err != nil {
+				return fmt.Errorf("restore key '%s' in '%s': %s", key, fcm.Path, err)
+			}
+		}
+	} else {
+		values, enabled, err := sectionValues(section, configData)
+		if err != nil {
+			return err
+		}
+		err = fcm.changeValuesFile(func(fileValues map[string]interface{}) {
+			valuesKey := sectionValuesKey(section)
+			delete(fileValues, valuesKey)
+			delete(fileValues, valuesKey+"Enabled")
+			if values != nil {
+				fileValues[valuesKey] = values
+			}
+			if enabled != nil {
+				fileValues[valuesKey+"Enabled"] = *enabled
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	delete(fcm.savedChecksums, section)
+	if len(configData) == 0 {
+		return nil
+	}
+	return fcm.saveVersion(section)
+}
+
+// saveChecksum remembers the checksum of the saved section to ignore the self-made change.
+func (fcm *fileConfigManager) saveChecksum(name string) error {
+	configData, err := fcm.readConfigData()
+	if err != nil {
+		return err
+	}
+	configData, _, secretErrs := fcm.resolveConfigData(configData)
+	if err, has := secretErrs[name]; has {
+		return err
+	}
+	checksum, err := SectionChecksum(name, configData)
+	if err != nil {
+		return err
+	}
+	fcm.savedChecksums[name] = checksum
+
+	return nil
+}
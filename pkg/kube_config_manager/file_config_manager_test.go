@@ -0,0 +1,163 @@
+package kube_config_manager
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+func Test_FileConfigManager_ValuesFile(t *testing.T) {
+	g := NewWithT(t)
+
+	tmpDir, err := ioutil.TempDir("", "file-config-manager")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "values.yaml")
+	err = ioutil.WriteFile(path, []byte(`
+global:
+  param1: val1
+moduleOne:
+  modParam1: val1
+moduleOneEnabled: false
+`), 0644)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	fcm := NewFileConfigManager(path)
+	fcm.WithContext(context.Background())
+
+	err = fcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	initial := fcm.InitialConfig()
+	g.Expect(initial.Values).Should(Equal(utils.Values{"global": map[string]interface{}{"param1": "val1"}}))
+	g.Expect(initial.ModuleConfigs).Should(HaveKey("module-one"))
+	g.Expect(*initial.ModuleConfigs["module-one"].IsEnabled).Should(BeFalse())
+
+	// Self-made changes should not be reported.
+	err = fcm.SetKubeModuleValues("module-one", utils.Values{"moduleOne": map[string]interface{}{"modParam1": "val2"}})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	err = fcm.(*fileConfigManager).checkChanges()
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ModuleConfigsUpdated).ShouldNot(Receive())
+
+	content, err := ioutil.ReadFile(path)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(string(content)).Should(ContainSubstring("modParam1: val2"))
+	g.Expect(string(content)).Should(ContainSubstring("moduleOneEnabled: false"))
+
+	// External change of global section.
+	err = ioutil.WriteFile(path, []byte(`
+global:
+  param1: val2
+moduleOne:
+  modParam1: val2
+moduleOneEnabled: false
+`), 0644)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	err = fcm.(*fileConfigManager).checkChanges()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	var config Config
+	g.Expect(ConfigUpdated).Should(Receive(&config))
+	g.Expect(config.Values).Should(Equal(utils.Values{"global": map[string]interface{}{"param1": "val2"}}))
+
+	// Invalid change is reported once.
+	err = ioutil.WriteFile(path, []byte(`
+global: [param1, val3]
+moduleOne:
+  modParam1: val2
+moduleOneEnabled: false
+`), 0644)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	err = fcm.(*fileConfigManager).checkChanges()
+	g.Expect(err).Should(HaveOccurred())
+	err = fcm.(*fileConfigManager).checkChanges()
+	g.Expect(err).ShouldNot(HaveOccurred())
+}
+
+func Test_FileConfigManager_Directory(t *testing.T) {
+	g := NewWithT(t)
+
+	tmpDir, err := ioutil.TempDir("", "file-config-manager")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "moduleOneEnabled"), []byte("true"), 0644)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	err = ioutil.WriteFile(filepath.Join(tmpDir, ".hidden"), []byte("garbage"), 0644)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	fcm := NewFileConfigManager(tmpDir)
+	fcm.WithContext(context.Background())
+
+	err = fcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(*fcm.InitialConfig().ModuleConfigs["module-one"].IsEnabled).Should(BeTrue())
+
+	err = fcm.SetKubeGlobalValues(utils.Values{"global": map[string]interface{}{"param1": "val1"}})
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	content, err := ioutil.ReadFile(filepath.Join(tmpDir, "global"))
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(string(content)).Should(ContainSubstring("param1: val1"))
+
+	err = fcm.(*fileConfigManager).checkChanges()
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ConfigUpdated).ShouldNot(Receive())
+
+	// Disable module.
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "moduleOneEnabled"), []byte("false"), 0644)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	err = fcm.(*fileConfigManager).checkChanges()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	var moduleConfigs ModuleConfigs
+	g.Expect(ModuleConfigsUpdated).Should(Receive(&moduleConfigs))
+	g.Expect(*moduleConfigs["module-one"].IsEnabled).Should(BeFalse())
+}
+
+func Test_FileConfigManager_Directory_WriteBack(t *testing.T) {
+	g := NewWithT(t)
+
+	tmpDir, err := ioutil.TempDir("", "file-config-manager")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "global.yaml"), []byte("param1: val1\n"), 0644)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	fcm := NewFileConfigManager(tmpDir)
+	fcm.WithContext(context.Background())
+	err = fcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(fcm.InitialConfig().Values).Should(Equal(utils.Values{"global": map[string]interface{}{"param1": "val1"}}))
+
+	// Section is saved into the file it was read from.
+	err = fcm.SetKubeGlobalValues(utils.Values{"global": map[string]interface{}{"param1": "val2"}})
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	files, err := ioutil.ReadDir(tmpDir)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(files).Should(HaveLen(1))
+	g.Expect(files[0].Name()).Should(Equal("global.yaml"))
+	content, err := ioutil.ReadFile(filepath.Join(tmpDir, "global.yaml"))
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(string(content)).Should(ContainSubstring("param1: val2"))
+
+	configData, err := readConfigDataFromDir(tmpDir)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(configData["global"]).Should(Equal("param1: val2"))
+
+	// Files with the same key are ambiguous.
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "global"), []byte("param1: val3\n"), 0644)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	_, err = readConfigDataFromDir(tmpDir)
+	g.Expect(err).Should(HaveOccurred())
+}
@@ -5,20 +5,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/hashicorp/go-multierror"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	corev1 "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 
 	"github.com/flant/shell-operator/pkg/kube"
+	"github.com/flant/shell-operator/pkg/metric_storage"
 
 	"github.com/flant/addon-operator/pkg/utils"
+	"github.com/flant/addon-operator/pkg/values/migration"
+	"github.com/flant/addon-operator/pkg/values/validation"
 )
 
 type KubeConfigManager interface {
@@ -27,9 +35,19 @@ type KubeConfigManager interface {
 	WithNamespace(namespace string)
 	WithConfigMapName(configMap string)
 	WithValuesChecksumsAnnotation(annotation string)
+	WithValuesValidator(validator *validation.ValuesValidator)
+	WithMetricStorage(storage *metric_storage.MetricStorage)
+	WithConfigMigrations(migrations *migration.Migrations)
+	WithConfigMigrationsWriteBack(writeBack bool)
+	WithModuleConfigMaps(enabled bool)
+	WithConfigHistory(max int, configMapName string)
 	SetKubeGlobalValues(values utils.Values) error
 	SetKubeModuleValues(moduleName string, values utils.Values) error
+	ValidateConfigData(configData map[string]string, versions map[string]int) error
+	ConfigHistory() []ConfigRevision
+	RollbackConfig(revision int, section string) error
 	Init() error
+	MigrateInitialConfig() error
 	Start()
 	Stop()
 	InitialConfig() *Config
@@ -44,12 +62,43 @@ type kubeConfigManager struct {
 	Namespace                 string
 	ConfigMapName             string
 	ValuesChecksumsAnnotation string
+	ValuesValidator           *validation.ValuesValidator
+	ConfigMigrations          *migration.Migrations
+	ConfigMigrationsWriteBack bool
+
+	metricStorage *metric_storage.MetricStorage
 
 	initialConfig *Config
 	currentConfig *Config
 
 	GlobalValuesChecksum  string
 	ModulesValuesChecksum map[string]string
+
+	// The last handled config data to resolve Secret references again on Secret change.
+	configDataLock        sync.Mutex
+	handledConfigSource   string
+	handledConfigData     map[string]string
+	handledSavedChecksums map[string]string
+	handledVersions       map[string]int
+	// The last accepted config data: invalid sections keep their accepted data.
+	acceptedConfigData map[string]string
+	acceptedVersions   map[string]int
+
+	// Config sections with Secret references.
+	secretsLock         sync.Mutex
+	secretSections      map[string]secretSection
+	secretsInformerOnce sync.Once
+	secretsInformer     cache.SharedIndexInformer
+
+	// Values of migrated sections to write back.
+	migratedLock     sync.Mutex
+	migratedSections map[string]utils.Values
+
+	// Module sections in separate ConfigMaps.
+	moduleConfigMaps moduleConfigMaps
+
+	// Accepted config revisions.
+	history configHistory
 }
 
 // kubeConfigManager should implement KubeConfigManager
@@ -120,6 +169,11 @@ func (kcm *kubeConfigManager) saveGlobalKubeConfig(globalKubeConfig GlobalKubeCo
 			return fmt.Errorf("update global values checksum in annotation: %s", err)
 		}
 
+		obj.Annotations, err = kcm.setConfigVersion(obj.Annotations, utils.GlobalValuesKey)
+		if err != nil {
+			return fmt.Errorf("update global values version in annotation: %s", err)
+		}
+
 		obj.Data = simpleMergeConfigMapData(obj.Data, globalKubeConfig.ConfigData)
 
 		return nil
@@ -127,7 +181,7 @@ func (kcm *kubeConfigManager) saveGlobalKubeConfig(globalKubeConfig GlobalKubeCo
 }
 
 func (kcm *kubeConfigManager) saveModuleKubeConfig(moduleKubeConfig ModuleKubeConfig) error {
-	return kcm.changeOrCreateKubeConfig(func(obj *v1.ConfigMap) error {
+	configChangeFunc := func(obj *v1.ConfigMap) error {
 		checksums, err := kcm.getValuesChecksums(obj)
 		if err != nil {
 			return err
@@ -140,18 +194,53 @@ func (kcm *kubeConfigManager) saveModuleKubeConfig(moduleKubeConfig ModuleKubeCo
 			return fmt.Errorf("update module '%s' values checksum in annotation: %s", moduleKubeConfig.ModuleName, err)
 		}
 
+		obj.Annotations, err = kcm.setConfigVersion(obj.Annotations, moduleKubeConfig.ModuleName)
+		if err != nil {
+			return fmt.Errorf("update module '%s' values version in annotation: %s", moduleKubeConfig.ModuleName, err)
+		}
+
 		obj.Data = simpleMergeConfigMapData(obj.Data, moduleKubeConfig.ConfigData)
 
 		return nil
-	})
+	}
+
+	if kcm.moduleConfigMaps.Enabled {
+		return kcm.changeOrCreateModuleConfigMap(moduleKubeConfig.ModuleName, configChangeFunc)
+	}
+	return kcm.changeOrCreateKubeConfig(configChangeFunc)
 }
 
+// changeOrCreateKubeConfig applies configChangeFunc to the fresh ConfigMap and updates it
+// with the resourceVersion of the fresh object. configChangeFunc should change only
+// its own keys, so concurrent changes of other keys are preserved on conflict retry.
 func (kcm *kubeConfigManager) changeOrCreateKubeConfig(configChangeFunc func(*v1.ConfigMap) error) error {
-	var err error
+	return kcm.retryOnConflict(fmt.Sprintf("ConfigMap/%s", kcm.ConfigMapName), func() error {
+		return kcm.tryChangeOrCreateKubeConfig(configChangeFunc)
+	})
+}
 
+// retryOnConflict retries fn if object is changed or created concurrently.
+func (kcm *kubeConfigManager) retryOnConflict(objDescription string, fn func() error) error {
+	attempt := 0
+	return retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return errors.IsConflict(err) || errors.IsAlreadyExists(err)
+	}, func() error {
+		attempt++
+		err := fn()
+		if errors.IsConflict(err) || errors.IsAlreadyExists(err) {
+			log.Warnf("Kube config manager: %s is changed concurrently, attempt %d: %s", objDescription, attempt, err)
+			if kcm.metricStorage != nil {
+				kcm.metricStorage.CounterAdd("{PREFIX}kube_config_conflicts_total", 1.0, map[string]string{})
+			}
+		}
+		return err
+	})
+}
+
+func (kcm *kubeConfigManager) tryChangeOrCreateKubeConfig(configChangeFunc func(*v1.ConfigMap) error) error {
 	obj, err := kcm.getConfigMap()
 	if err != nil {
-		return nil
+		return err
 	}
 
 	if obj != nil {
@@ -164,29 +253,22 @@ func (kcm *kubeConfigManager) changeOrCreateKubeConfig(configChangeFunc func(*v1
 			return err
 		}
 
-		_, err := kcm.KubeClient.CoreV1().ConfigMaps(kcm.Namespace).Update(obj)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	} else {
-		obj := &v1.ConfigMap{}
-		obj.Name = kcm.ConfigMapName
-		obj.Data = make(map[string]string)
-
-		err = configChangeFunc(obj)
-		if err != nil {
-			return err
-		}
+		// obj has resourceVersion of the fresh object, so Update fails on concurrent change.
+		_, err = kcm.KubeClient.CoreV1().ConfigMaps(kcm.Namespace).Update(obj)
+		return err
+	}
 
-		_, err := kcm.KubeClient.CoreV1().ConfigMaps(kcm.Namespace).Create(obj)
-		if err != nil {
-			return err
-		}
+	obj = &v1.ConfigMap{}
+	obj.Name = kcm.ConfigMapName
+	obj.Data = make(map[string]string)
 
-		return nil
+	err = configChangeFunc(obj)
+	if err != nil {
+		return err
 	}
+
+	_, err = kcm.KubeClient.CoreV1().ConfigMaps(kcm.Namespace).Create(obj)
+	return err
 }
 
 func (kcm *kubeConfigManager) WithNamespace(namespace string) {
@@ -201,6 +283,14 @@ func (kcm *kubeConfigManager) WithValuesChecksumsAnnotation(annotation string) {
 	kcm.ValuesChecksumsAnnotation = annotation
 }
 
+func (kcm *kubeConfigManager) WithValuesValidator(validator *validation.ValuesValidator) {
+	kcm.ValuesValidator = validator
+}
+
+func (kcm *kubeConfigManager) WithMetricStorage(storage *metric_storage.MetricStorage) {
+	kcm.metricStorage = storage
+}
+
 func (kcm *kubeConfigManager) SetKubeGlobalValues(values utils.Values) error {
 	globalKubeConfig, err := GetGlobalKubeConfigFromValues(values)
 	if err != nil {
@@ -210,6 +300,11 @@ func (kcm *kubeConfigManager) SetKubeGlobalValues(values utils.Values) error {
 	if globalKubeConfig != nil {
 		log.Debugf("Kube config manager: set kube global values:\n%s", values.DebugString())
 
+		globalKubeConfig.ConfigData, err = kcm.configDataWithSecretRefs(utils.GlobalValuesKey, values, globalKubeConfig.ConfigData)
+		if err != nil {
+			return err
+		}
+
 		err := kcm.saveGlobalKubeConfig(*globalKubeConfig)
 		if err != nil {
 			return err
@@ -228,6 +323,11 @@ func (kcm *kubeConfigManager) SetKubeModuleValues(moduleName string, values util
 	if moduleKubeConfig != nil {
 		log.Debugf("Kube config manager: set kube module values:\n%s", moduleKubeConfig.ModuleConfig.String())
 
+		moduleKubeConfig.ConfigData, err = kcm.configDataWithSecretRefs(utils.ModuleNameToValuesKey(moduleName), values, moduleKubeConfig.ConfigData)
+		if err != nil {
+			return err
+		}
+
 		err := kcm.saveModuleKubeConfig(*moduleKubeConfig)
 		if err != nil {
 			return err
@@ -279,10 +379,15 @@ func NewKubeConfigManager() KubeConfigManager {
 	kcm := &kubeConfigManager{}
 	kcm.initialConfig = NewConfig()
 	kcm.currentConfig = NewConfig()
+	kcm.ModulesValuesChecksum = make(map[string]string)
 	return kcm
 }
 
 func (kcm *kubeConfigManager) initConfig() error {
+	if kcm.moduleConfigMaps.Enabled {
+		return kcm.initConfigFromConfigMaps()
+	}
+
 	obj, err := kcm.getConfigMap()
 	if err != nil {
 		return err
@@ -293,11 +398,40 @@ func (kcm *kubeConfigManager) initConfig() error {
 		return nil
 	}
 
+	versions, err := GetConfigVersions(obj.Annotations)
+	if err != nil {
+		return fmt.Errorf("ConfigMap/%s: %s", obj.Name, err)
+	}
+
+	return kcm.initConfigFromData(obj.Data, versions)
+}
+
+// initConfigFromData sets initial config and checksums from ConfigMap-like data.
+// versions are versions of sections to apply migrations. Sections with bad yaml are
+// skipped, so the operator can start. Sections are validated with schemas by ModuleManager.
+func (kcm *kubeConfigManager) initConfigFromData(rawConfigData map[string]string, versions map[string]int) error {
 	initialConfig := NewConfig()
 	globalValuesChecksum := ""
 	modulesValuesChecksum := make(map[string]string)
 
-	globalKubeConfig, err := GetGlobalKubeConfigFromConfigData(obj.Data)
+	data, err := kcm.prepareConfigData(rawConfigData, versions)
+	if err != nil {
+		return err
+	}
+
+	sectionErrs := sectionErrors(data.resolved, nil)
+	mergeSectionErrors(sectionErrs, data.secretErrors)
+	if len(sectionErrs) > 0 {
+		log.Errorf("Kube config manager: initial config has invalid sections, they are ignored:\n%s", joinSectionErrors(sectionErrs))
+		rawConfigData, versions = withoutSections(rawConfigData, versions, sectionErrs)
+		data, err = kcm.prepareConfigData(rawConfigData, versions)
+		if err != nil {
+			return err
+		}
+	}
+	configData := data.resolved
+
+	globalKubeConfig, err := GetGlobalKubeConfigFromConfigData(configData)
 	if err != nil {
 		return err
 	}
@@ -306,9 +440,9 @@ func (kcm *kubeConfigManager) initConfig() error {
 		globalValuesChecksum = globalKubeConfig.Checksum
 	}
 
-	for moduleName := range GetModulesNamesFromConfigData(obj.Data) {
+	for moduleName := range GetModulesNamesFromConfigData(configData) {
 		// all GetModulesNamesFromConfigData must exist
-		moduleKubeConfig, err := ExtractModuleKubeConfig(moduleName, obj.Data)
+		moduleKubeConfig, err := ExtractModuleKubeConfig(moduleName, configData)
 		if err != nil {
 			return err
 		}
@@ -322,7 +456,52 @@ func (kcm *kubeConfigManager) initConfig() error {
 	kcm.GlobalValuesChecksum = globalValuesChecksum
 	kcm.ModulesValuesChecksum = modulesValuesChecksum
 
-	return nil
+	kcm.configDataLock.Lock()
+	kcm.handledConfigSource = "initial config"
+	kcm.handledConfigData = rawConfigData
+	kcm.handledSavedChecksums = make(map[string]string)
+	kcm.handledVersions = versions
+	kcm.acceptedConfigData = rawConfigData
+	kcm.acceptedVersions = versions
+	kcm.configDataLock.Unlock()
+	kcm.setSecretSections(data.secretSections)
+	kcm.recordConfigRevision("initial config", data.migrated)
+
+	return kcm.setMigratedSections(data.migratedSections, data.unversionedSections, configData)
+}
+
+// preparedConfigData is ConfigMap-like data with migrated sections and resolved Secret references.
+type preparedConfigData struct {
+	// Migrated data with Secret references.
+	migrated map[string]string
+	// Names of migrated sections.
+	migratedSections []string
+	// Names of unversioned sections not changed by migrations.
+	unversionedSections []string
+	// Migrated data with Secret references replaced by Secrets data.
+	resolved       map[string]string
+	secretSections map[string]secretSection
+	// Errors of sections with references that cannot be resolved.
+	secretErrors map[string]error
+}
+
+// prepareConfigData migrates sections and resolves Secret references.
+func (kcm *kubeConfigManager) prepareConfigData(rawConfigData map[string]string, versions map[string]int) (*preparedConfigData, error) {
+	migratedData, migrated, unversioned, err := kcm.migrateConfigData(rawConfigData, versions)
+	if err != nil {
+		return nil, fmt.Errorf("cannot migrate config values: %s", err)
+	}
+
+	configData, secretSections, secretErrs := kcm.resolveConfigData(migratedData)
+
+	return &preparedConfigData{
+		migrated:            migratedData,
+		migratedSections:    migrated,
+		unversionedSections: unversioned,
+		resolved:            configData,
+		secretSections:      secretSections,
+		secretErrors:        secretErrs,
+	}, nil
 }
 
 func (kcm *kubeConfigManager) Init() error {
@@ -336,7 +515,12 @@ func (kcm *kubeConfigManager) Init() error {
 	ConfigUpdated = make(chan Config, 1)
 	ModuleConfigsUpdated = make(chan ModuleConfigs, 1)
 
-	err := kcm.initConfig()
+	err := kcm.loadConfigHistory()
+	if err != nil {
+		return err
+	}
+
+	err = kcm.initConfig()
 	if err != nil {
 		return err
 	}
@@ -386,10 +570,70 @@ func (kcm *kubeConfigManager) handleNewCm(obj *v1.ConfigMap) error {
 		return err
 	}
 
-	globalKubeConfig, err := GetGlobalKubeConfigFromConfigData(obj.Data)
+	versions, err := GetConfigVersions(obj.Annotations)
+	if err != nil {
+		return fmt.Errorf("ConfigMap/%s: %s", obj.Name, err)
+	}
+
+	// Migrated valid sections are saved even if other sections are invalid.
+	err = kcm.handleNewConfigData(fmt.Sprintf("ConfigMap/%s", obj.Name), obj.Data, savedChecksums, versions)
+	kcm.writeBackMigratedSections(kcm)
+	return err
+}
+
+// handleNewConfigData determine changes in ConfigMap-like data. savedChecksums
+// are checksums of values saved by SetKubeGlobalValues and SetKubeModuleValues.
+// versions are versions of sections to apply migrations.
+func (kcm *kubeConfigManager) handleNewConfigData(source string, configData map[string]string, savedChecksums map[string]string, versions map[string]int) error {
+	kcm.configDataLock.Lock()
+	defer kcm.configDataLock.Unlock()
+
+	kcm.handledConfigSource = source
+	kcm.handledConfigData = configData
+	kcm.handledSavedChecksums = savedChecksums
+	kcm.handledVersions = versions
+
+	return kcm.handleConfigData(source, configData, savedChecksums, versions)
+}
+
+// handleConfigData migrates sections and resolves Secret references in ConfigMap-like data
+// and determines changes.
+func (kcm *kubeConfigManager) handleConfigData(source string, rawConfigData map[string]string, savedChecksums map[string]string, versions map[string]int) error {
+	data, err := kcm.prepareConfigData(rawConfigData, versions)
+	if err != nil {
+		return fmt.Errorf("%s: %s, changes are ignored", source, err)
+	}
+
+	// Reject invalid sections: they keep data from the last accepted config,
+	// so changes in other sections are applied.
+	sectionErrs := sectionErrors(data.resolved, kcm.ValuesValidator)
+	mergeSectionErrors(sectionErrs, data.secretErrors)
+	if len(sectionErrs) > 0 {
+		rawConfigData, versions = withoutSections(rawConfigData, versions, sectionErrs)
+		rawConfigData, versions = withSections(rawConfigData, versions, kcm.acceptedConfigData, kcm.acceptedVersions, sectionErrs)
+		data, err = kcm.prepareConfigData(rawConfigData, versions)
+		if err != nil {
+			return fmt.Errorf("%s: %s, changes are ignored", source, err)
+		}
+		if len(data.secretErrors) > 0 {
+			return fmt.Errorf("%s: %s, changes are ignored", source, joinSectionErrors(data.secretErrors))
+		}
+	}
+	kcm.acceptedConfigData = rawConfigData
+	kcm.acceptedVersions = versions
+	configData := data.resolved
+
+	globalKubeConfig, err := GetGlobalKubeConfigFromConfigData(configData)
+	if err != nil {
+		return err
+	}
+
+	kcm.setSecretSections(data.secretSections)
+	err = kcm.setMigratedSections(data.migratedSections, data.unversionedSections, configData)
 	if err != nil {
 		return err
 	}
+	kcm.recordConfigRevision(source, data.migrated)
 
 	// if global values are changed or deleted then new config should be sent over ConfigUpdated channel
 	isGlobalUpdated := globalKubeConfig != nil &&
@@ -411,9 +655,9 @@ func (kcm *kubeConfigManager) handleNewCm(obj *v1.ConfigMap) error {
 
 		// calculate new checksums of a module sections
 		newModulesValuesChecksum := make(map[string]string)
-		for moduleName := range GetModulesNamesFromConfigData(obj.Data) {
+		for moduleName := range GetModulesNamesFromConfigData(configData) {
 			// all GetModulesNamesFromConfigData must exist
-			moduleKubeConfig, err := ExtractModuleKubeConfig(moduleName, obj.Data)
+			moduleKubeConfig, err := ExtractModuleKubeConfig(moduleName, configData)
 			if err != nil {
 				return err
 			}
@@ -433,7 +677,7 @@ func (kcm *kubeConfigManager) handleNewCm(obj *v1.ConfigMap) error {
 
 		kcm.currentConfig = newConfig
 	} else {
-		actualModulesNames := GetModulesNamesFromConfigData(obj.Data)
+		actualModulesNames := GetModulesNamesFromConfigData(configData)
 
 		moduleConfigsActual := make(ModuleConfigs)
 		updatedCount := 0
@@ -443,7 +687,7 @@ func (kcm *kubeConfigManager) handleNewCm(obj *v1.ConfigMap) error {
 		// IsUpdated flag set for updated configs
 		for moduleName := range actualModulesNames {
 			// all GetModulesNamesFromConfigData must exist
-			moduleKubeConfig, err := ExtractModuleKubeConfig(moduleName, obj.Data)
+			moduleKubeConfig, err := ExtractModuleKubeConfig(moduleName, configData)
 			if err != nil {
 				return err
 			}
@@ -477,9 +721,136 @@ func (kcm *kubeConfigManager) handleNewCm(obj *v1.ConfigMap) error {
 		}
 	}
 
+	if len(sectionErrs) > 0 {
+		return fmt.Errorf("%s has invalid sections, their changes are ignored:\n%s", source, joinSectionErrors(sectionErrs))
+	}
 	return nil
 }
 
+// SectionChecksum returns a checksum of the global section or the module section
+// in ConfigMap-like data as calculated by handleNewConfigData.
+func SectionChecksum(moduleName string, configData map[string]string) (string, error) {
+	if moduleName == utils.GlobalValuesKey {
+		globalKubeConfig, err := GetGlobalKubeConfigFromConfigData(configData)
+		if err != nil || globalKubeConfig == nil {
+			return "", err
+		}
+		return globalKubeConfig.Checksum, nil
+	}
+
+	moduleKubeConfig, err := ExtractModuleKubeConfig(moduleName, configData)
+	if err != nil {
+		return "", err
+	}
+	return moduleKubeConfig.Checksum, nil
+}
+
+// validateConfigData validates global section and module sections with OpenAPI schemas.
+// Errors for all sections are returned at once.
+func (kcm *kubeConfigManager) validateConfigData(configData map[string]string) error {
+	sectionErrs := sectionErrors(configData, kcm.ValuesValidator)
+	if len(sectionErrs) == 0 {
+		return nil
+	}
+	return joinSectionErrors(sectionErrs)
+}
+
+// sectionErrors parses the global section and module sections and validates them
+// with OpenAPI schemas if validator is not nil. Errors are returned by section names:
+// 'global' or a module name.
+func sectionErrors(configData map[string]string, validator *validation.ValuesValidator) map[string]error {
+	errs := make(map[string]error)
+
+	globalKubeConfig, err := GetGlobalKubeConfigFromConfigData(configData)
+	if err != nil {
+		errs[utils.GlobalValuesKey] = err
+	} else if globalKubeConfig != nil && validator != nil {
+		err = validator.ValidateGlobalConfigValues(globalKubeConfig.Values)
+		if err != nil {
+			errs[utils.GlobalValuesKey] = err
+		}
+	}
+
+	for moduleName := range GetModulesNamesFromConfigData(configData) {
+		moduleKubeConfig, err := ExtractModuleKubeConfig(moduleName, configData)
+		if err != nil {
+			errs[moduleName] = err
+			continue
+		}
+		if validator == nil {
+			continue
+		}
+		err = validator.ValidateModuleConfigValues(moduleName, moduleKubeConfig.Values)
+		if err != nil {
+			errs[moduleName] = err
+		}
+	}
+
+	return errs
+}
+
+// mergeSectionErrors adds errors of sections to errs. Errors in errs are replaced.
+func mergeSectionErrors(errs map[string]error, other map[string]error) {
+	for section, err := range other {
+		errs[section] = err
+	}
+}
+
+// joinSectionErrors returns errors of sections in a stable order.
+func joinSectionErrors(sectionErrs map[string]error) error {
+	names := make([]string, 0, len(sectionErrs))
+	for name := range sectionErrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var allErrs *multierror.Error
+	for _, name := range names {
+		allErrs = multierror.Append(allErrs, sectionErrs[name])
+	}
+	return allErrs.ErrorOrNil()
+}
+
+// sectionKeys returns ConfigMap keys of the section: 'global' or module values key and its enabled flag.
+func sectionKeys(section string) []string {
+	if section == utils.GlobalValuesKey {
+		return []string{utils.GlobalValuesKey}
+	}
+	valuesKey := utils.ModuleNameToValuesKey(section)
+	return []string{valuesKey, valuesKey + "Enabled"}
+}
+
+// withoutSections returns a copy of config data and versions without the sections.
+func withoutSections(configData map[string]string, versions map[string]int, sections map[string]error) (map[string]string, map[string]int) {
+	resData := simpleMergeConfigMapData(make(map[string]string, len(configData)), configData)
+	resVersions := make(map[string]int, len(versions))
+	for section, version := range versions {
+		resVersions[section] = version
+	}
+	for section := range sections {
+		for _, key := range sectionKeys(section) {
+			delete(resData, key)
+		}
+		delete(resVersions, section)
+	}
+	return resData, resVersions
+}
+
+// withSections copies the sections from other config data and versions.
+func withSections(configData map[string]string, versions map[string]int, otherData map[string]string, otherVersions map[string]int, sections map[string]error) (map[string]string, map[string]int) {
+	for section := range sections {
+		for _, key := range sectionKeys(section) {
+			if data, has := otherData[key]; has {
+				configData[key] = data
+			}
+		}
+		if version, has := otherVersions[section]; has {
+			versions[section] = version
+		}
+	}
+	return configData, versions
+}
+
 func (kcm *kubeConfigManager) handleCmAdd(obj *v1.ConfigMap) error {
 	if VerboseDebug {
 		objYaml, err := yaml.Marshal(obj)
@@ -548,6 +919,11 @@ func (kcm *kubeConfigManager) handleCmDelete(obj *v1.ConfigMap) error {
 }
 
 func (kcm *kubeConfigManager) Start() {
+	if kcm.moduleConfigMaps.Enabled {
+		kcm.startWithModuleConfigMaps()
+		return
+	}
+
 	log.Debugf("Run kube config manager")
 
 	// define resyncPeriod for informer
@@ -11,11 +11,17 @@ import (
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	k8stesting "k8s.io/client-go/testing"
 
 	"github.com/flant/shell-operator/pkg/kube"
 
 	"github.com/flant/addon-operator/pkg/utils"
+	"github.com/flant/addon-operator/pkg/values/validation"
 )
 
 func Test_LoadValues_On_Init(t *testing.T) {
@@ -381,3 +387,170 @@ moduleLongName:
 	g.Expect(anno).To(ContainSubstring("module-long-name"))
 	g.Expect(anno).To(ContainSubstring("module1"))
 }
+
+// handleNewCm should reject ConfigMap with values not valid against OpenAPI schemas.
+func TestKubeConfigManager_RejectInvalidModuleValues(t *testing.T) {
+	g := NewWithT(t)
+
+	validator := validation.NewValuesValidator()
+	err := validator.SchemaStorage.AddModuleValuesSchemas("module-one", []byte(`
+type: object
+properties:
+  param1:
+    type: string
+`), nil)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	kubeClient := kube.NewFakeKubernetesClient()
+
+	cm := &v1.ConfigMap{}
+	cm.SetNamespace("default")
+	cm.SetName(app.ConfigMapName)
+	cm.Data = map[string]string{
+		"global": `
+param1: val1
+`,
+	}
+	_, err = kubeClient.CoreV1().ConfigMaps("default").Create(cm)
+	g.Expect(err).ShouldNot(HaveOccurred(), "ConfigMap should be created")
+
+	kcm := NewKubeConfigManager()
+	kcm.WithContext(context.Background())
+	kcm.WithKubeClient(kubeClient)
+	kcm.WithNamespace("default")
+	kcm.WithConfigMapName(app.ConfigMapName)
+	kcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+	kcm.WithValuesValidator(validator)
+
+	err = kcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred(), "KubeConfigManager should init correctly")
+
+	cm.Data["moduleOne"] = `
+param1: val1
+parma2: val2
+`
+
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("module 'module-one' config values are not valid"))
+	g.Expect(err.Error()).Should(ContainSubstring("moduleOne.parma2 is a forbidden property"))
+	g.Expect(kcm.(*kubeConfigManager).ModulesValuesChecksum).ShouldNot(HaveKey("module-one"))
+
+	cm.Data["moduleOne"] = `
+param1: val1
+`
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(kcm.(*kubeConfigManager).ModulesValuesChecksum).Should(HaveKey("module-one"))
+	g.Expect(<-ModuleConfigsUpdated).Should(HaveKey("module-one"))
+}
+
+// Changes in valid sections should be applied when other sections are invalid.
+func TestKubeConfigManager_RejectInvalidSectionOnly(t *testing.T) {
+	g := NewWithT(t)
+
+	validator := validation.NewValuesValidator()
+	err := validator.SchemaStorage.AddModuleValuesSchemas("module-one", []byte(`
+type: object
+properties:
+  param1:
+    type: string
+`), nil)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	kubeClient := kube.NewFakeKubernetesClient()
+
+	cm := &v1.ConfigMap{}
+	cm.SetNamespace("default")
+	cm.SetName(app.ConfigMapName)
+	cm.Data = map[string]string{
+		"moduleOne": "param1: val1\n",
+		"moduleTwo": "param1: val1\n",
+	}
+	_, err = kubeClient.CoreV1().ConfigMaps("default").Create(cm)
+	g.Expect(err).ShouldNot(HaveOccurred(), "ConfigMap should be created")
+
+	kcm := NewKubeConfigManager()
+	kcm.WithContext(context.Background())
+	kcm.WithKubeClient(kubeClient)
+	kcm.WithNamespace("default")
+	kcm.WithConfigMapName(app.ConfigMapName)
+	kcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+	kcm.WithValuesValidator(validator)
+
+	err = kcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred(), "KubeConfigManager should init correctly")
+	moduleOneChecksum := kcm.(*kubeConfigManager).ModulesValuesChecksum["module-one"]
+
+	cm.Data["moduleOne"] = "param1: val1\nparma2: val2\n"
+	cm.Data["moduleTwo"] = "param1: val2\n"
+
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("moduleOne.parma2 is a forbidden property"))
+	g.Expect(err.Error()).ShouldNot(ContainSubstring("moduleTwo"))
+	g.Expect(kcm.(*kubeConfigManager).ModulesValuesChecksum["module-one"]).Should(Equal(moduleOneChecksum))
+
+	updated := <-ModuleConfigsUpdated
+	g.Expect(updated).Should(HaveKey("module-two"))
+	g.Expect(updated["module-two"].Values).Should(Equal(utils.Values{"moduleTwo": map[string]interface{}{"param1": "val2"}}))
+	g.Expect(updated["module-one"].Values).Should(Equal(utils.Values{"moduleOne": map[string]interface{}{"param1": "val1"}}))
+}
+
+// clientWithFakeCoreV1 uses a fake clientset with reactors for CoreV1 resources.
+type clientWithFakeCoreV1 struct {
+	kube.KubernetesClient
+	clientset *fake.Clientset
+}
+
+func (c *clientWithFakeCoreV1) CoreV1() corev1client.CoreV1Interface {
+	return c.clientset.CoreV1()
+}
+
+// SetKubeModuleValues should retry on conflict and preserve concurrent changes of other keys.
+func TestKubeConfigManager_SetKubeModuleValues_Conflict(t *testing.T) {
+	g := NewWithT(t)
+
+	cm := &v1.ConfigMap{}
+	cm.SetNamespace("default")
+	cm.SetName(app.ConfigMapName)
+	cm.Data = map[string]string{
+		"global": "param1: val1\n",
+	}
+
+	clientset := fake.NewSimpleClientset(cm)
+	conflicts := 0
+	clientset.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			return false, nil, nil
+		}
+		conflicts++
+		// Someone changes ConfigMap between get and update.
+		concurrent := cm.DeepCopy()
+		concurrent.Data["moduleTwo"] = "param2: val2\n"
+		err := clientset.Tracker().Update(v1.SchemeGroupVersion.WithResource("configmaps"), concurrent, "default")
+		g.Expect(err).ShouldNot(HaveOccurred())
+		return true, nil, errors.NewConflict(v1.Resource("configmaps"), app.ConfigMapName, nil)
+	})
+
+	kcm := NewKubeConfigManager()
+	kcm.WithContext(context.Background())
+	kcm.WithKubeClient(&clientWithFakeCoreV1{KubernetesClient: kube.NewFakeKubernetesClient(), clientset: clientset})
+	kcm.WithNamespace("default")
+	kcm.WithConfigMapName(app.ConfigMapName)
+	kcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+
+	err := kcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred(), "KubeConfigManager should init correctly")
+
+	err = kcm.SetKubeModuleValues("module-one", utils.Values{"moduleOne": map[string]interface{}{"param1": "val1"}})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(conflicts).Should(Equal(1))
+
+	cm, err = clientset.CoreV1().ConfigMaps("default").Get(app.ConfigMapName, metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(cm.Data).Should(HaveKey("global"))
+	g.Expect(cm.Data).Should(HaveKey("moduleOne"))
+	g.Expect(cm.Data).Should(HaveKeyWithValue("moduleTwo", "param2: val2\n"))
+	g.Expect(cm.Annotations[app.ValuesChecksumsAnnotation]).Should(ContainSubstring("module-one"))
+}
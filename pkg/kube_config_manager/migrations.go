@@ -0,0 +1,228 @@
+package kube_config_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+
+	"github.com/flant/addon-operator/pkg/utils"
+	"github.com/flant/addon-operator/pkg/values/migration"
+)
+
+// ConfigVersionsAnnotation is an annotation of the ConfigMap with versions of config sections:
+//
+// addon-operator/config-versions: '{"global":1,"simple-module":3}'
+//
+// A section without a version has version 0.
+const ConfigVersionsAnnotation = "addon-operator/config-versions"
+
+func (kcm *kubeConfigManager) WithConfigMigrations(migrations *migration.Migrations) {
+	kcm.ConfigMigrations = migrations
+}
+
+func (kcm *kubeConfigManager) WithConfigMigrationsWriteBack(writeBack bool) {
+	kcm.ConfigMigrationsWriteBack = writeBack
+}
+
+// LatestConfigVersion returns a version of config values for the section
+// that is saved by SetKubeGlobalValues and SetKubeModuleValues.
+func (kcm *kubeConfigManager) LatestConfigVersion(section string) int {
+	return kcm.ConfigMigrations.LatestVersion(section)
+}
+
+// GetConfigVersions returns versions of config sections from the annotation.
+func GetConfigVersions(annotations map[string]string) (map[string]int, error) {
+	res := make(map[string]int)
+
+	data, hasKey := annotations[ConfigVersionsAnnotation]
+	if !hasKey {
+		return res, nil
+	}
+	err := json.Unmarshal([]byte(data), &res)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal json annotation '%s': %s\n%s", ConfigVersionsAnnotation, err, data)
+	}
+	return res, nil
+}
+
+// setConfigVersion sets the latest version of the section in the annotation.
+// Annotation is not added if there are no migrations for the section.
+func (kcm *kubeConfigManager) setConfigVersion(annotations map[string]string, section string) (map[string]string, error) {
+	version := kcm.LatestConfigVersion(section)
+	if version == 0 {
+		if _, hasKey := annotations[ConfigVersionsAnnotation]; !hasKey {
+			return annotations, nil
+		}
+	}
+
+	versions, err := GetConfigVersions(annotations)
+	if err != nil {
+		return nil, err
+	}
+	versions[section] = version
+
+	data, err := json.Marshal(versions)
+	if err != nil {
+		return nil, err
+	}
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[ConfigVersionsAnnotation] = string(data)
+	return annotations, nil
+}
+
+// migrateConfigData returns ConfigMap-like data with sections migrated from
+// stored versions to the latest versions and names of migrated sections.
+// Migrations are applied before resolving Secret references, so references
+// are moved with values and can be written back.
+// Names of unversioned sections that are not changed by migrations are returned
+// separately: they are already in the latest shape and are saved with the latest
+// version on write back, so later migrations are not applied to them from the start.
+func (kcm *kubeConfigManager) migrateConfigData(configData map[string]string, versions map[string]int) (map[string]string, []string, []string, error) {
+	migrated := make([]string, 0)
+	unversioned := make([]string, 0)
+	if kcm.ConfigMigrations == nil {
+		return configData, migrated, unversioned, nil
+	}
+
+	// Section name to values key.
+	sections := map[string]string{utils.GlobalValuesKey: utils.GlobalValuesKey}
+	for moduleName := range GetModulesNamesFromConfigData(configData) {
+		sections[moduleName] = utils.ModuleNameToValuesKey(moduleName)
+	}
+
+	res := make(map[string]string, len(configData))
+	simpleMergeConfigMapData(res, configData)
+
+	for section, valuesKey := range sections {
+		data, has := configData[valuesKey]
+		if !has {
+			continue
+		}
+		version := versions[section]
+		latest := kcm.ConfigMigrations.LatestVersion(section)
+		if latest == 0 || version == latest {
+			continue
+		}
+		if version > latest {
+			log.Warnf("Kube config manager: '%s' config values have version %d, the latest known version is %d: values are used as is", section, version, latest)
+			continue
+		}
+
+		var values map[string]interface{}
+		if err := yaml.Unmarshal([]byte(data), &values); err != nil {
+			// Bad yaml is reported by the config parser.
+			continue
+		}
+
+		// Migrations may change values in place, so the original values are kept separately.
+		var original map[string]interface{}
+		_ = yaml.Unmarshal([]byte(data), &original)
+
+		newValues, applied, err := kcm.ConfigMigrations.Migrate(section, version, values)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if version == 0 && reflect.DeepEqual(original, newValues) {
+			unversioned = append(unversioned, section)
+			continue
+		}
+		sectionData, err := utils.Values{valuesKey: newValues}.AsConfigMapData()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("cannot dump yaml for migrated '%s' config values: %s", section, err)
+		}
+		simpleMergeConfigMapData(res, sectionData)
+
+		log.Infof("Kube config manager: '%s' config values are migrated from version %d to %d with %d migrations", section, version, latest, len(applied))
+		migrated = append(migrated, section)
+	}
+
+	sort.Strings(migrated)
+	sort.Strings(unversioned)
+	return res, migrated, unversioned, nil
+}
+
+// setMigratedSections saves values of migrated sections from the accepted config to write them back.
+// Unversioned sections are saved with unchanged values to stamp them with the latest version.
+func (kcm *kubeConfigManager) setMigratedSections(migrated []string, unversioned []string, configData map[string]string) error {
+	if !kcm.ConfigMigrationsWriteBack || len(migrated)+len(unversioned) == 0 {
+		return nil
+	}
+
+	kcm.migratedLock.Lock()
+	defer kcm.migratedLock.Unlock()
+
+	if kcm.migratedSections == nil {
+		kcm.migratedSections = make(map[string]utils.Values)
+	}
+	for _, section := range append(migrated, unversioned...) {
+		if section == utils.GlobalValuesKey {
+			globalKubeConfig, err := GetGlobalKubeConfigFromConfigData(configData)
+			if err != nil {
+				return err
+			}
+			kcm.migratedSections[section] = globalKubeConfig.Values
+			continue
+		}
+		moduleKubeConfig, err := ExtractModuleKubeConfig(section, configData)
+		if err != nil {
+			return err
+		}
+		kcm.migratedSections[section] = moduleKubeConfig.Values
+	}
+	return nil
+}
+
+// writeBackMigratedSections saves migrated and unversioned sections with the latest versions.
+// The backend is a KubeConfigManager that embeds kubeConfigManager, so its own
+// SetKubeGlobalValues and SetKubeModuleValues are used. It should be called
+// without backend locks held.
+func (kcm *kubeConfigManager) writeBackMigratedSections(backend KubeConfigManager) {
+	kcm.migratedLock.Lock()
+	sections := kcm.migratedSections
+	kcm.migratedSections = nil
+	kcm.migratedLock.Unlock()
+
+	for section, values := range sections {
+		var err error
+		if section == utils.GlobalValuesKey {
+			err = backend.SetKubeGlobalValues(values)
+		} else {
+			err = backend.SetKubeModuleValues(section, values)
+		}
+		if err != nil {
+			log.Errorf("Kube config manager: cannot write back '%s' config values: %s", section, err)
+			continue
+		}
+		log.Infof("Kube config manager: '%s' config values are written back with version %d", section, kcm.LatestConfigVersion(section))
+	}
+}
+
+// migrateInitialConfig handles the initial config data again with loaded migrations.
+func (kcm *kubeConfigManager) migrateInitialConfig() error {
+	kcm.configDataLock.Lock()
+	configData := kcm.handledConfigData
+	versions := kcm.handledVersions
+	kcm.configDataLock.Unlock()
+
+	if configData == nil {
+		return nil
+	}
+	return kcm.initConfigFromData(configData, versions)
+}
+
+// MigrateInitialConfig applies migrations to the initial config. Migrations are loaded
+// by ModuleManager with modules after Init, so ModuleManager calls it before using InitialConfig.
+func (kcm *kubeConfigManager) MigrateInitialConfig() error {
+	err := kcm.migrateInitialConfig()
+	if err != nil {
+		return err
+	}
+	kcm.writeBackMigratedSections(kcm)
+	return nil
+}
@@ -0,0 +1,148 @@
+package kube_config_manager
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/flant/shell-operator/pkg/kube"
+
+	"github.com/flant/addon-operator/pkg/app"
+	"github.com/flant/addon-operator/pkg/utils"
+	"github.com/flant/addon-operator/pkg/values/migration"
+)
+
+func TestKubeConfigManager_Migrations(t *testing.T) {
+	g := NewWithT(t)
+
+	kubeClient := kube.NewFakeKubernetesClient()
+
+	cm := &v1.ConfigMap{}
+	cm.SetName(app.ConfigMapName)
+	cm.Data = map[string]string{
+		"global":    "param: value\n",
+		"moduleOne": "replicaCount: 2\n",
+	}
+	_, err := kubeClient.CoreV1().ConfigMaps("default").Create(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	kcm := NewKubeConfigManager()
+	kcm.WithKubeClient(kubeClient)
+	kcm.WithNamespace("default")
+	kcm.WithConfigMapName(app.ConfigMapName)
+	kcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+
+	migrations := migration.NewMigrations()
+	kcm.WithConfigMigrations(migrations)
+	kcm.WithConfigMigrationsWriteBack(true)
+
+	err = kcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	// Migrations are loaded after Init.
+	err = migrations.Add("module-one", migration.Migration{
+		Version: 2,
+		Source:  "test",
+		Migrate: func(values map[string]interface{}) (map[string]interface{}, error) {
+			return map[string]interface{}{"deployment": map[string]interface{}{"replicas": values["replicaCount"]}}, nil
+		},
+	})
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	err = kcm.MigrateInitialConfig()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	g.Expect(kcm.InitialConfig().ModuleConfigs["module-one"].Values).Should(Equal(utils.Values{
+		"moduleOne": map[string]interface{}{"deployment": map[string]interface{}{"replicas": 2.0}},
+	}))
+
+	// Migrated section is written back with the new version, other sections are not changed.
+	cm, err = kubeClient.CoreV1().ConfigMaps("default").Get(app.ConfigMapName, metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(cm.Data["moduleOne"]).Should(ContainSubstring("deployment"))
+	g.Expect(cm.Data["global"]).Should(Equal("param: value\n"))
+	versions, err := GetConfigVersions(cm.Annotations)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(versions).Should(Equal(map[string]int{"module-one": 2}))
+
+	// Section with the latest version is not migrated again.
+	cm.Data["moduleOne"] = "deployment:\n  replicas: 3\n"
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	moduleConfigs := <-ModuleConfigsUpdated
+	g.Expect(moduleConfigs["module-one"].Values).Should(Equal(utils.Values{
+		"moduleOne": map[string]interface{}{"deployment": map[string]interface{}{"replicas": 3.0}},
+	}))
+}
+
+func TestKubeConfigManager_Migrations_StampUnversioned(t *testing.T) {
+	g := NewWithT(t)
+
+	kubeClient := kube.NewFakeKubernetesClient()
+
+	cm := &v1.ConfigMap{}
+	cm.SetName(app.ConfigMapName)
+	cm.Data = map[string]string{
+		"moduleOne": "deployment:\n  replicas: 2\n",
+		"moduleTwo": "replicaCount: 2\n",
+	}
+	_, err := kubeClient.CoreV1().ConfigMaps("default").Create(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	kcm := NewKubeConfigManager()
+	kcm.WithKubeClient(kubeClient)
+	kcm.WithNamespace("default")
+	kcm.WithConfigMapName(app.ConfigMapName)
+	kcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+
+	migrations := migration.NewMigrations()
+	kcm.WithConfigMigrations(migrations)
+
+	err = kcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	for _, section := range []string{"module-one", "module-two"} {
+		err = migrations.Add(section, migration.Migration{
+			Version: 1,
+			Source:  "test",
+			Migrate: func(values map[string]interface{}) (map[string]interface{}, error) {
+				replicas, has := values["replicaCount"]
+				if !has {
+					return values, nil
+				}
+				return map[string]interface{}{"deployment": map[string]interface{}{"replicas": replicas}}, nil
+			},
+		})
+		g.Expect(err).ShouldNot(HaveOccurred())
+	}
+
+	err = kcm.MigrateInitialConfig()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	// Nothing is written without write back.
+	cm, err = kubeClient.CoreV1().ConfigMaps("default").Get(app.ConfigMapName, metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(cm.Data["moduleOne"]).Should(Equal("deployment:\n  replicas: 2\n"))
+	g.Expect(cm.Data["moduleTwo"]).Should(Equal("replicaCount: 2\n"))
+	g.Expect(cm.Annotations).ShouldNot(HaveKey(ConfigVersionsAnnotation))
+
+	g.Expect(kcm.InitialConfig().ModuleConfigs["module-two"].Values).Should(Equal(utils.Values{
+		"moduleTwo": map[string]interface{}{"deployment": map[string]interface{}{"replicas": 2.0}},
+	}))
+
+	// Unversioned section in the latest shape is stamped with the latest version,
+	// migrated section is written back.
+	kcm.WithConfigMigrationsWriteBack(true)
+	err = kcm.MigrateInitialConfig()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	cm, err = kubeClient.CoreV1().ConfigMaps("default").Get(app.ConfigMapName, metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(cm.Data["moduleOne"]).Should(MatchYAML("deployment:\n  replicas: 2\n"))
+	g.Expect(cm.Data["moduleTwo"]).Should(MatchYAML("deployment:\n  replicas: 2\n"))
+	versions, err := GetConfigVersions(cm.Annotations)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(versions).Should(Equal(map[string]int{"module-one": 1, "module-two": 1}))
+}
@@ -0,0 +1,468 @@
+package kube_config_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// ModuleConfigGVR is a resource of cluster-wide ModuleConfig objects.
+var ModuleConfigGVR = schema.GroupVersionResource{
+	Group:    "addon-operator.flant.com",
+	Version:  "v1alpha1",
+	Resource: "moduleconfigs",
+}
+
+const (
+	ModuleConfigKind = "ModuleConfig"
+	// GlobalModuleConfigName is a name of the ModuleConfig object with the global section.
+	GlobalModuleConfigName = "global"
+)
+
+// ModuleConfigSpec is a spec of the ModuleConfig object.
+//
+// apiVersion: addon-operator.flant.com/v1alpha1
+// kind: ModuleConfig
+// metadata:
+//   name: simple-module
+// spec:
+//   enabled: true
+//   version: 1
+//   settings:
+//     param1: value1
+type ModuleConfigSpec struct {
+	Enabled  *bool                  `json:"enabled,omitempty"`
+	Version  int                    `json:"version,omitempty"`
+	Settings map[string]interface{} `json:"settings,omitempty"`
+}
+
+// moduleConfigManager is a KubeConfigManager that uses ModuleConfig objects
+// instead of the ConfigMap. Objects are converted to the ConfigMap data format,
+// so changes are detected and sent over channels as in kubeConfigManager.
+type moduleConfigManager struct {
+	*kubeConfigManager
+
+	// Versions of settings from ModuleConfig objects to apply migrations.
+	SettingsVersions map[string]int
+
+	handleLock sync.Mutex
+	synced     bool
+}
+
+// moduleConfigManager should implement KubeConfigManager
+var _ KubeConfigManager = &moduleConfigManager{}
+
+func NewModuleConfigManager() KubeConfigManager {
+	return &moduleConfigManager{
+		kubeConfigManager: NewKubeConfigManager().(*kubeConfigManager),
+		SettingsVersions:  make(map[string]int),
+	}
+}
+
+// GetModuleConfigSpec returns the spec of the ModuleConfig object.
+func GetModuleConfigSpec(obj *unstructured.Unstructured) (*ModuleConfigSpec, error) {
+	spec := new(ModuleConfigSpec)
+
+	specObj, has := obj.Object["spec"]
+	if !has || specObj == nil {
+		return spec, nil
+	}
+
+	data, err := json.Marshal(specObj)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, spec)
+	if err != nil {
+		return nil, fmt.Errorf("ModuleConfig/%s: bad spec: %s", obj.GetName(), err)
+	}
+	return spec, nil
+}
+
+// ModuleConfigToConfigData converts ModuleConfig object to ConfigMap data:
+// spec.settings is a yaml string under the values key and spec.enabled is
+// under the enabled key.
+func ModuleConfigToConfigData(name string, spec *ModuleConfigSpec) (map[string]string, error) {
+	configData := make(map[string]string)
+
+	valuesKey := utils.GlobalValuesKey
+	if name != GlobalModuleConfigName {
+		valuesKey = utils.ModuleNameToValuesKey(name)
+		if utils.ModuleNameFromValuesKey(valuesKey) != name {
+			return nil, fmt.Errorf("ModuleConfig/%s: bad name: should be a kebab-cased module name", name)
+		}
+		if spec.Enabled != nil {
+			configData[valuesKey+"Enabled"] = strconv.FormatBool(*spec.Enabled)
+		}
+	}
+
+	if spec.Settings != nil {
+		data, err := yaml.Marshal(spec.Settings)
+		if err != nil {
+			return nil, fmt.Errorf("ModuleConfig/%s: dump settings: %s", name, err)
+		}
+		configData[valuesKey] = string(data)
+	}
+
+	return configData, nil
+}
+
+func (mcm *moduleConfigManager) getValuesChecksums(obj *unstructured.Unstructured) (map[string]string, error) {
+	data, hasKey := obj.GetAnnotations()[mcm.ValuesChecksumsAnnotation]
+	if !hasKey {
+		return make(map[string]string), nil
+	}
+
+	var res map[string]string
+	err := json.Unmarshal([]byte(data), &res)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal json annotation '%s' in ModuleConfig/%s: %s\n%s", mcm.ValuesChecksumsAnnotation, obj.GetName(), err, data)
+	}
+
+	return res, nil
+}
+
+// objectsToConfigData merges ModuleConfig objects into one ConfigMap data.
+func (mcm *moduleConfigManager) objectsToConfigData(objs []*unstructured.Unstructured) (configData map[string]string, savedChecksums map[string]string, versions map[string]int, err error) {
+	configData = make(map[string]string)
+	savedChecksums = make(map[string]string)
+	versions = make(map[string]int)
+
+	for _, obj := range objs {
+		spec, err := GetModuleConfigSpec(obj)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		data, err := ModuleConfigToConfigData(obj.GetName(), spec)
+		if err != nil {
+			log.Errorf("Kube config manager: %s: ignoring object", err)
+			continue
+		}
+		simpleMergeConfigMapData(configData, data)
+		versions[obj.GetName()] = spec.Version
+
+		checksums, err := mcm.getValuesChecksums(obj)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		simpleMergeConfigMapData(savedChecksums, checksums)
+	}
+
+	return configData, savedChecksums, versions, nil
+}
+
+func (mcm *moduleConfigManager) listModuleConfigs() ([]*unstructured.Unstructured, error) {
+	list, err := mcm.KubeClient.Dynamic().Resource(ModuleConfigGVR).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list %s objects: %s", ModuleConfigKind, err)
+	}
+
+	objs := make([]*unstructured.Unstructured, 0, len(list.Items))
+	for i := range list.Items {
+		objs = append(objs, &list.Items[i])
+	}
+	return objs, nil
+}
+
+func (mcm *moduleConfigManager) initConfig() error {
+	objs, err := mcm.listModuleConfigs()
+	if err != nil {
+		return err
+	}
+
+	configData, _, versions, err := mcm.objectsToConfigData(objs)
+	if err != nil {
+		return err
+	}
+	mcm.SettingsVersions = versions
+
+	return mcm.initConfigFromData(configData, versions)
+}
+
+func (mcm *moduleConfigManager) Init() error {
+	log.Debug("INIT: KUBE_CONFIG from ModuleConfig objects")
+
+	ConfigUpdated = make(chan Config, 1)
+	ModuleConfigsUpdated = make(chan ModuleConfigs, 1)
+
+	err := mcm.loadConfigHistory()
+	if err != nil {
+		return err
+	}
+
+	return mcm.initConfig()
+}
+
+func (mcm *moduleConfigManager) MigrateInitialConfig() error {
+	err := mcm.migrateInitialConfig()
+	if err != nil {
+		return err
+	}
+	mcm.writeBackMigratedSections(mcm)
+	return nil
+}
+
+func (mcm *moduleConfigManager) RollbackConfig(revision int, section string) error {
+	return mcm.rollbackConfig(mcm, revision, section)
+}
+
+// restoreSection writes settings and the enabled flag into the ModuleConfig object.
+// The object is deleted if configData is empty.
+func (mcm *moduleConfigManager) restoreSection(section string, configData map[string]string) error {
+	client := mcm.KubeClient.Dynamic().Resource(ModuleConfigGVR)
+
+	if len(configData) == 0 {
+		err := client.Delete(section, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("delete ModuleConfig/%s: %s", section, err)
+		}
+		return nil
+	}
+
+	values, enabled, err := sectionValues(section, configData)
+	if err != nil {
+		return err
+	}
+	spec := &ModuleConfigSpec{Enabled: enabled}
+	if values != nil {
+		settings, ok := values.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("ModuleConfig/%s: settings should be a map, got %T", section, values)
+		}
+		spec.Settings = settings
+	}
+	if version := mcm.LatestConfigVersion(section); version > 0 {
+		spec.Version = version
+	}
+	specObj, err := specToUnstructured(spec)
+	if err != nil {
+		return err
+	}
+
+	return mcm.retryOnConflict(fmt.Sprintf("%s/%s", ModuleConfigKind, section), func() error {
+		obj, err := client.Get(section, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("get ModuleConfig/%s: %s", section, err)
+		}
+		isNew := obj == nil || errors.IsNotFound(err)
+		if isNew {
+			obj = &unstructured.Unstructured{Object: map[string]interface{}{}}
+			obj.SetAPIVersion(ModuleConfigGVR.GroupVersion().String())
+			obj.SetKind(ModuleConfigKind)
+			obj.SetName(section)
+		}
+
+		annotations := obj.GetAnnotations()
+		delete(annotations, mcm.ValuesChecksumsAnnotation)
+		obj.SetAnnotations(annotations)
+		obj.Object["spec"] = specObj
+
+		if isNew {
+			_, err = client.Create(obj, metav1.CreateOptions{})
+		} else {
+			_, err = client.Update(obj, metav1.UpdateOptions{})
+		}
+		return err
+	})
+}
+
+// handleObjects determines changes in all ModuleConfig objects.
+func (mcm *moduleConfigManager) handleObjects(objs []*unstructured.Unstructured) error {
+	configData, savedChecksums, versions, err := mcm.objectsToConfigData(objs)
+	if err != nil {
+		return err
+	}
+
+	err = mcm.handleNewConfigData(fmt.Sprintf("%s objects", ModuleConfigKind), configData, savedChecksums, versions)
+	if err != nil {
+		return err
+	}
+	mcm.SettingsVersions = versions
+	mcm.writeBackMigratedSections(mcm)
+	return nil
+}
+
+func (mcm *moduleConfigManager) handleStore(store cache.Store, force bool) {
+	mcm.handleLock.Lock()
+	defer mcm.handleLock.Unlock()
+
+	// Ignore events from the initial list, all objects are handled at once after sync.
+	if !mcm.synced && !force {
+		return
+	}
+	mcm.synced = true
+
+	objs := make([]*unstructured.Unstructured, 0)
+	for _, item := range store.List() {
+		if obj, ok := item.(*unstructured.Unstructured); ok {
+			objs = append(objs, obj)
+		}
+	}
+
+	err := mcm.handleObjects(objs)
+	if err != nil {
+		log.Errorf("Kube config manager: cannot handle %s objects: %s", ModuleConfigKind, err)
+	}
+}
+
+func (mcm *moduleConfigManager) Start() {
+	log.Debugf("Run kube config manager for %s objects", ModuleConfigKind)
+
+	// define resyncPeriod for informer
+	resyncPeriod := time.Duration(5) * time.Minute
+
+	// define indexers for informer
+	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+
+	informer := dynamicinformer.NewFilteredDynamicInformer(mcm.KubeClient.Dynamic(), ModuleConfigGVR, metav1.NamespaceAll, resyncPeriod, indexers, nil)
+	store := informer.Informer().GetStore()
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			mcm.handleStore(store, false)
+		},
+		UpdateFunc: func(prevObj interface{}, obj interface{}) {
+			mcm.handleStore(store, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			mcm.handleStore(store, false)
+		},
+	})
+
+	go informer.Informer().Run(mcm.ctx.Done())
+
+	if !cache.WaitForCacheSync(mcm.ctx.Done(), informer.Informer().HasSynced) {
+		log.Errorf("Kube config manager: %s informer is not synced", ModuleConfigKind)
+		return
+	}
+	mcm.handleStore(store, true)
+}
+
+func (mcm *moduleConfigManager) SetKubeGlobalValues(values utils.Values) error {
+	if !values.HasGlobal() {
+		return nil
+	}
+
+	log.Debugf("Kube config manager: set kube global values:\n%s", values.DebugString())
+
+	return mcm.saveSettings(GlobalModuleConfigName, utils.GlobalValuesKey, values)
+}
+
+func (mcm *moduleConfigManager) SetKubeModuleValues(moduleName string, values utils.Values) error {
+	valuesKey := utils.ModuleNameToValuesKey(moduleName)
+	if !values.HasKey(valuesKey) {
+		return nil
+	}
+
+	log.Debugf("Kube config manager: set kube module '%s' values:\n%s", moduleName, values.DebugString())
+
+	return mcm.saveSettings(moduleName, valuesKey, values)
+}
+
+// saveSettings updates spec.settings of the ModuleConfig object or creates a new object.
+// The checksum of new settings is saved into annotation to ignore the self-made change.
+// Values from Secrets are saved as references.
+func (mcm *moduleConfigManager) saveSettings(name string, valuesKey string, values utils.Values) error {
+	settings, ok := values[valuesKey].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("ModuleConfig/%s: settings should be a map, got:\n%s", name, values.DebugString())
+	}
+	storedSettings, _ := mcm.withSecretRefs(valuesKey, settings).(map[string]interface{})
+
+	err := mcm.retryOnConflict(fmt.Sprintf("%s/%s", ModuleConfigKind, name), func() error {
+		return mcm.trySaveSettings(name, settings, storedSettings)
+	})
+	if err != nil {
+		return fmt.Errorf("save %s/%s: %s", ModuleConfigKind, name, err)
+	}
+	return nil
+}
+
+// trySaveSettings saves storedSettings into the object. The checksum is calculated for settings,
+// because references to Secrets are resolved before calculating checksums in handleNewConfigData.
+func (mcm *moduleConfigManager) trySaveSettings(name string, settings map[string]interface{}, storedSettings map[string]interface{}) error {
+	client := mcm.KubeClient.Dynamic().Resource(ModuleConfigGVR)
+
+	obj, err := client.Get(name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("get ModuleConfig/%s: %s", name, err)
+	}
+	isNew := obj == nil || errors.IsNotFound(err)
+	if isNew {
+		obj = &unstructured.Unstructured{Object: map[string]interface{}{}}
+		obj.SetAPIVersion(ModuleConfigGVR.GroupVersion().String())
+		obj.SetKind(ModuleConfigKind)
+		obj.SetName(name)
+	}
+
+	spec, err := GetModuleConfigSpec(obj)
+	if err != nil {
+		return err
+	}
+	spec.Settings = settings
+
+	checksum, err := mcm.settingsChecksum(name, spec)
+	if err != nil {
+		return err
+	}
+	spec.Settings = storedSettings
+	if version := mcm.LatestConfigVersion(name); version > 0 {
+		spec.Version = version
+	}
+	checksums, err := json.Marshal(map[string]string{name: checksum})
+	if err != nil {
+		return err
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[mcm.ValuesChecksumsAnnotation] = string(checksums)
+	obj.SetAnnotations(annotations)
+
+	specObj, err := specToUnstructured(spec)
+	if err != nil {
+		return err
+	}
+	obj.Object["spec"] = specObj
+
+	// The API error is returned as is to retry on conflict.
+	if isNew {
+		_, err = client.Create(obj, metav1.CreateOptions{})
+	} else {
+		_, err = client.Update(obj, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// settingsChecksum returns a checksum as calculated by handleNewConfigData.
+func (mcm *moduleConfigManager) settingsChecksum(name string, spec *ModuleConfigSpec) (string, error) {
+	configData, err := ModuleConfigToConfigData(name, spec)
+	if err != nil {
+		return "", err
+	}
+	return SectionChecksum(name, configData)
+}
+
+func specToUnstructured(spec *ModuleConfigSpec) (map[string]interface{}, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var res map[string]interface{}
+	err = json.Unmarshal(data, &res)
+	return res, err
+}
@@ -0,0 +1,186 @@
+package kube_config_manager
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/flant/shell-operator/pkg/kube"
+	"github.com/flant/shell-operator/pkg/metric_storage"
+
+	"github.com/flant/addon-operator/pkg/app"
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+func newModuleConfig(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion(ModuleConfigGVR.GroupVersion().String())
+	obj.SetKind(ModuleConfigKind)
+	obj.SetName(name)
+	return obj
+}
+
+func Test_ModuleConfigManager_Init_And_Update(t *testing.T) {
+	g := NewWithT(t)
+
+	kubeClient := kube.NewFakeKubernetesClient()
+	client := kubeClient.Dynamic().Resource(ModuleConfigGVR)
+
+	_, err := client.Create(newModuleConfig("global", map[string]interface{}{
+		"settings": map[string]interface{}{"param1": "val1"},
+	}), metav1.CreateOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	_, err = client.Create(newModuleConfig("module-one", map[string]interface{}{
+		"enabled":  false,
+		"version":  int64(2),
+		"settings": map[string]interface{}{"modParam1": "val1"},
+	}), metav1.CreateOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	mcm := NewModuleConfigManager()
+	mcm.WithContext(context.Background())
+	mcm.WithKubeClient(kubeClient)
+	mcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+
+	err = mcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	initial := mcm.InitialConfig()
+	g.Expect(initial.Values).Should(Equal(utils.Values{"global": map[string]interface{}{"param1": "val1"}}))
+	g.Expect(initial.ModuleConfigs).Should(HaveKey("module-one"))
+	moduleConfig := initial.ModuleConfigs["module-one"]
+	g.Expect(moduleConfig.IsEnabled).ShouldNot(BeNil())
+	g.Expect(*moduleConfig.IsEnabled).Should(BeFalse())
+	g.Expect(moduleConfig.Values).Should(Equal(utils.Values{"moduleOne": map[string]interface{}{"modParam1": "val1"}}))
+	g.Expect(mcm.(*moduleConfigManager).SettingsVersions).Should(HaveKeyWithValue("module-one", 2))
+
+	go mcm.Start()
+	defer mcm.Stop()
+
+	// Update module settings.
+	_, err = client.Update(newModuleConfig("module-one", map[string]interface{}{
+		"enabled":  true,
+		"settings": map[string]interface{}{"modParam1": "val2"},
+	}), metav1.UpdateOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	moduleConfigs := <-ModuleConfigsUpdated
+	g.Expect(moduleConfigs).Should(HaveKey("module-one"))
+	g.Expect(moduleConfigs["module-one"].IsUpdated).Should(BeTrue())
+	g.Expect(*moduleConfigs["module-one"].IsEnabled).Should(BeTrue())
+
+	// Delete global section.
+	err = client.Delete("global", &metav1.DeleteOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	config := <-ConfigUpdated
+	g.Expect(config.Values).Should(BeEmpty())
+	g.Expect(config.ModuleConfigs).Should(HaveKey("module-one"))
+}
+
+func Test_ModuleConfigManager_SetKubeModuleValues(t *testing.T) {
+	g := NewWithT(t)
+
+	kubeClient := kube.NewFakeKubernetesClient()
+	client := kubeClient.Dynamic().Resource(ModuleConfigGVR)
+
+	_, err := client.Create(newModuleConfig("module-one", map[string]interface{}{
+		"enabled": true,
+		"version": int64(1),
+	}), metav1.CreateOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	mcm := NewModuleConfigManager()
+	mcm.WithContext(context.Background())
+	mcm.WithKubeClient(kubeClient)
+	mcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+
+	err = mcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	// Update existing object, enabled and version should stay unchanged.
+	err = mcm.SetKubeModuleValues("module-one", utils.Values{"moduleOne": map[string]interface{}{"param1": "val1"}})
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	obj, err := client.Get("module-one", metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	spec, err := GetModuleConfigSpec(obj)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(*spec.Enabled).Should(BeTrue())
+	g.Expect(spec.Version).Should(Equal(1))
+	g.Expect(spec.Settings).Should(Equal(map[string]interface{}{"param1": "val1"}))
+	g.Expect(obj.GetAnnotations()).Should(HaveKey(app.ValuesChecksumsAnnotation))
+	g.Expect(obj.GetAnnotations()[app.ValuesChecksumsAnnotation]).Should(ContainSubstring("module-one"))
+
+	// Create a new object for the global section.
+	err = mcm.SetKubeGlobalValues(utils.Values{"global": map[string]interface{}{"param1": "val1"}})
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	obj, err = client.Get("global", metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	spec, err = GetModuleConfigSpec(obj)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(spec.Enabled).Should(BeNil())
+	g.Expect(spec.Settings).Should(Equal(map[string]interface{}{"param1": "val1"}))
+
+	// Self-made changes should not be reported.
+	objs, err := mcm.(*moduleConfigManager).listModuleConfigs()
+	g.Expect(err).ShouldNot(HaveOccurred())
+	err = mcm.(*moduleConfigManager).handleObjects(objs)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ConfigUpdated).ShouldNot(Receive())
+	g.Expect(ModuleConfigsUpdated).ShouldNot(Receive())
+}
+
+// SetKubeModuleValues should retry on conflict and count conflicts.
+func Test_ModuleConfigManager_SetKubeModuleValues_Conflict(t *testing.T) {
+	g := NewWithT(t)
+
+	kubeClient := kube.NewFakeKubernetesClient()
+	client := kubeClient.Dynamic().Resource(ModuleConfigGVR)
+
+	_, err := client.Create(newModuleConfig("module-one", map[string]interface{}{
+		"enabled": true,
+	}), metav1.CreateOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	updates := 0
+	kubeClient.Dynamic().(*fakedynamic.FakeDynamicClient).PrependReactor("update", ModuleConfigGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		if updates > 1 {
+			return false, nil, nil
+		}
+		return true, nil, errors.NewConflict(ModuleConfigGVR.GroupResource(), "module-one", nil)
+	})
+
+	mstor := metric_storage.NewMetricStorage()
+	mstor.WithNewRegistry()
+
+	mcm := NewModuleConfigManager()
+	mcm.WithContext(context.Background())
+	mcm.WithKubeClient(kubeClient)
+	mcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+	mcm.WithMetricStorage(mstor)
+
+	err = mcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	err = mcm.SetKubeModuleValues("module-one", utils.Values{"moduleOne": map[string]interface{}{"param1": "val1"}})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(updates).Should(Equal(2))
+	g.Expect(testutil.ToFloat64(mstor.Counter("{PREFIX}kube_config_conflicts_total", map[string]string{}))).Should(Equal(1.0))
+
+	obj, err := client.Get("module-one", metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	spec, err := GetModuleConfigSpec(obj)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(spec.Settings).Should(Equal(map[string]interface{}{"param1": "val1"}))
+}
@@ -0,0 +1,315 @@
+package kube_config_manager
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	corev1 "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// ModuleConfigMapLabel is a label of ConfigMaps with one module section. The value is a module name:
+//
+// apiVersion: v1
+// kind: ConfigMap
+// metadata:
+//   name: addon-operator-simple-module
+//   labels:
+//     addon-operator.io/module: simple-module
+// data:
+//   simpleModule: |
+//     param1: value1
+//   simpleModuleEnabled: "true"
+const ModuleConfigMapLabel = "addon-operator.io/module"
+
+// moduleConfigMaps is a state of the ConfigMap backend with module ConfigMaps.
+type moduleConfigMaps struct {
+	Enabled bool
+
+	handleLock sync.Mutex
+	synced     bool
+}
+
+// WithModuleConfigMaps enables module ConfigMaps: module sections are read from ConfigMaps
+// with the ModuleConfigMapLabel label in addition to the main ConfigMap
+// and SetKubeModuleValues saves the module section into the module ConfigMap.
+func (kcm *kubeConfigManager) WithModuleConfigMaps(enabled bool) {
+	kcm.moduleConfigMaps.Enabled = enabled
+}
+
+// ModuleConfigMapName returns a name for a new ConfigMap with the module section.
+func (kcm *kubeConfigManager) ModuleConfigMapName(moduleName string) string {
+	return fmt.Sprintf("%s-%s", kcm.ConfigMapName, moduleName)
+}
+
+// listModuleConfigMaps returns ConfigMaps with the ModuleConfigMapLabel label sorted by name.
+// All module ConfigMaps are returned if moduleName is empty.
+func (kcm *kubeConfigManager) listModuleConfigMaps(moduleName string) ([]*v1.ConfigMap, error) {
+	selector := ModuleConfigMapLabel
+	if moduleName != "" {
+		selector = fmt.Sprintf("%s=%s", ModuleConfigMapLabel, moduleName)
+	}
+
+	list, err := kcm.KubeClient.CoreV1().
+		ConfigMaps(kcm.Namespace).
+		List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("list module ConfigMaps: %s", err)
+	}
+
+	objs := make([]*v1.ConfigMap, 0, len(list.Items))
+	for i := range list.Items {
+		objs = append(objs, &list.Items[i])
+	}
+	sortConfigMaps(objs)
+	return objs, nil
+}
+
+func sortConfigMaps(objs []*v1.ConfigMap) {
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].Name < objs[j].Name
+	})
+}
+
+// getModuleConfigMap returns the first ConfigMap for the module or nil if there is no ConfigMap.
+func (kcm *kubeConfigManager) getModuleConfigMap(moduleName string) (*v1.ConfigMap, error) {
+	objs, err := kcm.listModuleConfigMaps(moduleName)
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) == 0 {
+		return nil, nil
+	}
+	return objs[0], nil
+}
+
+// moduleConfigMapData returns keys of the module section from the module ConfigMap.
+// Other keys are ignored.
+func moduleConfigMapData(moduleName string, obj *v1.ConfigMap) map[string]string {
+	valuesKey := utils.ModuleNameToValuesKey(moduleName)
+	enabledKey := valuesKey + "Enabled"
+
+	res := make(map[string]string)
+	for key, value := range obj.Data {
+		if key != valuesKey && key != enabledKey {
+			log.Warnf("Kube config manager: ConfigMap/%s for module '%s' has unexpected key '%s': ignoring key", obj.Name, moduleName, key)
+			continue
+		}
+		res[key] = value
+	}
+	return res
+}
+
+// configMapsToConfigData merges the main ConfigMap and module ConfigMaps into one ConfigMap data.
+// Keys from the module ConfigMap override keys of the module section in the main ConfigMap.
+// Checksums and versions of the module section are taken from the module ConfigMap.
+func (kcm *kubeConfigManager) configMapsToConfigData(mainCm *v1.ConfigMap, moduleCms []*v1.ConfigMap) (configData map[string]string, savedChecksums map[string]string, versions map[string]int, err error) {
+	configData = make(map[string]string)
+	savedChecksums = make(map[string]string)
+	versions = make(map[string]int)
+
+	if mainCm != nil {
+		simpleMergeConfigMapData(configData, mainCm.Data)
+		savedChecksums, err = kcm.getValuesChecksums(mainCm)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		versions, err = GetConfigVersions(mainCm.Annotations)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("ConfigMap/%s: %s", mainCm.Name, err)
+		}
+	}
+
+	handled := make(map[string]string)
+	for _, obj := range moduleCms {
+		moduleName := obj.Labels[ModuleConfigMapLabel]
+		if moduleName == "" || utils.ModuleNameFromValuesKey(utils.ModuleNameToValuesKey(moduleName)) != moduleName {
+			log.Errorf("Kube config manager: ConfigMap/%s has bad module name '%s' in label '%s': ignoring ConfigMap", obj.Name, moduleName, ModuleConfigMapLabel)
+			continue
+		}
+		if prevName, has := handled[moduleName]; has {
+			log.Warnf("Kube config manager: ConfigMap/%s and ConfigMap/%s are for the same module '%s': ignoring ConfigMap/%s", prevName, obj.Name, moduleName, obj.Name)
+			continue
+		}
+		handled[moduleName] = obj.Name
+
+		simpleMergeConfigMapData(configData, moduleConfigMapData(moduleName, obj))
+
+		checksums, err := kcm.getValuesChecksums(obj)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if checksum, has := checksums[moduleName]; has {
+			savedChecksums[moduleName] = checksum
+		}
+
+		moduleVersions, err := GetConfigVersions(obj.Annotations)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("ConfigMap/%s: %s", obj.Name, err)
+		}
+		if version, has := moduleVersions[moduleName]; has {
+			versions[moduleName] = version
+		}
+	}
+
+	return configData, savedChecksums, versions, nil
+}
+
+// initConfigFromConfigMaps sets initial config from the main ConfigMap and module ConfigMaps.
+func (kcm *kubeConfigManager) initConfigFromConfigMaps() error {
+	mainCm, err := kcm.getConfigMap()
+	if err != nil {
+		return err
+	}
+
+	moduleCms, err := kcm.listModuleConfigMaps("")
+	if err != nil {
+		return err
+	}
+
+	configData, _, versions, err := kcm.configMapsToConfigData(mainCm, moduleCms)
+	if err != nil {
+		return err
+	}
+
+	return kcm.initConfigFromData(configData, versions)
+}
+
+// changeOrCreateModuleConfigMap applies configChangeFunc to the fresh module ConfigMap
+// and updates it. New ConfigMap is created if there is no ConfigMap for the module.
+func (kcm *kubeConfigManager) changeOrCreateModuleConfigMap(moduleName string, configChangeFunc func(*v1.ConfigMap) error) error {
+	return kcm.retryOnConflict(fmt.Sprintf("ConfigMap for module '%s'", moduleName), func() error {
+		obj, err := kcm.getModuleConfigMap(moduleName)
+		if err != nil {
+			return err
+		}
+
+		if obj != nil {
+			if obj.Data == nil {
+				obj.Data = make(map[string]string)
+			}
+
+			err = configChangeFunc(obj)
+			if err != nil {
+				return err
+			}
+
+			_, err = kcm.KubeClient.CoreV1().ConfigMaps(kcm.Namespace).Update(obj)
+			return err
+		}
+
+		obj = &v1.ConfigMap{}
+		obj.Name = kcm.ModuleConfigMapName(moduleName)
+		obj.Labels = map[string]string{ModuleConfigMapLabel: moduleName}
+		obj.Data = make(map[string]string)
+
+		err = configChangeFunc(obj)
+		if err != nil {
+			return err
+		}
+
+		_, err = kcm.KubeClient.CoreV1().ConfigMaps(kcm.Namespace).Create(obj)
+		return err
+	})
+}
+
+// handleConfigMapStores determines changes in the main ConfigMap and module ConfigMaps.
+func (kcm *kubeConfigManager) handleConfigMapStores(mainStore cache.Store, moduleStore cache.Store, force bool) {
+	kcm.moduleConfigMaps.handleLock.Lock()
+	defer kcm.moduleConfigMaps.handleLock.Unlock()
+
+	// Ignore events from the initial list, all objects are handled at once after sync.
+	if !kcm.moduleConfigMaps.synced && !force {
+		return
+	}
+	kcm.moduleConfigMaps.synced = true
+
+	var mainCm *v1.ConfigMap
+	for _, item := range mainStore.List() {
+		if obj, ok := item.(*v1.ConfigMap); ok && obj.Name == kcm.ConfigMapName {
+			mainCm = obj
+		}
+	}
+
+	moduleCms := make([]*v1.ConfigMap, 0)
+	for _, item := range moduleStore.List() {
+		if obj, ok := item.(*v1.ConfigMap); ok {
+			moduleCms = append(moduleCms, obj)
+		}
+	}
+	sortConfigMaps(moduleCms)
+
+	err := kcm.handleConfigMaps(mainCm, moduleCms)
+	if err != nil {
+		log.Errorf("Kube config manager: cannot handle ConfigMaps: %s", err)
+	}
+}
+
+// handleConfigMaps determines changes in the main ConfigMap and module ConfigMaps.
+func (kcm *kubeConfigManager) handleConfigMaps(mainCm *v1.ConfigMap, moduleCms []*v1.ConfigMap) error {
+	configData, savedChecksums, versions, err := kcm.configMapsToConfigData(mainCm, moduleCms)
+	if err != nil {
+		return err
+	}
+
+	source := fmt.Sprintf("ConfigMap/%s with %d module ConfigMaps", kcm.ConfigMapName, len(moduleCms))
+	err = kcm.handleNewConfigData(source, configData, savedChecksums, versions)
+	if err != nil {
+		return err
+	}
+	kcm.writeBackMigratedSections(kcm)
+	return nil
+}
+
+// startWithModuleConfigMaps watches the main ConfigMap and module ConfigMaps.
+func (kcm *kubeConfigManager) startWithModuleConfigMaps() {
+	log.Debugf("Run kube config manager with module ConfigMaps")
+
+	// define resyncPeriod for informer
+	resyncPeriod := time.Duration(5) * time.Minute
+
+	// define indexers for informer
+	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+
+	mainInformer := corev1.NewFilteredConfigMapInformer(kcm.KubeClient, kcm.Namespace, resyncPeriod, indexers, func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", kcm.ConfigMapName).String()
+	})
+	moduleInformer := corev1.NewFilteredConfigMapInformer(kcm.KubeClient, kcm.Namespace, resyncPeriod, indexers, func(options *metav1.ListOptions) {
+		options.LabelSelector = ModuleConfigMapLabel
+	})
+	mainStore := mainInformer.GetStore()
+	moduleStore := moduleInformer.GetStore()
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			kcm.handleConfigMapStores(mainStore, moduleStore, false)
+		},
+		UpdateFunc: func(prevObj interface{}, obj interface{}) {
+			kcm.handleConfigMapStores(mainStore, moduleStore, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			kcm.handleConfigMapStores(mainStore, moduleStore, false)
+		},
+	}
+	mainInformer.AddEventHandler(handler)
+	moduleInformer.AddEventHandler(handler)
+
+	go mainInformer.Run(kcm.ctx.Done())
+	go moduleInformer.Run(kcm.ctx.Done())
+
+	if !cache.WaitForCacheSync(kcm.ctx.Done(), mainInformer.HasSynced, moduleInformer.HasSynced) {
+		log.Errorf("Kube config manager: ConfigMap informers are not synced")
+		return
+	}
+	kcm.handleConfigMapStores(mainStore, moduleStore, true)
+
+	<-kcm.ctx.Done()
+}
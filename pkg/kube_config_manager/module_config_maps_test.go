@@ -0,0 +1,104 @@
+package kube_config_manager
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/flant/shell-operator/pkg/kube"
+
+	"github.com/flant/addon-operator/pkg/app"
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+func TestKubeConfigManager_ModuleConfigMaps(t *testing.T) {
+	g := NewWithT(t)
+
+	kubeClient := kube.NewFakeKubernetesClient()
+
+	createCm := func(name string, moduleName string, data map[string]string) {
+		cm := &v1.ConfigMap{}
+		cm.SetName(name)
+		if moduleName != "" {
+			cm.SetLabels(map[string]string{ModuleConfigMapLabel: moduleName})
+		}
+		cm.Data = data
+		_, err := kubeClient.CoreV1().ConfigMaps("default").Create(cm)
+		g.Expect(err).ShouldNot(HaveOccurred())
+	}
+
+	createCm(app.ConfigMapName, "", map[string]string{
+		"global":           "param: value\n",
+		"moduleOne":        "param1: main\n",
+		"moduleOneEnabled": "false",
+	})
+	createCm("module-one-settings", "module-one", map[string]string{
+		"moduleOne": "param1: own\n",
+		"moduleTwo": "param1: ignored\n",
+	})
+	createCm("module-two-settings", "module-two", map[string]string{
+		"moduleTwo": "param1: val2\n",
+	})
+
+	kcm := NewKubeConfigManager()
+	kcm.WithKubeClient(kubeClient)
+	kcm.WithNamespace("default")
+	kcm.WithConfigMapName(app.ConfigMapName)
+	kcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+	kcm.WithModuleConfigMaps(true)
+
+	err := kcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	// Module ConfigMaps override keys of module sections in the main ConfigMap.
+	config := kcm.InitialConfig()
+	g.Expect(config.Values).Should(Equal(utils.Values{"global": map[string]interface{}{"param": "value"}}))
+	g.Expect(config.ModuleConfigs).Should(HaveLen(2))
+	g.Expect(config.ModuleConfigs["module-one"].Values).Should(Equal(utils.Values{"moduleOne": map[string]interface{}{"param1": "own"}}))
+	g.Expect(config.ModuleConfigs["module-one"].IsEnabled).ShouldNot(BeNil())
+	g.Expect(*config.ModuleConfigs["module-one"].IsEnabled).Should(BeFalse())
+	g.Expect(config.ModuleConfigs["module-two"].Values).Should(Equal(utils.Values{"moduleTwo": map[string]interface{}{"param1": "val2"}}))
+
+	// Module values are saved into the existing module ConfigMap.
+	err = kcm.SetKubeModuleValues("module-two", utils.Values{"moduleTwo": map[string]interface{}{"param1": "new"}})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	cm, err := kubeClient.CoreV1().ConfigMaps("default").Get("module-two-settings", metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(cm.Data["moduleTwo"]).Should(Equal("param1: new\n"))
+	g.Expect(cm.Annotations).Should(HaveKey(app.ValuesChecksumsAnnotation))
+
+	// New module ConfigMap is created for the module without ConfigMap.
+	err = kcm.SetKubeModuleValues("module-three", utils.Values{"moduleThree": map[string]interface{}{"param1": "val3"}})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	cm, err = kubeClient.CoreV1().ConfigMaps("default").Get(app.ConfigMapName+"-module-three", metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(cm.Labels).Should(HaveKeyWithValue(ModuleConfigMapLabel, "module-three"))
+	g.Expect(cm.Data["moduleThree"]).Should(Equal("param1: val3\n"))
+
+	// Main ConfigMap is not changed by module values.
+	cm, err = kubeClient.CoreV1().ConfigMaps("default").Get(app.ConfigMapName, metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(cm.Data).ShouldNot(HaveKey("moduleThree"))
+	g.Expect(cm.Data["moduleOne"]).Should(Equal("param1: main\n"))
+
+	// Changes in module ConfigMaps are reported with all module sections.
+	mainCm, err := kcm.(*kubeConfigManager).getConfigMap()
+	g.Expect(err).ShouldNot(HaveOccurred())
+	moduleCms, err := kcm.(*kubeConfigManager).listModuleConfigMaps("")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(moduleCms).Should(HaveLen(3))
+	for _, obj := range moduleCms {
+		if obj.Name == "module-one-settings" {
+			obj.Data["moduleOne"] = "param1: changed\n"
+		}
+	}
+	err = kcm.(*kubeConfigManager).handleConfigMaps(mainCm, moduleCms)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	moduleConfigs := <-ModuleConfigsUpdated
+	g.Expect(moduleConfigs["module-one"].IsUpdated).Should(BeTrue())
+	g.Expect(moduleConfigs["module-one"].Values).Should(Equal(utils.Values{"moduleOne": map[string]interface{}{"param1": "changed"}}))
+	g.Expect(moduleConfigs).Should(HaveKey("module-two"))
+	g.Expect(moduleConfigs["module-three"].Values).Should(Equal(utils.Values{"moduleThree": map[string]interface{}{"param1": "val3"}}))
+}
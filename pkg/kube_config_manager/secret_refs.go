@@ -0,0 +1,423 @@
+package kube_config_manager
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// SecretRefKey is a key of the object that is replaced with a value from the Secret:
+//
+// global: |
+//   dbPassword:
+//     $secretRef:
+//       name: db-credentials
+//       key: password
+const SecretRefKey = "$secretRef"
+
+// sensitiveValuesOwner is an owner of values from Secrets in the redaction list.
+const sensitiveValuesOwner = "kube-config-secrets"
+
+// SecretRef is a reference to the key of the Secret in the addon-operator namespace.
+type SecretRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// secretSection is a config section with references to Secrets.
+type secretSection struct {
+	// Section values with references.
+	Raw interface{}
+	// Section values with references replaced by Secrets data.
+	Resolved interface{}
+	Refs     []SecretRef
+	// Strings from Secrets data.
+	Values []string
+}
+
+// GetSecretRef returns a reference if the value is an object with the SecretRefKey key.
+func GetSecretRef(value interface{}) (*SecretRef, error) {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	refObj, has := obj[SecretRefKey]
+	if !has {
+		return nil, nil
+	}
+	if len(obj) != 1 {
+		return nil, fmt.Errorf("'%s' should be the only key of the object", SecretRefKey)
+	}
+
+	refMap, ok := refObj.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("'%s' should be an object with 'name' and 'key' fields", SecretRefKey)
+	}
+	name, _ := refMap["name"].(string)
+	key, _ := refMap["key"].(string)
+	if name == "" || key == "" {
+		return nil, fmt.Errorf("'%s' should have non-empty 'name' and 'key' fields", SecretRefKey)
+	}
+	return &SecretRef{Name: name, Key: key}, nil
+}
+
+// ResolveSecretRefs returns a copy of the value with references replaced by strings
+// from Secrets data and a list of found references.
+func ResolveSecretRefs(value interface{}, getSecretData func(name string) (map[string][]byte, error)) (interface{}, []SecretRef, error) {
+	refs := make([]SecretRef, 0)
+	res, err := resolveSecretRefs("", value, getSecretData, &refs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, refs, nil
+}
+
+func resolveSecretRefs(path string, value interface{}, getSecretData func(name string) (map[string][]byte, error), refs *[]SecretRef) (interface{}, error) {
+	ref, err := GetSecretRef(value)
+	if err != nil {
+		return nil, fmt.Errorf("bad reference at '%s': %s", path, err)
+	}
+	if ref != nil {
+		data, err := getSecretData(ref.Name)
+		if err != nil {
+			return nil, fmt.Errorf("resolve reference at '%s': %s", path, err)
+		}
+		secretValue, has := data[ref.Key]
+		if !has {
+			return nil, fmt.Errorf("resolve reference at '%s': Secret/%s has no key '%s'", path, ref.Name, ref.Key)
+		}
+		*refs = append(*refs, *ref)
+		return string(secretValue), nil
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			resolved, err := resolveSecretRefs(path+"/"+utils.EscapeJsonPointer(key), item, getSecretData, refs)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = resolved
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, 0, len(v))
+		for i, item := range v {
+			resolved, err := resolveSecretRefs(fmt.Sprintf("%s/%d", path, i), item, getSecretData, refs)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, resolved)
+		}
+		return res, nil
+	}
+	return value, nil
+}
+
+// restoreSecretRefs returns a copy of the current value with references from the raw value
+// for paths where the current value is equal to the resolved value. So values from Secrets
+// are not written back into the config.
+func restoreSecretRefs(raw interface{}, resolved interface{}, current interface{}) interface{} {
+	if ref, _ := GetSecretRef(raw); ref != nil {
+		if reflect.DeepEqual(resolved, current) {
+			return raw
+		}
+		return current
+	}
+
+	switch v := current.(type) {
+	case map[string]interface{}:
+		rawMap, rawOk := raw.(map[string]interface{})
+		resolvedMap, resolvedOk := resolved.(map[string]interface{})
+		if !rawOk || !resolvedOk {
+			return current
+		}
+		res := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			res[key] = restoreSecretRefs(rawMap[key], resolvedMap[key], item)
+		}
+		return res
+	case []interface{}:
+		rawArr, rawOk := raw.([]interface{})
+		resolvedArr, resolvedOk := resolved.([]interface{})
+		if !rawOk || !resolvedOk {
+			return current
+		}
+		res := make([]interface{}, 0, len(v))
+		for i, item := range v {
+			if i < len(rawArr) {
+				item = restoreSecretRefs(rawArr[i], resolvedArr[i], item)
+			}
+			res = append(res, item)
+		}
+		return res
+	}
+	return current
+}
+
+// getSecretData returns data of the Secret from the Secrets informer cache. The API server
+// is requested only until the informer is started and synced.
+func (kcm *kubeConfigManager) getSecretData(name string) (map[string][]byte, error) {
+	kcm.secretsLock.Lock()
+	informer := kcm.secretsInformer
+	kcm.secretsLock.Unlock()
+
+	if informer != nil && informer.HasSynced() {
+		item, exists, err := informer.GetStore().GetByKey(kcm.Namespace + "/" + name)
+		if err != nil {
+			return nil, fmt.Errorf("get Secret/%s: %s", name, err)
+		}
+		if !exists {
+			return nil, fmt.Errorf("get Secret/%s: not found", name)
+		}
+		secret, ok := item.(*v1.Secret)
+		if !ok {
+			return nil, fmt.Errorf("get Secret/%s: unexpected object %T", name, item)
+		}
+		return secret.Data, nil
+	}
+
+	if kcm.KubeClient == nil {
+		return nil, fmt.Errorf("get Secret/%s: no Kubernetes client", name)
+	}
+	secret, err := kcm.KubeClient.CoreV1().Secrets(kcm.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get Secret/%s: %s", name, err)
+	}
+	return secret.Data, nil
+}
+
+// lastSecretSection returns the key of the last accepted config if it has the same references.
+func (kcm *kubeConfigManager) lastSecretSection(key string, raw interface{}) (secretSection, bool) {
+	kcm.secretsLock.Lock()
+	defer kcm.secretsLock.Unlock()
+
+	section, has := kcm.secretSections[key]
+	if !has || !reflect.DeepEqual(section.Raw, raw) {
+		return secretSection{}, false
+	}
+	return section, true
+}
+
+// sectionOfKey returns a section name for the ConfigMap key: 'global' or a module name.
+func sectionOfKey(key string) string {
+	if key == utils.GlobalValuesKey {
+		return key
+	}
+	return utils.ModuleNameFromValuesKey(strings.TrimSuffix(key, "Enabled"))
+}
+
+// resolveConfigData returns ConfigMap-like data with references replaced by Secrets data.
+// Keys without references are not changed. References that cannot be resolved make
+// the section invalid: its key is not changed and the error is returned for the section.
+// The key unchanged since the last accepted config keeps values resolved before,
+// so the config is not broken by the deleted Secret.
+func (kcm *kubeConfigManager) resolveConfigData(configData map[string]string) (map[string]string, map[string]secretSection, map[string]error) {
+	res := make(map[string]string, len(configData))
+	sections := make(map[string]secretSection)
+	errs := make(map[string]error)
+
+	secrets := make(map[string]map[string][]byte)
+	getSecretData := func(name string) (map[string][]byte, error) {
+		if data, has := secrets[name]; has {
+			return data, nil
+		}
+		data, err := kcm.getSecretData(name)
+		if err != nil {
+			return nil, err
+		}
+		secrets[name] = data
+		return data, nil
+	}
+
+	for key, data := range configData {
+		res[key] = data
+		if !strings.Contains(data, SecretRefKey) {
+			continue
+		}
+
+		var raw interface{}
+		if err := yaml.Unmarshal([]byte(data), &raw); err != nil {
+			// Bad yaml is reported by the config parser.
+			continue
+		}
+
+		resolved, refs, err := ResolveSecretRefs(raw, getSecretData)
+		if err != nil {
+			last, has := kcm.lastSecretSection(key, raw)
+			if !has {
+				errs[sectionOfKey(key)] = fmt.Errorf("key '%s': cannot resolve Secret references: %s", key, err)
+				continue
+			}
+			log.Warnf("Kube config manager: key '%s': %s: values resolved before are used", key, err)
+			resolvedData, err := yaml.Marshal(last.Resolved)
+			if err != nil {
+				errs[sectionOfKey(key)] = fmt.Errorf("key '%s': dump resolved values: %s", key, err)
+				continue
+			}
+			res[key] = string(resolvedData)
+			sections[key] = last
+			continue
+		}
+		if len(refs) == 0 {
+			continue
+		}
+
+		resolvedData, err := yaml.Marshal(resolved)
+		if err != nil {
+			errs[sectionOfKey(key)] = fmt.Errorf("key '%s': dump resolved values: %s", key, err)
+			continue
+		}
+		res[key] = string(resolvedData)
+
+		section := secretSection{Raw: raw, Resolved: resolved, Refs: refs}
+		for _, ref := range refs {
+			section.Values = append(section.Values, string(secrets[ref.Name][ref.Key]))
+		}
+		sections[key] = section
+	}
+
+	return res, sections, errs
+}
+
+// setSecretSections saves sections of the accepted config and registers values from
+// Secrets for redaction. Secrets are watched only if config has references.
+func (kcm *kubeConfigManager) setSecretSections(sections map[string]secretSection) {
+	kcm.secretsLock.Lock()
+	defer kcm.secretsLock.Unlock()
+
+	kcm.secretSections = sections
+
+	values := make([]string, 0)
+	for _, section := range sections {
+		values = append(values, section.Values...)
+	}
+	utils.SetSensitiveValues(sensitiveValuesOwner, values)
+
+	if len(sections) > 0 {
+		kcm.secretsInformerOnce.Do(kcm.startSecretsInformer)
+	}
+}
+
+// isSecretReferenced returns true if the config has references to the Secret.
+func (kcm *kubeConfigManager) isSecretReferenced(name string) bool {
+	kcm.secretsLock.Lock()
+	defer kcm.secretsLock.Unlock()
+
+	for _, section := range kcm.secretSections {
+		for _, ref := range section.Refs {
+			if ref.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withSecretRefs returns section values with restored references to Secrets.
+func (kcm *kubeConfigManager) withSecretRefs(valuesKey string, sectionValues interface{}) interface{} {
+	kcm.secretsLock.Lock()
+	defer kcm.secretsLock.Unlock()
+
+	section, has := kcm.secretSections[valuesKey]
+	if !has {
+		return sectionValues
+	}
+	return restoreSecretRefs(section.Raw, section.Resolved, sectionValues)
+}
+
+// configDataWithSecretRefs returns config data for the section with restored references to Secrets.
+func (kcm *kubeConfigManager) configDataWithSecretRefs(valuesKey string, values utils.Values, configData map[string]string) (map[string]string, error) {
+	restored := kcm.withSecretRefs(valuesKey, values[valuesKey])
+	if reflect.DeepEqual(restored, values[valuesKey]) {
+		return configData, nil
+	}
+
+	sectionData, err := utils.Values{valuesKey: restored}.AsConfigMapData()
+	if err != nil {
+		return nil, fmt.Errorf("cannot dump yaml for '%s' with Secret references: %s", valuesKey, err)
+	}
+	res := make(map[string]string, len(configData))
+	simpleMergeConfigMapData(res, configData)
+	simpleMergeConfigMapData(res, sectionData)
+	return res, nil
+}
+
+// startSecretsInformer watches Secrets in the namespace and handles the config again
+// if referenced Secret is changed.
+func (kcm *kubeConfigManager) startSecretsInformer() {
+	if kcm.ctx == nil || kcm.KubeClient == nil {
+		return
+	}
+
+	log.Infof("Kube config manager: config has references to Secrets, watch Secrets in namespace '%s'", kcm.Namespace)
+
+	// define resyncPeriod for informer
+	resyncPeriod := time.Duration(5) * time.Minute
+
+	// define indexers for informer
+	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+
+	secretInformer := corev1.NewFilteredSecretInformer(kcm.KubeClient, kcm.Namespace, resyncPeriod, indexers, nil)
+	// Called under secretsLock from setSecretSections.
+	kcm.secretsInformer = secretInformer
+	secretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			kcm.handleSecretChange(secretName(obj))
+		},
+		UpdateFunc: func(prevObj interface{}, obj interface{}) {
+			kcm.handleSecretChange(secretName(obj))
+		},
+		DeleteFunc: func(obj interface{}) {
+			kcm.handleSecretChange(secretName(obj))
+		},
+	})
+
+	go secretInformer.Run(kcm.ctx.Done())
+}
+
+func secretName(obj interface{}) string {
+	switch v := obj.(type) {
+	case *v1.Secret:
+		return v.Name
+	case cache.DeletedFinalStateUnknown:
+		if secret, ok := v.Obj.(*v1.Secret); ok {
+			return secret.Name
+		}
+	}
+	return ""
+}
+
+// handleSecretChange handles the last config data again to resolve references
+// with new Secret data. Changed sections are sent over channels as usual.
+func (kcm *kubeConfigManager) handleSecretChange(name string) {
+	if name == "" || !kcm.isSecretReferenced(name) {
+		return
+	}
+
+	kcm.configDataLock.Lock()
+	defer kcm.configDataLock.Unlock()
+
+	if kcm.handledConfigData == nil {
+		return
+	}
+
+	log.Debugf("Kube config manager: Secret/%s is changed, resolve references", name)
+
+	source := fmt.Sprintf("%s with Secret/%s", kcm.handledConfigSource, name)
+	err := kcm.handleConfigData(source, kcm.handledConfigData, kcm.handledSavedChecksums, kcm.handledVersions)
+	if err != nil {
+		log.Errorf("Kube config manager: cannot handle Secret/%s change: %s", name, err)
+	}
+}
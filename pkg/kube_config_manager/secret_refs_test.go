@@ -0,0 +1,218 @@
+package kube_config_manager
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/flant/shell-operator/pkg/kube"
+
+	"github.com/flant/addon-operator/pkg/app"
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+func Test_ResolveSecretRefs(t *testing.T) {
+	g := NewWithT(t)
+
+	secrets := map[string]map[string][]byte{
+		"creds": {"password": []byte("qwerty"), "user": []byte("admin")},
+	}
+	getSecretData := func(name string) (map[string][]byte, error) {
+		return secrets[name], nil
+	}
+
+	raw := map[string]interface{}{
+		"password": map[string]interface{}{
+			SecretRefKey: map[string]interface{}{"name": "creds", "key": "password"},
+		},
+		"users": []interface{}{
+			map[string]interface{}{SecretRefKey: map[string]interface{}{"name": "creds", "key": "user"}},
+		},
+		"param": "value",
+	}
+
+	resolved, refs, err := ResolveSecretRefs(raw, getSecretData)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(refs).Should(HaveLen(2))
+	g.Expect(resolved).Should(Equal(map[string]interface{}{
+		"password": "qwerty",
+		"users":    []interface{}{"admin"},
+		"param":    "value",
+	}))
+
+	// Unchanged values are restored as references, changed values are kept.
+	current := map[string]interface{}{
+		"password": "qwerty",
+		"users":    []interface{}{"root"},
+		"param":    "new-value",
+	}
+	g.Expect(restoreSecretRefs(raw, resolved, current)).Should(Equal(map[string]interface{}{
+		"password": raw["password"],
+		"users":    []interface{}{"root"},
+		"param":    "new-value",
+	}))
+
+	// Missing key is an error.
+	_, _, err = ResolveSecretRefs(map[string]interface{}{
+		"a": map[string]interface{}{SecretRefKey: map[string]interface{}{"name": "creds", "key": "token"}},
+	}, getSecretData)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("Secret/creds has no key 'token'"))
+}
+
+func TestKubeConfigManager_SecretRefs(t *testing.T) {
+	g := NewWithT(t)
+	defer utils.SetSensitiveValues(sensitiveValuesOwner, nil)
+
+	kubeClient := kube.NewFakeKubernetesClient()
+
+	secret := &v1.Secret{}
+	secret.SetName("creds")
+	secret.Data = map[string][]byte{"password": []byte("qwerty")}
+	_, err := kubeClient.CoreV1().Secrets("default").Create(secret)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	cm := &v1.ConfigMap{}
+	cm.SetName(app.ConfigMapName)
+	cm.Data = map[string]string{
+		"moduleOne": `
+user: admin
+password:
+  $secretRef:
+    name: creds
+    key: password
+`,
+	}
+	_, err = kubeClient.CoreV1().ConfigMaps("default").Create(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	kcm := NewKubeConfigManager()
+	kcm.WithKubeClient(kubeClient)
+	kcm.WithNamespace("default")
+	kcm.WithConfigMapName(app.ConfigMapName)
+	kcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+
+	err = kcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	moduleValues := kcm.CurrentConfig().ModuleConfigs["module-one"].Values
+	g.Expect(moduleValues).Should(Equal(utils.Values{
+		"moduleOne": map[string]interface{}{"user": "admin", "password": "qwerty"},
+	}))
+	// Values from Secrets are redacted.
+	g.Expect(moduleValues.DebugString()).ShouldNot(ContainSubstring("qwerty"))
+	g.Expect(utils.RedactString("password is qwerty")).Should(Equal("password is " + utils.RedactedValue))
+
+	// Reference is saved back instead of the value from the Secret.
+	err = kcm.SetKubeModuleValues("module-one", utils.Values{
+		"moduleOne": map[string]interface{}{"user": "root", "password": "qwerty"},
+	})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	cm, err = kubeClient.CoreV1().ConfigMaps("default").Get(app.ConfigMapName, metav1.GetOptions{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(cm.Data["moduleOne"]).Should(ContainSubstring(SecretRefKey))
+	g.Expect(cm.Data["moduleOne"]).Should(ContainSubstring("root"))
+	g.Expect(cm.Data["moduleOne"]).ShouldNot(ContainSubstring("qwerty"))
+
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	<-ModuleConfigsUpdated
+
+	// Secret change leads to the module config update.
+	secret.Data["password"] = []byte("new-password")
+	_, err = kubeClient.CoreV1().Secrets("default").Update(secret)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	kcm.(*kubeConfigManager).handleSecretChange("creds")
+	moduleConfigs := <-ModuleConfigsUpdated
+	g.Expect(moduleConfigs["module-one"].IsUpdated).Should(BeTrue())
+	g.Expect(moduleConfigs["module-one"].Values).Should(Equal(utils.Values{
+		"moduleOne": map[string]interface{}{"user": "root", "password": "new-password"},
+	}))
+	g.Expect(utils.RedactString("new-password")).Should(Equal(utils.RedactedValue))
+}
+
+// Unresolved references should reject only their section. Secrets should be read from the informer cache.
+func TestKubeConfigManager_SecretRefs_Unresolved(t *testing.T) {
+	g := NewWithT(t)
+	defer utils.SetSensitiveValues(sensitiveValuesOwner, nil)
+
+	secret := &v1.Secret{}
+	secret.SetNamespace("default")
+	secret.SetName("creds")
+	secret.Data = map[string][]byte{"password": []byte("qwerty")}
+
+	cm := &v1.ConfigMap{}
+	cm.SetNamespace("default")
+	cm.SetName(app.ConfigMapName)
+	cm.Data = map[string]string{
+		"moduleOne": "password:\n  $secretRef:\n    name: creds\n    key: password\n",
+		"moduleTwo": "param1: val1\n",
+	}
+
+	clientset := fake.NewSimpleClientset(secret, cm)
+	secretGets := 0
+	clientset.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		secretGets++
+		return false, nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kcm := NewKubeConfigManager()
+	kcm.WithContext(ctx)
+	kcm.WithKubeClient(&clientWithFakeCoreV1{KubernetesClient: kube.NewFakeKubernetesClient(), clientset: clientset})
+	kcm.WithNamespace("default")
+	kcm.WithConfigMapName(app.ConfigMapName)
+	kcm.WithValuesChecksumsAnnotation(app.ValuesChecksumsAnnotation)
+
+	err := kcm.Init()
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(secretGets).Should(Equal(1))
+
+	// Secrets informer is started for the config with references.
+	g.Eventually(func() bool {
+		kcm.(*kubeConfigManager).secretsLock.Lock()
+		defer kcm.(*kubeConfigManager).secretsLock.Unlock()
+		informer := kcm.(*kubeConfigManager).secretsInformer
+		return informer != nil && informer.HasSynced()
+	}, "5s", "10ms").Should(BeTrue())
+
+	// Reference to the absent Secret rejects only its section.
+	cm.Data["moduleOne"] = "password:\n  $secretRef:\n    name: absent\n    key: password\n"
+	cm.Data["moduleTwo"] = "param1: val2\n"
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("Secret/absent"))
+
+	updated := <-ModuleConfigsUpdated
+	g.Expect(updated["module-two"].Values).Should(Equal(utils.Values{"moduleTwo": map[string]interface{}{"param1": "val2"}}))
+	g.Expect(updated["module-one"].IsUpdated).Should(BeFalse())
+	g.Expect(updated["module-one"].Values).Should(Equal(utils.Values{"moduleOne": map[string]interface{}{"password": "qwerty"}}))
+
+	// Secrets are read from the informer cache.
+	g.Expect(secretGets).Should(Equal(1))
+
+	// The section unchanged since the accepted config keeps values resolved before, if the Secret is deleted.
+	err = clientset.Tracker().Delete(v1.SchemeGroupVersion.WithResource("secrets"), "default", "creds")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Eventually(func() bool {
+		_, err := kcm.(*kubeConfigManager).getSecretData("creds")
+		return err != nil
+	}, "5s", "10ms").Should(BeTrue())
+
+	cm.Data["moduleOne"] = "password:\n  $secretRef:\n    name: creds\n    key: password\n"
+	cm.Data["moduleTwo"] = "param1: val3\n"
+	err = kcm.(*kubeConfigManager).handleNewCm(cm)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	updated = <-ModuleConfigsUpdated
+	g.Expect(updated["module-two"].Values).Should(Equal(utils.Values{"moduleTwo": map[string]interface{}{"param1": "val3"}}))
+	g.Expect(updated["module-one"].Values).Should(Equal(utils.Values{"moduleOne": map[string]interface{}{"password": "qwerty"}}))
+}
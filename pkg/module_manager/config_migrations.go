@@ -0,0 +1,51 @@
+package module_manager
+
+import (
+	"fmt"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/flant/addon-operator/pkg/utils"
+	"github.com/flant/addon-operator/pkg/values/migration"
+	"github.com/flant/addon-operator/sdk/registry"
+)
+
+// loadConfigMigrations adds migrations for config values of the global section or the module:
+// files from the 'migrations' directory in dir and Go migrations from the registry.
+func (mm *moduleManager) loadConfigMigrations(section string, dir string) error {
+	if mm.ConfigMigrations == nil {
+		return nil
+	}
+
+	migrations, err := migration.LoadDir(filepath.Join(dir, migration.MigrationsDir))
+	if err != nil {
+		return err
+	}
+
+	// Global migrations are registered with an empty module name.
+	moduleName := section
+	if section == utils.GlobalValuesKey {
+		moduleName = ""
+	}
+	for _, goMigration := range registry.Registry().ConfigMigrations() {
+		if goMigration.Module != moduleName {
+			continue
+		}
+		migrations = append(migrations, migration.Migration{
+			Version: goMigration.Version,
+			Source:  fmt.Sprintf("Go migration to version %d", goMigration.Version),
+			Migrate: goMigration.Migrate,
+		})
+	}
+
+	if len(migrations) == 0 {
+		return nil
+	}
+	err = mm.ConfigMigrations.Add(section, migrations...)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Config values migrations for '%s': %d, latest version is %d", section, len(migrations), mm.ConfigMigrations.LatestVersion(section))
+	return nil
+}
@@ -3,6 +3,7 @@ package module_manager
 import (
 	"fmt"
 	"path/filepath"
+	"reflect"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -83,7 +84,7 @@ func (g *GlobalHook) Order(binding BindingType) float64 {
 type globalValuesMergeResult struct {
 	// Global values with the root "global" key.
 	Values utils.Values
-	// Original values patch argument.
+	// Values patch argument with operations converted by NormalizeValuesPatch.
 	ValuesPatch utils.ValuesPatch
 	// Whether values changed after applying patch.
 	ValuesChanged bool
@@ -111,15 +112,16 @@ func (h *GlobalHook) handleGlobalValuesPatch(currentValues utils.Values, valuesP
 		return nil, nil
 	}
 
-	newValues, valuesChanged, err := utils.ApplyValuesPatch(currentValues, valuesPatch)
+	// 'test' operations are checked here, other operations are converted to 'add' and 'remove' for compaction.
+	newValues, normalizedPatch, err := utils.NormalizeValuesPatch(currentValues, valuesPatch)
 	if err != nil {
 		return nil, fmt.Errorf("merge global values failed: %s", err)
 	}
 
 	result := &globalValuesMergeResult{
 		Values:        utils.Values{utils.GlobalValuesKey: make(map[string]interface{})},
-		ValuesChanged: valuesChanged,
-		ValuesPatch:   valuesPatch,
+		ValuesChanged: !reflect.DeepEqual(currentValues, newValues),
+		ValuesPatch:   normalizedPatch,
 	}
 
 	if newValues.HasGlobal() {
@@ -160,7 +162,7 @@ func (h *GlobalHook) Run(bindingType BindingType, context []BindingContext, logL
 	if has && configValuesPatch != nil {
 		preparedConfigValues := utils.MergeValues(
 			utils.Values{"global": map[string]interface{}{}},
-			h.moduleManager.kubeGlobalConfigValues,
+			h.moduleManager.kubeGlobalConfig(),
 		)
 
 		configValuesPatchResult, err := h.handleGlobalValuesPatch(preparedConfigValues, *configValuesPatch)
@@ -169,14 +171,19 @@ func (h *GlobalHook) Run(bindingType BindingType, context []BindingContext, logL
 		}
 
 		if configValuesPatchResult != nil && configValuesPatchResult.ValuesChanged {
-			err := h.moduleManager.kubeConfigManager.SetKubeGlobalValues(configValuesPatchResult.Values)
+			err := h.moduleManager.ValuesValidator.ValidateGlobalConfigValues(configValuesPatchResult.Values)
 			if err != nil {
-				log.Debugf("Global hook '%s' kube config global values stay unchanged:\n%s", h.Name, h.moduleManager.kubeGlobalConfigValues.DebugString())
+				return fmt.Errorf("global hook '%s': ConfigMap patch is not accepted: %s", h.Name, err)
+			}
+
+			err = h.moduleManager.kubeConfigManager.SetKubeGlobalValues(configValuesPatchResult.Values)
+			if err != nil {
+				log.Debugf("Global hook '%s' kube config global values stay unchanged:\n%s", h.Name, h.moduleManager.kubeGlobalConfig().DebugString())
 				return fmt.Errorf("global hook '%s': set kube config failed: %s", h.Name, err)
 			}
 
-			h.moduleManager.kubeGlobalConfigValues = configValuesPatchResult.Values
-			log.Debugf("Global hook '%s': kube config global values updated:\n%s", h.Name, h.moduleManager.kubeGlobalConfigValues.DebugString())
+			h.moduleManager.setKubeGlobalConfigValues(configValuesPatchResult.Values)
+			log.Debugf("Global hook '%s': kube config global values updated:\n%s", h.Name, configValuesPatchResult.Values.DebugString())
 		}
 	}
 
@@ -193,7 +200,13 @@ func (h *GlobalHook) Run(bindingType BindingType, context []BindingContext, logL
 		// MemoryValuesPatch from global hook can contains patches for *Enabled keys
 		// and no patches for 'global' section — valuesPatchResult will be nil in this case.
 		if valuesPatchResult != nil && valuesPatchResult.ValuesChanged {
-			h.moduleManager.globalDynamicValuesPatches = utils.AppendValuesPatch(h.moduleManager.globalDynamicValuesPatches, valuesPatchResult.ValuesPatch)
+			err := h.moduleManager.ValuesValidator.ValidateGlobalValues(valuesPatchResult.Values)
+			if err != nil {
+				return fmt.Errorf("global hook '%s': values patch is not accepted: %s", h.Name, err)
+			}
+
+			valuesPatchResult.ValuesPatch.WithSource(utils.NewValuesPatchSource(h.Name, string(bindingType), logLabels["task.id"]))
+			h.moduleManager.appendGlobalValuesPatch(valuesPatchResult.ValuesPatch)
 			newGlobalValues, err := h.moduleManager.GlobalValues()
 			if err != nil {
 				return fmt.Errorf("global hook '%s': global values after patch apply: %s", h.Name, err)
@@ -234,6 +247,16 @@ func (h *GlobalHook) PrepareTmpFilesForHookRun(bindingContext []byte) (tmpFiles
 		return
 	}
 
+	tmpFiles["CONFIG_VALUES_MERGE_PATCH_PATH"], err = h.prepareConfigValuesMergePatchFile()
+	if err != nil {
+		return
+	}
+
+	tmpFiles["VALUES_MERGE_PATCH_PATH"], err = h.prepareValuesMergePatchFile()
+	if err != nil {
+		return
+	}
+
 	tmpFiles["METRICS_PATH"], err = h.prepareMetricsFile()
 	if err != nil {
 		return
@@ -323,6 +346,24 @@ func (h *GlobalHook) prepareValuesJsonPatchFile() (string, error) {
 	return path, nil
 }
 
+// CONFIG_VALUES_MERGE_PATCH_PATH
+func (h *GlobalHook) prepareConfigValuesMergePatchFile() (string, error) {
+	path := filepath.Join(h.TmpDir, fmt.Sprintf("%s.global-hook-config-values-%s.merge-patch", h.SafeName(), uuid.NewV4().String()))
+	if err := CreateEmptyWritableFile(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// VALUES_MERGE_PATCH_PATH
+func (h *GlobalHook) prepareValuesMergePatchFile() (string, error) {
+	path := filepath.Join(h.TmpDir, fmt.Sprintf("%s.global-hook-values-%s.merge-patch", h.SafeName(), uuid.NewV4().String()))
+	if err := CreateEmptyWritableFile(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
 // METRICS_PATH
 func (h *GlobalHook) prepareMetricsFile() (string, error) {
 	path := filepath.Join(h.TmpDir, fmt.Sprintf("%s.global-hook-metrics-%s.json", h.SafeName(), uuid.NewV4().String()))
@@ -0,0 +1,165 @@
+package module_manager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/flant/addon-operator/pkg/helm/client"
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// Actions on a failed helm upgrade.
+const (
+	HelmUpgradeOnFailureImmediateRollback = "immediateRollback"
+	HelmUpgradeOnFailureRollback          = "rollback"
+)
+
+var HelmUpgradeOnFailureActions = []string{HelmUpgradeOnFailureImmediateRollback, HelmUpgradeOnFailureRollback}
+
+// DefaultHelmUpgradeMaxFailures is a number of failed upgrades before rollback if maxFailures is not set.
+const DefaultHelmUpgradeMaxFailures = 3
+
+// HelmUpgradePolicy defines what to do with the release after a failed helm upgrade.
+//
+// "immediateRollback" rolls back the release to the last deployed revision right after the failed upgrade.
+// The release is uninstalled if there is no deployed revision. Unlike helm's --atomic flag,
+// it does not wait for resources: use the readiness policy to fail upgrades with not ready resources.
+//
+// "rollback" rolls back the release to the last deployed revision after maxFailures
+// failed upgrades in a row. The release is left as is if there is no deployed revision.
+//
+// The rolled back upgrade is not retried until module values or the chart are changed,
+// the module is failed until then.
+type HelmUpgradePolicy struct {
+	OnFailure   string `json:"onFailure"`
+	MaxFailures int    `json:"maxFailures,omitempty"`
+}
+
+func (p *HelmUpgradePolicy) Validate() error {
+	if !utils.ListFullyIn([]string{p.OnFailure}, HelmUpgradeOnFailureActions) {
+		return fmt.Errorf("bad onFailure '%s', expected one of: %s", p.OnFailure, strings.Join(HelmUpgradeOnFailureActions, ", "))
+	}
+	if p.MaxFailures < 0 {
+		return fmt.Errorf("maxFailures should not be negative")
+	}
+	if p.OnFailure == HelmUpgradeOnFailureImmediateRollback && p.MaxFailures > 1 {
+		return fmt.Errorf("maxFailures is not used with '%s'", HelmUpgradeOnFailureImmediateRollback)
+	}
+	return nil
+}
+
+// FailuresBeforeRollback returns a number of failed upgrades in a row to start a rollback.
+func (p *HelmUpgradePolicy) FailuresBeforeRollback() int {
+	if p.OnFailure == HelmUpgradeOnFailureImmediateRollback {
+		return 1
+	}
+	if p.MaxFailures == 0 {
+		return DefaultHelmUpgradeMaxFailures
+	}
+	return p.MaxFailures
+}
+
+// HelmUpgradePolicy returns a policy for failed helm upgrades or nil if module has no policy.
+func (m *Module) HelmUpgradePolicy() *HelmUpgradePolicy {
+	if m.Definition == nil {
+		return nil
+	}
+	return m.Definition.HelmUpgrade
+}
+
+// resetHelmUpgradeFailures is called after the successful helm upgrade.
+func (m *Module) resetHelmUpgradeFailures() {
+	m.UpdateState(func(state *ModuleState) {
+		state.HelmUpgradeFailures = 0
+		state.HelmRolledBackChecksum = ""
+		state.HelmRolledBackRevision = ""
+	})
+}
+
+// lastDeployedRevision returns the revision to rollback to if module has the policy for failed upgrades.
+// It should be called before upgrade, because the upgraded revision is deployed even if resources are not ready.
+func (m *Module) lastDeployedRevision(helmClient client.HelmClient, releaseName string) (string, error) {
+	if m.HelmUpgradePolicy() == nil {
+		return "", nil
+	}
+	revision, err := helmClient.LastDeployedRevision(releaseName)
+	if err != nil {
+		return "", fmt.Errorf("cannot get deployed revision of release '%s': %s", releaseName, err)
+	}
+	return revision, nil
+}
+
+// handleFailedHelmUpgrade reports the failed upgrade and rolls back the release to
+// the deployedRevision according to the module policy. It returns an error for the ModuleRun task.
+func (m *Module) handleFailedHelmUpgrade(helmClient client.HelmClient, releaseName string, checksum string, deployedRevision string, upgradeErr error, logLabels map[string]string) error {
+	logEntry := log.WithFields(utils.LabelsToLogFields(logLabels))
+	metricLabels := map[string]string{"module": m.Name}
+
+	m.UpdateState(func(state *ModuleState) {
+		state.HelmUpgradeFailures++
+	})
+	m.metricStorage.CounterAdd("{PREFIX}module_helm_upgrade_errors_total", 1.0, metricLabels)
+
+	failedRevision, status, err := helmClient.LastReleaseStatus(releaseName)
+	if err == nil {
+		if revision, err := strconv.Atoi(failedRevision); err == nil {
+			m.metricStorage.GaugeSet("{PREFIX}module_helm_failed_revision", float64(revision), metricLabels)
+		}
+		logEntry.Errorf("Helm upgrade of release '%s' failed %d time(s) in a row, revision %s has status '%s'", releaseName, m.State.HelmUpgradeFailures, failedRevision, status)
+	} else {
+		logEntry.Errorf("Helm upgrade of release '%s' failed %d time(s) in a row", releaseName, m.State.HelmUpgradeFailures)
+	}
+
+	policy := m.HelmUpgradePolicy()
+	if policy == nil || m.State.HelmUpgradeFailures < policy.FailuresBeforeRollback() {
+		return upgradeErr
+	}
+
+	if deployedRevision == "" {
+		if policy.OnFailure != HelmUpgradeOnFailureImmediateRollback {
+			logEntry.Warnf("Helm release '%s' has no deployed revision to rollback", releaseName)
+			return upgradeErr
+		}
+		// Nothing was deployed before, so the immediate rollback removes the failed release.
+		err := helmClient.DeleteRelease(releaseName)
+		if err != nil {
+			return fmt.Errorf("%s\ncannot uninstall failed release: %s", upgradeErr, err)
+		}
+		deployedRevision = "0"
+		logEntry.Warnf("Helm release '%s' has no deployed revision: failed release is uninstalled", releaseName)
+	} else {
+		err := helmClient.RollbackRelease(releaseName, deployedRevision)
+		if err != nil {
+			return fmt.Errorf("%s\ncannot rollback to revision %s: %s", upgradeErr, deployedRevision, err)
+		}
+		logEntry.Warnf("Helm release '%s' is rolled back from failed revision %s to revision %s", releaseName, failedRevision, deployedRevision)
+	}
+
+	m.UpdateState(func(state *ModuleState) {
+		state.HelmUpgradeFailures = 0
+		state.HelmRolledBackChecksum = checksum
+		state.HelmRolledBackRevision = deployedRevision
+	})
+	m.metricStorage.CounterAdd("{PREFIX}module_helm_rollbacks_total", 1.0, metricLabels)
+	if revision, err := strconv.Atoi(deployedRevision); err == nil {
+		m.metricStorage.GaugeSet("{PREFIX}module_helm_rollback_revision", float64(revision), metricLabels)
+	}
+
+	return fmt.Errorf("%s\n%s", upgradeErr, rolledBackError(deployedRevision))
+}
+
+// postponedUpgradeError is returned by ModuleRun while the upgrade with the rolled back checksum is skipped,
+// so the module stays failed until its values or the chart are changed.
+func (m *Module) postponedUpgradeError(releaseName string) error {
+	return fmt.Errorf("helm upgrade of release '%s' failed, %s", releaseName, rolledBackError(m.StateSnapshot().HelmRolledBackRevision))
+}
+
+func rolledBackError(revision string) error {
+	if revision == "0" {
+		return fmt.Errorf("release is uninstalled, upgrade is postponed until values or chart are changed")
+	}
+	return fmt.Errorf("release is rolled back to revision %s, upgrade is postponed until values or chart are changed", revision)
+}
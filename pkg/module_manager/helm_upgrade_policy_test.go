@@ -0,0 +1,115 @@
+package module_manager
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/shell-operator/pkg/metric_storage"
+
+	"github.com/flant/addon-operator/pkg/helm"
+	"github.com/flant/addon-operator/pkg/helm/client"
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+func newModuleWithHelmUpgradePolicy(policy *HelmUpgradePolicy) *Module {
+	m := NewModule("module", "/modules/module")
+	m.Definition = &ModuleDefinition{Name: "module", HelmUpgrade: policy}
+	mstor := metric_storage.NewMetricStorage()
+	mstor.WithNewRegistry()
+	m.WithMetricStorage(mstor)
+	return m
+}
+
+func Test_HelmUpgradePolicy_Validate(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect((&HelmUpgradePolicy{OnFailure: "immediateRollback"}).Validate()).Should(Succeed())
+	g.Expect((&HelmUpgradePolicy{OnFailure: "rollback", MaxFailures: 5}).Validate()).Should(Succeed())
+	g.Expect((&HelmUpgradePolicy{OnFailure: "retry"}).Validate()).ShouldNot(Succeed())
+	g.Expect((&HelmUpgradePolicy{OnFailure: "rollback", MaxFailures: -1}).Validate()).ShouldNot(Succeed())
+	g.Expect((&HelmUpgradePolicy{OnFailure: "immediateRollback", MaxFailures: 3}).Validate()).ShouldNot(Succeed())
+
+	g.Expect((&HelmUpgradePolicy{OnFailure: "immediateRollback"}).FailuresBeforeRollback()).Should(Equal(1))
+	g.Expect((&HelmUpgradePolicy{OnFailure: "rollback"}).FailuresBeforeRollback()).Should(Equal(DefaultHelmUpgradeMaxFailures))
+	g.Expect((&HelmUpgradePolicy{OnFailure: "rollback", MaxFailures: 5}).FailuresBeforeRollback()).Should(Equal(5))
+}
+
+func Test_HandleFailedHelmUpgrade(t *testing.T) {
+	g := NewWithT(t)
+	upgradeErr := fmt.Errorf("helm upgrade failed")
+
+	// No policy: release is left as is.
+	m := newModuleWithHelmUpgradePolicy(nil)
+	hc := &helm.MockHelmClient{LastRevision: "4", LastStatus: "failed", DeployedRevision: "3"}
+	for i := 0; i < 5; i++ {
+		err := m.handleFailedHelmUpgrade(hc, "module", "checksum", hc.DeployedRevision, upgradeErr, nil)
+		g.Expect(err).Should(Equal(upgradeErr))
+	}
+	g.Expect(hc.RolledBackRevision).Should(BeEmpty())
+	g.Expect(m.State.HelmUpgradeFailures).Should(Equal(5))
+
+	// Rollback after 2 failures in a row.
+	m = newModuleWithHelmUpgradePolicy(&HelmUpgradePolicy{OnFailure: "rollback", MaxFailures: 2})
+	hc = &helm.MockHelmClient{LastRevision: "4", LastStatus: "failed", DeployedRevision: "3"}
+	err := m.handleFailedHelmUpgrade(hc, "module", "checksum", hc.DeployedRevision, upgradeErr, nil)
+	g.Expect(err).Should(Equal(upgradeErr))
+	g.Expect(hc.RolledBackRevision).Should(BeEmpty())
+	err = m.handleFailedHelmUpgrade(hc, "module", "checksum", hc.DeployedRevision, upgradeErr, nil)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("rolled back to revision 3"))
+	g.Expect(hc.RolledBackRevision).Should(Equal("3"))
+	g.Expect(m.State.HelmUpgradeFailures).Should(Equal(0))
+	g.Expect(m.State.HelmRolledBackRevision).Should(Equal("3"))
+
+	// Rolled back upgrade is not retried with the same checksum.
+	shouldRun, err := m.ShouldRunHelmUpgrade(hc, "module", "checksum", nil, nil)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(shouldRun).Should(BeFalse())
+
+	// Immediate rollback uninstalls the release without deployed revisions.
+	m = newModuleWithHelmUpgradePolicy(&HelmUpgradePolicy{OnFailure: "immediateRollback"})
+	hc = &helm.MockHelmClient{LastRevision: "1", LastStatus: "failed"}
+	err = m.handleFailedHelmUpgrade(hc, "module", "checksum", hc.DeployedRevision, upgradeErr, nil)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(hc.DeleteReleaseExecuted).Should(BeTrue())
+	g.Expect(hc.RolledBackRevision).Should(BeEmpty())
+	g.Expect(m.State.HelmRolledBackRevision).Should(Equal("0"))
+
+	m.resetHelmUpgradeFailures()
+	g.Expect(m.State.HelmRolledBackChecksum).Should(BeEmpty())
+}
+
+func Test_RunHelmInstall_PostponedUpgrade(t *testing.T) {
+	g := NewWithT(t)
+
+	hc := &helm.MockHelmClient{
+		LastRevision: "4",
+		LastStatus:   "deployed",
+		Manifests:    "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n",
+	}
+	helm.NewClient = func(logLabels ...map[string]string) client.HelmClient {
+		return hc
+	}
+
+	mm := NewMainModuleManager()
+	mm.WithKubeConfigManager(MockKubeConfigManager{})
+	initModuleManager(t, mm, "test_run_module")
+	mstor := metric_storage.NewMetricStorage()
+	mstor.WithNewRegistry()
+
+	m := mm.GetModule("module")
+	m.WithMetricStorage(mstor)
+	m.UpdateState(func(state *ModuleState) {
+		state.HelmRolledBackChecksum = utils.CalculateStringsChecksum(hc.Manifests)
+		state.HelmRolledBackRevision = "3"
+	})
+
+	// Module is failed while values and the chart are the same as in the rolled back upgrade.
+	err := m.runHelmInstall(map[string]string{})
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("rolled back to revision 3, upgrade is postponed"))
+	g.Expect(hc.UpgradeReleaseExecuted).Should(BeFalse())
+	g.Expect(m.State.HelmReleaseRevision).Should(Equal("4"))
+}
@@ -260,6 +260,22 @@ func (mm *moduleManager) RegisterGlobalHooks() error {
 	mm.globalHooksOrder = make(map[BindingType][]*GlobalHook)
 	mm.globalHooksByName = make(map[string]*GlobalHook)
 
+	// load OpenAPI schemas for global config values and values
+	configBytes, valuesBytes, err := ReadOpenAPIFiles(filepath.Join(mm.GlobalHooksDir, "openapi"))
+	if err != nil {
+		return fmt.Errorf("read global OpenAPI schemas: %s", err)
+	}
+	err = mm.ValuesValidator.SchemaStorage.AddGlobalValuesSchemas(configBytes, valuesBytes)
+	if err != nil {
+		return fmt.Errorf("load global OpenAPI schemas: %s", err)
+	}
+	utils.SetSensitivePaths(utils.GlobalValuesKey, mm.ValuesValidator.SchemaStorage.GlobalSensitivePaths())
+
+	err = mm.loadConfigMigrations(utils.GlobalValuesKey, mm.GlobalHooksDir)
+	if err != nil {
+		return fmt.Errorf("load global config values migrations: %s", err)
+	}
+
 	hooks, err := SearchGlobalHooks(mm.GlobalHooksDir)
 	if err != nil {
 		return err
@@ -1,7 +1,9 @@
 package module_manager
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 
@@ -26,8 +28,11 @@ type HookExecutor struct {
 	ContextPath           string
 	ConfigValuesPatchPath string
 	ValuesPatchPath       string
-	MetricsPath           string
-	LogLabels             map[string]string
+	// Paths for RFC 7386 merge patches in JSON or YAML format.
+	ConfigValuesMergePatchPath string
+	ValuesMergePatchPath       string
+	MetricsPath                string
+	LogLabels                  map[string]string
 }
 
 func NewHookExecutor(h Hook, context []BindingContext, configVersion string) *HookExecutor {
@@ -75,6 +80,8 @@ func (e *HookExecutor) Run() (patches map[utils.ValuesPatchType]*utils.ValuesPat
 	}()
 	e.ConfigValuesPatchPath = tmpFiles["CONFIG_VALUES_JSON_PATCH_PATH"]
 	e.ValuesPatchPath = tmpFiles["VALUES_JSON_PATCH_PATH"]
+	e.ConfigValuesMergePatchPath = tmpFiles["CONFIG_VALUES_MERGE_PATCH_PATH"]
+	e.ValuesMergePatchPath = tmpFiles["VALUES_MERGE_PATCH_PATH"]
 	e.MetricsPath = tmpFiles["METRICS_PATH"]
 
 	envs := []string{}
@@ -101,6 +108,18 @@ func (e *HookExecutor) Run() (patches map[utils.ValuesPatchType]*utils.ValuesPat
 		return nil, nil, fmt.Errorf("got bad json patch for values: %s", err)
 	}
 
+	err = e.addMergePatch(patches, utils.ConfigMapPatch, e.ConfigValuesMergePatchPath, func() (utils.Values, error) {
+		return e.Hook.GetConfigValues(), nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("got bad merge patch for config values: %s", err)
+	}
+
+	err = e.addMergePatch(patches, utils.MemoryValuesPatch, e.ValuesMergePatchPath, e.Hook.GetValues)
+	if err != nil {
+		return nil, nil, fmt.Errorf("got bad merge patch for values: %s", err)
+	}
+
 	metrics, err = metric_operation.MetricOperationsFromFile(e.MetricsPath)
 	if err != nil {
 		return nil, nil, fmt.Errorf("got bad metrics: %s", err)
@@ -109,6 +128,46 @@ func (e *HookExecutor) Run() (patches map[utils.ValuesPatchType]*utils.ValuesPat
 	return patches, metrics, nil
 }
 
+// addMergePatch converts a merge patch from the file into operations and appends them
+// to the JSON patch of the same type. Merge patch is applied after the JSON patch,
+// so it is converted using values with applied JSON patch.
+func (e *HookExecutor) addMergePatch(patches map[utils.ValuesPatchType]*utils.ValuesPatch, patchType utils.ValuesPatchType, path string, getValues func() (utils.Values, error)) error {
+	if path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %s", path, err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	values, err := getValues()
+	if err != nil {
+		return err
+	}
+	jsonPatch := patches[patchType]
+	if jsonPatch != nil {
+		values, _, err = utils.ApplyValuesPatch(values, *jsonPatch)
+		if err != nil {
+			return err
+		}
+	}
+
+	mergePatch, err := utils.ValuesPatchFromMergePatchBytes(values, data)
+	if err != nil {
+		return err
+	}
+
+	if jsonPatch == nil {
+		patches[patchType] = mergePatch
+		return nil
+	}
+	jsonPatch.MergeOperations(mergePatch)
+	return nil
+}
+
 func (e *HookExecutor) RunGoHook() (patches map[utils.ValuesPatchType]*utils.ValuesPatch, metrics []metric_operation.MetricOperation, err error) {
 	goHook := e.Hook.GetGoHook()
 	if goHook == nil {
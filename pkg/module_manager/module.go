@@ -8,7 +8,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime/trace"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kennygrant/sanitize"
@@ -29,6 +31,7 @@ import (
 	"github.com/flant/addon-operator/pkg/app"
 	"github.com/flant/addon-operator/pkg/helm"
 	"github.com/flant/addon-operator/pkg/helm/client"
+	"github.com/flant/addon-operator/pkg/helm_resources_manager"
 	"github.com/flant/addon-operator/pkg/utils"
 )
 
@@ -39,10 +42,15 @@ type Module struct {
 	CommonStaticConfig *utils.ModuleConfig
 	// module values from modules/<module name>/values.yaml
 	StaticConfig *utils.ModuleConfig
+	// module definition from modules/<module name>/module.yaml
+	Definition *ModuleDefinition
 
 	LastReleaseManifests []manifest.Manifest
 
-	State *ModuleState
+	// State is changed only by ModuleRun and ModuleDelete of the module, but it is read
+	// by debug endpoints, so State changes should be done with UpdateState.
+	State     *ModuleState
+	stateLock sync.RWMutex
 
 	// There was a successful Run() without values changes
 	IsReady bool
@@ -63,6 +71,26 @@ type ModuleState struct {
 
 	// flag to prevent excess monitor starts
 	MonitorsStarted bool
+
+	// Reason why the module enabled by config is disabled because of unmet requirements.
+	DisabledReason string
+
+	// Result of the last ModuleRun task.
+	LastRunTime  time.Time
+	LastRunError string
+
+	// Revision of the helm release installed by the last ModuleRun.
+	HelmReleaseRevision string
+
+	// Number of failed helm upgrades in a row.
+	HelmUpgradeFailures int
+	// Checksum of the upgrade rolled back by the HelmUpgradePolicy and the revision
+	// the release was rolled back to ("0" if the release was uninstalled).
+	HelmRolledBackChecksum string
+	HelmRolledBackRevision string
+
+	// Fields of release resources left drifted after the last helm upgrade.
+	HelmKnownDrift []helm_resources_manager.DriftedField
 }
 
 func NewModule(name, path string) *Module {
@@ -73,6 +101,20 @@ func NewModule(name, path string) *Module {
 	}
 }
 
+// UpdateState changes the module state under lock.
+func (m *Module) UpdateState(fn func(state *ModuleState)) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	fn(m.State)
+}
+
+// StateSnapshot returns a copy of the module state to read it concurrently with ModuleRun.
+func (m *Module) StateSnapshot() ModuleState {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	return *m.State
+}
+
 func (m *Module) WithModuleManager(moduleManager *moduleManager) {
 	m.moduleManager = moduleManager
 }
@@ -269,6 +311,11 @@ func (m *Module) runHelmInstall(logLabels map[string]string) error {
 		}
 	}
 
+	err = m.validateValues()
+	if err != nil {
+		return err
+	}
+
 	helmReleaseName := m.generateHelmReleaseName()
 
 	valuesPath, err := m.PrepareValuesYamlFile()
@@ -332,13 +379,34 @@ func (m *Module) runHelmInstall(logLabels map[string]string) error {
 	}
 
 	if !runUpgradeRelease {
+		// Resources of the rolled back upgrade are not deployed, so there is nothing to monitor.
+		if m.State.HelmRolledBackChecksum == checksum {
+			m.updateHelmReleaseRevision(helmClient, helmReleaseName, logLabels)
+			return m.postponedUpgradeError(helmReleaseName)
+		}
+		// Revision is unknown after restart.
+		if m.State.HelmReleaseRevision == "" {
+			m.updateHelmReleaseRevision(helmClient, helmReleaseName, logLabels)
+		}
+		// Resources of the release can be not ready yet if the previous wait is failed.
+		if policy := m.ReadinessPolicy(); policy != nil {
+			err = m.waitForReady(policy, helmReleaseName, logLabels)
+			if err != nil {
+				return err
+			}
+		}
 		// Start resources monitor if release is not changed
 		if !m.moduleManager.HelmResourcesManager.HasMonitor(m.Name) {
-			m.moduleManager.HelmResourcesManager.StartMonitor(m.Name, manifests, app.Namespace)
+			m.moduleManager.HelmResourcesManager.StartMonitor(m.Name, manifests, app.Namespace, m.DriftOptions())
 		}
 		return nil
 	}
 
+	deployedRevision, err := m.lastDeployedRevision(helmClient, helmReleaseName)
+	if err != nil {
+		return err
+	}
+
 	// Run helm upgrade. Trace and measure its time.
 	func() {
 		defer trace.StartRegion(context.Background(), "ModuleRun-HelmPhase-helm-upgrade").End()
@@ -361,25 +429,105 @@ func (m *Module) runHelmInstall(logLabels map[string]string) error {
 		)
 	}()
 
+	// Wait until resources are ready if module has the readiness policy.
+	if policy := m.ReadinessPolicy(); err == nil && policy != nil {
+		err = m.waitForReady(policy, helmReleaseName, logLabels)
+	}
+
 	if err != nil {
-		return err
+		return m.handleFailedHelmUpgrade(helmClient, helmReleaseName, checksum, deployedRevision, err, logLabels)
 	}
+	m.resetHelmUpgradeFailures()
+
+	m.updateHelmReleaseRevision(helmClient, helmReleaseName, logLabels)
+	m.updateKnownDrift(manifests, logLabels)
 
 	// Start monitor resources if release was successful
-	m.moduleManager.HelmResourcesManager.StartMonitor(m.Name, manifests, app.Namespace)
+	m.moduleManager.HelmResourcesManager.StartMonitor(m.Name, manifests, app.Namespace, m.DriftOptions())
+
+	return nil
+}
+
+// waitForReady waits until resources of the last rendered release are ready.
+// It blocks the queue with the ModuleRun task up to the policy timeout.
+func (m *Module) waitForReady(policy *ReadinessPolicy, helmReleaseName string, logLabels map[string]string) error {
+	defer trace.StartRegion(context.Background(), "ModuleRun-HelmPhase-helm-wait-ready").End()
+
+	metricLabels := map[string]string{
+		"module":     m.Name,
+		"activation": logLabels["event.type"],
+		"operation":  "wait-ready",
+	}
+	defer measure.Duration(func(d time.Duration) {
+		m.metricStorage.HistogramObserve("{PREFIX}helm_operation_seconds", d.Seconds(), metricLabels)
+	})()
 
+	logEntry := log.WithFields(utils.LabelsToLogFields(logLabels))
+	logEntry.Infof("Wait up to %s for %d resources of release '%s' to become ready", policy.WaitTimeout(), len(m.LastReleaseManifests), helmReleaseName)
+	err := m.moduleManager.HelmResourcesManager.WaitForReady(m.LastReleaseManifests, app.Namespace, policy.WaitTimeout())
+	if err != nil {
+		return fmt.Errorf("helm release '%s' is not ready: %s", helmReleaseName, err)
+	}
 	return nil
 }
 
+// updateKnownDrift saves fields that are still drifted after helm upgrade. Helm cannot restore them,
+// so they do not trigger upgrades until their values are changed.
+func (m *Module) updateKnownDrift(manifests []manifest.Manifest, logLabels map[string]string) {
+	var known []helm_resources_manager.DriftedField
+	if helm.RestoresDrift {
+		logEntry := log.WithFields(utils.LabelsToLogFields(logLabels))
+		fields, err := m.moduleManager.HelmResourcesManager.GetDriftedFields(manifests, app.Namespace, m.DriftIgnoreRules())
+		if err != nil {
+			logEntry.Warnf("Cannot check drift of helm release resources: %s", err)
+		}
+		if len(fields) > 0 {
+			logEntry.Warnf("Drifted fields are not restored by helm upgrade, ignore them while their values are unchanged: %s", driftedFieldsString(fields))
+		}
+		known = fields
+	}
+	m.UpdateState(func(state *ModuleState) {
+		state.HelmKnownDrift = known
+	})
+}
+
+func driftedFieldsString(fields []helm_resources_manager.DriftedField) string {
+	res := make([]string, 0, len(fields))
+	for _, field := range fields {
+		res = append(res, field.String())
+	}
+	return strings.Join(res, ", ")
+}
+
+// updateHelmReleaseRevision saves a revision of the last release for debug output.
+func (m *Module) updateHelmReleaseRevision(helmClient client.HelmClient, releaseName string, logLabels map[string]string) {
+	revision, _, err := helmClient.LastReleaseStatus(releaseName)
+	if err != nil {
+		log.WithFields(utils.LabelsToLogFields(logLabels)).
+			Warnf("Cannot get revision of helm release '%s': %s", releaseName, err)
+		return
+	}
+	m.UpdateState(func(state *ModuleState) {
+		state.HelmReleaseRevision = revision
+	})
+}
+
 // ShouldRunHelmUpgrade tells if there is a case to run `helm upgrade`:
 //  - Helm chart in not installed yet.
 //  - Last release has FAILED status.
 //  - Checksum in release values not equals to checksum argument.
 //  - Some resources installed previously are missing.
+//  - Some resources are changed in cluster (drifted).
 // If all these conditions aren't met, helm upgrade can be skipped.
+// Upgrade is also skipped if the upgrade with the same checksum was rolled back by the HelmUpgradePolicy.
 func (m *Module) ShouldRunHelmUpgrade(helmClient client.HelmClient, releaseName string, checksum string, manifests []manifest.Manifest, logLabels map[string]string) (bool, error) {
 	logEntry := log.WithFields(utils.LabelsToLogFields(logLabels))
 
+	if m.State.HelmRolledBackChecksum != "" && m.State.HelmRolledBackChecksum == checksum {
+		logEntry.Warnf("helm release '%s' was rolled back to revision %s after failed upgrade with checksum '%s': skip release upgrade until values or chart are changed", releaseName, m.State.HelmRolledBackRevision, checksum)
+		return false, nil
+	}
+
 	revision, status, err := helmClient.LastReleaseStatus(releaseName)
 
 	if revision == "0" {
@@ -420,8 +568,8 @@ func (m *Module) ShouldRunHelmUpgrade(helmClient client.HelmClient, releaseName
 		}
 	}
 
-	// Check if there are absent resources
-	absent, err := m.moduleManager.HelmResourcesManager.GetAbsentResources(manifests, app.Namespace)
+	// Check if there are absent or drifted resources
+	absent, drifted, err := m.moduleManager.HelmResourcesManager.GetAbsentAndDriftedResources(manifests, app.Namespace, m.DriftOptions())
 	if err != nil {
 		return false, err
 	}
@@ -432,6 +580,12 @@ func (m *Module) ShouldRunHelmUpgrade(helmClient client.HelmClient, releaseName
 		return true, nil
 	}
 
+	// Run helm upgrade if resources are changed in cluster
+	if len(drifted) > 0 {
+		logEntry.Debugf("helm release '%s' has %d drifted resources: should run upgrade", releaseName, len(drifted))
+		return true, nil
+	}
+
 	logEntry.Debugf("helm release '%s' is unchanged: skip release upgrade", releaseName)
 	return false, nil
 }
@@ -637,38 +791,30 @@ func (m *Module) ConfigValues() utils.Values {
 	return utils.MergeValues(
 		// global section
 		utils.Values{"global": map[string]interface{}{}},
-		m.moduleManager.kubeGlobalConfigValues,
+		m.moduleManager.kubeGlobalConfig(),
 		// module section
 		utils.Values{m.ValuesKey(): map[string]interface{}{}},
-		m.moduleManager.kubeModulesConfigValues[m.Name],
+		m.moduleManager.kubeModuleConfigValues(m.Name),
 	)
 }
 
 // constructValues returns effective values for module hook:
 //
-// global section: static + kube + patches from hooks
+// global section: defaults from schemas + static + kube + patches from hooks
 //
-// module section: static + kube + patches from hooks
+// module section: defaults from schemas + static + kube + patches from hooks
 func (m *Module) constructValues() (utils.Values, error) {
 	var err error
 
-	res := utils.MergeValues(
-		// global
-		utils.Values{"global": map[string]interface{}{}},
-		m.moduleManager.commonStaticValues.Global(),
-		m.moduleManager.kubeGlobalConfigValues,
-		// module
-		utils.Values{m.ValuesKey(): map[string]interface{}{}},
-		m.CommonStaticConfig.Values,
-		m.StaticConfig.Values,
-		m.moduleManager.kubeModulesConfigValues[m.Name],
-	)
+	layers := m.valuesLayers()
+	layersValues := make([]utils.Values, 0, len(layers))
+	for _, layer := range layers {
+		layersValues = append(layersValues, layer.Values)
+	}
+	res := utils.MergeValues(layersValues...)
 
-	for _, patches := range [][]utils.ValuesPatch{
-		m.moduleManager.globalDynamicValuesPatches,
-		m.moduleManager.modulesDynamicValuesPatches[m.Name],
-	} {
-		for _, patch := range patches {
+	for _, layer := range m.valuesPatchesLayers() {
+		for _, patch := range layer.Patches {
 			// Invariant: do not store patches that does not apply
 			// Give user error for patches early, after patch receive
 
@@ -679,6 +825,9 @@ func (m *Module) constructValues() (utils.Values, error) {
 		}
 	}
 
+	// Redact values at sensitive paths in log messages.
+	utils.SetSensitiveValues("values/"+m.Name, utils.SensitiveStrings(res))
+
 	return res, nil
 }
 
@@ -712,12 +861,28 @@ func (m *Module) Values() (utils.Values, error) {
 	return res, nil
 }
 
+// validateValues validates module config values and effective values with OpenAPI schemas.
+func (m *Module) validateValues() error {
+	validator := m.moduleManager.ValuesValidator
+
+	err := validator.ValidateModuleConfigValues(m.Name, m.ConfigValues())
+	if err != nil {
+		return err
+	}
+
+	values, err := m.Values()
+	if err != nil {
+		return err
+	}
+	return validator.ValidateModuleValues(m.Name, values)
+}
+
 func (m *Module) ValuesKey() string {
 	return utils.ModuleNameToValuesKey(m.Name)
 }
 
 func (m *Module) ValuesPatches() []utils.ValuesPatch {
-	return m.moduleManager.modulesDynamicValuesPatches[m.Name]
+	return m.moduleManager.moduleValuesPatches(m.Name)
 }
 
 func (m *Module) prepareModuleEnabledResultFile() (string, error) {
@@ -845,6 +1010,8 @@ func (m *Module) checkIsEnabledByScript(precedingEnabledModules []string, logLab
 
 var ValidModuleNameRe = regexp.MustCompile(`^[0-9][0-9][0-9]-(.*)$`)
 
+// SearchModules returns modules from modules directory ordered by weight.
+// Module directory should be named as "NNN-name" or should contain module.yaml.
 func SearchModules(modulesDir string) (modules []*Module, err error) {
 	files, err := ioutil.ReadDir(modulesDir) // returns a list of modules sorted by filename
 	if err != nil {
@@ -853,31 +1020,42 @@ func SearchModules(modulesDir string) (modules []*Module, err error) {
 
 	badModulesDirs := make([]string, 0)
 	modules = make([]*Module, 0)
+	modulesPaths := make(map[string]string)
 
 	for _, file := range files {
 		if !file.IsDir() {
 			continue
 		}
-		matchRes := ValidModuleNameRe.FindStringSubmatch(file.Name())
-		if matchRes != nil {
-			moduleName := matchRes[1]
-			modulePath := filepath.Join(modulesDir, file.Name())
-			module := NewModule(moduleName, modulePath)
-			modules = append(modules, module)
-		} else {
-			badModulesDirs = append(badModulesDirs, filepath.Join(modulesDir, file.Name()))
+		modulePath := filepath.Join(modulesDir, file.Name())
+		definition, err := LoadModuleDefinition(modulePath)
+		if err != nil {
+			badModulesDirs = append(badModulesDirs, fmt.Sprintf("%s: %s", modulePath, err))
+			continue
 		}
+
+		if otherPath, has := modulesPaths[definition.Name]; has {
+			return nil, fmt.Errorf("module '%s' is defined twice: in '%s' and in '%s'", definition.Name, otherPath, modulePath)
+		}
+		modulesPaths[definition.Name] = modulePath
+
+		module := NewModule(definition.Name, modulePath)
+		module.Definition = definition
+		modules = append(modules, module)
 	}
 
 	if len(badModulesDirs) > 0 {
-		return nil, fmt.Errorf("modules directory contains directories not matched ValidModuleRegex '%s': %s", ValidModuleNameRe, strings.Join(badModulesDirs, ", "))
+		return nil, fmt.Errorf("modules directory contains bad modules directories:\n%s", strings.Join(badModulesDirs, "\n"))
 	}
 
+	// Modules with equal weights are ordered by directory name.
+	sort.SliceStable(modules, func(i, j int) bool {
+		return modules[i].Definition.Weight < modules[j].Definition.Weight
+	})
+
 	return
 }
 
 // RegisterModules load all available modules from modules directory
-// FIXME: Only 000-name modules are loaded, allow non-prefixed modules.
 func (mm *moduleManager) RegisterModules() error {
 	log.Debug("Search and register modules")
 
@@ -887,6 +1065,27 @@ func (mm *moduleManager) RegisterModules() error {
 	}
 	log.Debugf("Found %d modules", len(modules))
 
+	for _, module := range modules {
+		module.WithModuleManager(mm)
+		module.WithMetricStorage(mm.metricStorage)
+
+		// load OpenAPI schemas for config values and values
+		err = module.loadValuesSchemas()
+		if err != nil {
+			log.WithField("module", module.Name).Errorf("Load OpenAPI schemas: %s", err)
+			return fmt.Errorf("bad module '%s' OpenAPI schemas", module.Name)
+		}
+
+		// Sensitive paths should be known before values are logged.
+		utils.SetSensitivePaths(module.ValuesKey(), module.SensitivePaths())
+
+		err = mm.loadConfigMigrations(module.Name, module.Path)
+		if err != nil {
+			log.WithField("module", module.Name).Errorf("Load config values migrations: %s", err)
+			return fmt.Errorf("bad module '%s' config values migrations", module.Name)
+		}
+	}
+
 	// load global and modules common static values from modules/values.yaml
 	if err := mm.loadCommonStaticValues(); err != nil {
 		return fmt.Errorf("load common values for modules: %s", err)
@@ -895,9 +1094,6 @@ func (mm *moduleManager) RegisterModules() error {
 	for _, module := range modules {
 		logEntry := log.WithField("module", module.Name)
 
-		module.WithModuleManager(mm)
-		module.WithMetricStorage(mm.metricStorage)
-
 		// load static config from values.yaml
 		err := module.loadStaticValues()
 		if err != nil {
@@ -911,6 +1107,17 @@ func (mm *moduleManager) RegisterModules() error {
 		logEntry.Infof("Module '%s' is registered", module.Name)
 	}
 
+	// Required modules should go first to be enabled first and deleted last.
+	requires := make(map[string][]string)
+	for name, module := range mm.allModulesByName {
+		requires[name] = module.Requires()
+	}
+	mm.allModulesNamesInOrder, err = SortModulesByRequirements(mm.allModulesNamesInOrder, requires)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Modules in order: %v", mm.allModulesNamesInOrder)
+
 	return nil
 }
 
@@ -944,6 +1151,15 @@ func (m *Module) loadStaticValues() (err error) {
 	return nil
 }
 
+// loadValuesSchemas loads OpenAPI schemas from 'openapi' directory into the values validator.
+func (m *Module) loadValuesSchemas() error {
+	configBytes, valuesBytes, err := ReadOpenAPIFiles(filepath.Join(m.Path, "openapi"))
+	if err != nil {
+		return err
+	}
+	return m.moduleManager.ValuesValidator.SchemaStorage.AddModuleValuesSchemas(m.Name, configBytes, valuesBytes)
+}
+
 func (mm *moduleManager) loadCommonStaticValues() error {
 	valuesPath := filepath.Join(mm.ModulesDir, "values.yaml")
 	if _, err := os.Stat(valuesPath); os.IsNotExist(err) {
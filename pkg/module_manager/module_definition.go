@@ -0,0 +1,316 @@
+package module_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/flant/addon-operator/pkg/helm"
+	"github.com/flant/addon-operator/pkg/helm_resources_manager"
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+const ModuleDefinitionFile = "module.yaml"
+
+// Maturity stages of modules.
+const (
+	ModuleStageExperimental = "Experimental"
+	ModuleStagePreview      = "Preview"
+	ModuleStageGA           = "GA"
+	ModuleStageDeprecated   = "Deprecated"
+)
+
+var ModuleStages = []string{ModuleStageExperimental, ModuleStagePreview, ModuleStageGA, ModuleStageDeprecated}
+
+// ModuleDefinition is a module metadata. Name and weight are taken from
+// the "NNN-name" directory name. The optional module.yaml file in the module
+// directory can override them and define other fields:
+//
+// name: ingress-nginx
+// weight: 400
+// description: Ingress controller
+// tags: ["network"]
+// stage: GA
+// requires:
+// - cert-manager
+// sensitive:
+// - auth.password
+// helmUpgrade:
+//   onFailure: rollback
+//   maxFailures: 3
+// readiness:
+//   wait: true
+//   timeout: 10m
+// drift:
+//   ignore:
+//   - Deployment:spec.replicas
+type ModuleDefinition struct {
+	Name        string   `json:"name"`
+	Weight      int      `json:"weight"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Stage       string   `json:"stage,omitempty"`
+	// Names of modules that should be enabled before this module.
+	Requires []string `json:"requires,omitempty"`
+	// Dotted paths of sensitive values in the module section, see utils.SetSensitivePaths.
+	Sensitive []string `json:"sensitive,omitempty"`
+	// Policy for failed helm upgrades, see HelmUpgradePolicy.
+	HelmUpgrade *HelmUpgradePolicy `json:"helmUpgrade,omitempty"`
+	// Waiting for resources after helm upgrade, see ReadinessPolicy.
+	Readiness *ReadinessPolicy `json:"readiness,omitempty"`
+	// Fields of release resources that are expected to change in cluster, see DriftPolicy.
+	Drift *DriftPolicy `json:"drift,omitempty"`
+}
+
+// DriftPolicy defines fields that are not checked for drift. Rules are in the
+// "[Kind[/name]:]dotted.path" format, see helm_resources_manager.DriftIgnoreRule.
+type DriftPolicy struct {
+	Ignore []string `json:"ignore,omitempty"`
+}
+
+// ModuleNameRe is a rule for module names in module.yaml: lowercase words separated by '-'.
+var ModuleNameRe = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`)
+
+// ValidateModuleName checks that the name matches ModuleNameRe and can be restored
+// from the values key, e.g. 'cert-manager' and 'certManager'.
+func ValidateModuleName(name string) error {
+	if !ModuleNameRe.MatchString(name) {
+		return fmt.Errorf("'%s' does not match '%s'", name, ModuleNameRe)
+	}
+	valuesKey := utils.ModuleNameToValuesKey(name)
+	if utils.ModuleNameFromValuesKey(valuesKey) != name {
+		return fmt.Errorf("'%s' cannot be restored from the values key '%s'", name, valuesKey)
+	}
+	return nil
+}
+
+// LoadModuleDefinition returns a definition for the module directory.
+// Directory without numeric prefix should have module.yaml with the module name.
+func LoadModuleDefinition(modulePath string) (*ModuleDefinition, error) {
+	definition := new(ModuleDefinition)
+
+	dirName := filepath.Base(modulePath)
+	dirModuleName := dirName
+	matchRes := ValidModuleNameRe.FindStringSubmatch(dirName)
+	if matchRes != nil {
+		dirModuleName = matchRes[1]
+		definition.Name = dirModuleName
+		definition.Weight, _ = strconv.Atoi(dirName[:3])
+	}
+
+	definitionPath := filepath.Join(modulePath, ModuleDefinitionFile)
+	_, err := os.Stat(definitionPath)
+	if os.IsNotExist(err) {
+		if matchRes == nil {
+			return nil, fmt.Errorf("directory name is not matched ValidModuleRegex '%s' and there is no %s", ValidModuleNameRe, ModuleDefinitionFile)
+		}
+		return definition, nil
+	}
+
+	data, err := ioutil.ReadFile(definitionPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read '%s': %s", definitionPath, err)
+	}
+
+	// Values from the file override values from the directory name.
+	err = yaml.UnmarshalStrict(data, definition)
+	if err != nil {
+		return nil, fmt.Errorf("bad '%s': %s", definitionPath, err)
+	}
+
+	if definition.Name == "" {
+		return nil, fmt.Errorf("'%s' should define the module name", definitionPath)
+	}
+	if definition.Name != dirModuleName {
+		return nil, fmt.Errorf("'%s' has name '%s', but the module directory is named '%s'", definitionPath, definition.Name, dirName)
+	}
+	err = ValidateModuleName(definition.Name)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' has bad name: %s", definitionPath, err)
+	}
+	if definition.Stage != "" && !utils.ListFullyIn([]string{definition.Stage}, ModuleStages) {
+		return nil, fmt.Errorf("'%s' has bad stage '%s', expected one of: %s", definitionPath, definition.Stage, strings.Join(ModuleStages, ", "))
+	}
+	if definition.HelmUpgrade != nil {
+		err = definition.HelmUpgrade.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("'%s' has bad helmUpgrade: %s", definitionPath, err)
+		}
+	}
+	if definition.Readiness != nil {
+		err = definition.Readiness.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("'%s' has bad readiness: %s", definitionPath, err)
+		}
+	}
+	if definition.Drift != nil {
+		_, err = helm_resources_manager.ParseDriftIgnoreRules(definition.Drift.Ignore)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' has bad drift: %s", definitionPath, err)
+		}
+	}
+
+	return definition, nil
+}
+
+// SensitivePaths returns paths of sensitive values from module.yaml and from schemas.
+func (m *Module) SensitivePaths() []string {
+	paths := m.moduleManager.ValuesValidator.SchemaStorage.ModuleSensitivePaths(m.Name)
+	if m.Definition != nil {
+		paths = append(paths, m.Definition.Sensitive...)
+	}
+	return paths
+}
+
+// DriftIgnoreRules returns rules for fields that are not checked for drift.
+func (m *Module) DriftIgnoreRules() []helm_resources_manager.DriftIgnoreRule {
+	if m.Definition == nil || m.Definition.Drift == nil {
+		return nil
+	}
+	// Rules are validated in LoadModuleDefinition.
+	rules, _ := helm_resources_manager.ParseDriftIgnoreRules(m.Definition.Drift.Ignore)
+	return rules
+}
+
+// DriftOptions returns options of drift detection for resources of the module release.
+// Drift is only logged for Helm 2 as its upgrade does not restore drifted fields.
+func (m *Module) DriftOptions() helm_resources_manager.DriftOptions {
+	return helm_resources_manager.DriftOptions{
+		Ignore:     m.DriftIgnoreRules(),
+		Known:      m.StateSnapshot().HelmKnownDrift,
+		ReportOnly: !helm.RestoresDrift,
+	}
+}
+
+// Requires returns names of required modules.
+func (m *Module) Requires() []string {
+	if m.Definition == nil {
+		return nil
+	}
+	return m.Definition.Requires
+}
+
+// SortModulesByRequirements returns module names in an order where each module
+// goes after all its requirements. The original order is preserved for
+// independent modules, so the weight is still used for ordering.
+// An error is returned for unknown requirements and for dependency cycles.
+func SortModulesByRequirements(names []string, requires map[string][]string) ([]string, error) {
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+	}
+
+	for _, name := range names {
+		for _, req := range requires[name] {
+			if req == name {
+				return nil, fmt.Errorf("module '%s' requires itself", name)
+			}
+			if !known[req] {
+				return nil, fmt.Errorf("module '%s' requires unknown module '%s'", name, req)
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	added := make(map[string]bool, len(names))
+
+	// Add the first module in the original order with all requirements added.
+	// It is O(n^2), but the number of modules is small.
+	for len(sorted) < len(names) {
+		progress := false
+		for _, name := range names {
+			if added[name] {
+				continue
+			}
+			ready := true
+			for _, req := range requires[name] {
+				if !added[req] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				sorted = append(sorted, name)
+				added[name] = true
+				progress = true
+				break
+			}
+		}
+
+		if !progress {
+			cycled := make([]string, 0)
+			for _, name := range names {
+				if !added[name] {
+					cycled = append(cycled, name)
+				}
+			}
+			return nil, fmt.Errorf("modules have cyclic requirements: %s", strings.Join(cycled, ", "))
+		}
+	}
+
+	return sorted, nil
+}
+
+// GroupModulesByRequirements splits modules ordered by requirements into groups
+// that should be run one after another. Modules in one group have the same weight and
+// do not require each other, so they can be run concurrently. Modules with different
+// weights are never in one group to keep the order of weights.
+func GroupModulesByRequirements(names []string, requires map[string][]string, weights map[string]int) [][]string {
+	groups := make([][]string, 0)
+
+	start := 0
+	for i := 1; i <= len(names); i++ {
+		if i < len(names) && weights[names[i]] == weights[names[start]] {
+			continue
+		}
+		groups = append(groups, groupByRequirements(names[start:i], requires)...)
+		start = i
+	}
+
+	return groups
+}
+
+// groupByRequirements splits modules into groups by the depth of requirements.
+// Requirements out of names are considered met.
+func groupByRequirements(names []string, requires map[string][]string) [][]string {
+	groups := make([][]string, 0)
+	groupIdx := make(map[string]int, len(names))
+
+	for _, name := range names {
+		idx := 0
+		for _, req := range requires[name] {
+			if reqIdx, has := groupIdx[req]; has && reqIdx+1 > idx {
+				idx = reqIdx + 1
+			}
+		}
+		groupIdx[name] = idx
+		if idx == len(groups) {
+			groups = append(groups, []string{})
+		}
+		groups[idx] = append(groups[idx], name)
+	}
+
+	return groups
+}
+
+// unmetRequirements returns required modules that are not in the list of enabled modules.
+func (m *Module) unmetRequirements(enabledModules []string) []string {
+	enabled := make(map[string]bool, len(enabledModules))
+	for _, name := range enabledModules {
+		enabled[name] = true
+	}
+
+	unmet := make([]string, 0)
+	for _, req := range m.Requires() {
+		if !enabled[req] {
+			unmet = append(unmet, req)
+		}
+	}
+	return unmet
+}
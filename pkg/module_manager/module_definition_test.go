@@ -0,0 +1,195 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func Test_SortModulesByRequirements(t *testing.T) {
+	g := NewWithT(t)
+
+	names := []string{"a", "b", "c", "d"}
+
+	sorted, err := SortModulesByRequirements(names, map[string][]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(sorted).Should(Equal(names))
+
+	sorted, err = SortModulesByRequirements(names, map[string][]string{"a": {"d"}, "b": {"c"}})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(sorted).Should(Equal([]string{"c", "b", "d", "a"}))
+
+	_, err = SortModulesByRequirements(names, map[string][]string{"a": {"e"}})
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("unknown module 'e'"))
+
+	_, err = SortModulesByRequirements(names, map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}})
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("cyclic requirements: a, b, c"))
+}
+
+func Test_GroupModulesByRequirements(t *testing.T) {
+	g := NewWithT(t)
+
+	groups := GroupModulesByRequirements([]string{"a", "b", "c", "d", "e"}, map[string][]string{
+		"c": {"a"},
+		"d": {"c", "b"},
+		"e": {"b"},
+	}, nil)
+	g.Expect(groups).Should(Equal([][]string{{"a", "b"}, {"c", "e"}, {"d"}}))
+
+	g.Expect(GroupModulesByRequirements([]string{}, nil, nil)).Should(BeEmpty())
+
+	// Modules with different weights are run one after another, even if they are independent.
+	// "dashboard" requires "ingress", so it goes after it despite the lower weight.
+	groups = GroupModulesByRequirements(
+		[]string{"crds", "cert-manager", "prometheus", "ingress", "dashboard", "grafana", "monitoring"},
+		map[string][]string{
+			"dashboard":  {"ingress"},
+			"monitoring": {"prometheus", "grafana"},
+		},
+		map[string]int{
+			"crds":         10,
+			"cert-manager": 100,
+			"prometheus":   100,
+			"ingress":      200,
+			"dashboard":    150,
+			"grafana":      300,
+			"monitoring":   300,
+		})
+	g.Expect(groups).Should(Equal([][]string{
+		{"crds"},
+		{"cert-manager", "prometheus"},
+		{"ingress"},
+		{"dashboard"},
+		{"grafana"},
+		{"monitoring"},
+	}))
+}
+
+func Test_SearchModules_WithDefinitions(t *testing.T) {
+	g := NewWithT(t)
+
+	modules, err := SearchModules("testdata/search_modules__with_definitions/modules")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(modules).Should(HaveLen(3))
+
+	g.Expect(modules[0].Definition).Should(Equal(&ModuleDefinition{
+		Name:        "gamma",
+		Weight:      1,
+		Description: "Module with overridden weight",
+		Requires:    []string{"alpha"},
+	}))
+	g.Expect(modules[1].Definition).Should(Equal(&ModuleDefinition{
+		Name:        "beta",
+		Weight:      5,
+		Description: "Module without numeric prefix",
+		Tags:        []string{"network", "system"},
+		Stage:       ModuleStagePreview,
+	}))
+	g.Expect(modules[2].Definition).Should(Equal(&ModuleDefinition{
+		Name:   "alpha",
+		Weight: 10,
+	}))
+}
+
+func Test_LoadModuleDefinition_Errors(t *testing.T) {
+	g := NewWithT(t)
+
+	tmpDir, err := ioutil.TempDir("", "module-definition")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	defer os.RemoveAll(tmpDir)
+
+	// No prefix and no module.yaml.
+	modulePath := filepath.Join(tmpDir, "module")
+	g.Expect(os.Mkdir(modulePath, 0755)).Should(Succeed())
+	_, err = LoadModuleDefinition(modulePath)
+	g.Expect(err).Should(HaveOccurred())
+
+	// No name in module.yaml.
+	g.Expect(ioutil.WriteFile(filepath.Join(modulePath, ModuleDefinitionFile), []byte("weight: 10\n"), 0644)).Should(Succeed())
+	_, err = LoadModuleDefinition(modulePath)
+	g.Expect(err).Should(HaveOccurred())
+
+	// Name differs from the directory name.
+	g.Expect(ioutil.WriteFile(filepath.Join(modulePath, ModuleDefinitionFile), []byte("name: other\n"), 0644)).Should(Succeed())
+	_, err = LoadModuleDefinition(modulePath)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("has name 'other'"))
+
+	prefixedPath := filepath.Join(tmpDir, "010-module")
+	g.Expect(os.Mkdir(prefixedPath, 0755)).Should(Succeed())
+	g.Expect(ioutil.WriteFile(filepath.Join(prefixedPath, ModuleDefinitionFile), []byte("name: other\n"), 0644)).Should(Succeed())
+	_, err = LoadModuleDefinition(prefixedPath)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("has name 'other'"))
+
+	// Name breaks the module name rules.
+	badPath := filepath.Join(tmpDir, "Bad_Module")
+	g.Expect(os.Mkdir(badPath, 0755)).Should(Succeed())
+	g.Expect(ioutil.WriteFile(filepath.Join(badPath, ModuleDefinitionFile), []byte("name: Bad_Module\n"), 0644)).Should(Succeed())
+	_, err = LoadModuleDefinition(badPath)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("bad name"))
+
+	// Bad stage.
+	g.Expect(ioutil.WriteFile(filepath.Join(modulePath, ModuleDefinitionFile), []byte("name: module\nstage: Stable\n"), 0644)).Should(Succeed())
+	_, err = LoadModuleDefinition(modulePath)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("bad stage"))
+
+	// Bad helm upgrade policy.
+	g.Expect(ioutil.WriteFile(filepath.Join(modulePath, ModuleDefinitionFile), []byte("name: module\nhelmUpgrade:\n  onFailure: retry\n"), 0644)).Should(Succeed())
+	_, err = LoadModuleDefinition(modulePath)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("bad helmUpgrade"))
+
+	// Bad readiness timeout.
+	g.Expect(ioutil.WriteFile(filepath.Join(modulePath, ModuleDefinitionFile), []byte("name: module\nreadiness:\n  wait: true\n  timeout: 5\n"), 0644)).Should(Succeed())
+	_, err = LoadModuleDefinition(modulePath)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("bad readiness"))
+
+	// Bad drift ignore rule.
+	g.Expect(ioutil.WriteFile(filepath.Join(modulePath, ModuleDefinitionFile), []byte("name: module\ndrift:\n  ignore: [\"Deployment:\"]\n"), 0644)).Should(Succeed())
+	_, err = LoadModuleDefinition(modulePath)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("bad drift"))
+}
+
+func Test_ValidateModuleName(t *testing.T) {
+	g := NewWithT(t)
+
+	for _, name := range []string{"module", "cert-manager", "ingress-nginx-2"} {
+		g.Expect(ValidateModuleName(name)).Should(Succeed(), name)
+	}
+	for _, name := range []string{"", "Module", "cert_manager", "-module", "module-", "cert--manager", "1module", "module-1a"} {
+		g.Expect(ValidateModuleName(name)).ShouldNot(Succeed(), name)
+	}
+}
+
+func Test_Module_ReadinessPolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	m := NewModule("module", "/modules/module")
+	g.Expect(m.ReadinessPolicy()).Should(BeNil())
+
+	m.Definition = &ModuleDefinition{Name: "module", Readiness: &ReadinessPolicy{Wait: false, Timeout: "1m"}}
+	g.Expect(m.ReadinessPolicy()).Should(BeNil())
+
+	m.Definition.Readiness.Wait = true
+	g.Expect(m.ReadinessPolicy()).ShouldNot(BeNil())
+	g.Expect(m.ReadinessPolicy().WaitTimeout()).Should(Equal(time.Minute))
+
+	m.Definition.Readiness.Timeout = ""
+	g.Expect(m.ReadinessPolicy().WaitTimeout()).Should(Equal(DefaultReadinessTimeout))
+
+	// Timeout is capped, because waiting blocks the queue.
+	m.Definition.Readiness.Timeout = "1h"
+	g.Expect(m.Definition.Readiness.Validate()).Should(HaveOccurred())
+	g.Expect(m.ReadinessPolicy().WaitTimeout()).Should(Equal(MaxReadinessTimeout))
+}
@@ -3,6 +3,7 @@ package module_manager
 import (
 	"fmt"
 	"path/filepath"
+	"reflect"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -104,7 +105,8 @@ func (h *ModuleHook) handleModuleValuesPatch(currentValues utils.Values, valuesP
 		return nil, fmt.Errorf("merge module '%s' values failed: %s", h.Module.Name, err)
 	}
 
-	newValues, valuesChanged, err := utils.ApplyValuesPatch(currentValues, valuesPatch)
+	// 'test' operations are checked here, other operations are converted to 'add' and 'remove' for compaction.
+	newValues, normalizedPatch, err := utils.NormalizeValuesPatch(currentValues, valuesPatch)
 	if err != nil {
 		return nil, fmt.Errorf("merge module '%s' values failed: %s", h.Module.Name, err)
 	}
@@ -112,8 +114,8 @@ func (h *ModuleHook) handleModuleValuesPatch(currentValues utils.Values, valuesP
 	result := &moduleValuesMergeResult{
 		ModuleValuesKey: moduleValuesKey,
 		Values:          utils.Values{moduleValuesKey: make(map[string]interface{})},
-		ValuesChanged:   valuesChanged,
-		ValuesPatch:     valuesPatch,
+		ValuesChanged:   !reflect.DeepEqual(currentValues, newValues),
+		ValuesPatch:     normalizedPatch,
 	}
 
 	if newValues.HasKey(moduleValuesKey) {
@@ -166,7 +168,7 @@ func (h *ModuleHook) Run(bindingType BindingType, context []BindingContext, logL
 	if has && configValuesPatch != nil {
 		preparedConfigValues := utils.MergeValues(
 			utils.Values{h.Module.ValuesKey(): map[string]interface{}{}},
-			h.moduleManager.kubeModulesConfigValues[moduleName],
+			h.moduleManager.kubeModuleConfigValues(moduleName),
 		)
 
 		configValuesPatchResult, err := h.handleModuleValuesPatch(preparedConfigValues, *configValuesPatch)
@@ -174,14 +176,19 @@ func (h *ModuleHook) Run(bindingType BindingType, context []BindingContext, logL
 			return fmt.Errorf("module hook '%s': kube module config values update error: %s", h.Name, err)
 		}
 		if configValuesPatchResult.ValuesChanged {
-			err := h.moduleManager.kubeConfigManager.SetKubeModuleValues(moduleName, configValuesPatchResult.Values)
+			err := h.moduleManager.ValuesValidator.ValidateModuleConfigValues(moduleName, configValuesPatchResult.Values)
 			if err != nil {
-				log.Debugf("Module hook '%s' kube module config values stay unchanged:\n%s", h.Name, h.moduleManager.kubeModulesConfigValues[moduleName].DebugString())
+				return fmt.Errorf("module hook '%s': ConfigMap patch is not accepted: %s", h.Name, err)
+			}
+
+			err = h.moduleManager.kubeConfigManager.SetKubeModuleValues(moduleName, configValuesPatchResult.Values)
+			if err != nil {
+				log.Debugf("Module hook '%s' kube module config values stay unchanged:\n%s", h.Name, h.moduleManager.kubeModuleConfigValues(moduleName).DebugString())
 				return fmt.Errorf("module hook '%s': set kube module config failed: %s", h.Name, err)
 			}
 
-			h.moduleManager.kubeModulesConfigValues[moduleName] = configValuesPatchResult.Values
-			log.Debugf("Module hook '%s': kube module '%s' config values updated:\n%s", h.Name, moduleName, h.moduleManager.kubeModulesConfigValues[moduleName].DebugString())
+			h.moduleManager.setKubeModuleConfigValues(moduleName, configValuesPatchResult.Values)
+			log.Debugf("Module hook '%s': kube module '%s' config values updated:\n%s", h.Name, moduleName, configValuesPatchResult.Values.DebugString())
 		}
 	}
 
@@ -196,7 +203,13 @@ func (h *ModuleHook) Run(bindingType BindingType, context []BindingContext, logL
 			return fmt.Errorf("module hook '%s': dynamic module values update error: %s", h.Name, err)
 		}
 		if valuesPatchResult.ValuesChanged {
-			h.moduleManager.modulesDynamicValuesPatches[moduleName] = utils.AppendValuesPatch(h.moduleManager.modulesDynamicValuesPatches[moduleName], valuesPatchResult.ValuesPatch)
+			err := h.moduleManager.ValuesValidator.ValidateModuleValues(moduleName, valuesPatchResult.Values)
+			if err != nil {
+				return fmt.Errorf("module hook '%s': values patch is not accepted: %s", h.Name, err)
+			}
+
+			valuesPatchResult.ValuesPatch.WithSource(utils.NewValuesPatchSource(h.Name, string(bindingType), logLabels["task.id"]))
+			h.moduleManager.appendModuleValuesPatch(moduleName, valuesPatchResult.ValuesPatch)
 			newValues, err := h.Module.Values()
 			if err != nil {
 				return fmt.Errorf("get module values after values patch: %s", err)
@@ -239,6 +252,16 @@ func (h *ModuleHook) PrepareTmpFilesForHookRun(bindingContext []byte) (tmpFiles
 		return
 	}
 
+	tmpFiles["CONFIG_VALUES_MERGE_PATCH_PATH"], err = h.prepareConfigValuesMergePatchFile()
+	if err != nil {
+		return
+	}
+
+	tmpFiles["VALUES_MERGE_PATCH_PATH"], err = h.prepareValuesMergePatchFile()
+	if err != nil {
+		return
+	}
+
 	tmpFiles["METRICS_PATH"], err = h.prepareMetricsFile()
 	if err != nil {
 		return
@@ -296,6 +319,24 @@ func (h *ModuleHook) prepareValuesJsonPatchFile() (string, error) {
 	return path, nil
 }
 
+// CONFIG_VALUES_MERGE_PATCH_PATH
+func (h *ModuleHook) prepareConfigValuesMergePatchFile() (string, error) {
+	path := filepath.Join(h.TmpDir, fmt.Sprintf("%s.module-hook-config-values-%s.merge-patch", h.SafeName(), uuid.NewV4().String()))
+	if err := CreateEmptyWritableFile(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// VALUES_MERGE_PATCH_PATH
+func (h *ModuleHook) prepareValuesMergePatchFile() (string, error) {
+	path := filepath.Join(h.TmpDir, fmt.Sprintf("%s.module-hook-values-%s.merge-patch", h.SafeName(), uuid.NewV4().String()))
+	if err := CreateEmptyWritableFile(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
 // METRICS_PATH
 func (h *ModuleHook) prepareMetricsFile() (string, error) {
 	path := filepath.Join(h.TmpDir, fmt.Sprintf("%s.module-hook-metrics-%s.json", h.SafeName(), uuid.NewV4().String()))
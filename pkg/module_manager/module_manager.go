@@ -29,6 +29,8 @@ import (
 	"github.com/flant/addon-operator/pkg/helm_resources_manager"
 	"github.com/flant/addon-operator/pkg/kube_config_manager"
 	"github.com/flant/addon-operator/pkg/utils"
+	"github.com/flant/addon-operator/pkg/values/migration"
+	"github.com/flant/addon-operator/pkg/values/validation"
 )
 
 // TODO separate modules and hooks storage, values storage and actions
@@ -47,11 +49,17 @@ type ModuleManager interface {
 	WithHelmResourcesManager(manager helm_resources_manager.HelmResourcesManager)
 	WithMetricStorage(storage *metric_storage.MetricStorage)
 	WithHookMetricStorage(storage *metric_storage.MetricStorage)
+	WithValuesValidator(validator *validation.ValuesValidator)
+	GetValuesValidator() *validation.ValuesValidator
+	WithConfigMigrations(migrations *migration.Migrations)
 
 	GetGlobalHooksInOrder(bindingType BindingType) []string
 	GetGlobalHook(name string) *GlobalHook
 
 	GetModuleNamesInOrder() []string
+	GetModulesDisabledReasons() map[string]string
+	GetModulesStatus() []ModuleStatus
+	GetModuleEnabledTrace(moduleName string) *ModuleEnabledTrace
 	GetModule(name string) *Module
 	GetModuleHookNames(moduleName string) []string
 	GetModuleHook(name string) *ModuleHook
@@ -149,6 +157,19 @@ type moduleManager struct {
 	// This list is changed on ConfigMap changes.
 	enabledModulesInOrder []string
 
+	// Reasons for modules that are enabled by config but disabled
+	// because of unmet requirements. Key is module name.
+	modulesDisabledReasons map[string]string
+
+	// Sources of the enabled state for all modules. Key is module name.
+	modulesEnabledSources map[string]string
+
+	// Decision traces from the last calculateEnabledModulesByConfig and
+	// runModulesEnabledScript runs. Key is module name.
+	enabledByConfigTraces map[string][]EnabledTraceStep
+	enabledScriptTraces   map[string]EnabledTraceStep
+	enabledTraceLock      sync.Mutex
+
 	// Index of all global hooks. Key is global hook name
 	globalHooksByName map[string]*GlobalHook
 	// Index for searching global hooks by their bindings.
@@ -187,6 +208,12 @@ type moduleManager struct {
 
 	kubeConfigManager kube_config_manager.KubeConfigManager
 
+	// Validator for global and modules values with OpenAPI schemas.
+	ValuesValidator *validation.ValuesValidator
+
+	// Migrations for global and modules config values.
+	ConfigMigrations *migration.Migrations
+
 	// Saved values from ConfigMap to handle Ambiguous state.
 	moduleConfigsUpdateBeforeAmbiguos kube_config_manager.ModuleConfigs
 	// Internal event: module manager needs to be restarted.
@@ -239,6 +266,8 @@ func NewMainModuleManager() *moduleManager {
 		enabledModulesByConfig:      make([]string, 0),
 		enabledModulesInOrder:       make([]string, 0),
 		dynamicEnabled:              make(map[string]*bool),
+		enabledByConfigTraces:       make(map[string][]EnabledTraceStep),
+		enabledScriptTraces:         make(map[string]EnabledTraceStep),
 		globalHooksByName:           make(map[string]*GlobalHook),
 		globalHooksOrder:            make(map[BindingType][]*GlobalHook),
 		modulesHooksOrderByName:     make(map[string]map[BindingType][]*ModuleHook),
@@ -252,6 +281,8 @@ func NewMainModuleManager() *moduleManager {
 		globalValuesChanged: make(chan bool, 1),
 
 		kubeConfigManager: nil,
+		ValuesValidator:   validation.NewValuesValidator(),
+		ConfigMigrations:  migration.NewMigrations(),
 
 		moduleConfigsUpdateBeforeAmbiguos: make(kube_config_manager.ModuleConfigs),
 		retryOnAmbiguous:                  make(chan bool, 1),
@@ -292,6 +323,18 @@ func (mm *moduleManager) WithHookMetricStorage(storage *metric_storage.MetricSto
 	mm.hookMetricStorage = storage
 }
 
+func (mm *moduleManager) WithValuesValidator(validator *validation.ValuesValidator) {
+	mm.ValuesValidator = validator
+}
+
+func (mm *moduleManager) GetValuesValidator() *validation.ValuesValidator {
+	return mm.ValuesValidator
+}
+
+func (mm *moduleManager) WithConfigMigrations(migrations *migration.Migrations) {
+	mm.ConfigMigrations = migrations
+}
+
 func (mm *moduleManager) WithContext(ctx context.Context) {
 	mm.ctx, mm.cancel = context.WithCancel(ctx)
 }
@@ -304,24 +347,67 @@ func (mm *moduleManager) Stop() {
 
 // RunModulesEnabledScript runs enable script for each module that is enabled by config.
 // Enable script receives a list of previously enabled modules.
-func (mm *moduleManager) RunModulesEnabledScript(enabledByConfig []string, logLabels map[string]string) ([]string, error) {
+// Reasons for modules disabled because of unmet requirements are returned as well.
+func (mm *moduleManager) RunModulesEnabledScript(enabledByConfig []string, logLabels map[string]string) ([]string, map[string]string, error) {
+	return mm.runModulesEnabledScript(enabledByConfig, logLabels)
+}
+
+// runModulesEnabledScript runs enable scripts for modules with met requirements.
+// Modules are checked in order of requirements, so a module is disabled
+// if its required modules are disabled. Reasons for such modules are returned.
+func (mm *moduleManager) runModulesEnabledScript(enabledByConfig []string, logLabels map[string]string) ([]string, map[string]string, error) {
 	enabledModules := make([]string, 0)
+	disabledReasons := make(map[string]string)
+	traces := make(map[string]EnabledTraceStep)
+	defer func() {
+		mm.setEnabledScriptTraces(traces)
+	}()
 
 	for _, name := range utils.SortByReference(enabledByConfig, mm.allModulesNamesInOrder) {
 		moduleLogLabels := utils.MergeLabels(logLabels)
 		moduleLogLabels["module"] = name
 		module := mm.allModulesByName[name]
+
+		unmet := module.unmetRequirements(enabledModules)
+		if len(unmet) > 0 {
+			disabledReasons[name] = fmt.Sprintf("required modules are disabled: %s", strings.Join(unmet, ", "))
+			traces[name] = EnabledTraceStep{
+				Source:  EnabledSourceRequirements,
+				Enabled: &utils.ModuleDisabled,
+				Message: disabledReasons[name],
+			}
+			log.WithFields(utils.LabelsToLogFields(moduleLogLabels)).
+				Infof("Module is disabled: %s", disabledReasons[name])
+			continue
+		}
+
 		moduleIsEnabled, err := module.checkIsEnabledByScript(enabledModules, moduleLogLabels)
 		if err != nil {
-			return nil, err
+			traces[name] = EnabledTraceStep{
+				Source:  EnabledSourceScript,
+				Message: fmt.Sprintf("enabled script error: %s", err),
+			}
+			return nil, nil, err
 		}
 
+		step := EnabledTraceStep{
+			Source:  EnabledSourceScript,
+			Enabled: &moduleIsEnabled,
+			Result:  moduleIsEnabled,
+			Message: fmt.Sprintf("previously enabled modules: %s", strings.Join(enabledModules, ", ")),
+		}
+		if !module.hasEnabledScript() {
+			step.Enabled = nil
+			step.Message = "no enabled script"
+		}
+		traces[name] = step
+
 		if moduleIsEnabled {
 			enabledModules = append(enabledModules, name)
 		}
 	}
 
-	return enabledModules, nil
+	return enabledModules, disabledReasons, nil
 }
 
 // kubeUpdate
@@ -329,14 +415,20 @@ type kubeUpdate struct {
 	EnabledModulesByConfig  []string
 	KubeGlobalConfigValues  utils.Values
 	KubeModulesConfigValues map[string]utils.Values
+	ModulesDisabledReasons  map[string]string
 	Events                  []Event
 }
 
 func (mm *moduleManager) applyKubeUpdate(kubeUpdate *kubeUpdate) error {
 	log.Debugf("Apply kubeupdate %+v", kubeUpdate)
+	mm.ValuesLock.Lock()
 	mm.kubeGlobalConfigValues = kubeUpdate.KubeGlobalConfigValues
 	mm.kubeModulesConfigValues = kubeUpdate.KubeModulesConfigValues
+	mm.ValuesLock.Unlock()
 	mm.enabledModulesByConfig = kubeUpdate.EnabledModulesByConfig
+	if kubeUpdate.ModulesDisabledReasons != nil {
+		mm.setModulesDisabledReasons(kubeUpdate.ModulesDisabledReasons)
+	}
 
 	for _, event := range kubeUpdate.Events {
 		mm.EventCh <- event
@@ -378,7 +470,7 @@ func (mm *moduleManager) handleNewKubeModuleConfigs(moduleConfigs kube_config_ma
 
 	res := &kubeUpdate{
 		Events:                 make([]Event, 0),
-		KubeGlobalConfigValues: mm.kubeGlobalConfigValues,
+		KubeGlobalConfigValues: mm.kubeGlobalConfig(),
 	}
 
 	// NOTE: values for non changed modules were copied from mm.kubeModulesConfigValues[moduleName].
@@ -402,7 +494,9 @@ func (mm *moduleManager) handleNewKubeModuleConfigs(moduleConfigs kube_config_ma
 				module.CommonStaticConfig.IsEnabled,
 				module.StaticConfig.IsEnabled,
 				mm.dynamicEnabled[moduleName])
+			mm.ValuesLock.Lock()
 			_, hasValues := mm.kubeModulesConfigValues[moduleName]
+			mm.ValuesLock.Unlock()
 			if isEnabled && hasValues {
 				updateOnSectionRemove[moduleName] = true
 			}
@@ -414,11 +508,13 @@ func (mm *moduleManager) handleNewKubeModuleConfigs(moduleConfigs kube_config_ma
 
 	// Run enable scripts
 	logEntry.Debugf("Run enabled script for %+v", res.EnabledModulesByConfig)
-	enabledModules, err := mm.RunModulesEnabledScript(res.EnabledModulesByConfig, logLabels)
+	enabledModules, disabledReasons, err := mm.RunModulesEnabledScript(res.EnabledModulesByConfig, logLabels)
 	if err != nil {
 		return nil, err
 	}
 	logEntry.Infof("Modules enabled by script: %+v", enabledModules)
+	logModulesDisabledReasons(logEntry, disabledReasons)
+	res.ModulesDisabledReasons = disabledReasons
 
 	// Configure events
 	if !reflect.DeepEqual(mm.enabledModulesInOrder, enabledModules) {
@@ -481,11 +577,9 @@ func (mm *moduleManager) calculateEnabledModulesByConfig(moduleConfigs kube_conf
 	for moduleName, module := range mm.allModulesByName {
 		kubeConfig, hasKubeConfig := moduleConfigs[moduleName]
 		if hasKubeConfig {
-			isEnabled := mergeEnabled(
-				module.CommonStaticConfig.IsEnabled,
-				module.StaticConfig.IsEnabled,
-				kubeConfig.IsEnabled,
-				mm.dynamicEnabled[moduleName])
+			trace := mm.enabledByConfigTrace(module, &kubeConfig)
+			mm.setEnabledByConfigTrace(moduleName, trace)
+			isEnabled := trace[len(trace)-1].Result
 
 			if isEnabled {
 				enabled = append(enabled, moduleName)
@@ -498,10 +592,9 @@ func (mm *moduleManager) calculateEnabledModulesByConfig(moduleConfigs kube_conf
 				kubeConfig.IsUpdated,
 				mm.dynamicEnabled[moduleName])
 		} else {
-			isEnabled := mergeEnabled(
-				module.CommonStaticConfig.IsEnabled,
-				module.StaticConfig.IsEnabled,
-				mm.dynamicEnabled[moduleName])
+			trace := mm.enabledByConfigTrace(module, nil)
+			mm.setEnabledByConfigTrace(moduleName, trace)
+			isEnabled := trace[len(trace)-1].Result
 
 			if isEnabled {
 				enabled = append(enabled, moduleName)
@@ -536,6 +629,11 @@ func (mm *moduleManager) Init() error {
 		return err
 	}
 
+	// Migrations are loaded only after modules registration, so initial config is migrated here.
+	if err := mm.kubeConfigManager.MigrateInitialConfig(); err != nil {
+		return err
+	}
+
 	kubeConfig := mm.kubeConfigManager.InitialConfig()
 	mm.kubeGlobalConfigValues = kubeConfig.Values
 
@@ -550,9 +648,33 @@ func (mm *moduleManager) Init() error {
 		log.Warnf("ConfigMap/%s has values for absent modules: %+v", app.ConfigMapName, unknownNames)
 	}
 
+	// Schemas are loaded only after modules registration, so initial config is validated here.
+	mm.validateKubeConfigValues()
 	return nil
 }
 
+// validateKubeConfigValues validates global and modules config values from ConfigMap.
+// Invalid config should not stop the start: invalid global values are replaced
+// with static values and modules with invalid values fail on run.
+func (mm *moduleManager) validateKubeConfigValues() {
+	err := mm.ValuesValidator.ValidateGlobalConfigValues(mm.kubeGlobalConfigValues)
+	if err != nil {
+		log.Errorf("ConfigMap/%s: global section is ignored, static values are used: %s", app.ConfigMapName, err)
+		mm.kubeGlobalConfigValues = utils.Values{}
+	}
+
+	for _, moduleName := range mm.allModulesNamesInOrder {
+		values, has := mm.kubeModulesConfigValues[moduleName]
+		if !has {
+			continue
+		}
+		err := mm.ValuesValidator.ValidateModuleConfigValues(moduleName, values)
+		if err != nil {
+			log.Errorf("ConfigMap/%s: module '%s' will fail until its section is fixed: %s", app.ConfigMapName, moduleName, err)
+		}
+	}
+}
+
 // Module manager loop
 func (mm *moduleManager) Start() {
 	go mm.kubeConfigManager.Start()
@@ -646,11 +768,14 @@ func (mm *moduleManager) DiscoverModulesState(logLabels map[string]string) (stat
 
 	currentEnabledModules := mm.enabledModulesInOrder
 
-	updateEnabledModules, updateModuleValues, _ := mm.calculateEnabledModulesByConfig(mm.kubeConfigManager.CurrentConfig().ModuleConfigs)
+	moduleConfigs := mm.kubeConfigManager.CurrentConfig().ModuleConfigs
+	updateEnabledModules, updateModuleValues, _ := mm.calculateEnabledModulesByConfig(moduleConfigs)
 	updateEnabledModules = utils.SortByReference(updateEnabledModules, mm.allModulesNamesInOrder)
 
 	mm.enabledModulesByConfig = updateEnabledModules
+	mm.ValuesLock.Lock()
 	mm.kubeModulesConfigValues = updateModuleValues
+	mm.ValuesLock.Unlock()
 
 	logEntry.Debugf("DISCOVER state updated:\n"+
 		"    mm.enabledModulesByConfig: %v\n"+
@@ -685,12 +810,15 @@ func (mm *moduleManager) DiscoverModulesState(logLabels map[string]string) (stat
 	// no need to refresh mm.enabledModulesByConfig because
 	// it is updated before in Init or in applyKubeUpdate
 	logEntry.Debugf("Run enabled script for %+v", mm.enabledModulesByConfig)
-	enabledModules, err := mm.RunModulesEnabledScript(mm.enabledModulesByConfig, logLabels)
+	enabledModules, disabledReasons, err := mm.runModulesEnabledScript(mm.enabledModulesByConfig, logLabels)
 	logEntry.Infof("Modules enabled by script: %+v", enabledModules)
 
 	if err != nil {
 		return nil, err
 	}
+	logModulesDisabledReasons(logEntry, disabledReasons)
+	mm.setModulesDisabledReasons(disabledReasons)
+	mm.modulesEnabledSources = mm.calculateEnabledSources(moduleConfigs, disabledReasons)
 
 	for _, moduleName := range enabledModules {
 		if err = mm.RegisterModuleHooks(mm.allModulesByName[moduleName], logLabels); err != nil {
@@ -709,7 +837,7 @@ func (mm *moduleManager) DiscoverModulesState(logLabels map[string]string) (stat
 	state.ModulesToDisable = utils.ListSubtract(mm.allModulesNamesInOrder, enabledModules)
 	enabledAndReleased := utils.ListUnion(currentEnabledModules, releasedModules)
 	state.ModulesToDisable = utils.ListIntersection(state.ModulesToDisable, enabledAndReleased)
-	// disable modules in reverse order
+	// disable modules in reverse order, so dependent modules are deleted before their requirements
 	state.ModulesToDisable = utils.SortReverseByReference(state.ModulesToDisable, mm.allModulesNamesInOrder)
 
 	logEntry.Debugf("DISCOVER state results:\n"+
@@ -743,6 +871,34 @@ func (mm *moduleManager) GetModuleNamesInOrder() []string {
 	return mm.enabledModulesInOrder
 }
 
+// GetModulesDisabledReasons returns reasons for modules disabled because of unmet requirements.
+func (mm *moduleManager) GetModulesDisabledReasons() map[string]string {
+	return mm.modulesDisabledReasons
+}
+
+// setModulesDisabledReasons saves reasons for modules disabled because of unmet requirements
+// and copies them to the states of all modules.
+func (mm *moduleManager) setModulesDisabledReasons(disabledReasons map[string]string) {
+	mm.modulesDisabledReasons = disabledReasons
+	for name, module := range mm.allModulesByName {
+		reason := disabledReasons[name]
+		module.UpdateState(func(state *ModuleState) {
+			state.DisabledReason = reason
+		})
+	}
+}
+
+func logModulesDisabledReasons(logEntry *log.Entry, disabledReasons map[string]string) {
+	names := make([]string, 0, len(disabledReasons))
+	for name := range disabledReasons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		logEntry.Infof("Module '%s' is disabled: %s", name, disabledReasons[name])
+	}
+}
+
 func (mm *moduleManager) GetGlobalHook(name string) *GlobalHook {
 	globalHook, exist := mm.globalHooksByName[name]
 	if exist {
@@ -945,11 +1101,62 @@ func (mm *moduleManager) RunModuleHook(hookName string, binding BindingType, bin
 	return nil
 }
 
+// Module values are accessed by concurrently running ModuleRun tasks, so
+// access to maps with modules values and to global values is guarded by ValuesLock.
+
+func (mm *moduleManager) kubeGlobalConfig() utils.Values {
+	mm.ValuesLock.Lock()
+	defer mm.ValuesLock.Unlock()
+	return mm.kubeGlobalConfigValues
+}
+
+func (mm *moduleManager) setKubeGlobalConfigValues(values utils.Values) {
+	mm.ValuesLock.Lock()
+	defer mm.ValuesLock.Unlock()
+	mm.kubeGlobalConfigValues = values
+}
+
+func (mm *moduleManager) globalValuesPatches() []utils.ValuesPatch {
+	mm.ValuesLock.Lock()
+	defer mm.ValuesLock.Unlock()
+	return mm.globalDynamicValuesPatches
+}
+
+func (mm *moduleManager) appendGlobalValuesPatch(patch utils.ValuesPatch) {
+	mm.ValuesLock.Lock()
+	defer mm.ValuesLock.Unlock()
+	mm.globalDynamicValuesPatches = utils.AppendValuesPatch(mm.globalDynamicValuesPatches, patch)
+}
+
+func (mm *moduleManager) kubeModuleConfigValues(moduleName string) utils.Values {
+	mm.ValuesLock.Lock()
+	defer mm.ValuesLock.Unlock()
+	return mm.kubeModulesConfigValues[moduleName]
+}
+
+func (mm *moduleManager) setKubeModuleConfigValues(moduleName string, values utils.Values) {
+	mm.ValuesLock.Lock()
+	defer mm.ValuesLock.Unlock()
+	mm.kubeModulesConfigValues[moduleName] = values
+}
+
+func (mm *moduleManager) moduleValuesPatches(moduleName string) []utils.ValuesPatch {
+	mm.ValuesLock.Lock()
+	defer mm.ValuesLock.Unlock()
+	return mm.modulesDynamicValuesPatches[moduleName]
+}
+
+func (mm *moduleManager) appendModuleValuesPatch(moduleName string, patch utils.ValuesPatch) {
+	mm.ValuesLock.Lock()
+	defer mm.ValuesLock.Unlock()
+	mm.modulesDynamicValuesPatches[moduleName] = utils.AppendValuesPatch(mm.modulesDynamicValuesPatches[moduleName], patch)
+}
+
 // GlobalConfigValues return global values defined in a ConfigMap
 func (mm *moduleManager) GlobalConfigValues() utils.Values {
 	return utils.MergeValues(
 		utils.Values{"global": map[string]interface{}{}},
-		mm.kubeGlobalConfigValues,
+		mm.kubeGlobalConfig(),
 	)
 }
 
@@ -959,25 +1166,29 @@ func (mm *moduleManager) GlobalValues() (utils.Values, error) {
 
 	res := utils.MergeValues(
 		utils.Values{"global": map[string]interface{}{}},
+		mm.ValuesValidator.SchemaStorage.GlobalValuesDefaults(),
 		mm.commonStaticValues.Global(),
-		mm.kubeGlobalConfigValues,
+		mm.kubeGlobalConfig(),
 	)
 
 	// Invariant: do not store patches that does not apply
 	// Give user error for patches early, after patch receive
-	for _, patch := range mm.globalDynamicValuesPatches {
+	for _, patch := range mm.globalValuesPatches() {
 		res, _, err = utils.ApplyValuesPatch(res, patch)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	// Redact values at sensitive paths in log messages.
+	utils.SetSensitiveValues("values/"+utils.GlobalValuesKey, utils.SensitiveStrings(res))
+
 	return res, nil
 }
 
 // GlobalValues return patches for global values
 func (mm *moduleManager) GlobalValuesPatches() []utils.ValuesPatch {
-	return mm.globalDynamicValuesPatches
+	return mm.globalValuesPatches()
 }
 
 func (mm *moduleManager) HandleKubeEvent(kubeEvent KubeEvent, createGlobalTaskFn func(*GlobalHook, controller.BindingExecutionInfo), createModuleTaskFn func(*Module, *ModuleHook, controller.BindingExecutionInfo)) {
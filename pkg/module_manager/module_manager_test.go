@@ -219,6 +219,67 @@ func Test_MainModuleManager_LoadValuesInInit(t *testing.T) {
 
 }
 
+// Defaults from OpenAPI schemas should be a layer below static values and ConfigMap values.
+func Test_MainModuleManager_ValuesWithSchemaDefaults(t *testing.T) {
+	mm := NewMainModuleManager()
+	initModuleManager(t, mm, "load_values__with_schema_defaults")
+
+	globalValues, err := mm.GlobalValues()
+	if assert.NoError(t, err) {
+		assert.Equal(t, utils.Values{
+			"global": map[string]interface{}{
+				"param1": "default1",
+				"param2": "kube2",
+			},
+		}, globalValues)
+	}
+
+	module := mm.GetModule("module-one")
+	values, err := module.Values()
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{
+			"param1": "default1",
+			"param2": "static2",
+			"param3": "kube3",
+			"internal": map[string]interface{}{
+				"level": 5.0,
+			},
+		}, values["moduleOne"])
+		assert.Equal(t, "kube2", values["global"].(map[string]interface{})["param2"])
+	}
+
+	// Config values should not contain defaults.
+	assert.Equal(t, map[string]interface{}{"param3": "kube3"}, module.ConfigValues()["moduleOne"])
+}
+
+func Test_MainModuleManager_ValuesProvenance(t *testing.T) {
+	mm := NewMainModuleManager()
+	initModuleManager(t, mm, "load_values__with_schema_defaults")
+
+	patch := utils.MustValuesPatch(utils.ValuesPatchFromBytes([]byte(`[{"op":"add", "path":"/moduleOne/param2", "value":"patched2"}]`)))
+	mm.appendModuleValuesPatch("module-one", *patch.WithSource(utils.NewValuesPatchSource("hook.sh", "schedule", "task-id")))
+
+	provenance, err := mm.GetModule("module-one").ValuesProvenance()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	origins := map[string]ValuesOrigin{}
+	for _, origin := range provenance {
+		origins[origin.Path] = origin
+	}
+	assert.Equal(t, ValuesLayerGlobalSchema, origins["/global/param1"].Layer)
+	assert.Equal(t, ValuesLayerGlobalConfigMap, origins["/global/param2"].Layer)
+	assert.Equal(t, ValuesLayerModuleSchema, origins["/moduleOne/param1"].Layer)
+	assert.Equal(t, ValuesLayerModuleConfigMap, origins["/moduleOne/param3"].Layer)
+	assert.Equal(t, "patched2", origins["/moduleOne/param2"].Value)
+	assert.Equal(t, ValuesLayerModulePatches, origins["/moduleOne/param2"].Layer)
+	if assert.NotNil(t, origins["/moduleOne/param2"].Source) {
+		assert.Equal(t, "hook.sh", origins["/moduleOne/param2"].Source.HookName)
+	}
+	assert.Equal(t, ValuesLayerGlobalEnabledModule, origins["/global/enabledModules"].Layer)
+}
+
 func Test_MainModuleManager_Get_Module(t *testing.T) {
 	mm := NewMainModuleManager()
 
@@ -259,6 +320,7 @@ func Test_MainModuleManager_Get_Module(t *testing.T) {
 						ModuleEnabledKey: "moduleEnabled",
 						RawConfig:        []string{},
 					},
+					Definition:    &ModuleDefinition{Name: "module"},
 					State:         &ModuleState{},
 					moduleManager: mm,
 				}
@@ -1146,11 +1208,28 @@ func Test_MainModuleManager_DiscoverModulesState(t *testing.T) {
 				// If all modules are enabled by default, then beta should be disabled by script.
 				assert.Equal(t, []string{"alpha", "gamma", "delta", "epsilon", "zeta", "eta"}, modulesState.EnabledModules)
 
+				trace := mm.GetModuleEnabledTrace("beta")
+				if assert.NotNil(t, trace) && assert.Len(t, trace.Steps, 5) {
+					assert.False(t, trace.Enabled)
+					assert.Equal(t, EnabledSourceCommonValues, trace.Steps[0].Source)
+					assert.True(t, trace.Steps[0].Result)
+					assert.Equal(t, EnabledSourceScript, trace.Steps[4].Source)
+					assert.False(t, trace.Steps[4].Result)
+				}
+				assert.Nil(t, mm.GetModuleEnabledTrace("unknown"))
+
 				// Turn off alpha so gamma, delta and zeta should become disabled
 				// with the next call to DiscoverModulesState.
 				mm.dynamicEnabled["alpha"] = &utils.ModuleDisabled
 				modulesState, err = mm.DiscoverModulesState(map[string]string{})
 				assert.Equal(t, []string{"epsilon", "eta"}, modulesState.EnabledModules)
+
+				// Script is not run for alpha, the last step is a dynamic enabled patch.
+				trace = mm.GetModuleEnabledTrace("alpha")
+				if assert.Len(t, trace.Steps, 4) {
+					assert.False(t, trace.Enabled)
+					assert.Equal(t, EnabledSourceDynamic, trace.Steps[3].Source)
+				}
 			},
 		},
 		{
@@ -1167,6 +1246,76 @@ func Test_MainModuleManager_DiscoverModulesState(t *testing.T) {
 
 			},
 		},
+		{
+			"requirements",
+			"discover_modules_state__with_requirements",
+			[]string{},
+			func() {
+				if !assert.NoError(t, err) {
+					return
+				}
+				// dashboard has a lower prefix, but it goes after required ingress.
+				assert.Equal(t, []string{"prometheus", "cert-manager", "ingress", "dashboard", "monitoring"}, mm.allModulesNamesInOrder)
+				// monitoring requires disabled prometheus.
+				assert.Equal(t, []string{"cert-manager", "ingress", "dashboard"}, modulesState.EnabledModules)
+				assert.Equal(t, map[string]string{"monitoring": "required modules are disabled: prometheus"}, mm.GetModulesDisabledReasons())
+				assert.Equal(t, "required modules are disabled: prometheus", mm.GetModule("monitoring").StateSnapshot().DisabledReason)
+				assert.Equal(t, "", mm.GetModule("ingress").StateSnapshot().DisabledReason)
+
+				// Reasons are returned to callers of enabled scripts.
+				enabledModules, disabledReasons, err := mm.RunModulesEnabledScript(mm.enabledModulesByConfig, map[string]string{})
+				assert.NoError(t, err)
+				assert.Equal(t, modulesState.EnabledModules, enabledModules)
+				assert.Equal(t, mm.GetModulesDisabledReasons(), disabledReasons)
+
+				// Disable cert-manager: dependent modules should be deleted first.
+				mm.dynamicEnabled["cert-manager"] = &utils.ModuleDisabled
+				modulesState, err = mm.DiscoverModulesState(map[string]string{})
+				assert.NoError(t, err)
+				assert.Equal(t, []string{}, modulesState.EnabledModules)
+				assert.Equal(t, []string{"dashboard", "ingress", "cert-manager"}, modulesState.ModulesToDisable)
+				assert.Contains(t, mm.GetModulesDisabledReasons(), "ingress")
+				assert.Contains(t, mm.GetModulesDisabledReasons(), "dashboard")
+				assert.NotEmpty(t, mm.GetModule("ingress").StateSnapshot().DisabledReason)
+			},
+		},
+		{
+			"modules_status",
+			"discover_modules_state__with_requirements",
+			[]string{},
+			func() {
+				if !assert.NoError(t, err) {
+					return
+				}
+				mm.dynamicEnabled["dashboard"] = &utils.ModuleDisabled
+				modulesState, err = mm.DiscoverModulesState(map[string]string{})
+				assert.NoError(t, err)
+
+				statuses := mm.GetModulesStatus()
+				sources := map[string]string{}
+				enabled := map[string]bool{}
+				for _, status := range statuses {
+					sources[status.Name] = status.EnabledSource
+					enabled[status.Name] = status.Enabled
+				}
+				assert.Equal(t, "prometheus", statuses[0].Name)
+				assert.Equal(t, map[string]string{
+					"prometheus":   EnabledSourceCommonValues,
+					"cert-manager": EnabledSourceCommonValues,
+					"ingress":      EnabledSourceCommonValues,
+					"dashboard":    EnabledSourceDynamic,
+					"monitoring":   EnabledSourceRequirements,
+				}, sources)
+				assert.Equal(t, map[string]bool{
+					"prometheus":   false,
+					"cert-manager": true,
+					"ingress":      true,
+					"dashboard":    false,
+					"monitoring":   false,
+				}, enabled)
+				assert.Equal(t, "required modules are disabled: prometheus", statuses[4].DisabledReason)
+			},
+		},
 	}
 
 	for _, test := range tests {
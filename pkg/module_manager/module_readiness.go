@@ -0,0 +1,59 @@
+package module_manager
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultReadinessTimeout is a time to wait for module resources if timeout is not set.
+const DefaultReadinessTimeout = 5 * time.Minute
+
+// MaxReadinessTimeout is a maximum time to wait for module resources. Waiting blocks
+// the queue with the ModuleRun task, so other modules and hooks wait too.
+const MaxReadinessTimeout = 10 * time.Minute
+
+// ReadinessPolicy enables waiting for module resources after helm upgrade.
+// ModuleRun is successful only when Deployments, StatefulSets and DaemonSets are rolled out,
+// Jobs are complete and CRDs are established. Timeout is a Go duration string
+// not greater than MaxReadinessTimeout.
+type ReadinessPolicy struct {
+	Wait    bool   `json:"wait"`
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func (p *ReadinessPolicy) Validate() error {
+	if p.Timeout == "" {
+		return nil
+	}
+	timeout, err := time.ParseDuration(p.Timeout)
+	if err != nil {
+		return fmt.Errorf("bad timeout '%s': %s", p.Timeout, err)
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("timeout should be positive")
+	}
+	if timeout > MaxReadinessTimeout {
+		return fmt.Errorf("timeout should not be greater than %s", MaxReadinessTimeout)
+	}
+	return nil
+}
+
+// WaitTimeout returns a timeout for waiting. Timeout is validated in LoadModuleDefinition.
+func (p *ReadinessPolicy) WaitTimeout() time.Duration {
+	timeout, err := time.ParseDuration(p.Timeout)
+	if err != nil || timeout <= 0 {
+		return DefaultReadinessTimeout
+	}
+	if timeout > MaxReadinessTimeout {
+		return MaxReadinessTimeout
+	}
+	return timeout
+}
+
+// ReadinessPolicy returns a policy to wait for resources or nil if waiting is not enabled.
+func (m *Module) ReadinessPolicy() *ReadinessPolicy {
+	if m.Definition == nil || m.Definition.Readiness == nil || !m.Definition.Readiness.Wait {
+		return nil
+	}
+	return m.Definition.Readiness
+}
@@ -0,0 +1,75 @@
+package module_manager
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/shell-operator/pkg/metric_storage"
+	"github.com/flant/shell-operator/pkg/utils/manifest"
+
+	"github.com/flant/addon-operator/pkg/helm"
+	"github.com/flant/addon-operator/pkg/helm/client"
+	"github.com/flant/addon-operator/pkg/helm_resources_manager"
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// mockReadinessResourcesManager reports no absent resources and returns readyErr on wait.
+type mockReadinessResourcesManager struct {
+	helm_resources_manager.HelmResourcesManager
+	readyErr    error
+	waitCounter int
+}
+
+func (m *mockReadinessResourcesManager) GetAbsentAndDriftedResources(_ []manifest.Manifest, _ string, _ helm_resources_manager.DriftOptions) ([]manifest.Manifest, []manifest.Manifest, error) {
+	return nil, nil, nil
+}
+
+func (m *mockReadinessResourcesManager) WaitForReady(_ []manifest.Manifest, _ string, _ time.Duration) error {
+	m.waitCounter++
+	return m.readyErr
+}
+
+func (m *mockReadinessResourcesManager) HasMonitor(_ string) bool {
+	return true
+}
+
+// Readiness should be checked if the release is not upgraded.
+func Test_RunHelmInstall_ReadinessWithoutUpgrade(t *testing.T) {
+	g := NewWithT(t)
+
+	hc := &helm.MockHelmClient{
+		LastRevision: "4",
+		LastStatus:   "deployed",
+		Manifests:    "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: backend\n",
+	}
+	hc.ReleaseValues = utils.Values{"_addonOperatorModuleChecksum": utils.CalculateStringsChecksum(hc.Manifests)}
+	helm.NewClient = func(logLabels ...map[string]string) client.HelmClient {
+		return hc
+	}
+
+	mm := NewMainModuleManager()
+	mm.WithKubeConfigManager(MockKubeConfigManager{})
+	initModuleManager(t, mm, "test_run_module")
+	resourcesManager := &mockReadinessResourcesManager{readyErr: fmt.Errorf("Deployment/backend is not rolled out")}
+	mm.WithHelmResourcesManager(resourcesManager)
+	mstor := metric_storage.NewMetricStorage()
+	mstor.WithNewRegistry()
+
+	m := mm.GetModule("module")
+	m.WithMetricStorage(mstor)
+	m.Definition = &ModuleDefinition{Name: "module", Readiness: &ReadinessPolicy{Wait: true}}
+
+	err := m.runHelmInstall(map[string]string{})
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("is not ready: Deployment/backend is not rolled out"))
+	g.Expect(hc.UpgradeReleaseExecuted).Should(BeFalse())
+	g.Expect(resourcesManager.waitCounter).Should(Equal(1))
+
+	resourcesManager.readyErr = nil
+	err = m.runHelmInstall(map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(hc.UpgradeReleaseExecuted).Should(BeFalse())
+}
@@ -0,0 +1,210 @@
+package module_manager
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/flant/addon-operator/pkg/kube_config_manager"
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// Sources of the module enabled state.
+const (
+	EnabledSourceDefault      = "default"
+	EnabledSourceCommonValues = "static values (modules/values.yaml)"
+	EnabledSourceModuleValues = "static values (module values.yaml)"
+	EnabledSourceConfigMap    = "ConfigMap"
+	EnabledSourceDynamic      = "dynamic enabled patch"
+	EnabledSourceScript       = "enabled script"
+	EnabledSourceRequirements = "requirements"
+)
+
+// ModuleStatus is a module state for the debug output.
+type ModuleStatus struct {
+	*ModuleDefinition
+
+	Enabled bool `json:"enabled"`
+	// Source of the last decision about enabled state.
+	EnabledSource  string `json:"enabledSource"`
+	DisabledReason string `json:"disabledReason,omitempty"`
+
+	HelmRelease         string `json:"helmRelease,omitempty"`
+	HelmReleaseRevision string `json:"helmReleaseRevision,omitempty"`
+	// Failed helm upgrades in a row and the revision the release was rolled back to.
+	HelmUpgradeFailures    int    `json:"helmUpgradeFailures,omitempty"`
+	HelmRolledBackRevision string `json:"helmRolledBackRevision,omitempty"`
+	// Fields left drifted after the last helm upgrade.
+	HelmKnownDrift []string `json:"helmKnownDrift,omitempty"`
+
+	LastRunTime    string `json:"lastRunTime,omitempty"`
+	LastRunError   string `json:"lastRunError,omitempty"`
+	MonitorRunning bool   `json:"monitorRunning"`
+}
+
+// EnabledTraceStep is a step of the decision about module enabled state.
+type EnabledTraceStep struct {
+	Source string `json:"source"`
+	// Enabled flag defined by the source, nil if the source has no flag.
+	Enabled *bool `json:"enabled"`
+	// Enabled state after this step.
+	Result  bool   `json:"result"`
+	Message string `json:"message,omitempty"`
+}
+
+// ModuleEnabledTrace explains why the module is enabled or disabled.
+type ModuleEnabledTrace struct {
+	Name    string             `json:"name"`
+	Enabled bool               `json:"enabled"`
+	Steps   []EnabledTraceStep `json:"steps"`
+}
+
+// enabledByConfigTrace returns steps for merging enabled flags from static values,
+// ConfigMap and dynamic enabled patches. The last defined flag wins.
+func (mm *moduleManager) enabledByConfigTrace(module *Module, kubeConfig *utils.ModuleConfig) []EnabledTraceStep {
+	steps := []EnabledTraceStep{
+		{Source: EnabledSourceCommonValues, Enabled: module.CommonStaticConfig.IsEnabled},
+		{Source: EnabledSourceModuleValues, Enabled: module.StaticConfig.IsEnabled},
+		{Source: EnabledSourceConfigMap, Message: "no module section"},
+		{Source: EnabledSourceDynamic, Enabled: mm.dynamicEnabled[module.Name]},
+	}
+	if kubeConfig != nil {
+		steps[2].Enabled = kubeConfig.IsEnabled
+		steps[2].Message = ""
+	}
+
+	// Module is disabled by default.
+	result := false
+	for i := range steps {
+		if steps[i].Enabled != nil {
+			result = *steps[i].Enabled
+		}
+		steps[i].Result = result
+	}
+	return steps
+}
+
+// enabledByConfig merges enabled flags from static values, ConfigMap and dynamic enabled patches.
+// It returns a merged flag and a source of the last defined flag.
+func (mm *moduleManager) enabledByConfig(module *Module, kubeConfig *utils.ModuleConfig) (bool, string) {
+	enabled := false
+	source := EnabledSourceDefault
+	for _, step := range mm.enabledByConfigTrace(module, kubeConfig) {
+		if step.Enabled != nil {
+			enabled = *step.Enabled
+			source = step.Source
+		}
+	}
+	return enabled, source
+}
+
+// setEnabledByConfigTrace saves steps from enabledByConfigTrace for the module.
+func (mm *moduleManager) setEnabledByConfigTrace(moduleName string, steps []EnabledTraceStep) {
+	mm.enabledTraceLock.Lock()
+	defer mm.enabledTraceLock.Unlock()
+	mm.enabledByConfigTraces[moduleName] = steps
+}
+
+// setEnabledScriptTraces saves steps made by runModulesEnabledScript.
+func (mm *moduleManager) setEnabledScriptTraces(steps map[string]EnabledTraceStep) {
+	mm.enabledTraceLock.Lock()
+	defer mm.enabledTraceLock.Unlock()
+	mm.enabledScriptTraces = steps
+}
+
+// GetModuleEnabledTrace returns the last decision trace for the module or nil for unknown module.
+func (mm *moduleManager) GetModuleEnabledTrace(moduleName string) *ModuleEnabledTrace {
+	if _, has := mm.allModulesByName[moduleName]; !has {
+		return nil
+	}
+
+	mm.enabledTraceLock.Lock()
+	defer mm.enabledTraceLock.Unlock()
+
+	trace := &ModuleEnabledTrace{
+		Name:  moduleName,
+		Steps: make([]EnabledTraceStep, 0),
+	}
+	trace.Steps = append(trace.Steps, mm.enabledByConfigTraces[moduleName]...)
+	if len(trace.Steps) > 0 {
+		trace.Enabled = trace.Steps[len(trace.Steps)-1].Result
+	}
+	// Enabled script is run only for modules enabled by config.
+	if step, has := mm.enabledScriptTraces[moduleName]; has && trace.Enabled {
+		trace.Steps = append(trace.Steps, step)
+		trace.Enabled = step.Result
+	}
+	return trace
+}
+
+// hasEnabledScript returns true if the module has the 'enabled' script.
+func (m *Module) hasEnabledScript() bool {
+	_, err := os.Stat(filepath.Join(m.Path, "enabled"))
+	return err == nil
+}
+
+// calculateEnabledSources determines a source of the enabled state for all modules.
+// disabledReasons is a result of runModulesEnabledScript.
+func (mm *moduleManager) calculateEnabledSources(moduleConfigs kube_config_manager.ModuleConfigs, disabledReasons map[string]string) map[string]string {
+	sources := make(map[string]string, len(mm.allModulesByName))
+	for name, module := range mm.allModulesByName {
+		var kubeConfig *utils.ModuleConfig
+		if config, has := moduleConfigs[name]; has {
+			kubeConfig = &config
+		}
+		isEnabledByConfig, source := mm.enabledByConfig(module, kubeConfig)
+
+		switch {
+		case !isEnabledByConfig:
+			sources[name] = source
+		case disabledReasons[name] != "":
+			sources[name] = EnabledSourceRequirements
+		case module.hasEnabledScript():
+			sources[name] = EnabledSourceScript
+		default:
+			sources[name] = source
+		}
+	}
+	return sources
+}
+
+// GetModulesStatus returns states of all known modules in order.
+func (mm *moduleManager) GetModulesStatus() []ModuleStatus {
+	enabled := make(map[string]bool)
+	for _, name := range mm.enabledModulesInOrder {
+		enabled[name] = true
+	}
+
+	statuses := make([]ModuleStatus, 0, len(mm.allModulesNamesInOrder))
+	for _, name := range mm.allModulesNamesInOrder {
+		module := mm.allModulesByName[name]
+		state := module.StateSnapshot()
+		status := ModuleStatus{
+			ModuleDefinition: module.Definition,
+			Enabled:          enabled[name],
+			EnabledSource:    mm.modulesEnabledSources[name],
+			DisabledReason:   state.DisabledReason,
+			LastRunError:     state.LastRunError,
+		}
+		if status.ModuleDefinition == nil {
+			status.ModuleDefinition = &ModuleDefinition{Name: name}
+		}
+		if chartExists, _ := module.checkHelmChart(); chartExists {
+			status.HelmRelease = module.generateHelmReleaseName()
+			status.HelmReleaseRevision = state.HelmReleaseRevision
+			status.HelmUpgradeFailures = state.HelmUpgradeFailures
+			status.HelmRolledBackRevision = state.HelmRolledBackRevision
+			for _, field := range state.HelmKnownDrift {
+				status.HelmKnownDrift = append(status.HelmKnownDrift, field.String())
+			}
+		}
+		if !state.LastRunTime.IsZero() {
+			status.LastRunTime = state.LastRunTime.Format(time.RFC3339)
+		}
+		if mm.HelmResourcesManager != nil {
+			status.MonitorRunning = mm.HelmResourcesManager.HasMonitor(name)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -1,6 +1,13 @@
 package module_manager
 
-import "os"
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/flant/addon-operator/pkg/values/validation"
+)
 
 func CreateEmptyWritableFile(filePath string) error {
 	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
@@ -11,3 +18,32 @@ func CreateEmptyWritableFile(filePath string) error {
 	_ = file.Close()
 	return nil
 }
+
+// ReadOpenAPIFiles reads config-values.yaml and values.yaml from the 'openapi' directory.
+// Absent files are returned as empty content.
+func ReadOpenAPIFiles(openApiDir string) (configSchemaBytes, valuesSchemaBytes []byte, err error) {
+	if openApiDir == "" {
+		return nil, nil, nil
+	}
+	if _, err := os.Stat(openApiDir); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+
+	configPath := filepath.Join(openApiDir, validation.ConfigValuesSchemaFile)
+	if _, err := os.Stat(configPath); !os.IsNotExist(err) {
+		configSchemaBytes, err = ioutil.ReadFile(configPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read file '%s': %s", configPath, err)
+		}
+	}
+
+	valuesPath := filepath.Join(openApiDir, validation.ValuesSchemaFile)
+	if _, err := os.Stat(valuesPath); !os.IsNotExist(err) {
+		valuesSchemaBytes, err = ioutil.ReadFile(valuesPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read file '%s': %s", valuesPath, err)
+		}
+	}
+
+	return configSchemaBytes, valuesSchemaBytes, nil
+}
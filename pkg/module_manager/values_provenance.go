@@ -0,0 +1,161 @@
+package module_manager
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// Layers of module values in order of merging.
+const (
+	ValuesLayerDefault             = "default"
+	ValuesLayerGlobalSchema        = "global defaults (openapi)"
+	ValuesLayerGlobalCommonStatic  = "global static values (modules/values.yaml)"
+	ValuesLayerGlobalConfigMap     = "global ConfigMap section"
+	ValuesLayerModuleSchema        = "module defaults (openapi)"
+	ValuesLayerModuleCommonStatic  = "module static values (modules/values.yaml)"
+	ValuesLayerModuleStatic        = "module static values (values.yaml)"
+	ValuesLayerModuleConfigMap     = "module ConfigMap section"
+	ValuesLayerGlobalPatches       = "global hooks patches"
+	ValuesLayerModulePatches       = "module hooks patches"
+	ValuesLayerGlobalEnabledModule = "enabled modules"
+)
+
+type valuesLayer struct {
+	Name   string
+	Values utils.Values
+}
+
+type valuesPatchesLayer struct {
+	Name    string
+	Patches []utils.ValuesPatch
+}
+
+// ValuesOrigin describes where the leaf value of module values came from.
+type ValuesOrigin struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+	Layer string      `json:"layer"`
+	// A hook run for values from patches.
+	Source *utils.ValuesPatchSource `json:"source,omitempty"`
+}
+
+// valuesLayers returns values to merge in constructValues.
+func (m *Module) valuesLayers() []valuesLayer {
+	schemaStorage := m.moduleManager.ValuesValidator.SchemaStorage
+
+	return []valuesLayer{
+		// global
+		{ValuesLayerDefault, utils.Values{"global": map[string]interface{}{}}},
+		{ValuesLayerGlobalSchema, schemaStorage.GlobalValuesDefaults()},
+		{ValuesLayerGlobalCommonStatic, m.moduleManager.commonStaticValues.Global()},
+		{ValuesLayerGlobalConfigMap, m.moduleManager.kubeGlobalConfig()},
+		// module
+		{ValuesLayerDefault, utils.Values{m.ValuesKey(): map[string]interface{}{}}},
+		{ValuesLayerModuleSchema, schemaStorage.ModuleValuesDefaults(m.Name)},
+		{ValuesLayerModuleCommonStatic, m.CommonStaticConfig.Values},
+		{ValuesLayerModuleStatic, m.StaticConfig.Values},
+		{ValuesLayerModuleConfigMap, m.moduleManager.kubeModuleConfigValues(m.Name)},
+	}
+}
+
+// valuesPatchesLayers returns patches to apply after merging valuesLayers.
+func (m *Module) valuesPatchesLayers() []valuesPatchesLayer {
+	return []valuesPatchesLayer{
+		{ValuesLayerGlobalPatches, m.moduleManager.globalValuesPatches()},
+		{ValuesLayerModulePatches, m.moduleManager.moduleValuesPatches(m.Name)},
+	}
+}
+
+// ValuesProvenance returns module values as in Values() with the origin of every leaf path.
+// Values are merged layer by layer and patches are applied operation by operation,
+// a leaf gets the origin of the last layer that defines or changes it.
+func (m *Module) ValuesProvenance() ([]ValuesOrigin, error) {
+	tracker := &valuesProvenanceTracker{
+		values:  make(utils.Values),
+		origins: make(map[string]ValuesOrigin),
+	}
+
+	for _, layer := range m.valuesLayers() {
+		layerLeafs := utils.ValuesLeafs(layer.Values)
+		tracker.update(utils.MergeValues(tracker.values, layer.Values), layer.Name, nil, func(path string) bool {
+			_, has := layerLeafs[path]
+			return has
+		})
+	}
+
+	for _, layer := range m.valuesPatchesLayers() {
+		for _, patch := range layer.Patches {
+			for _, op := range patch.Operations {
+				res, _, err := utils.ApplyValuesPatch(tracker.values, utils.ValuesPatch{Operations: []*utils.ValuesPatchOperation{op}})
+				if err != nil {
+					return nil, fmt.Errorf("construct values, apply patch error: %s", err)
+				}
+				opPath := op.Path
+				tracker.update(res, layer.Name, op.Source, func(path string) bool {
+					return path == opPath || strings.HasPrefix(path, opPath+"/")
+				})
+			}
+		}
+	}
+
+	enabledModules := utils.Values{
+		"global": map[string]interface{}{
+			"enabledModules": m.moduleManager.enabledModulesInOrder,
+		},
+	}
+	enabledLeafs := utils.ValuesLeafs(enabledModules)
+	tracker.update(utils.MergeValues(tracker.values, enabledModules), ValuesLayerGlobalEnabledModule, nil, func(path string) bool {
+		_, has := enabledLeafs[path]
+		return has
+	})
+
+	return tracker.result(), nil
+}
+
+// valuesProvenanceTracker keeps origins of leafs while values are changed step by step.
+type valuesProvenanceTracker struct {
+	values  utils.Values
+	leafs   map[string]interface{}
+	origins map[string]ValuesOrigin
+}
+
+// update replaces values with the result of the step. Leafs that are changed by the step
+// or touched by the step get the layer as their origin.
+func (t *valuesProvenanceTracker) update(newValues utils.Values, layer string, source *utils.ValuesPatchSource, touched func(path string) bool) {
+	newLeafs := utils.ValuesLeafs(newValues)
+
+	for path := range t.origins {
+		if _, has := newLeafs[path]; !has {
+			delete(t.origins, path)
+		}
+	}
+
+	for path, value := range newLeafs {
+		prevValue, hadValue := t.leafs[path]
+		if !hadValue || !reflect.DeepEqual(prevValue, value) || touched(path) {
+			t.origins[path] = ValuesOrigin{Path: path, Layer: layer, Source: source}
+		}
+		origin := t.origins[path]
+		origin.Value = value
+		t.origins[path] = origin
+	}
+
+	t.values = newValues
+	t.leafs = newLeafs
+}
+
+// result returns origins sorted by path.
+func (t *valuesProvenanceTracker) result() []ValuesOrigin {
+	res := make([]ValuesOrigin, 0, len(t.origins))
+	for _, origin := range t.origins {
+		res = append(res, origin)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Path < res[j].Path
+	})
+	return res
+}
@@ -30,6 +30,12 @@ type HookMetadata struct {
 
 	KubernetesBindingId    string // Unique id for kubernetes bindings
 	WaitForSynchronization bool   // kubernetes.Synchronization task should be waited
+
+	ParallelModuleRunTasks []task.Task // ModuleRun tasks for independent modules that should be run concurrently
+	// Tasks returned by successful ModuleRun tasks, they are queued when all modules are ready.
+	ParallelHeadTasks  []task.Task
+	ParallelAfterTasks []task.Task
+	ParallelTailTasks  []task.Task
 }
 
 var _ task_metadata.HookNameAccessor = HookMetadata{}
@@ -60,6 +66,11 @@ func (hm HookMetadata) GetDescription() string {
 		bindingNames = ":" + strings.Join(bindings, ",")
 	}
 
+	if len(hm.ParallelModuleRunTasks) > 0 {
+		// parallel module run
+		return fmt.Sprintf("%s:%s", strings.Join(hm.ParallelModuleNames(), ","), hm.EventDescription)
+	}
+
 	if hm.ModuleName == "" {
 		// global hook
 		return fmt.Sprintf("%s:%s%s:%s", string(hm.BindingType), hm.HookName, bindingNames, hm.EventDescription)
@@ -78,6 +89,15 @@ func (hm HookMetadata) GetDescription() string {
 	}
 }
 
+// ParallelModuleNames returns names of modules in ParallelModuleRun task.
+func (hm HookMetadata) ParallelModuleNames() []string {
+	names := make([]string, 0, len(hm.ParallelModuleRunTasks))
+	for _, t := range hm.ParallelModuleRunTasks {
+		names = append(names, HookMetadataAccessor(t).ModuleName)
+	}
+	return names
+}
+
 func (m HookMetadata) GetHookName() string {
 	return m.HookName
 }
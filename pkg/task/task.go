@@ -8,6 +8,7 @@ import (
 const (
 	ModuleDelete         task.TaskType = "ModuleDelete"
 	ModuleRun            task.TaskType = "ModuleRun"
+	ParallelModuleRun    task.TaskType = "ParallelModuleRun"
 	ModuleHookRun        task.TaskType = "ModuleHookRun"
 	GlobalHookRun        task.TaskType = "GlobalHookRun"
 	ReloadAllModules     task.TaskType = "ReloadAllModules"
@@ -74,6 +74,17 @@ func Test_TaskDescription(t *testing.T) {
 			},
 			"kubernetes:module/hook.sh:Kubernetes",
 		},
+		{
+			"parallel module run",
+			HookMetadata{
+				EventDescription: "DiscoverModulesState",
+				ParallelModuleRunTasks: []sh_task.Task{
+					sh_task.NewTask(ModuleRun).WithMetadata(HookMetadata{ModuleName: "module-a"}),
+					sh_task.NewTask(ModuleRun).WithMetadata(HookMetadata{ModuleName: "module-b"}),
+				},
+			},
+			"module-a,module-b:DiscoverModulesState",
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,257 @@
+package utils
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RedactedValue is shown instead of sensitive values in logs and debug output.
+// Values from Secrets and values at sensitive paths are sensitive.
+const RedactedValue = "<redacted>"
+
+// minRedactSubstringLen is a minimal length of the sensitive value to redact it
+// inside of other strings. Shorter values are redacted only if the whole string matches.
+const minRedactSubstringLen = 4
+
+// SensitivePathWildcard matches any key or array index in the sensitive path.
+const SensitivePathWildcard = "*"
+
+// sensitivePaths are dotted paths of sensitive values by values key.
+var sensitivePaths = struct {
+	sync.RWMutex
+	byKey map[string][][]string
+}{
+	byKey: make(map[string][][]string),
+}
+
+// SetSensitivePaths replaces paths of sensitive values in the section with the values key.
+// Paths are dotted keys relative to the section, e.g. "auth.password" or "users.*.token".
+// An empty path means the whole section is sensitive.
+func SetSensitivePaths(valuesKey string, paths []string) {
+	sensitivePaths.Lock()
+	defer sensitivePaths.Unlock()
+
+	if len(paths) == 0 {
+		delete(sensitivePaths.byKey, valuesKey)
+		return
+	}
+	tokens := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		if path == "" {
+			tokens = append(tokens, []string{})
+			continue
+		}
+		tokens = append(tokens, strings.Split(path, "."))
+	}
+	sensitivePaths.byKey[valuesKey] = tokens
+}
+
+// JoinSensitivePath adds a key to the beginning of the sensitive path.
+func JoinSensitivePath(key string, path string) string {
+	if path == "" {
+		return key
+	}
+	return key + "." + path
+}
+
+// isSensitivePath returns true if the path from the values root is inside of the sensitive path.
+func isSensitivePath(path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+
+	sensitivePaths.RLock()
+	defer sensitivePaths.RUnlock()
+
+	for _, pattern := range sensitivePaths.byKey[path[0]] {
+		if len(path)-1 < len(pattern) {
+			continue
+		}
+		matched := true
+		for i, token := range pattern {
+			if token != SensitivePathWildcard && token != path[i+1] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitiveValues are strings that should not be shown in logs and debug output,
+// e.g. values resolved from Secrets. Values are grouped by owner to replace them on change.
+var sensitiveValues = struct {
+	sync.RWMutex
+	byOwner map[string][]string
+	all     []string
+}{
+	byOwner: make(map[string][]string),
+}
+
+// SetSensitiveValues replaces sensitive values registered by the owner.
+func SetSensitiveValues(owner string, values []string) {
+	sensitiveValues.Lock()
+	defer sensitiveValues.Unlock()
+
+	if len(values) == 0 {
+		delete(sensitiveValues.byOwner, owner)
+	} else {
+		sensitiveValues.byOwner[owner] = values
+	}
+
+	all := make([]string, 0)
+	for _, ownerValues := range sensitiveValues.byOwner {
+		for _, value := range ownerValues {
+			if value != "" {
+				all = append(all, value)
+			}
+		}
+	}
+	// Replace longer values first to not leave parts of them.
+	sort.Slice(all, func(i, j int) bool {
+		return len(all[i]) > len(all[j])
+	})
+	sensitiveValues.all = all
+}
+
+// RedactString replaces sensitive values in the string.
+func RedactString(s string) string {
+	sensitiveValues.RLock()
+	defer sensitiveValues.RUnlock()
+
+	for _, value := range sensitiveValues.all {
+		if s == value {
+			return RedactedValue
+		}
+		if len(value) >= minRedactSubstringLen {
+			s = strings.Replace(s, value, RedactedValue, -1)
+		}
+	}
+	return s
+}
+
+// RedactValue returns a copy of values from the root with sensitive strings
+// and values at sensitive paths redacted.
+func RedactValue(value interface{}) interface{} {
+	return redactValue([]string{}, value)
+}
+
+// RedactValueAtPath is like RedactValue for the value at JSON pointer path.
+func RedactValueAtPath(path string, value interface{}) interface{} {
+	return redactValue(jsonPointerTokens(path), value)
+}
+
+func redactValue(path []string, value interface{}) interface{} {
+	if value != nil && isSensitivePath(path) {
+		return RedactedValue
+	}
+
+	switch v := value.(type) {
+	case string:
+		return RedactString(v)
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			res[key] = redactValue(append(path[:len(path):len(path)], key), item)
+		}
+		return res
+	case Values:
+		return Values(redactValue(path, map[string]interface{}(v)).(map[string]interface{}))
+	case []interface{}:
+		res := make([]interface{}, 0, len(v))
+		for i, item := range v {
+			res = append(res, redactValue(append(path[:len(path):len(path)], strconv.Itoa(i)), item))
+		}
+		return res
+	}
+	return value
+}
+
+// Redacted returns a copy of values with sensitive strings and values at sensitive paths redacted.
+func (v Values) Redacted() Values {
+	if v == nil {
+		return nil
+	}
+	return Values(RedactValue(map[string]interface{}(v)).(map[string]interface{}))
+}
+
+// Redacted returns a copy of the patch with sensitive values redacted in operation values.
+func (p ValuesPatch) Redacted() ValuesPatch {
+	ops := make([]*ValuesPatchOperation, 0, len(p.Operations))
+	for _, op := range p.Operations {
+		redacted := *op
+		redacted.Value = RedactValueAtPath(op.Path, op.Value)
+		ops = append(ops, &redacted)
+	}
+	return ValuesPatch{Operations: ops}
+}
+
+// RedactValuesPatches returns redacted copies of patches.
+func RedactValuesPatches(patches []ValuesPatch) []ValuesPatch {
+	res := make([]ValuesPatch, 0, len(patches))
+	for _, patch := range patches {
+		res = append(res, patch.Redacted())
+	}
+	return res
+}
+
+// SensitiveStrings returns strings at sensitive paths in values. These strings
+// can be registered with SetSensitiveValues to redact them in log messages.
+func SensitiveStrings(values Values) []string {
+	res := make([]string, 0)
+	collectSensitiveStrings([]string{}, map[string]interface{}(values), false, &res)
+	return res
+}
+
+func collectSensitiveStrings(path []string, value interface{}, sensitive bool, res *[]string) {
+	sensitive = sensitive || isSensitivePath(path)
+
+	switch v := value.(type) {
+	case string:
+		if sensitive {
+			*res = append(*res, v)
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			collectSensitiveStrings(append(path[:len(path):len(path)], key), item, sensitive, res)
+		}
+	case []interface{}:
+		for i, item := range v {
+			collectSensitiveStrings(append(path[:len(path):len(path)], strconv.Itoa(i)), item, sensitive, res)
+		}
+	}
+}
+
+// redactLogHook replaces sensitive values in log messages and fields.
+type redactLogHook struct{}
+
+// NewRedactLogHook returns a logrus hook that redacts sensitive values.
+func NewRedactLogHook() log.Hook {
+	return &redactLogHook{}
+}
+
+func (h *redactLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *redactLogHook) Fire(entry *log.Entry) error {
+	entry.Message = RedactString(entry.Message)
+
+	// Data map is shared with the parent entry, so redact a copy.
+	data := make(log.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		if s, ok := value.(string); ok {
+			value = RedactString(s)
+		}
+		data[key] = value
+	}
+	entry.Data = data
+	return nil
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func Test_Values_Redacted(t *testing.T) {
+	g := NewWithT(t)
+
+	SetSensitivePaths("moduleOne", []string{"auth.password", "users.*.token"})
+	SetSensitiveValues("test", []string{"secret-value"})
+	defer SetSensitivePaths("moduleOne", nil)
+	defer SetSensitiveValues("test", nil)
+
+	values := Values{
+		"moduleOne": map[string]interface{}{
+			"auth": map[string]interface{}{
+				"user":     "admin",
+				"password": "qwerty",
+			},
+			"users": []interface{}{
+				map[string]interface{}{"name": "one", "token": "abc"},
+			},
+			"dsn": "postgres://admin:secret-value@db",
+		},
+		"moduleTwo": map[string]interface{}{
+			"auth": map[string]interface{}{"password": "qwerty"},
+		},
+	}
+
+	g.Expect(values.Redacted()).Should(Equal(Values{
+		"moduleOne": map[string]interface{}{
+			"auth": map[string]interface{}{
+				"user":     "admin",
+				"password": RedactedValue,
+			},
+			"users": []interface{}{
+				map[string]interface{}{"name": "one", "token": RedactedValue},
+			},
+			"dsn": "postgres://admin:" + RedactedValue + "@db",
+		},
+		"moduleTwo": map[string]interface{}{
+			"auth": map[string]interface{}{"password": "qwerty"},
+		},
+	}))
+	// Original values are not changed.
+	g.Expect(values["moduleOne"].(map[string]interface{})["auth"].(map[string]interface{})["password"]).Should(Equal("qwerty"))
+
+	// Patch values are redacted relative to the operation path.
+	patch := ValuesPatch{Operations: []*ValuesPatchOperation{
+		{Op: "add", Path: "/moduleOne/auth", Value: map[string]interface{}{"password": "new"}},
+		{Op: "add", Path: "/moduleOne/users/0/token", Value: "def"},
+	}}
+	redacted := patch.Redacted()
+	g.Expect(redacted.Operations[0].Value).Should(Equal(map[string]interface{}{"password": RedactedValue}))
+	g.Expect(redacted.Operations[1].Value).Should(Equal(RedactedValue))
+	g.Expect(patch.Operations[1].Value).Should(Equal("def"))
+
+	g.Expect(SensitiveStrings(values)).Should(ConsistOf("qwerty", "abc"))
+}
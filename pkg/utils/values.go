@@ -8,7 +8,9 @@ import (
 	"io/ioutil"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	log "github.com/sirupsen/logrus"
@@ -65,6 +67,14 @@ func (p *ValuesPatch) Apply(doc []byte) ([]byte, error) {
 	return patch.Apply(doc)
 }
 
+// WithSource sets a source for all operations.
+func (p *ValuesPatch) WithSource(source *ValuesPatchSource) *ValuesPatch {
+	for _, op := range p.Operations {
+		op.Source = source
+	}
+	return p
+}
+
 func (p *ValuesPatch) MergeOperations(src *ValuesPatch) {
 	if src == nil {
 		return
@@ -75,7 +85,28 @@ func (p *ValuesPatch) MergeOperations(src *ValuesPatch) {
 type ValuesPatchOperation struct {
 	Op    string      `json:"op"`
 	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
 	Value interface{} `json:"value,omitempty"`
+
+	// A hook run that returned this operation. It is ignored by Apply.
+	Source *ValuesPatchSource `json:"source,omitempty"`
+}
+
+// ValuesPatchSource describes a hook run that returned the patch operation.
+type ValuesPatchSource struct {
+	HookName string    `json:"hookName"`
+	Binding  string    `json:"binding,omitempty"`
+	TaskId   string    `json:"taskId,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+func NewValuesPatchSource(hookName string, binding string, taskId string) *ValuesPatchSource {
+	return &ValuesPatchSource{
+		HookName: hookName,
+		Binding:  binding,
+		TaskId:   taskId,
+		Time:     time.Now(),
+	}
 }
 
 func (op *ValuesPatchOperation) ToString() string {
@@ -228,13 +259,21 @@ func DecodeJsonPatchOperation(v interface{}) (jsonpatch.Operation, error) {
 
 // ValuesPatchFromBytes reads a JSON stream of json patches
 // and single operations from bytes and returns a ValuesPatch with
-// all json patch operations.
+// all json patch operations. A YAML array of operations is also accepted.
 // TODO do we need a separate ValuesPatchOperation type??
 func ValuesPatchFromBytes(data []byte) (*ValuesPatch, error) {
 	// Get combined patch from bytes
 	patch, err := JsonPatchFromBytes(data)
 	if err != nil {
-		return nil, fmt.Errorf("bad json-patch data: %s\n%s", err, string(data))
+		// JSON patch can be written in YAML format.
+		jsonData, yamlErr := k8syaml.YAMLToJSON(data)
+		if yamlErr != nil {
+			return nil, fmt.Errorf("bad json-patch data: %s\n%s", err, string(data))
+		}
+		patch, err = JsonPatchFromBytes(jsonData)
+		if err != nil {
+			return nil, fmt.Errorf("bad json-patch data: %s\n%s", err, string(data))
+		}
 	}
 
 	// Convert combined patch to bytes
@@ -280,12 +319,31 @@ func CompactValuesPatches(valuesPatches []ValuesPatch, newValuesPatch ValuesPatc
 }
 
 // CompactPatches simplifies a patches tree — one path, one operation.
+//
+// Only 'add' and 'remove' operations can be compacted, so operations are returned as is
+// if there are other operations. Use NormalizeValuesPatch to convert them.
+//
+// Operations with array elements, e.g. '/a/-' or '/a/0/b', do not replace the whole value:
+// 'add' inserts an element and shifts indexes. They are not collapsed and are kept
+// in order after operations for the array path.
 func CompactPatches(operations []*ValuesPatchOperation) ValuesPatch {
+	for _, op := range operations {
+		if op.Op != "add" && op.Op != "remove" {
+			return ValuesPatch{Operations: operations}
+		}
+	}
+
 	patchesTree := make(map[string][]*ValuesPatchOperation)
 
 	for _, op := range operations {
-		// remove previous operations for subpaths if got 'remove' operation for parent path
-		if op.Op == "remove" {
+		if elementsPath, isElement := arrayElementsPath(op.Path); isElement {
+			patchesTree[elementsPath] = append(patchesTree[elementsPath], op)
+			continue
+		}
+
+		// remove previous operations for subpaths if got 'add' or 'remove' operation for parent path:
+		// 'add' replaces the whole subtree and 'remove' deletes it.
+		if op.Op == "add" || op.Op == "remove" {
 			for subPath := range patchesTree {
 				if len(op.Path) < len(subPath) && strings.HasPrefix(subPath, op.Path+"/") {
 					delete(patchesTree, subPath)
@@ -344,6 +402,22 @@ func CompactPatches(operations []*ValuesPatchOperation) ValuesPatch {
 	return newValuesPatch
 }
 
+// arrayElementsPath returns a key for operations with elements of the array if the path
+// has an array index or '-': '/a/-' and '/a/0/b' give '/a/-'. Numeric keys of maps are
+// handled as array indexes, so these operations are not collapsed too.
+func arrayElementsPath(path string) (string, bool) {
+	tokens := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, token := range tokens {
+		if i == 0 {
+			continue
+		}
+		if _, err := strconv.Atoi(token); err == nil || token == "-" {
+			return "/" + strings.Join(tokens[:i], "/") + "/-", true
+		}
+	}
+	return "", false
+}
+
 // ApplyValuesPatch applies a set of json patch operations to the values and returns a result
 func ApplyValuesPatch(values Values, valuesPatch ValuesPatch) (Values, bool, error) {
 	var err error
@@ -368,31 +442,53 @@ func ApplyValuesPatch(values Values, valuesPatch ValuesPatch) (Values, bool, err
 	return resValues, valuesChanged, nil
 }
 
+// ValidateHookValuesPatch checks that operations are valid JSON patch operations
+// for acceptableKey. Global hooks can also add and remove *Enabled keys.
 func ValidateHookValuesPatch(valuesPatch ValuesPatch, acceptableKey string) error {
 	for _, op := range valuesPatch.Operations {
-		if op.Op == "replace" {
+		switch op.Op {
+		case "add", "remove", "replace", "test":
+		case "move", "copy":
+			if op.From == "" {
+				return fmt.Errorf("patch operation '%s' has no 'from' path: '%s'", op.Op, op.ToString())
+			}
+			if err := validatePatchPath(op, op.From, acceptableKey, false); err != nil {
+				return err
+			}
+		default:
 			return fmt.Errorf("unsupported patch operation '%s': '%s'", op.Op, op.ToString())
 		}
 
-		pathParts := strings.Split(op.Path, "/")
-		if len(pathParts) > 1 {
-			affectedKey := pathParts[1]
-			// patches for *Enabled keys are accepted from global hooks
-			if strings.HasSuffix(affectedKey, "Enabled") && acceptableKey == GlobalValuesKey {
-				continue
-			}
-			// patches for acceptableKey are allowed
-			if affectedKey == acceptableKey {
-				continue
-			}
-			// all other patches are denied
-			return fmt.Errorf("unacceptable patch operation for path '%s' (only '%s' accepted): '%s'", affectedKey, acceptableKey, op.ToString())
+		enabledAllowed := op.Op == "add" || op.Op == "remove"
+		if err := validatePatchPath(op, op.Path, acceptableKey, enabledAllowed); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+func validatePatchPath(op *ValuesPatchOperation, path string, acceptableKey string, enabledAllowed bool) error {
+	pathParts := strings.Split(path, "/")
+	if len(pathParts) > 1 {
+		affectedKey := pathParts[1]
+		// patches for *Enabled keys are accepted from global hooks
+		if strings.HasSuffix(affectedKey, "Enabled") && acceptableKey == GlobalValuesKey {
+			if enabledAllowed {
+				return nil
+			}
+			return fmt.Errorf("unsupported patch operation '%s' for path '%s' (only 'add' and 'remove' accepted): '%s'", op.Op, affectedKey, op.ToString())
+		}
+		// patches for acceptableKey are allowed
+		if affectedKey == acceptableKey {
+			return nil
+		}
+		// all other patches are denied
+		return fmt.Errorf("unacceptable patch operation for path '%s' (only '%s' accepted): '%s'", affectedKey, acceptableKey, op.ToString())
+	}
+	return nil
+}
+
 func FilterValuesPatch(valuesPatch ValuesPatch, rootPath string) ValuesPatch {
 	resOps := []*ValuesPatchOperation{}
 
@@ -437,9 +533,10 @@ func MergeValues(values ...Values) Values {
 	return res
 }
 
-// DebugString returns values as yaml or an error line if dump is failed
+// DebugString returns values as yaml or an error line if dump is failed.
+// Sensitive values are redacted.
 func (v Values) DebugString() string {
-	b, err := v.YamlBytes()
+	b, err := v.Redacted().YamlBytes()
 	if err != nil {
 		return "bad values: " + err.Error()
 	}
@@ -588,3 +685,32 @@ func NewLoaderFromJsonFile(path string) ValuesLoader {
 func (*ValuesLoaderFromJsonFile) Read() (Values, error) {
 	return nil, fmt.Errorf("implement Read methoid")
 }
+
+// ValuesLeafs returns leaf values indexed by JSON pointer paths.
+// Scalars, arrays and empty maps are leafs.
+func ValuesLeafs(values map[string]interface{}) map[string]interface{} {
+	leafs := make(map[string]interface{})
+	collectValuesLeafs("", values, leafs)
+	return leafs
+}
+
+func collectValuesLeafs(path string, value interface{}, leafs map[string]interface{}) {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		if values, isValues := value.(Values); isValues {
+			obj, ok = values, true
+		}
+	}
+	if !ok || (len(obj) == 0 && path != "") {
+		leafs[path] = value
+		return
+	}
+	for key, subValue := range obj {
+		collectValuesLeafs(path+"/"+EscapeJsonPointer(key), subValue, leafs)
+	}
+}
+
+// EscapeJsonPointer escapes a key for JSON pointer as defined in RFC 6901.
+func EscapeJsonPointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+// NormalizeValuesPatch applies the patch to values operation by operation and returns the result
+// and an equivalent patch with only 'add' and 'remove' operations that can be compacted
+// by CompactPatches:
+//  - 'test' operations are checked and dropped, an error is returned if the test fails;
+//  - 'replace' and 'copy' become 'add' of the resulting value;
+//  - 'move' becomes 'remove' of the source path and 'add' of the resulting value;
+//  - these operations inside an array become 'add' of the whole resulting array,
+//    because indexes of the array elements are shifted by 'add' and 'remove'.
+func NormalizeValuesPatch(values Values, valuesPatch ValuesPatch) (Values, ValuesPatch, error) {
+	res := values
+	ops := make([]*ValuesPatchOperation, 0, len(valuesPatch.Operations))
+
+	for _, op := range valuesPatch.Operations {
+		newValues, _, err := ApplyValuesPatch(res, ValuesPatch{Operations: []*ValuesPatchOperation{op}})
+		if err != nil {
+			if op.Op == "test" {
+				return nil, ValuesPatch{}, fmt.Errorf("test operation failed for path '%s': %s", op.Path, err)
+			}
+			return nil, ValuesPatch{}, err
+		}
+
+		switch op.Op {
+		case "add", "remove":
+			ops = append(ops, op)
+		case "test":
+			// Precondition is checked, nothing to store.
+		case "replace", "copy":
+			ops = append(ops, addResultOperation(op, newValues, op.Path))
+		case "move":
+			if arrayPath, inArray := arrayContainerPath(res, op.From); inArray {
+				if _, has := valueByPointer(newValues, arrayPath); has {
+					ops = append(ops, addResultOperation(op, newValues, arrayPath))
+				} else {
+					ops = append(ops, &ValuesPatchOperation{Op: "remove", Path: arrayPath, Source: op.Source})
+				}
+			} else {
+				ops = append(ops, &ValuesPatchOperation{Op: "remove", Path: op.From, Source: op.Source})
+			}
+			ops = append(ops, addResultOperation(op, newValues, op.Path))
+		default:
+			return nil, ValuesPatch{}, fmt.Errorf("unsupported patch operation '%s'", op.Op)
+		}
+
+		res = newValues
+	}
+
+	return res, ValuesPatch{Operations: ops}, nil
+}
+
+// addResultOperation returns 'add' operation with the value from values for the path
+// or for the array that contains the path.
+func addResultOperation(op *ValuesPatchOperation, values Values, path string) *ValuesPatchOperation {
+	if arrayPath, inArray := arrayContainerPath(values, path); inArray {
+		path = arrayPath
+	}
+	value, _ := valueByPointer(values, path)
+	return &ValuesPatchOperation{
+		Op:     "add",
+		Path:   path,
+		Value:  value,
+		Source: op.Source,
+	}
+}
+
+// arrayContainerPath returns a path of the outermost array that contains the path.
+func arrayContainerPath(values Values, path string) (string, bool) {
+	tokens := jsonPointerTokens(path)
+
+	var current interface{} = map[string]interface{}(values)
+	for i, token := range tokens {
+		switch v := current.(type) {
+		case []interface{}:
+			return "/" + strings.Join(escapeJsonPointerTokens(tokens[:i]), "/"), true
+		case map[string]interface{}:
+			current = v[token]
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// valueByPointer returns a value at the JSON pointer path.
+func valueByPointer(values Values, path string) (interface{}, bool) {
+	var current interface{} = map[string]interface{}(values)
+	for _, token := range jsonPointerTokens(path) {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			value, has := v[token]
+			if !has {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return nil, false
+			}
+			current = v[idx]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// jsonPointerTokens returns unescaped reference tokens of the JSON pointer.
+func jsonPointerTokens(path string) []string {
+	if path == "" {
+		return []string{}
+	}
+	tokens := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens
+}
+
+func escapeJsonPointerTokens(tokens []string) []string {
+	res := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		res = append(res, EscapeJsonPointer(token))
+	}
+	return res
+}
+
+// ValuesPatchFromMergePatchBytes reads a stream of RFC 7386 merge patches in JSON or YAML format
+// and converts them into 'add' and 'remove' operations for values. Patches are applied one by one.
+func ValuesPatchFromMergePatchBytes(values Values, data []byte) (*ValuesPatch, error) {
+	mergePatches, err := mergePatchesFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	res := NewValuesPatch()
+	for _, mergePatch := range mergePatches {
+		patch := MergePatchToValuesPatch(values, mergePatch)
+		values, _, err = ApplyValuesPatch(values, *patch)
+		if err != nil {
+			return nil, fmt.Errorf("apply merge patch: %s", err)
+		}
+		res.MergeOperations(patch)
+	}
+	return res, nil
+}
+
+// mergePatchesFromBytes decodes a stream of JSON objects or YAML documents.
+func mergePatchesFromBytes(data []byte) ([]map[string]interface{}, error) {
+	res := make([]map[string]interface{}, 0)
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var mergePatch map[string]interface{}
+		err := dec.Decode(&mergePatch)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			break
+		}
+		res = append(res, mergePatch)
+	}
+
+	// Not a JSON stream, try YAML documents.
+	res = make([]map[string]interface{}, 0)
+	for _, doc := range yamlDocumentSeparator.Split(string(data), -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		var mergePatch map[string]interface{}
+		if err := k8syaml.Unmarshal([]byte(doc), &mergePatch); err != nil {
+			return nil, fmt.Errorf("bad merge patch data: %s\n%s", err, doc)
+		}
+		if mergePatch != nil {
+			res = append(res, mergePatch)
+		}
+	}
+	return res, nil
+}
+
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// MergePatchToValuesPatch converts RFC 7386 merge patch into 'add' and 'remove'
+// operations for values: null removes a key, an object is merged recursively and
+// other values replace the target.
+func MergePatchToValuesPatch(values Values, mergePatch map[string]interface{}) *ValuesPatch {
+	return &ValuesPatch{
+		Operations: mergePatchOperations("", map[string]interface{}(values), mergePatch),
+	}
+}
+
+func mergePatchOperations(path string, target interface{}, mergePatch map[string]interface{}) []*ValuesPatchOperation {
+	ops := make([]*ValuesPatchOperation, 0)
+
+	targetObj, isObj := target.(map[string]interface{})
+	if !isObj {
+		if path != "" {
+			ops = append(ops, &ValuesPatchOperation{Op: "add", Path: path, Value: map[string]interface{}{}})
+		}
+		targetObj = map[string]interface{}{}
+	}
+
+	keys := make([]string, 0, len(mergePatch))
+	for key := range mergePatch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := mergePatch[key]
+		keyPath := path + "/" + EscapeJsonPointer(key)
+		targetValue, hasKey := targetObj[key]
+
+		switch v := value.(type) {
+		case nil:
+			if hasKey {
+				ops = append(ops, &ValuesPatchOperation{Op: "remove", Path: keyPath})
+			}
+		case map[string]interface{}:
+			ops = append(ops, mergePatchOperations(keyPath, targetValue, v)...)
+		default:
+			ops = append(ops, &ValuesPatchOperation{Op: "add", Path: keyPath, Value: v})
+		}
+	}
+
+	return ops
+}
@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func Test_NormalizeValuesPatch(t *testing.T) {
+	values := Values{
+		"global": map[string]interface{}{
+			"a":   "foo",
+			"b":   map[string]interface{}{"c": "bar"},
+			"arr": []interface{}{"one", "two"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		patch       string
+		expectedOps string
+		expectedErr bool
+	}{
+		{
+			"test and replace",
+			`[{"op":"test", "path":"/global/a", "value":"foo"},{"op":"replace", "path":"/global/a", "value":"baz"}]`,
+			`[{"op":"add", "path":"/global/a", "value":"baz"}]`,
+			false,
+		},
+		{
+			"failed test",
+			`[{"op":"test", "path":"/global/a", "value":"qwe"},{"op":"replace", "path":"/global/a", "value":"baz"}]`,
+			``,
+			true,
+		},
+		{
+			"move",
+			`[{"op":"move", "from":"/global/b/c", "path":"/global/d"}]`,
+			`[{"op":"remove", "path":"/global/b/c"},{"op":"add", "path":"/global/d", "value":"bar"}]`,
+			false,
+		},
+		{
+			"copy",
+			`[{"op":"copy", "from":"/global/b", "path":"/global/d"}]`,
+			`[{"op":"add", "path":"/global/d", "value":{"c":"bar"}}]`,
+			false,
+		},
+		{
+			"replace in array",
+			`[{"op":"replace", "path":"/global/arr/1", "value":"three"}]`,
+			`[{"op":"add", "path":"/global/arr", "value":["one","three"]}]`,
+			false,
+		},
+		{
+			"move from array",
+			`[{"op":"move", "from":"/global/arr/0", "path":"/global/first"}]`,
+			`[{"op":"add", "path":"/global/arr", "value":["two"]},{"op":"add", "path":"/global/first", "value":"one"}]`,
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			patch := MustValuesPatch(ValuesPatchFromBytes([]byte(tt.patch)))
+			newValues, normalized, err := NormalizeValuesPatch(values, *patch)
+			if tt.expectedErr {
+				g.Expect(err).Should(HaveOccurred())
+				return
+			}
+			g.Expect(err).ShouldNot(HaveOccurred())
+
+			ops, err := json.Marshal(normalized.Operations)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(ops).Should(MatchJSON(tt.expectedOps))
+
+			// Normalized and compacted patch should give the same result.
+			compacted := CompactPatches(normalized.Operations)
+			compactedValues, _, err := ApplyValuesPatch(values, compacted)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(compactedValues).Should(Equal(newValues))
+		})
+	}
+}
+
+func Test_CompactPatches_AddReplacesSubtree(t *testing.T) {
+	g := NewWithT(t)
+
+	patch := MustValuesPatch(ValuesPatchFromBytes([]byte(`[
+{"op":"add", "path":"/a", "value":{}},
+{"op":"add", "path":"/a/b", "value":"foo"},
+{"op":"add", "path":"/a", "value":{"c":"bar"}}
+]`)))
+
+	compacted := CompactPatches(patch.Operations)
+	g.Expect(compacted.Operations).Should(HaveLen(1))
+
+	values, _, err := ApplyValuesPatch(Values{}, compacted)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(values).Should(Equal(Values{"a": map[string]interface{}{"c": "bar"}}))
+}
+
+func Test_CompactPatches_ArrayElements(t *testing.T) {
+	g := NewWithT(t)
+
+	patch := MustValuesPatch(ValuesPatchFromBytes([]byte(`[
+{"op":"add", "path":"/a", "value":{"list":["x"]}},
+{"op":"add", "path":"/a/list/-", "value":"y"},
+{"op":"add", "path":"/a/list/-", "value":"z"},
+{"op":"add", "path":"/a/list/0", "value":"w"},
+{"op":"remove", "path":"/a/list/1"},
+{"op":"add", "path":"/a/list/-", "value":"v"}
+]`)))
+	expected, _, err := ApplyValuesPatch(Values{}, *patch)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(expected).Should(Equal(Values{"a": map[string]interface{}{"list": []interface{}{"w", "y", "z", "v"}}}))
+
+	// Repeated appends are kept in order.
+	compacted := CompactPatches(patch.Operations)
+	g.Expect(compacted.Operations).Should(HaveLen(6))
+	values, _, err := ApplyValuesPatch(Values{}, compacted)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(values).Should(Equal(expected))
+
+	// 'add' of the array replaces previous operations with elements.
+	patch.Operations = append(patch.Operations, &ValuesPatchOperation{Op: "add", Path: "/a/list", Value: []interface{}{"u"}})
+	compacted = CompactPatches(patch.Operations)
+	g.Expect(compacted.Operations).Should(HaveLen(2))
+	values, _, err = ApplyValuesPatch(Values{}, compacted)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(values).Should(Equal(Values{"a": map[string]interface{}{"list": []interface{}{"u"}}}))
+}
+
+func Test_ValidateHookValuesPatch_Operations(t *testing.T) {
+	g := NewWithT(t)
+
+	patch := MustValuesPatch(ValuesPatchFromBytes([]byte(`[
+{"op":"test", "path":"/global/a", "value":"foo"},
+{"op":"replace", "path":"/global/a", "value":"bar"},
+{"op":"move", "from":"/global/a", "path":"/global/b"},
+{"op":"copy", "from":"/global/b", "path":"/global/c"},
+{"op":"add", "path":"/moduleOneEnabled", "value":true}
+]`)))
+	g.Expect(ValidateHookValuesPatch(*patch, GlobalValuesKey)).Should(Succeed())
+
+	// 'from' should be in the acceptable key.
+	patch = MustValuesPatch(ValuesPatchFromBytes([]byte(`[{"op":"copy", "from":"/moduleOne/a", "path":"/global/a"}]`)))
+	g.Expect(ValidateHookValuesPatch(*patch, GlobalValuesKey)).ShouldNot(Succeed())
+
+	// *Enabled keys can be only added and removed.
+	patch = MustValuesPatch(ValuesPatchFromBytes([]byte(`[{"op":"replace", "path":"/moduleOneEnabled", "value":false}]`)))
+	g.Expect(ValidateHookValuesPatch(*patch, GlobalValuesKey)).ShouldNot(Succeed())
+}
+
+func Test_ValuesPatchFromMergePatchBytes(t *testing.T) {
+	values := Values{
+		"global": map[string]interface{}{
+			"a": "foo",
+			"b": map[string]interface{}{"c": "bar", "d": "baz"},
+			"e": "qwe",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		data     string
+		expected Values
+	}{
+		{
+			"json",
+			`{"global":{"a":"new","b":{"c":null},"e":{"f":1}}}`,
+			Values{
+				"global": map[string]interface{}{
+					"a": "new",
+					"b": map[string]interface{}{"d": "baz"},
+					"e": map[string]interface{}{"f": 1.0},
+				},
+			},
+		},
+		{
+			"json stream",
+			`{"global":{"a":"new"}}
+{"global":{"a":null,"x":"y"}}`,
+			Values{
+				"global": map[string]interface{}{
+					"b": map[string]interface{}{"c": "bar", "d": "baz"},
+					"e": "qwe",
+					"x": "y",
+				},
+			},
+		},
+		{
+			"yaml documents",
+			`
+global:
+  b: null
+---
+global:
+  arr:
+  - one
+  - two
+`,
+			Values{
+				"global": map[string]interface{}{
+					"a":   "foo",
+					"e":   "qwe",
+					"arr": []interface{}{"one", "two"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			patch, err := ValuesPatchFromMergePatchBytes(values, []byte(tt.data))
+			g.Expect(err).ShouldNot(HaveOccurred())
+			for _, op := range patch.Operations {
+				g.Expect(op.Op).Should(Or(Equal("add"), Equal("remove")))
+			}
+
+			newValues, _, err := ApplyValuesPatch(values, CompactPatches(patch.Operations))
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(newValues).Should(Equal(tt.expected))
+		})
+	}
+}
+
+func Test_ValuesPatchFromBytes_Yaml(t *testing.T) {
+	g := NewWithT(t)
+
+	patch, err := ValuesPatchFromBytes([]byte(`
+- op: add
+  path: /global/a
+  value: foo
+- op: remove
+  path: /global/b
+`))
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(patch.Operations).Should(HaveLen(2))
+	g.Expect(patch.Operations[0].Value).Should(Equal("foo"))
+	g.Expect(patch.Operations[1].Op).Should(Equal("remove"))
+}
@@ -132,9 +132,9 @@ func TestApplyPatch(t *testing.T) {
 			ValuesPatch{
 				[]*ValuesPatchOperation{
 					{
-						"add",
-						"/test_key_3",
-						"baz",
+						Op:    "add",
+						Path:  "/test_key_3",
+						Value: "baz",
 					},
 				},
 			},
@@ -154,9 +154,9 @@ func TestApplyPatch(t *testing.T) {
 			ValuesPatch{
 				[]*ValuesPatchOperation{
 					{
-						"remove",
-						"/test_key_3",
-						"baz",
+						Op:    "remove",
+						Path:  "/test_key_3",
+						Value: "baz",
 					},
 				},
 			},
@@ -697,3 +697,24 @@ paramArr:
 	g.Expect(values).To(Equal(expected))
 
 }
+
+func Test_CompactPatches_KeepSource(t *testing.T) {
+	g := NewWithT(t)
+
+	first := MustValuesPatch(ValuesPatchFromBytes([]byte(`[{"op":"add", "path":"/a", "value":"foo"},{"op":"add", "path":"/b", "value":"bar"}]`)))
+	first.WithSource(NewValuesPatchSource("first.sh", "schedule", "task-1"))
+	second := MustValuesPatch(ValuesPatchFromBytes([]byte(`[{"op":"add", "path":"/b", "value":"baz"}]`)))
+	second.WithSource(NewValuesPatchSource("second.sh", "kubernetes", "task-2"))
+
+	patches := AppendValuesPatch([]ValuesPatch{*first}, *second)
+	g.Expect(patches).Should(HaveLen(1))
+	g.Expect(patches[0].Operations).Should(HaveLen(2))
+	g.Expect(patches[0].Operations[0].Source.HookName).Should(Equal("first.sh"))
+	g.Expect(patches[0].Operations[1].Source.HookName).Should(Equal("second.sh"))
+	g.Expect(patches[0].Operations[1].Source.TaskId).Should(Equal("task-2"))
+
+	// Source is not a part of JSON patch.
+	values, _, err := ApplyValuesPatch(Values{}, patches[0])
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(values).Should(Equal(Values{"a": "foo", "b": "baz"}))
+}
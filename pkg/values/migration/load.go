@@ -0,0 +1,112 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	. "github.com/flant/libjq-go"
+	sh_app "github.com/flant/shell-operator/pkg/app"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// MigrationsDir is a directory with migration files in the module directory and in the global hooks directory.
+const MigrationsDir = "migrations"
+
+// migrationFileRe matches migration files: a target version, an optional description
+// and an extension: '2.json', '3-rename-replicas.jq', '4-move-auth.yaml'.
+var migrationFileRe = regexp.MustCompile(`^([0-9]+)(-[^.]*)?\.(json|yaml|yml|jq)$`)
+
+// LoadDir returns migrations from files in the directory:
+//  - '*.json', '*.yaml' and '*.yml' files are JSON patches with paths relative to the section;
+//  - '*.jq' files are jq filters with section values as an input and new section values as an output.
+// Absent directory means no migrations.
+func LoadDir(dir string) ([]Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list migrations directory '%s': %s", dir, err)
+	}
+
+	res := make([]Migration, 0)
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		filePath := filepath.Join(dir, file.Name())
+
+		matches := migrationFileRe.FindStringSubmatch(file.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("bad migration file name '%s': should be a version with an optional description, e.g. '2-rename-param.json'", filePath)
+		}
+		version, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad migration file name '%s': %s", filePath, err)
+		}
+
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("read migration file '%s': %s", filePath, err)
+		}
+
+		var migrate MigrateFunc
+		if matches[3] == "jq" {
+			migrate = JqMigration(string(content))
+		} else {
+			patch, err := utils.ValuesPatchFromBytes(content)
+			if err != nil {
+				return nil, fmt.Errorf("migration file '%s': %s", filePath, err)
+			}
+			migrate = JsonPatchMigration(*patch)
+		}
+
+		res = append(res, Migration{
+			Version: version,
+			Source:  filePath,
+			Migrate: migrate,
+		})
+	}
+	return res, nil
+}
+
+// JsonPatchMigration returns a migration that applies JSON patch operations to section values.
+func JsonPatchMigration(patch utils.ValuesPatch) MigrateFunc {
+	return func(values map[string]interface{}) (map[string]interface{}, error) {
+		res, _, err := utils.ApplyValuesPatch(utils.Values(values), patch)
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+}
+
+// JqMigration returns a migration that runs a jq filter over section values.
+// The filter should return an object or null for empty values.
+func JqMigration(filter string) MigrateFunc {
+	return func(values map[string]interface{}) (map[string]interface{}, error) {
+		input, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+
+		output, err := Jq().WithLibPath(sh_app.JqLibraryPath).Program(filter).Cached().Run(string(input))
+		if err != nil {
+			return nil, fmt.Errorf("jq filter: %s", err)
+		}
+
+		var res map[string]interface{}
+		err = json.Unmarshal([]byte(output), &res)
+		if err != nil {
+			return nil, fmt.Errorf("jq filter should return an object: %s\n%s", err, output)
+		}
+		return res, nil
+	}
+}
@@ -0,0 +1,133 @@
+package migration
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MigrateFunc converts config values of the section from the previous version.
+type MigrateFunc func(values map[string]interface{}) (map[string]interface{}, error)
+
+// Migration converts config values of the global section or the module section
+// from the previous version to the Version.
+type Migration struct {
+	Version int
+	// Source is a file path or a description of the Go migration for logs.
+	Source  string
+	Migrate MigrateFunc
+}
+
+// Migrations stores migrations for the global section and module sections.
+// Sections are named as ModuleConfig objects: 'global' or the module name.
+type Migrations struct {
+	lock      sync.RWMutex
+	bySection map[string][]Migration
+}
+
+func NewMigrations() *Migrations {
+	return &Migrations{
+		bySection: make(map[string][]Migration),
+	}
+}
+
+// Add adds migrations for the section. Migrations are ordered by version,
+// each version should be positive and unique.
+func (m *Migrations) Add(section string, migrations ...Migration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	res := append([]Migration{}, m.bySection[section]...)
+	for _, migration := range migrations {
+		if migration.Version <= 0 {
+			return fmt.Errorf("'%s' migration %s: version should be positive, got %d", section, migration.Source, migration.Version)
+		}
+		if migration.Migrate == nil {
+			return fmt.Errorf("'%s' migration %s: no migrate function", section, migration.Source)
+		}
+		for _, existing := range res {
+			if existing.Version == migration.Version {
+				return fmt.Errorf("'%s' migration to version %d is defined twice: %s and %s", section, migration.Version, existing.Source, migration.Source)
+			}
+		}
+		res = append(res, migration)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+	m.bySection[section] = res
+	return nil
+}
+
+// LatestVersion returns a version of the last migration for the section or 0 if there are no migrations.
+func (m *Migrations) LatestVersion(section string) int {
+	if m == nil {
+		return 0
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	migrations := m.bySection[section]
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrate applies migrations with versions greater than the version to the section values.
+// It returns migrated values and a list of applied migrations.
+func (m *Migrations) Migrate(section string, version int, values map[string]interface{}) (map[string]interface{}, []Migration, error) {
+	applied := make([]Migration, 0)
+	if m == nil {
+		return values, applied, nil
+	}
+
+	m.lock.RLock()
+	migrations := m.bySection[section]
+	m.lock.RUnlock()
+
+	res := values
+	for _, migration := range migrations {
+		if migration.Version <= version {
+			continue
+		}
+		migrated, err := migration.Migrate(copyValues(res))
+		if err != nil {
+			return nil, nil, fmt.Errorf("migrate '%s' config values to version %d with %s: %s", section, migration.Version, migration.Source, err)
+		}
+		if migrated == nil {
+			migrated = make(map[string]interface{})
+		}
+		res = migrated
+		applied = append(applied, migration)
+	}
+	return res, applied, nil
+}
+
+// copyValues returns a deep copy of values, so migrations can change their input.
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return make(map[string]interface{})
+	}
+	return copyValue(values).(map[string]interface{})
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			res[key] = copyValue(item)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			res = append(res, copyValue(item))
+		}
+		return res
+	}
+	return value
+}
@@ -0,0 +1,64 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func Test_Migrations(t *testing.T) {
+	g := NewWithT(t)
+
+	migrations, err := LoadDir("testdata/migrations")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(migrations).Should(HaveLen(2))
+
+	st := NewMigrations()
+	err = st.Add("module-one", migrations...)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	err = st.Add("module-one", Migration{
+		Version: 1,
+		Source:  "Go migration",
+		Migrate: func(values map[string]interface{}) (map[string]interface{}, error) {
+			values["replicas"] = values["replicaCount"]
+			delete(values, "replicaCount")
+			return values, nil
+		},
+	})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(st.LatestVersion("module-one")).Should(Equal(3))
+	g.Expect(st.LatestVersion("global")).Should(Equal(0))
+
+	values := map[string]interface{}{"replicaCount": 2.0, "param": "value"}
+
+	res, applied, err := st.Migrate("module-one", 0, values)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(applied).Should(HaveLen(3))
+	g.Expect(res).Should(Equal(map[string]interface{}{
+		"deployment": map[string]interface{}{"replicas": 2.0, "enabled": true},
+		"param":      "value",
+	}))
+	// Input values are not changed.
+	g.Expect(values).Should(HaveKey("replicaCount"))
+
+	// Only migrations with greater versions are applied.
+	res, applied, err = st.Migrate("module-one", 2, map[string]interface{}{
+		"deployment": map[string]interface{}{"replicas": 0.0},
+	})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(applied).Should(HaveLen(1))
+	g.Expect(res).Should(Equal(map[string]interface{}{
+		"deployment": map[string]interface{}{"replicas": 0.0, "enabled": false},
+	}))
+
+	// Failed migration is an error with the migration source.
+	_, _, err = st.Migrate("module-one", 1, map[string]interface{}{})
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("2-move-replicas.yaml"))
+
+	// Version should be unique.
+	err = st.Add("module-one", migrations[0])
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(strings.Contains(err.Error(), "defined twice")).Should(BeTrue())
+}
@@ -0,0 +1,68 @@
+package validation
+
+import (
+	"github.com/go-openapi/spec"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// GlobalValuesDefaults returns 'global' section with defaults from global schemas.
+func (st *SchemaStorage) GlobalValuesDefaults() utils.Values {
+	return valuesDefaults(utils.GlobalValuesKey, st.GlobalSchemas)
+}
+
+// ModuleValuesDefaults returns module section with defaults from module schemas.
+func (st *SchemaStorage) ModuleValuesDefaults(moduleName string) utils.Values {
+	return valuesDefaults(utils.ModuleNameToValuesKey(moduleName), st.ModuleSchemas[moduleName])
+}
+
+// valuesDefaults collects defaults from config values schema and values schema.
+// Defaults from values schema take precedence. A new object is returned
+// on every call, so the result can be safely merged.
+func valuesDefaults(key string, schemas map[SchemaType]*spec.Schema) utils.Values {
+	res := make(map[string]interface{})
+	for _, schemaType := range []SchemaType{ConfigValuesSchema, ValuesSchema} {
+		defaults, ok := SchemaDefaults(schemas[schemaType]).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		res = utils.MergeValues(res, defaults)
+	}
+
+	if len(res) == 0 {
+		return utils.Values{}
+	}
+
+	values, err := utils.NewValues(map[string]interface{}{key: res})
+	if err != nil {
+		log.Errorf("Bad defaults in schema for '%s': %v", key, err)
+		return utils.Values{}
+	}
+	return values
+}
+
+// SchemaDefaults returns a default value for the schema. If schema
+// has no default, then defaults for object properties are collected.
+// Objects without defaults in properties are omitted, so nil is
+// returned for schema without defaults.
+func SchemaDefaults(s *spec.Schema) interface{} {
+	if s == nil {
+		return nil
+	}
+	if s.Default != nil {
+		return s.Default
+	}
+
+	res := make(map[string]interface{})
+	for name, prop := range s.Properties {
+		prop := prop
+		if propDefault := SchemaDefaults(&prop); propDefault != nil {
+			res[name] = propDefault
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
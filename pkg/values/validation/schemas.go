@@ -0,0 +1,184 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-openapi/spec"
+	"sigs.k8s.io/yaml"
+)
+
+type SchemaType string
+
+const (
+	// ConfigValuesSchema is a schema for values in ConfigMap and values.yaml files.
+	ConfigValuesSchema SchemaType = "config"
+	// ValuesSchema is a schema for effective values: config values with patches from hooks.
+	ValuesSchema SchemaType = "values"
+)
+
+// Files with schemas in 'openapi' directory of a module or global hooks directory.
+const (
+	ConfigValuesSchemaFile = "config-values.yaml"
+	ValuesSchemaFile       = "values.yaml"
+)
+
+// XExtendKey is an extension to reuse properties from config values schema in values schema:
+//
+// x-extend:
+//   schema: config-values.yaml
+const XExtendKey = "x-extend"
+
+// LoadSchema loads schema from YAML or JSON content. Local refs are expanded
+// and "additionalProperties: false" is set for objects with properties.
+func LoadSchema(openApiContent []byte) (*spec.Schema, error) {
+	jsonContent, err := yaml.YAMLToJSON(openApiContent)
+	if err != nil {
+		return nil, fmt.Errorf("yaml to json: %v", err)
+	}
+
+	s := new(spec.Schema)
+	if err := json.Unmarshal(jsonContent, s); err != nil {
+		return nil, fmt.Errorf("json unmarshal: %v", err)
+	}
+
+	err = spec.ExpandSchema(s, s, nil)
+	if err != nil {
+		return nil, fmt.Errorf("expand schema: %v", err)
+	}
+
+	return TransformSchema(s), nil
+}
+
+// TransformSchema sets "additionalProperties: false" for all objects with defined properties.
+// Typos in values are detected this way. Use "additionalProperties: true"
+// to explicitly allow arbitrary keys.
+func TransformSchema(s *spec.Schema) *spec.Schema {
+	if s == nil {
+		return nil
+	}
+
+	if len(s.Properties) > 0 && s.AdditionalProperties == nil && len(s.PatternProperties) == 0 {
+		s.AdditionalProperties = &spec.SchemaOrBool{Allows: false}
+	}
+
+	for k, prop := range s.Properties {
+		s.Properties[k] = *TransformSchema(&prop)
+	}
+	for k, prop := range s.PatternProperties {
+		s.PatternProperties[k] = *TransformSchema(&prop)
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		TransformSchema(s.AdditionalProperties.Schema)
+	}
+	if s.Items != nil {
+		TransformSchema(s.Items.Schema)
+		for i := range s.Items.Schemas {
+			TransformSchema(&s.Items.Schemas[i])
+		}
+	}
+	for _, schemas := range [][]spec.Schema{s.AllOf, s.AnyOf, s.OneOf} {
+		for i := range schemas {
+			TransformSchema(&schemas[i])
+		}
+	}
+
+	return s
+}
+
+// ExtendSchema copies properties and required fields from the parent schema
+// if the schema has an x-extend extension.
+func ExtendSchema(s *spec.Schema, parent *spec.Schema) *spec.Schema {
+	if s == nil || parent == nil {
+		return s
+	}
+	if _, has := s.Extensions[XExtendKey]; !has {
+		return s
+	}
+
+	if s.Properties == nil {
+		s.Properties = make(map[string]spec.Schema)
+	}
+	for k, prop := range parent.Properties {
+		if _, has := s.Properties[k]; !has {
+			s.Properties[k] = prop
+		}
+	}
+
+	required := make(map[string]bool)
+	for _, k := range s.Required {
+		required[k] = true
+	}
+	for _, k := range parent.Required {
+		if !required[k] {
+			s.Required = append(s.Required, k)
+		}
+	}
+
+	return s
+}
+
+// SchemaStorage is a storage for global and module schemas.
+type SchemaStorage struct {
+	GlobalSchemas map[SchemaType]*spec.Schema
+	ModuleSchemas map[string]map[SchemaType]*spec.Schema
+}
+
+func NewSchemaStorage() *SchemaStorage {
+	return &SchemaStorage{
+		GlobalSchemas: make(map[SchemaType]*spec.Schema),
+		ModuleSchemas: make(map[string]map[SchemaType]*spec.Schema),
+	}
+}
+
+// PrepareSchemas loads config values schema and values schema. Empty content means no schema.
+func PrepareSchemas(configBytes, valuesBytes []byte) (map[SchemaType]*spec.Schema, error) {
+	res := make(map[SchemaType]*spec.Schema)
+
+	if len(configBytes) > 0 {
+		schema, err := LoadSchema(configBytes)
+		if err != nil {
+			return nil, fmt.Errorf("load '%s' schema: %s", ConfigValuesSchemaFile, err)
+		}
+		res[ConfigValuesSchema] = schema
+	}
+
+	if len(valuesBytes) > 0 {
+		schema, err := LoadSchema(valuesBytes)
+		if err != nil {
+			return nil, fmt.Errorf("load '%s' schema: %s", ValuesSchemaFile, err)
+		}
+		res[ValuesSchema] = ExtendSchema(schema, res[ConfigValuesSchema])
+	}
+
+	return res, nil
+}
+
+func (st *SchemaStorage) AddGlobalValuesSchemas(configBytes, valuesBytes []byte) error {
+	schemas, err := PrepareSchemas(configBytes, valuesBytes)
+	if err != nil {
+		return err
+	}
+	st.GlobalSchemas = schemas
+	return nil
+}
+
+func (st *SchemaStorage) AddModuleValuesSchemas(moduleName string, configBytes, valuesBytes []byte) error {
+	schemas, err := PrepareSchemas(configBytes, valuesBytes)
+	if err != nil {
+		return err
+	}
+	st.ModuleSchemas[moduleName] = schemas
+	return nil
+}
+
+func (st *SchemaStorage) GlobalValuesSchema(schemaType SchemaType) *spec.Schema {
+	return st.GlobalSchemas[schemaType]
+}
+
+func (st *SchemaStorage) ModuleValuesSchema(moduleName string, schemaType SchemaType) *spec.Schema {
+	if _, has := st.ModuleSchemas[moduleName]; !has {
+		return nil
+	}
+	return st.ModuleSchemas[moduleName][schemaType]
+}
@@ -0,0 +1,83 @@
+package validation
+
+import (
+	"sort"
+
+	"github.com/go-openapi/spec"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// XSensitiveKey is an extension to mark values that should be redacted in logs and debug output:
+//
+// properties:
+//   password:
+//     type: string
+//     x-sensitive: true
+const XSensitiveKey = "x-sensitive"
+
+// GlobalSensitivePaths returns paths of sensitive values from global schemas.
+func (st *SchemaStorage) GlobalSensitivePaths() []string {
+	return sensitivePaths(st.GlobalSchemas)
+}
+
+// ModuleSensitivePaths returns paths of sensitive values from module schemas.
+func (st *SchemaStorage) ModuleSensitivePaths(moduleName string) []string {
+	return sensitivePaths(st.ModuleSchemas[moduleName])
+}
+
+// sensitivePaths returns unique sensitive paths from config values schema and values schema.
+func sensitivePaths(schemas map[SchemaType]*spec.Schema) []string {
+	unique := make(map[string]bool)
+	for _, schemaType := range []SchemaType{ConfigValuesSchema, ValuesSchema} {
+		for _, path := range SchemaSensitivePaths(schemas[schemaType]) {
+			unique[path] = true
+		}
+	}
+
+	res := make([]string, 0, len(unique))
+	for path := range unique {
+		res = append(res, path)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// SchemaSensitivePaths returns paths of properties with "x-sensitive: true"
+// in the utils.SensitivePath format. Array items and additional properties
+// are matched with "*". An empty path means the whole value is sensitive.
+func SchemaSensitivePaths(s *spec.Schema) []string {
+	if s == nil {
+		return nil
+	}
+	if sensitive, _ := s.Extensions.GetBool(XSensitiveKey); sensitive {
+		return []string{""}
+	}
+
+	res := make([]string, 0)
+	add := func(key string, child *spec.Schema) {
+		for _, path := range SchemaSensitivePaths(child) {
+			res = append(res, utils.JoinSensitivePath(key, path))
+		}
+	}
+
+	for name, prop := range s.Properties {
+		prop := prop
+		add(name, &prop)
+	}
+	for _, prop := range s.PatternProperties {
+		prop := prop
+		add(utils.SensitivePathWildcard, &prop)
+	}
+	if s.AdditionalProperties != nil {
+		add(utils.SensitivePathWildcard, s.AdditionalProperties.Schema)
+	}
+	if s.Items != nil {
+		add(utils.SensitivePathWildcard, s.Items.Schema)
+		for i := range s.Items.Schemas {
+			add(utils.SensitivePathWildcard, &s.Items.Schemas[i])
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,86 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/go-openapi/spec"
+
+	"github.com/flant/shell-operator/pkg/hook/config"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+// ValuesValidator validates global and module sections of values
+// using OpenAPI schemas from 'openapi' directories.
+type ValuesValidator struct {
+	SchemaStorage *SchemaStorage
+}
+
+func NewValuesValidator() *ValuesValidator {
+	return &ValuesValidator{
+		SchemaStorage: NewSchemaStorage(),
+	}
+}
+
+// ValidateGlobalConfigValues validates 'global' section of config values.
+func (v *ValuesValidator) ValidateGlobalConfigValues(values utils.Values) error {
+	return v.validateGlobal(ConfigValuesSchema, values)
+}
+
+// ValidateGlobalValues validates 'global' section of effective values.
+func (v *ValuesValidator) ValidateGlobalValues(values utils.Values) error {
+	return v.validateGlobal(ValuesSchema, values)
+}
+
+// ValidateModuleConfigValues validates module section of config values.
+func (v *ValuesValidator) ValidateModuleConfigValues(moduleName string, values utils.Values) error {
+	return v.validateModule(moduleName, ConfigValuesSchema, values)
+}
+
+// ValidateModuleValues validates module section of effective values.
+func (v *ValuesValidator) ValidateModuleValues(moduleName string, values utils.Values) error {
+	return v.validateModule(moduleName, ValuesSchema, values)
+}
+
+func (v *ValuesValidator) validateGlobal(schemaType SchemaType, values utils.Values) error {
+	s := v.SchemaStorage.GlobalValuesSchema(schemaType)
+	if s == nil {
+		return nil
+	}
+	err := ValidateSection(s, utils.GlobalValuesKey, values)
+	if err != nil {
+		return fmt.Errorf("'%s' %s are not valid: %s", utils.GlobalValuesKey, schemaTypeDescription(schemaType), err)
+	}
+	return nil
+}
+
+func (v *ValuesValidator) validateModule(moduleName string, schemaType SchemaType, values utils.Values) error {
+	s := v.SchemaStorage.ModuleValuesSchema(moduleName, schemaType)
+	if s == nil {
+		return nil
+	}
+	err := ValidateSection(s, utils.ModuleNameToValuesKey(moduleName), values)
+	if err != nil {
+		return fmt.Errorf("module '%s' %s are not valid: %s", moduleName, schemaTypeDescription(schemaType), err)
+	}
+	return nil
+}
+
+// ValidateSection validates a section of values with the key. Absent section is treated as an empty object.
+func ValidateSection(s *spec.Schema, key string, values utils.Values) error {
+	var obj interface{} = map[string]interface{}{}
+	if section, has := values[key]; has && section != nil {
+		obj = section
+	}
+	return config.ValidateConfig(obj, s, key)
+}
+
+func schemaTypeDescription(schemaType SchemaType) string {
+	switch schemaType {
+	case ConfigValuesSchema:
+		return "config values"
+	case ValuesSchema:
+		return "values"
+	}
+	return string(schemaType)
+}
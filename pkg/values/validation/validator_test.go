@@ -0,0 +1,202 @@
+package validation
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/addon-operator/pkg/utils"
+)
+
+func Test_Validate_ModuleConfigValues(t *testing.T) {
+	g := NewWithT(t)
+
+	configSchema := `
+type: object
+required:
+- replicas
+properties:
+  replicas:
+    type: integer
+    minimum: 1
+  logLevel:
+    type: string
+    enum: ["Debug", "Info", "Error"]
+  nodeSelector:
+    type: object
+    additionalProperties:
+      type: string
+`
+	v := NewValuesValidator()
+	err := v.SchemaStorage.AddModuleValuesSchemas("module-one", []byte(configSchema), nil)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	tests := []struct {
+		name    string
+		values  string
+		isValid bool
+	}{
+		{
+			"valid",
+			`
+moduleOne:
+  replicas: 2
+  logLevel: Info
+  nodeSelector:
+    node-role: system
+`,
+			true,
+		},
+		{
+			"typo in key",
+			`
+moduleOne:
+  replicas: 2
+  logLevl: Info
+`,
+			false,
+		},
+		{
+			"bad enum value",
+			`
+moduleOne:
+  replicas: 2
+  logLevel: Warning
+`,
+			false,
+		},
+		{
+			"float instead of integer",
+			`
+moduleOne:
+  replicas: 1.5
+`,
+			false,
+		},
+		{
+			"absent section with required field",
+			`
+anotherModule:
+  replicas: 1
+`,
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := utils.NewValuesFromBytes([]byte(tt.values))
+			g.Expect(err).ShouldNot(HaveOccurred())
+
+			err = v.ValidateModuleConfigValues("module-one", values)
+			if tt.isValid {
+				g.Expect(err).ShouldNot(HaveOccurred())
+			} else {
+				g.Expect(err).Should(HaveOccurred())
+				g.Expect(err.Error()).Should(ContainSubstring("module 'module-one' config values are not valid"))
+				t.Logf("expected error: %v", err)
+			}
+		})
+	}
+
+	// No schema for values, so any values are valid.
+	values, _ := utils.NewValuesFromBytes([]byte("moduleOne:\n  internal: {a: b}\n"))
+	g.Expect(v.ValidateModuleValues("module-one", values)).ShouldNot(HaveOccurred())
+	// No schemas for unknown module.
+	g.Expect(v.ValidateModuleConfigValues("module-two", values)).ShouldNot(HaveOccurred())
+}
+
+func Test_Validate_ExtendedValuesSchema(t *testing.T) {
+	g := NewWithT(t)
+
+	configSchema := `
+type: object
+properties:
+  param1:
+    type: string
+`
+	valuesSchema := `
+x-extend:
+  schema: config-values.yaml
+type: object
+properties:
+  internal:
+    type: object
+    properties:
+      cert:
+        type: string
+`
+	v := NewValuesValidator()
+	err := v.SchemaStorage.AddGlobalValuesSchemas([]byte(configSchema), []byte(valuesSchema))
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	values, _ := utils.NewValuesFromBytes([]byte(`
+global:
+  param1: value1
+  internal:
+    cert: "---CERT---"
+`))
+	g.Expect(v.ValidateGlobalValues(values)).ShouldNot(HaveOccurred())
+	// 'internal' is not defined in config values schema.
+	g.Expect(v.ValidateGlobalConfigValues(values)).Should(HaveOccurred())
+
+	values, _ = utils.NewValuesFromBytes([]byte(`
+global:
+  param1: value1
+  internal:
+    crt: "---CERT---"
+`))
+	g.Expect(v.ValidateGlobalValues(values)).Should(HaveOccurred())
+}
+
+func Test_LoadSchema_Error(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := LoadSchema([]byte("type: object\nproperties: [a, b]\n"))
+	g.Expect(err).Should(HaveOccurred())
+}
+
+func Test_ModuleSensitivePaths(t *testing.T) {
+	g := NewWithT(t)
+
+	configSchema := `
+type: object
+properties:
+  auth:
+    type: object
+    properties:
+      user:
+        type: string
+      password:
+        type: string
+        x-sensitive: true
+  users:
+    type: array
+    items:
+      type: object
+      properties:
+        token:
+          type: string
+          x-sensitive: true
+`
+	valuesSchema := `
+x-extend:
+  schema: config-values.yaml
+type: object
+properties:
+  internal:
+    type: object
+    x-sensitive: true
+`
+
+	st := NewSchemaStorage()
+	err := st.AddModuleValuesSchemas("module-one", []byte(configSchema), []byte(valuesSchema))
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	g.Expect(st.ModuleSensitivePaths("module-one")).Should(Equal([]string{
+		"auth.password",
+		"internal",
+		"users.*.token",
+	}))
+	g.Expect(st.ModuleSensitivePaths("module-two")).Should(BeEmpty())
+}
@@ -13,17 +13,24 @@ func initRegistry() bool {
 		Registry().Add(h)
 		return true
 	}
+	RegisterConfigMigration = func(m ConfigMigration) bool {
+		Registry().AddConfigMigration(m)
+		return true
+	}
 	return true
 }
 
 type HookRegistry interface {
 	Hooks() []GoHook
 	Add(hook GoHook)
+	ConfigMigrations() []ConfigMigration
+	AddConfigMigration(migration ConfigMigration)
 }
 
 type hookRegistry struct {
-	hooks []GoHook
-	m     sync.Mutex
+	hooks      []GoHook
+	migrations []ConfigMigration
+	m          sync.Mutex
 }
 
 var instance *hookRegistry
@@ -49,3 +56,13 @@ func (h *hookRegistry) Add(hook GoHook) {
 	defer h.m.Unlock()
 	h.hooks = append(h.hooks, hook)
 }
+
+func (h *hookRegistry) ConfigMigrations() []ConfigMigration {
+	return h.migrations
+}
+
+func (h *hookRegistry) AddConfigMigration(migration ConfigMigration) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.migrations = append(h.migrations, migration)
+}
@@ -149,6 +149,20 @@ type GoHook interface {
 //   var _ =
 var Register = func(_ GoHook) bool { return false }
 
+// ConfigMigration converts config values of the global section or the module section
+// from the previous version to the Version.
+type ConfigMigration struct {
+	// Module is a module name. Empty for the global section.
+	Module  string
+	Version int
+	Migrate func(values map[string]interface{}) (map[string]interface{}, error)
+}
+
+// RegisterConfigMigration is a method to define config values migrations in Go.
+// return value is for trick with
+//   var _ =
+var RegisterConfigMigration = func(_ ConfigMigration) bool { return false }
+
 type HookLoader interface {
 	Load()
 }